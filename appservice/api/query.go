@@ -19,7 +19,10 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
@@ -41,6 +44,55 @@ type AppServiceInternalAPI interface {
 		req *UserIDExistsRequest,
 		resp *UserIDExistsResponse,
 	) error
+	// Query the third party protocols provided by application services
+	Protocols(
+		ctx context.Context,
+		req *ProtocolRequest,
+		resp *ProtocolResponse,
+	) error
+	// Query application services for third party locations
+	Locations(
+		ctx context.Context,
+		req *LocationRequest,
+		resp *LocationResponse,
+	) error
+	// Query application services for third party users
+	User(
+		ctx context.Context,
+		req *UserRequest,
+		resp *UserResponse,
+	) error
+	// Register a new application service at runtime, or update one which
+	// was previously registered at runtime
+	PerformRegisterAppservice(
+		ctx context.Context,
+		req *PerformRegisterAppserviceRequest,
+		resp *PerformRegisterAppserviceResponse,
+	) error
+	// Remove an application service which was registered at runtime
+	PerformRemoveAppservice(
+		ctx context.Context,
+		req *PerformRemoveAppserviceRequest,
+		resp *PerformRemoveAppserviceResponse,
+	) error
+	// List all of the application services known to the homeserver
+	QueryAppservices(
+		ctx context.Context,
+		req *QueryAppservicesRequest,
+		resp *QueryAppservicesResponse,
+	) error
+	// Check that the homeserver can reach an application service (MSC2659)
+	PerformAppservicePing(
+		ctx context.Context,
+		req *PerformAppservicePingRequest,
+		resp *PerformAppservicePingResponse,
+	) error
+	// Report how well transactions are being delivered to application services
+	QueryAppserviceStatus(
+		ctx context.Context,
+		req *QueryAppserviceStatusRequest,
+		resp *QueryAppserviceStatusResponse,
+	) error
 }
 
 // RoomAliasExistsRequest is a request to an application service
@@ -77,6 +129,231 @@ type UserIDExistsResponse struct {
 	UserIDExists bool `json:"exists"`
 }
 
+// ProtocolRequest is a request for the third party protocols provided by
+// application services. If Protocol is empty, all protocols are returned.
+type ProtocolRequest struct {
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// ProtocolResponse is a response containing the third party protocols
+// provided by application services, keyed by protocol name. Exists is
+// false if no application service provides the requested protocol.
+type ProtocolResponse struct {
+	Protocols map[string]ASProtocolResponse `json:"protocols"`
+	Exists    bool                          `json:"exists"`
+}
+
+// ASProtocolResponse is the metadata of a third party protocol, as returned
+// by an application service.
+type ASProtocolResponse struct {
+	FieldTypes     map[string]FieldType `json:"field_types,omitempty"`
+	Icon           string               `json:"icon"`
+	Instances      []ProtocolInstance   `json:"instances"`
+	LocationFields []string             `json:"location_fields"`
+	UserFields     []string             `json:"user_fields"`
+}
+
+// FieldType describes a field used when looking up third party locations
+// and users.
+type FieldType struct {
+	Placeholder string `json:"placeholder"`
+	Regexp      string `json:"regexp"`
+}
+
+// ProtocolInstance is a single network which a third party protocol can
+// bridge to, e.g. an IRC network.
+type ProtocolInstance struct {
+	Description string          `json:"desc"`
+	Icon        string          `json:"icon,omitempty"`
+	Fields      json.RawMessage `json:"fields,omitempty"`
+	NetworkID   string          `json:"network_id,omitempty"`
+	// InstanceID is set by the homeserver and identifies the network in
+	// public room directory queries. It is made up of the application
+	// service ID and the network ID.
+	InstanceID string `json:"instance_id"`
+}
+
+// ThirdPartyInstanceID builds the instance ID for a network provided by
+// the given application service.
+func ThirdPartyInstanceID(appserviceID, networkID string) string {
+	return appserviceID + "|" + networkID
+}
+
+// ParseThirdPartyInstanceID splits an instance ID into the application
+// service ID and network ID.
+func ParseThirdPartyInstanceID(instanceID string) (appserviceID, networkID string, err error) {
+	appserviceID, networkID, found := strings.Cut(instanceID, "|")
+	if !found || appserviceID == "" || networkID == "" {
+		return "", "", fmt.Errorf("invalid third party instance ID %q", instanceID)
+	}
+	return appserviceID, networkID, nil
+}
+
+// LocationRequest is a request for third party locations. If Protocol is
+// empty, all application services are asked. Params is the encoded query
+// string passed to the application services.
+type LocationRequest struct {
+	Protocol string `json:"protocol,omitempty"`
+	Params   string `json:"params,omitempty"`
+}
+
+// LocationResponse is a response containing the third party locations found
+// by application services. Exists is false if no application service provides
+// the requested protocol.
+type LocationResponse struct {
+	Locations []ASLocationResponse `json:"locations,omitempty"`
+	Exists    bool                 `json:"exists,omitempty"`
+}
+
+// ASLocationResponse is a third party location, as returned by an
+// application service.
+type ASLocationResponse struct {
+	Alias    string          `json:"alias"`
+	Fields   json.RawMessage `json:"fields"`
+	Protocol string          `json:"protocol"`
+}
+
+// UserRequest is a request for third party users. If Protocol is empty,
+// all application services are asked. Params is the encoded query string
+// passed to the application services.
+type UserRequest struct {
+	Protocol string `json:"protocol,omitempty"`
+	Params   string `json:"params,omitempty"`
+}
+
+// UserResponse is a response containing the third party users found by
+// application services. Exists is false if no application service provides
+// the requested protocol.
+type UserResponse struct {
+	Users  []ASUserResponse `json:"users,omitempty"`
+	Exists bool             `json:"exists,omitempty"`
+}
+
+// ASUserResponse is a third party user, as returned by an application service.
+type ASUserResponse struct {
+	Protocol string          `json:"protocol"`
+	UserID   string          `json:"userid"`
+	Fields   json.RawMessage `json:"fields"`
+}
+
+// PerformRegisterAppserviceRequest is a request to register or update an
+// application service at runtime. The registration is in the same format as
+// the registration files, as YAML or JSON.
+type PerformRegisterAppserviceRequest struct {
+	// If set, the ID in the registration must match, or is filled in if
+	// the registration doesn't have one.
+	AppserviceID string `json:"appservice_id"`
+	Registration []byte `json:"registration"`
+}
+
+// PerformRegisterAppserviceResponse is a response to registering an
+// application service at runtime.
+type PerformRegisterAppserviceResponse struct {
+	// If set, the registration was rejected, e.g. because it is invalid or
+	// clashes with another application service.
+	Error string `json:"error,omitempty"`
+}
+
+// PerformRemoveAppserviceRequest is a request to remove an application
+// service which was registered at runtime.
+type PerformRemoveAppserviceRequest struct {
+	AppserviceID string `json:"appservice_id"`
+}
+
+// PerformRemoveAppserviceResponse is a response to removing an application
+// service. Removed is false if no application service with the given ID was
+// registered at runtime.
+type PerformRemoveAppserviceResponse struct {
+	Removed bool `json:"removed"`
+	// If set, the application service can't be removed, e.g. because it was
+	// loaded from the config files.
+	Error string `json:"error,omitempty"`
+}
+
+// QueryAppservicesRequest is a request for all application services.
+type QueryAppservicesRequest struct{}
+
+// QueryAppservicesResponse lists all application services.
+type QueryAppservicesResponse struct {
+	Appservices []AppserviceRegistration `json:"appservices"`
+}
+
+// AppserviceRegistration is a summary of an application service.
+type AppserviceRegistration struct {
+	ID              string   `json:"id"`
+	URL             string   `json:"url"`
+	SenderLocalpart string   `json:"sender_localpart"`
+	Protocols       []string `json:"protocols,omitempty"`
+	// Runtime is true if the application service was registered at runtime,
+	// rather than loaded from the config files.
+	Runtime bool `json:"runtime"`
+}
+
+// OutputAppserviceRegistrations is published whenever the application
+// services registered at runtime change. It always contains all of the
+// registrations, so that only the latest message matters.
+type OutputAppserviceRegistrations struct {
+	Registrations []string `json:"registrations"`
+}
+
+// The error codes which PerformAppservicePing can return, as defined by
+// MSC2659, along with M_NOT_FOUND for unknown application services.
+const (
+	PingErrNotFound          = "M_NOT_FOUND"
+	PingErrURLNotSet         = "M_URL_NOT_SET"
+	PingErrBadStatus         = "M_BAD_STATUS"
+	PingErrConnectionFailed  = "M_CONNECTION_FAILED"
+	PingErrConnectionTimeout = "M_CONNECTION_TIMEOUT"
+)
+
+// PerformAppservicePingRequest is a request to ping an application service.
+type PerformAppservicePingRequest struct {
+	AppserviceID  string `json:"appservice_id"`
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// PerformAppservicePingResponse is the result of pinging an application
+// service. If the ping failed then ErrCode is set to one of the PingErr
+// codes.
+type PerformAppservicePingResponse struct {
+	DurationMS int64  `json:"duration_ms"`
+	ErrCode    string `json:"errcode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// The HTTP status code and body returned by the application service,
+	// set along with PingErrBadStatus.
+	StatusCode int    `json:"status,omitempty"`
+	Body       string `json:"body,omitempty"`
+}
+
+// QueryAppserviceStatusRequest is a request for the delivery status of an
+// application service, or of all of them if AppserviceID is empty.
+type QueryAppserviceStatusRequest struct {
+	AppserviceID string `json:"appservice_id,omitempty"`
+}
+
+// QueryAppserviceStatusResponse is the delivery status of the requested
+// application services.
+type QueryAppserviceStatusResponse struct {
+	Statuses []AppserviceStatus `json:"statuses"`
+}
+
+// AppserviceStatus describes how well transactions are being delivered to
+// an application service.
+type AppserviceStatus struct {
+	ID string `json:"id"`
+	// The number of transactions waiting to be sent.
+	Backlog int64 `json:"backlog"`
+	// When a transaction was last accepted by the application service.
+	LastSuccessTS gomatrixserverlib.Timestamp `json:"last_success_ts,omitempty"`
+	// When sending a transaction last failed, and why.
+	LastErrorTS gomatrixserverlib.Timestamp `json:"last_error_ts,omitempty"`
+	LastError   string                      `json:"last_error,omitempty"`
+	// How long we are waiting before retrying after a failure, and when the
+	// next attempt will be. Both are zero if we aren't backing off.
+	BackoffMS int64                       `json:"backoff_ms,omitempty"`
+	RetryTS   gomatrixserverlib.Timestamp `json:"retry_ts,omitempty"`
+}
+
 // RetrieveUserProfile is a wrapper that queries both the local database and
 // application services for a given user's profile
 // TODO: Remove this, it's called from federationapi and clientapi but is a pure function
@@ -18,6 +18,7 @@ import (
 	"context"
 	"crypto/tls"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -27,9 +28,11 @@ import (
 	"github.com/matrix-org/dendrite/appservice/consumers"
 	"github.com/matrix-org/dendrite/appservice/inthttp"
 	"github.com/matrix-org/dendrite/appservice/query"
+	"github.com/matrix-org/dendrite/appservice/storage"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/base"
 	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/process"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 )
 
@@ -55,67 +58,191 @@ func NewInternalAPI(
 			Proxy: http.ProxyFromEnvironment,
 		},
 	}
+	js, _ := base.NATS.Prepare(base.ProcessContext, &base.Cfg.Global.JetStream)
+	db, err := storage.NewDatabase(base, &base.Cfg.AppServiceAPI.Database)
+	if err != nil {
+		logrus.WithError(err).Panicf("failed to connect to appservice db")
+	}
+
+	// Transactions are stored in the database before they are sent, so
+	// that the consumers don't have to wait for the application services.
+	queue := consumers.NewTransactionQueue(db, client, &base.Cfg.AppServiceAPI)
+
 	// Create appserivce query API with an HTTP client that will be used for all
 	// outbound and inbound requests (inbound only for the internal API)
 	appserviceQueryAPI := &query.AppServiceQueryAPI{
-		HTTPClient: client,
-		Cfg:        &base.Cfg.AppServiceAPI,
+		HTTPClient:   client,
+		Cfg:          &base.Cfg.AppServiceAPI,
+		DB:           db,
+		UserAPI:      userAPI,
+		JetStream:    js,
+		Transactions: queue,
 	}
 
-	if len(base.Cfg.Derived.ApplicationServices) == 0 {
-		return appserviceQueryAPI
+	// Add the application services which were registered at runtime to
+	// the ones from the config files.
+	appservices, err := appserviceQueryAPI.LoadRegistrations(base.Context())
+	if err != nil {
+		logrus.WithError(err).Panicf("failed to load appservice registrations")
+	}
+	if err = base.Cfg.AppServiceAPI.SetRuntimeAppServices(appservices); err != nil {
+		logrus.WithError(err).Panicf("invalid appservice registrations")
 	}
 
-	// Wrap application services in a type that relates the application service and
-	// a sync.Cond object that can be used to notify workers when there are new
-	// events to be sent out.
-	for _, appservice := range base.Cfg.Derived.ApplicationServices {
+	for _, appservice := range base.Cfg.Derived.AppServices() {
 		// Create bot account for this AS if it doesn't already exist
-		if err := generateAppServiceAccount(userAPI, appservice); err != nil {
+		if err = query.GenerateAppServiceAccount(base.Context(), userAPI, appservice); err != nil {
 			logrus.WithFields(logrus.Fields{
 				"appservice": appservice.ID,
 			}).WithError(err).Panicf("failed to generate bot account for appservice")
 		}
 	}
 
-	// Only consume if we actually have ASes to track, else we'll just chew cycles needlessly.
-	// We can't add ASes at runtime so this is safe to do.
-	js, _ := base.NATS.Prepare(base.ProcessContext, &base.Cfg.Global.JetStream)
-	consumer := consumers.NewOutputRoomEventConsumer(
-		base.ProcessContext, &base.Cfg.AppServiceAPI,
-		client, js, rsAPI,
-	)
-	if err := consumer.Start(); err != nil {
-		logrus.WithError(err).Panicf("failed to start appservice roomserver consumer")
+	// Each application service has its own consumers, which are started and
+	// stopped as application services are registered and removed at runtime.
+	workers := &appserviceWorkers{
+		process: base.ProcessContext,
+		queue:   queue,
+		roomserverConsumer: consumers.NewOutputRoomEventConsumer(
+			base.ProcessContext, &base.Cfg.AppServiceAPI,
+			js, rsAPI, queue,
+		),
+		ephemeralConsumer: consumers.NewOutputEphemeralConsumer(
+			base.ProcessContext, &base.Cfg.AppServiceAPI,
+			js, rsAPI, queue,
+		),
+		running: map[string]*appserviceWorker{},
 	}
+	base.Cfg.Derived.OnAppServicesChanged(func() {
+		if err := workers.sync(base.Cfg.Derived.AppServices()); err != nil {
+			logrus.WithError(err).Error("Failed to update appservice consumers")
+		}
+	})
+	if err = workers.sync(base.Cfg.Derived.AppServices()); err != nil {
+		logrus.WithError(err).Panicf("failed to start appservice consumers")
+	}
+
+	// Tell the other components about the runtime registrations, in case
+	// they changed in the database while we weren't running.
+	if err = appserviceQueryAPI.PublishRegistrations(base.Context(), appservices); err != nil {
+		logrus.WithError(err).Panicf("failed to publish appservice registrations")
+	}
+	base.WatchAppServiceRegistrations()
 
 	return appserviceQueryAPI
 }
 
-// generateAppServiceAccounts creates a dummy account based off the
-// `sender_localpart` field of each application service if it doesn't
-// exist already
-func generateAppServiceAccount(
-	userAPI userapi.AppserviceUserAPI,
-	as config.ApplicationService,
+// appserviceWorkers keeps track of the consumers running for each
+// application service.
+type appserviceWorkers struct {
+	process            *process.ProcessContext
+	queue              *consumers.TransactionQueue
+	roomserverConsumer *consumers.OutputRoomEventConsumer
+	ephemeralConsumer  *consumers.OutputEphemeralConsumer
+	mutex              sync.Mutex
+	running            map[string]*appserviceWorker
+}
+
+// appserviceWorker holds the functions which stop each group of consumers
+// for an application service, or nil if that group isn't running.
+type appserviceWorker struct {
+	sender      context.CancelFunc
+	roomserver  context.CancelFunc
+	ephemeral   context.CancelFunc
+	deviceLists context.CancelFunc
+}
+
+// sync starts consumers and senders for new application services and stops
+// them for the ones which have gone away. The consumers pick up other changes to the
+// registrations by themselves, so they are only started or stopped when an
+// application service starts or stops wanting ephemeral events or device
+// list changes. Stopped consumers are deleted from JetStream, and backlogs
+// from the database, so that they don't hold on to messages which will never
+// be sent.
+func (w *appserviceWorkers) sync(appservices []config.ApplicationService) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	wanted := make(map[string]bool, len(appservices))
+	for _, appservice := range appservices {
+		wanted[appservice.ID] = true
+		worker, ok := w.running[appservice.ID]
+		if !ok {
+			worker = &appserviceWorker{}
+			w.running[appservice.ID] = worker
+		}
+		if err := w.toggle(&worker.sender, true, func(ctx context.Context) error {
+			return w.queue.StartAppservice(ctx, appservice.ID)
+		}, nil); err != nil {
+			return err
+		}
+		if err := w.toggle(&worker.roomserver, true, func(ctx context.Context) error {
+			return w.roomserverConsumer.StartAppservice(ctx, appservice)
+		}, nil); err != nil {
+			return err
+		}
+		hasURL := appservice.URL != ""
+		if err := w.toggle(&worker.ephemeral, hasURL && appservice.WantsEphemeral(), func(ctx context.Context) error {
+			return w.ephemeralConsumer.StartEphemeral(ctx, appservice)
+		}, func() error {
+			return w.ephemeralConsumer.DeleteEphemeral(appservice.ID)
+		}); err != nil {
+			return err
+		}
+		if err := w.toggle(&worker.deviceLists, hasURL && appservice.DeviceLists, func(ctx context.Context) error {
+			return w.ephemeralConsumer.StartDeviceLists(ctx, appservice)
+		}, func() error {
+			return w.ephemeralConsumer.DeleteDeviceLists(appservice.ID)
+		}); err != nil {
+			return err
+		}
+	}
+	for id, worker := range w.running {
+		if wanted[id] {
+			continue
+		}
+		if err := w.toggle(&worker.roomserver, false, nil, func() error {
+			return w.roomserverConsumer.DeleteAppservice(id)
+		}); err != nil {
+			return err
+		}
+		if err := w.toggle(&worker.ephemeral, false, nil, func() error {
+			return w.ephemeralConsumer.DeleteEphemeral(id)
+		}); err != nil {
+			return err
+		}
+		if err := w.toggle(&worker.deviceLists, false, nil, func() error {
+			return w.ephemeralConsumer.DeleteDeviceLists(id)
+		}); err != nil {
+			return err
+		}
+		if err := w.toggle(&worker.sender, false, nil, func() error {
+			return w.queue.DeleteAppservice(id)
+		}); err != nil {
+			return err
+		}
+		delete(w.running, id)
+	}
+	return nil
+}
+
+// toggle starts or stops a group of consumers, depending on whether they
+// are wanted and whether they are already running.
+func (w *appserviceWorkers) toggle(
+	cancel *context.CancelFunc, want bool,
+	start func(ctx context.Context) error, remove func() error,
 ) error {
-	var accRes userapi.PerformAccountCreationResponse
-	err := userAPI.PerformAccountCreation(context.Background(), &userapi.PerformAccountCreationRequest{
-		AccountType:  userapi.AccountTypeAppService,
-		Localpart:    as.SenderLocalpart,
-		AppServiceID: as.ID,
-		OnConflict:   userapi.ConflictUpdate,
-	}, &accRes)
-	if err != nil {
-		return err
+	switch {
+	case want && *cancel == nil:
+		ctx, c := context.WithCancel(w.process.Context())
+		if err := start(ctx); err != nil {
+			c()
+			return err
+		}
+		*cancel = c
+	case !want && *cancel != nil:
+		(*cancel)()
+		*cancel = nil
+		return remove()
 	}
-	var devRes userapi.PerformDeviceCreationResponse
-	err = userAPI.PerformDeviceCreation(context.Background(), &userapi.PerformDeviceCreationRequest{
-		Localpart:          as.SenderLocalpart,
-		AccessToken:        as.ASToken,
-		DeviceID:           &as.SenderLocalpart,
-		DeviceDisplayName:  &as.SenderLocalpart,
-		NoDeviceListUpdate: true,
-	}, &devRes)
-	return err
+	return nil
 }
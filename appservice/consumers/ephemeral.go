@@ -0,0 +1,509 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/internal/caching"
+	keyapi "github.com/matrix-org/dendrite/keyserver/api"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	syncTypes "github.com/matrix-org/dendrite/syncapi/types"
+)
+
+// OutputEphemeralConsumer consumes typing notifications, receipts, presence,
+// send-to-device messages and device key changes, and passes them on to the
+// application services which have asked for them (MSC2409 and MSC3202).
+type OutputEphemeralConsumer struct {
+	ctx       context.Context
+	cfg       *config.AppServiceAPI
+	jetstream nats.JetStreamContext
+	rsAPI     api.AppserviceRoomserverAPI
+	queue     *TransactionQueue
+}
+
+// ephemeralTransaction is the body of a transaction containing ephemeral
+// data. The events array is required by the spec, so is always sent even
+// though it is empty.
+type ephemeralTransaction struct {
+	Events            []json.RawMessage `json:"events"`
+	Ephemeral         []json.RawMessage `json:"ephemeral,omitempty"`
+	UnstableEphemeral []json.RawMessage `json:"de.sorunome.msc2409.ephemeral,omitempty"`
+	ToDevice          []json.RawMessage `json:"de.sorunome.msc2409.to_device,omitempty"`
+	DeviceLists       *deviceLists      `json:"org.matrix.msc3202.device_lists,omitempty"`
+}
+
+// ephemeralDurables maps the streams consumed by StartEphemeral to the
+// prefixes of their durable names.
+var ephemeralDurables = map[string]string{
+	jetstream.OutputTypingEvent:       "AppserviceTyping_",
+	jetstream.OutputReceiptEvent:      "AppserviceReceipt_",
+	jetstream.OutputPresenceEvent:     "AppservicePresence_",
+	jetstream.OutputSendToDeviceEvent: "AppserviceSendToDevice_",
+}
+
+const deviceListsDurable = "AppserviceKeyChange_"
+
+// reliableStreams are the streams whose messages are delivered again by
+// JetStream if the application service doesn't accept them. Typing
+// notifications, receipts and presence are only useful when they are
+// current, so are dropped instead.
+var reliableStreams = map[string]bool{
+	jetstream.OutputSendToDeviceEvent: true,
+	jetstream.OutputKeyChangeEvent:    true,
+}
+
+// ephemeralRetryDelay is how long to wait before JetStream delivers the
+// messages of a reliable stream again.
+const ephemeralRetryDelay = 5 * time.Second
+
+// deviceLists only ever lists changed users. Working out which users no
+// longer share a room with the application service would need membership
+// changes, which the key change stream doesn't carry, so "left" is omitted.
+type deviceLists struct {
+	Changed []string `json:"changed"`
+}
+
+// interestCache remembers which rooms and users an application service is
+// interested in while a batch of messages is processed, so that each of them
+// is only looked up in the roomserver once.
+type interestCache struct {
+	rsAPI api.AppserviceRoomserverAPI
+	as    *config.ApplicationService
+	rooms map[string]bool
+	users map[string]bool
+}
+
+func newInterestCache(rsAPI api.AppserviceRoomserverAPI, as *config.ApplicationService) *interestCache {
+	return &interestCache{
+		rsAPI: rsAPI,
+		as:    as,
+		rooms: map[string]bool{},
+		users: map[string]bool{},
+	}
+}
+
+// NewOutputEphemeralConsumer creates a new OutputEphemeralConsumer. Call
+// Start() to begin consuming.
+func NewOutputEphemeralConsumer(
+	process *process.ProcessContext,
+	cfg *config.AppServiceAPI,
+	js nats.JetStreamContext,
+	rsAPI api.AppserviceRoomserverAPI,
+	queue *TransactionQueue,
+) *OutputEphemeralConsumer {
+	return &OutputEphemeralConsumer{
+		ctx:       process.Context(),
+		cfg:       cfg,
+		jetstream: js,
+		rsAPI:     rsAPI,
+		queue:     queue,
+	}
+}
+
+// Start consuming for each application service which wants ephemeral
+// events or device list changes.
+func (s *OutputEphemeralConsumer) Start() error {
+	for _, as := range s.cfg.Derived.AppServices() {
+		if err := s.StartAppservice(s.ctx, as); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartAppservice starts the consumers for a single application service,
+// if it wants ephemeral events or device list changes, until the given
+// context expires.
+func (s *OutputEphemeralConsumer) StartAppservice(ctx context.Context, appsvc config.ApplicationService) error {
+	if appsvc.URL == "" {
+		return nil
+	}
+	if appsvc.WantsEphemeral() {
+		if err := s.StartEphemeral(ctx, appsvc); err != nil {
+			return err
+		}
+	}
+	if appsvc.DeviceLists {
+		if err := s.StartDeviceLists(ctx, appsvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartEphemeral starts the consumers for typing notifications, receipts,
+// presence and send-to-device messages for a single application service.
+func (s *OutputEphemeralConsumer) StartEphemeral(ctx context.Context, appsvc config.ApplicationService) error {
+	typing := caching.NewTypingCache()
+	if err := s.consume(ctx, &appsvc, jetstream.OutputTypingEvent, ephemeralDurables[jetstream.OutputTypingEvent], func(ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg) *ephemeralTransaction {
+		return s.typingTransaction(ctx, as, typing, msgs)
+	}, nats.HeadersOnly()); err != nil {
+		return err
+	}
+	if err := s.consume(ctx, &appsvc, jetstream.OutputReceiptEvent, ephemeralDurables[jetstream.OutputReceiptEvent], s.receiptTransaction, nats.HeadersOnly()); err != nil {
+		return err
+	}
+	if err := s.consume(ctx, &appsvc, jetstream.OutputPresenceEvent, ephemeralDurables[jetstream.OutputPresenceEvent], s.presenceTransaction, nats.HeadersOnly()); err != nil {
+		return err
+	}
+	return s.consume(ctx, &appsvc, jetstream.OutputSendToDeviceEvent, ephemeralDurables[jetstream.OutputSendToDeviceEvent], s.sendToDeviceTransaction)
+}
+
+// StartDeviceLists starts the consumer for device list changes for a
+// single application service.
+func (s *OutputEphemeralConsumer) StartDeviceLists(ctx context.Context, appsvc config.ApplicationService) error {
+	return s.consume(ctx, &appsvc, jetstream.OutputKeyChangeEvent, deviceListsDurable, s.deviceListsTransaction)
+}
+
+// DeleteEphemeral deletes the durable consumers created by StartEphemeral,
+// which would otherwise hold on to messages for an application service
+// which no longer wants them.
+func (s *OutputEphemeralConsumer) DeleteEphemeral(appserviceID string) error {
+	for stream, durablePrefix := range ephemeralDurables {
+		if err := deleteConsumer(s.jetstream, s.cfg, stream, durablePrefix, appserviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteDeviceLists deletes the durable consumer created by
+// StartDeviceLists.
+func (s *OutputEphemeralConsumer) DeleteDeviceLists(appserviceID string) error {
+	return deleteConsumer(s.jetstream, s.cfg, jetstream.OutputKeyChangeEvent, deviceListsDurable, appserviceID)
+}
+
+// consume starts a consumer on the given stream for a single application
+// service. Each consumer has its own backoff state, so that a slow stream
+// doesn't hold up the others.
+func (s *OutputEphemeralConsumer) consume(
+	ctx context.Context, appsvc *config.ApplicationService, stream, durablePrefix string,
+	f func(ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg) *ephemeralTransaction,
+	opts ...nats.SubOpt,
+) error {
+	state := &appserviceState{
+		ApplicationService: appsvc,
+	}
+	token := jetstream.Tokenise(appsvc.ID)
+	opts = append(opts, nats.DeliverNew(), nats.ManualAck())
+	if err := jetstream.JetStreamConsumer(
+		ctx, s.jetstream, s.cfg.Matrix.JetStream.Prefixed(stream),
+		s.cfg.Matrix.JetStream.Durable(durablePrefix+token),
+		50, // maximum number of messages to send in a single transaction
+		func(ctx context.Context, msgs []*nats.Msg) bool {
+			state.refresh(s.cfg)
+			txn := f(ctx, state.ApplicationService, msgs)
+			if txn == nil {
+				return true
+			}
+			err := s.sendEphemeral(ctx, state, stream, txn)
+			if err == nil {
+				return true
+			}
+			logger := log.WithField("appservice", state.ID).WithError(err)
+			if !reliableStreams[stream] {
+				logger.Warnf("Dropping %s transaction which the appservice didn't accept", stream)
+				return true
+			}
+			logger.Warnf("Unable to send %s transaction to appservice, will retry", stream)
+			wait(ctx, ephemeralRetryDelay)
+			return false
+		},
+		opts...,
+	); err != nil {
+		return fmt.Errorf("failed to create %q %s consumer: %w", token, stream, err)
+	}
+	return nil
+}
+
+// sendEphemeral sends the transaction straight to the application service.
+// Ephemeral data isn't stored in the backlog.
+func (s *OutputEphemeralConsumer) sendEphemeral(
+	ctx context.Context, state *appserviceState, stream string,
+	txn *ephemeralTransaction,
+) error {
+	txn.Events = []json.RawMessage{}
+	txn.UnstableEphemeral = txn.Ephemeral
+	transaction, err := json.Marshal(txn)
+	if err != nil {
+		return err
+	}
+	log.WithField("appservice", state.ID).Debugf("Appservice worker sending %s transaction", stream)
+	return s.queue.SendEphemeral(ctx, state.ID, transaction)
+}
+
+// typingTransaction builds m.typing events for the rooms the application
+// service is interested in, listing everyone who is currently typing.
+func (s *OutputEphemeralConsumer) typingTransaction(
+	ctx context.Context, as *config.ApplicationService, typing *caching.EDUCache, msgs []*nats.Msg,
+) *ephemeralTransaction {
+	var rooms []string
+	seen := map[string]bool{}
+	for _, msg := range msgs {
+		roomID := msg.Header.Get(jetstream.RoomID)
+		userID := msg.Header.Get(jetstream.UserID)
+		isTyping, err := strconv.ParseBool(msg.Header.Get("typing"))
+		if err != nil {
+			log.WithError(err).Errorf("Appservice failed to parse typing message, ignoring")
+			continue
+		}
+		if isTyping {
+			timeout, err := strconv.Atoi(msg.Header.Get("timeout_ms"))
+			if err != nil {
+				log.WithError(err).Errorf("Appservice failed to parse typing timeout, ignoring")
+				continue
+			}
+			expiry := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+			typing.AddTypingUser(userID, roomID, &expiry)
+		} else {
+			typing.RemoveUser(userID, roomID)
+		}
+		if !seen[roomID] {
+			seen[roomID] = true
+			rooms = append(rooms, roomID)
+		}
+	}
+
+	txn := &ephemeralTransaction{}
+	interest := newInterestCache(s.rsAPI, as)
+	for _, roomID := range rooms {
+		if !interest.room(ctx, roomID) {
+			continue
+		}
+		userIDs := typing.GetTypingUsers(roomID)
+		if userIDs == nil {
+			userIDs = []string{}
+		}
+		s.appendEphemeral(txn, map[string]interface{}{
+			"type":    "m.typing",
+			"room_id": roomID,
+			"content": map[string]interface{}{
+				"user_ids": userIDs,
+			},
+		})
+	}
+	return txn.orNil()
+}
+
+// receiptTransaction builds m.receipt events for the rooms the application
+// service is interested in.
+func (s *OutputEphemeralConsumer) receiptTransaction(
+	ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg,
+) *ephemeralTransaction {
+	txn := &ephemeralTransaction{}
+	interest := newInterestCache(s.rsAPI, as)
+	for _, msg := range msgs {
+		roomID := msg.Header.Get(jetstream.RoomID)
+		timestamp, err := strconv.ParseUint(msg.Header.Get("timestamp"), 10, 64)
+		if err != nil {
+			log.WithError(err).Errorf("Appservice failed to parse receipt message, ignoring")
+			continue
+		}
+		if !interest.room(ctx, roomID) {
+			continue
+		}
+		s.appendEphemeral(txn, map[string]interface{}{
+			"type":    gomatrixserverlib.MReceipt,
+			"room_id": roomID,
+			"content": map[string]interface{}{
+				msg.Header.Get(jetstream.EventID): map[string]interface{}{
+					msg.Header.Get("type"): map[string]interface{}{
+						msg.Header.Get(jetstream.UserID): map[string]interface{}{
+							"ts": timestamp,
+						},
+					},
+				},
+			},
+		})
+	}
+	return txn.orNil()
+}
+
+// presenceTransaction builds m.presence events for users that the
+// application service is interested in, or who share a room with it.
+func (s *OutputEphemeralConsumer) presenceTransaction(
+	ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg,
+) *ephemeralTransaction {
+	txn := &ephemeralTransaction{}
+	interest := newInterestCache(s.rsAPI, as)
+	for _, msg := range msgs {
+		userID := msg.Header.Get(jetstream.UserID)
+		ts, err := strconv.Atoi(msg.Header.Get("last_active_ts"))
+		if err != nil {
+			log.WithError(err).Errorf("Appservice failed to parse presence message, ignoring")
+			continue
+		}
+		if !interest.user(ctx, userID) {
+			continue
+		}
+		p := syncTypes.PresenceInternal{LastActiveTS: gomatrixserverlib.Timestamp(ts)}
+		content := map[string]interface{}{
+			"presence":         msg.Header.Get("presence"),
+			"last_active_ago":  p.LastActiveAgo(),
+			"currently_active": p.CurrentlyActive(),
+		}
+		if data, ok := msg.Header["status_msg"]; ok && len(data) > 0 {
+			content["status_msg"] = msg.Header.Get("status_msg")
+		}
+		s.appendEphemeral(txn, map[string]interface{}{
+			"type":    gomatrixserverlib.MPresence,
+			"sender":  userID,
+			"content": content,
+		})
+	}
+	return txn.orNil()
+}
+
+// sendToDeviceTransaction passes on send-to-device messages for users in
+// the application service's namespace.
+func (s *OutputEphemeralConsumer) sendToDeviceTransaction(
+	ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg,
+) *ephemeralTransaction {
+	txn := &ephemeralTransaction{}
+	for _, msg := range msgs {
+		var output syncTypes.OutputSendToDeviceEvent
+		if err := json.Unmarshal(msg.Data, &output); err != nil {
+			log.WithError(err).Errorf("Appservice failed to parse send-to-device message, ignoring")
+			continue
+		}
+		if !as.IsInterestedInUserID(output.UserID) {
+			continue
+		}
+		j, err := json.Marshal(map[string]interface{}{
+			"type":         output.Type,
+			"sender":       output.Sender,
+			"content":      output.Content,
+			"to_user_id":   output.UserID,
+			"to_device_id": output.DeviceID,
+		})
+		if err != nil {
+			log.WithError(err).Errorf("Appservice failed to marshal send-to-device message, ignoring")
+			continue
+		}
+		txn.ToDevice = append(txn.ToDevice, j)
+	}
+	if len(txn.ToDevice) == 0 {
+		return nil
+	}
+	return txn
+}
+
+// deviceListsTransaction passes on the users whose device keys have changed
+// that the application service is interested in, or who share a room with it.
+func (s *OutputEphemeralConsumer) deviceListsTransaction(
+	ctx context.Context, as *config.ApplicationService, msgs []*nats.Msg,
+) *ephemeralTransaction {
+	lists := &deviceLists{Changed: []string{}}
+	interest := newInterestCache(s.rsAPI, as)
+	seen := map[string]bool{}
+	for _, msg := range msgs {
+		var m keyapi.DeviceMessage
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			log.WithError(err).Errorf("Appservice failed to parse device message, ignoring")
+			continue
+		}
+		var userID string
+		switch {
+		case m.DeviceKeys != nil:
+			userID = m.DeviceKeys.UserID
+		case m.OutputCrossSigningKeyUpdate != nil:
+			userID = m.OutputCrossSigningKeyUpdate.CrossSigningKeyUpdate.UserID
+		default:
+			continue
+		}
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		if interest.user(ctx, userID) {
+			lists.Changed = append(lists.Changed, userID)
+		}
+	}
+	if len(lists.Changed) == 0 {
+		return nil
+	}
+	return &ephemeralTransaction{DeviceLists: lists}
+}
+
+// room returns true if the application service is interested in the room.
+func (c *interestCache) room(ctx context.Context, roomID string) bool {
+	interested, ok := c.rooms[roomID]
+	if !ok {
+		interested = appserviceIsInterestedInRoom(ctx, c.rsAPI, roomID, c.as)
+		c.rooms[roomID] = interested
+	}
+	return interested
+}
+
+// user returns true if the user is in the application service's namespace
+// or is joined to a room that the application service is interested in.
+func (c *interestCache) user(ctx context.Context, userID string) bool {
+	if c.as.IsInterestedInUserID(userID) {
+		return true
+	}
+	if interested, ok := c.users[userID]; ok {
+		return interested
+	}
+	var res api.QueryRoomsForUserResponse
+	if err := c.rsAPI.QueryRoomsForUser(ctx, &api.QueryRoomsForUserRequest{
+		UserID:         userID,
+		WantMembership: gomatrixserverlib.Join,
+	}, &res); err != nil {
+		log.WithFields(log.Fields{
+			"appservice": c.as.ID,
+			"user_id":    userID,
+		}).WithError(err).Errorf("Unable to get rooms for user")
+		return false
+	}
+	interested := false
+	for _, roomID := range res.RoomIDs {
+		if c.room(ctx, roomID) {
+			interested = true
+			break
+		}
+	}
+	c.users[userID] = interested
+	return interested
+}
+
+func (s *OutputEphemeralConsumer) appendEphemeral(txn *ephemeralTransaction, ev map[string]interface{}) {
+	j, err := json.Marshal(ev)
+	if err != nil {
+		log.WithError(err).Errorf("Appservice failed to marshal ephemeral event, ignoring")
+		return
+	}
+	txn.Ephemeral = append(txn.Ephemeral, j)
+}
+
+// orNil returns nil if there are no ephemeral events in the transaction,
+// so that nothing is sent to the application service.
+func (t *ephemeralTransaction) orNil() *ephemeralTransaction {
+	if len(t.Ephemeral) == 0 {
+		return nil
+	}
+	return t
+}
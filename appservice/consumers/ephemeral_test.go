@@ -0,0 +1,358 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/internal/caching"
+	keyapi "github.com/matrix-org/dendrite/keyserver/api"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/nats-io/nats.go"
+)
+
+type fakeRoomserverAPI struct {
+	api.AppserviceRoomserverAPI
+	roomsForUser  map[string][]string
+	userQueries   int
+	aliasQueries  int
+	memberQueries int
+}
+
+func (f *fakeRoomserverAPI) GetAliasesForRoomID(ctx context.Context, req *api.GetAliasesForRoomIDRequest, res *api.GetAliasesForRoomIDResponse) error {
+	f.aliasQueries++
+	return nil
+}
+
+func (f *fakeRoomserverAPI) QueryMembershipsForRoom(ctx context.Context, req *api.QueryMembershipsForRoomRequest, res *api.QueryMembershipsForRoomResponse) error {
+	f.memberQueries++
+	return nil
+}
+
+func (f *fakeRoomserverAPI) QueryRoomsForUser(ctx context.Context, req *api.QueryRoomsForUserRequest, res *api.QueryRoomsForUserResponse) error {
+	f.userQueries++
+	res.RoomIDs = f.roomsForUser[req.UserID]
+	return nil
+}
+
+func testAppservice(url string) *config.ApplicationService {
+	return &config.ApplicationService{
+		ID:               "bridge",
+		URL:              url,
+		HSToken:          "hs_token",
+		ReceiveEphemeral: true,
+		NamespaceMap: map[string][]config.ApplicationServiceNamespace{
+			"users": {{Regex: "@bridge_.*", RegexpObject: regexp.MustCompile("@bridge_.*")}},
+			"rooms": {{Regex: "!bridged:.*", RegexpObject: regexp.MustCompile("!bridged:.*")}},
+		},
+	}
+}
+
+func typingMsg(roomID, userID string, typing bool) *nats.Msg {
+	msg := nats.NewMsg("typing")
+	msg.Header.Set(jetstream.RoomID, roomID)
+	msg.Header.Set(jetstream.UserID, userID)
+	if typing {
+		msg.Header.Set("typing", "true")
+	} else {
+		msg.Header.Set("typing", "false")
+	}
+	msg.Header.Set("timeout_ms", "30000")
+	return msg
+}
+
+func TestTypingTransaction(t *testing.T) {
+	s := &OutputEphemeralConsumer{rsAPI: &fakeRoomserverAPI{}}
+	as := testAppservice("")
+	typing := caching.NewTypingCache()
+
+	txn := s.typingTransaction(context.Background(), as, typing, []*nats.Msg{
+		typingMsg("!bridged:test", "@alice:test", true),
+		typingMsg("!bridged:test", "@bob:test", true),
+		typingMsg("!other:test", "@alice:test", true),
+	})
+	if txn == nil || len(txn.Ephemeral) != 1 {
+		t.Fatalf("expected one typing event for the bridged room, got %+v", txn)
+	}
+	var ev struct {
+		Type    string `json:"type"`
+		RoomID  string `json:"room_id"`
+		Content struct {
+			UserIDs []string `json:"user_ids"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(txn.Ephemeral[0], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != "m.typing" || ev.RoomID != "!bridged:test" || len(ev.Content.UserIDs) != 2 {
+		t.Fatalf("unexpected typing event: %s", txn.Ephemeral[0])
+	}
+
+	txn = s.typingTransaction(context.Background(), as, typing, []*nats.Msg{
+		typingMsg("!bridged:test", "@alice:test", false),
+	})
+	if err := json.Unmarshal(txn.Ephemeral[0], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(ev.Content.UserIDs) != 1 || ev.Content.UserIDs[0] != "@bob:test" {
+		t.Fatalf("expected only @bob:test to still be typing, got %v", ev.Content.UserIDs)
+	}
+
+	if txn = s.typingTransaction(context.Background(), as, typing, []*nats.Msg{
+		typingMsg("!other:test", "@bob:test", false),
+	}); txn != nil {
+		t.Fatalf("expected no transaction for an uninteresting room, got %+v", txn)
+	}
+}
+
+func TestSendEphemeral(t *testing.T) {
+	type request struct {
+		path string
+		body map[string]json.RawMessage
+	}
+	requests := make(chan request, 2)
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]json.RawMessage
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		requests <- request{r.URL.Path, body}
+		// Fail the first attempt, which shouldn't be retried.
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	as := testAppservice(srv.URL)
+	cfg := &config.AppServiceAPI{
+		Derived: &config.Derived{ApplicationServices: []config.ApplicationService{*as}},
+	}
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	db, err := storage.NewDatabase(nil, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewTransactionQueue(db, srv.Client(), cfg)
+	s := &OutputEphemeralConsumer{rsAPI: &fakeRoomserverAPI{}, queue: queue}
+
+	msg := nats.NewMsg("receipts")
+	msg.Header.Set(jetstream.RoomID, "!bridged:test")
+	msg.Header.Set(jetstream.UserID, "@alice:test")
+	msg.Header.Set(jetstream.EventID, "$event")
+	msg.Header.Set("type", "m.read")
+	msg.Header.Set("timestamp", "1234")
+
+	txn := s.receiptTransaction(context.Background(), as, []*nats.Msg{msg})
+	if txn == nil {
+		t.Fatal("expected a receipt transaction")
+	}
+	state := &appserviceState{ApplicationService: as}
+	if err = s.sendEphemeral(context.Background(), state, jetstream.OutputReceiptEvent, txn); err == nil {
+		t.Fatal("expected the first transaction to fail")
+	}
+	first := <-requests
+	if err = s.sendEphemeral(context.Background(), state, jetstream.OutputReceiptEvent, txn); err != nil {
+		t.Fatal(err)
+	}
+	second := <-requests
+	if first.path == second.path {
+		t.Fatalf("expected each transaction to have its own ID, got %q twice", first.path)
+	}
+	body := second.body
+	if string(body["events"]) != "[]" {
+		t.Fatalf("expected an empty events array, got %s", body["events"])
+	}
+	for _, key := range []string{"ephemeral", "de.sorunome.msc2409.ephemeral"} {
+		var ephemeral []map[string]interface{}
+		if err = json.Unmarshal(body[key], &ephemeral); err != nil || len(ephemeral) != 1 {
+			t.Fatalf("expected one event in %q, got %s", key, body[key])
+		}
+		if ephemeral[0]["type"] != "m.receipt" {
+			t.Fatalf("unexpected ephemeral event: %v", ephemeral[0])
+		}
+	}
+
+	// Ephemeral data never goes into the backlog.
+	if backlog, err := db.GetBacklogSize(context.Background(), as.ID); err != nil || backlog != 0 {
+		t.Fatalf("expected an empty backlog, got %d (%v)", backlog, err)
+	}
+}
+
+func TestDeviceListsTransactionCachesLookups(t *testing.T) {
+	rsAPI := &fakeRoomserverAPI{
+		roomsForUser: map[string][]string{
+			"@alice:test": {"!bridged:test", "!shared:test"},
+			"@bob:test":   {"!shared:test", "!other:test"},
+			"@carol:test": {"!other:test"},
+		},
+	}
+	s := &OutputEphemeralConsumer{rsAPI: rsAPI}
+	as := testAppservice("")
+	var msgs []*nats.Msg
+	for _, userID := range []string{"@alice:test", "@bob:test", "@carol:test", "@bob:test", "@bridge_1:test"} {
+		data, err := json.Marshal(keyapi.DeviceMessage{
+			Type:       keyapi.TypeDeviceKeyUpdate,
+			DeviceKeys: &keyapi.DeviceKeys{UserID: userID, DeviceID: "DEVICE"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := nats.NewMsg("keychange")
+		msg.Data = data
+		msgs = append(msgs, msg)
+	}
+
+	txn := s.deviceListsTransaction(context.Background(), as, msgs)
+	if txn == nil || txn.DeviceLists == nil {
+		t.Fatal("expected a device lists transaction")
+	}
+	if want := []string{"@alice:test", "@bridge_1:test"}; !reflect.DeepEqual(txn.DeviceLists.Changed, want) {
+		t.Fatalf("expected %v to have changed, got %v", want, txn.DeviceLists.Changed)
+	}
+	// Users in the namespace don't need looking up, and each other user and
+	// each room that isn't in the namespace is only looked up once.
+	if rsAPI.userQueries != 3 {
+		t.Fatalf("expected 3 rooms-for-user queries, got %d", rsAPI.userQueries)
+	}
+	if rsAPI.memberQueries != 2 || rsAPI.aliasQueries != 2 {
+		t.Fatalf("expected 2 queries for each room, got %d alias and %d membership queries", rsAPI.aliasQueries, rsAPI.memberQueries)
+	}
+	body, err := json.Marshal(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(body, []byte(`"left"`)) {
+		t.Fatalf("expected no left list, got %s", body)
+	}
+}
+
+func TestEnqueueBacklogFull(t *testing.T) {
+	as := testAppservice("http://localhost")
+	cfg := &config.AppServiceAPI{
+		Derived: &config.Derived{ApplicationServices: []config.ApplicationService{*as}},
+	}
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	db, err := storage.NewDatabase(nil, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewTransactionQueue(db, http.DefaultClient, cfg)
+	queue.maxBacklog = 2
+
+	// The sender isn't started, so nothing leaves the backlog.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err = queue.Enqueue(ctx, as.ID, []byte(`{"events":[]}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = queue.Enqueue(ctx, as.ID, []byte(`{"events":[]}`)); err != errBacklogFull {
+		t.Fatalf("expected errBacklogFull, got %v", err)
+	}
+	if backlog, err := db.GetBacklogSize(context.Background(), as.ID); err != nil || backlog != 2 {
+		t.Fatalf("expected a backlog of 2, got %d (%v)", backlog, err)
+	}
+}
+
+func TestEnqueueRetriesWithSameTransactionID(t *testing.T) {
+	paths := make(chan string, 2)
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		// Fail the first attempt, so that the transaction is retried.
+		if !failed {
+			failed = true
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	as := testAppservice(srv.URL)
+	cfg := &config.AppServiceAPI{
+		Derived: &config.Derived{ApplicationServices: []config.ApplicationService{*as}},
+	}
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	db, err := storage.NewDatabase(nil, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewTransactionQueue(db, srv.Client(), cfg)
+	if err = queue.Enqueue(context.Background(), as.ID, []byte(`{"events":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = queue.StartAppservice(ctx, as.ID); err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		select {
+		case path := <-paths:
+			return path
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for transaction")
+		}
+		return ""
+	}
+	first := next()
+	// Don't wait for the backoff to expire.
+	queue.Retry(as.ID)
+	if second := next(); first != second {
+		t.Fatalf("expected the retry to use the same transaction ID, got %q then %q", first, second)
+	}
+
+	// The status records both the failure and the success.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		status, ok, err := queue.Status(context.Background(), as.ID)
+		if err != nil || !ok {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		if status.Backlog == 0 && status.LastSuccessTS != 0 {
+			if status.LastError == "" || status.BackoffMS != 0 {
+				t.Fatalf("unexpected status: %+v", status)
+			}
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for the backlog to be sent: %+v", status)
+		}
+	}
+}
@@ -19,11 +19,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
 	"net/url"
-	"strconv"
-	"time"
 
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/nats-io/nats.go"
@@ -40,15 +37,26 @@ import (
 type OutputRoomEventConsumer struct {
 	ctx       context.Context
 	cfg       *config.AppServiceAPI
-	client    *http.Client
 	jetstream nats.JetStreamContext
 	topic     string
 	rsAPI     api.AppserviceRoomserverAPI
+	queue     *TransactionQueue
 }
 
 type appserviceState struct {
 	*config.ApplicationService
-	backoff int
+}
+
+// refresh picks up any changes to the application service's registration
+// made at runtime, so that the consumers don't need to be restarted.
+func (s *appserviceState) refresh(cfg *config.AppServiceAPI) {
+	for _, as := range cfg.Derived.AppServices() {
+		if as.ID == s.ID {
+			appsvc := as
+			s.ApplicationService = &appsvc
+			return
+		}
+	}
 }
 
 // NewOutputRoomEventConsumer creates a new OutputRoomEventConsumer. Call
@@ -56,43 +64,70 @@ type appserviceState struct {
 func NewOutputRoomEventConsumer(
 	process *process.ProcessContext,
 	cfg *config.AppServiceAPI,
-	client *http.Client,
 	js nats.JetStreamContext,
 	rsAPI api.AppserviceRoomserverAPI,
+	queue *TransactionQueue,
 ) *OutputRoomEventConsumer {
 	return &OutputRoomEventConsumer{
 		ctx:       process.Context(),
 		cfg:       cfg,
-		client:    client,
 		jetstream: js,
 		topic:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputRoomEvent),
 		rsAPI:     rsAPI,
+		queue:     queue,
 	}
 }
 
 // Start consuming from room servers
 func (s *OutputRoomEventConsumer) Start() error {
-	for _, as := range s.cfg.Derived.ApplicationServices {
-		appsvc := as
-		state := &appserviceState{
-			ApplicationService: &appsvc,
-		}
-		token := jetstream.Tokenise(as.ID)
-		if err := jetstream.JetStreamConsumer(
-			s.ctx, s.jetstream, s.topic,
-			s.cfg.Matrix.JetStream.Durable("Appservice_"+token),
-			50, // maximum number of events to send in a single transaction
-			func(ctx context.Context, msgs []*nats.Msg) bool {
-				return s.onMessage(ctx, state, msgs)
-			},
-			nats.DeliverNew(), nats.ManualAck(),
-		); err != nil {
-			return fmt.Errorf("failed to create %q consumer: %w", token, err)
+	for _, as := range s.cfg.Derived.AppServices() {
+		if err := s.StartAppservice(s.ctx, as); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// StartAppservice starts consuming from room servers for a single
+// application service, until the given context expires.
+func (s *OutputRoomEventConsumer) StartAppservice(ctx context.Context, as config.ApplicationService) error {
+	state := &appserviceState{
+		ApplicationService: &as,
+	}
+	token := jetstream.Tokenise(as.ID)
+	if err := jetstream.JetStreamConsumer(
+		ctx, s.jetstream, s.topic,
+		s.cfg.Matrix.JetStream.Durable("Appservice_"+token),
+		50, // maximum number of events to send in a single transaction
+		func(ctx context.Context, msgs []*nats.Msg) bool {
+			state.refresh(s.cfg)
+			return s.onMessage(ctx, state, msgs)
+		},
+		nats.DeliverNew(), nats.ManualAck(),
+	); err != nil {
+		return fmt.Errorf("failed to create %q consumer: %w", token, err)
+	}
+	return nil
+}
+
+// DeleteAppservice deletes the durable consumer created by StartAppservice,
+// which would otherwise hold on to room events for an application service
+// which no longer exists.
+func (s *OutputRoomEventConsumer) DeleteAppservice(appserviceID string) error {
+	return deleteConsumer(s.jetstream, s.cfg, jetstream.OutputRoomEvent, "Appservice_", appserviceID)
+}
+
+// deleteConsumer deletes the durable pull consumer for an application
+// service, if it exists.
+func deleteConsumer(js nats.JetStreamContext, cfg *config.AppServiceAPI, stream, durablePrefix, appserviceID string) error {
+	durable := cfg.Matrix.JetStream.Durable(durablePrefix+jetstream.Tokenise(appserviceID)) + "Pull"
+	err := js.DeleteConsumer(cfg.Matrix.JetStream.Prefixed(stream), durable)
+	if err != nil && err != nats.ErrConsumerNotFound {
+		return fmt.Errorf("failed to delete %q consumer: %w", durable, err)
+	}
+	return nil
+}
+
 // onMessage is called when the appservice component receives a new event from
 // the room server output log.
 func (s *OutputRoomEventConsumer) onMessage(
@@ -157,25 +192,17 @@ func (s *OutputRoomEventConsumer) onMessage(
 		return true
 	}
 
-	txnID := ""
-	// Try to get the message metadata, if we're able to, use the timestamp as the txnID
-	metadata, err := msgs[0].Metadata()
-	if err == nil {
-		txnID = strconv.Itoa(int(metadata.Timestamp.UnixNano()))
-	}
-
-	// Send event to any relevant application services. If we hit
-	// an error here, return false, so that we negatively ack.
-	log.WithField("appservice", state.ID).Debugf("Appservice worker sending %d events(s) from roomserver", len(events))
-	return s.sendEvents(ctx, state, events, txnID) == nil
+	// Add the events to the application service's backlog. If we hit an
+	// error here, return false, so that we negatively ack.
+	log.WithField("appservice", state.ID).Debugf("Appservice worker queueing %d events(s) from roomserver", len(events))
+	return s.queueEvents(ctx, state, events) == nil
 }
 
-// sendEvents passes events to the appservice by using the transactions
-// endpoint. It will block for the backoff period if necessary.
-func (s *OutputRoomEventConsumer) sendEvents(
+// queueEvents adds a transaction containing the events to the backlog of
+// the application service.
+func (s *OutputRoomEventConsumer) queueEvents(
 	ctx context.Context, state *appserviceState,
 	events []*gomatrixserverlib.HeaderedEvent,
-	txnID string,
 ) error {
 	// Create the transaction body.
 	transaction, err := json.Marshal(
@@ -186,47 +213,34 @@ func (s *OutputRoomEventConsumer) sendEvents(
 	if err != nil {
 		return err
 	}
+	return s.queue.Enqueue(ctx, state.ID, transaction)
+}
 
-	// If txnID is not defined, generate one from the events.
-	if txnID == "" {
-		txnID = fmt.Sprintf("%d_%d", events[0].Event.OriginServerTS(), len(transaction))
-	}
-
+// sendTransaction sends a transaction to the appservice by using the
+// transactions endpoint.
+func sendTransaction(
+	ctx context.Context, client *http.Client, as *config.ApplicationService,
+	txnID string, transaction []byte,
+) error {
 	// Send the transaction to the appservice.
 	// https://matrix.org/docs/spec/application_service/r0.1.2#put-matrix-app-v1-transactions-txnid
-	address := fmt.Sprintf("%s/transactions/%s?access_token=%s", state.URL, txnID, url.QueryEscape(state.HSToken))
+	address := fmt.Sprintf("%s/transactions/%s?access_token=%s", as.URL, txnID, url.QueryEscape(as.HSToken))
 	req, err := http.NewRequestWithContext(ctx, "PUT", address, bytes.NewBuffer(transaction))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := s.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return state.backoffAndPause(err)
+		return err
 	}
-
-	// If the response was fine then we can clear any backoffs in place and
-	// report that everything was OK. Otherwise, back off for a while.
-	switch resp.StatusCode {
-	case http.StatusOK:
-		state.backoff = 0
-	default:
-		return state.backoffAndPause(fmt.Errorf("received HTTP status code %d from appservice", resp.StatusCode))
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received HTTP status code %d from appservice", resp.StatusCode)
 	}
 	return nil
 }
 
-// backoff pauses the calling goroutine for a 2^some backoff exponent seconds
-func (s *appserviceState) backoffAndPause(err error) error {
-	if s.backoff < 6 {
-		s.backoff++
-	}
-	duration := time.Second * time.Duration(math.Pow(2, float64(s.backoff)))
-	log.WithField("appservice", s.ID).WithError(err).Errorf("Unable to send transaction to appservice, backing off for %s", duration.String())
-	time.Sleep(duration)
-	return err
-}
-
 // appserviceIsInterestedInEvent returns a boolean depending on whether a given
 // event falls within one of a given application service's namespaces.
 //
@@ -237,8 +251,6 @@ func (s *OutputRoomEventConsumer) appserviceIsInterestedInEvent(ctx context.Cont
 		return false
 	case appservice.IsInterestedInUserID(event.Sender()):
 		return true
-	case appservice.IsInterestedInRoomID(event.RoomID()):
-		return true
 	}
 
 	if event.Type() == gomatrixserverlib.MRoomMember && event.StateKey() != nil {
@@ -247,10 +259,21 @@ func (s *OutputRoomEventConsumer) appserviceIsInterestedInEvent(ctx context.Cont
 		}
 	}
 
-	// Check all known room aliases of the room the event came from
-	queryReq := api.GetAliasesForRoomIDRequest{RoomID: event.RoomID()}
+	return appserviceIsInterestedInRoom(ctx, s.rsAPI, event.RoomID(), appservice)
+}
+
+// appserviceIsInterestedInRoom returns a boolean depending on whether a given
+// room falls within one of a given application service's namespaces, either
+// by its room ID, one of its aliases or one of its joined members.
+func appserviceIsInterestedInRoom(ctx context.Context, rsAPI api.AppserviceRoomserverAPI, roomID string, appservice *config.ApplicationService) bool {
+	if appservice.IsInterestedInRoomID(roomID) {
+		return true
+	}
+
+	// Check all known room aliases of the room
+	queryReq := api.GetAliasesForRoomIDRequest{RoomID: roomID}
 	var queryRes api.GetAliasesForRoomIDResponse
-	if err := s.rsAPI.GetAliasesForRoomID(ctx, &queryReq, &queryRes); err == nil {
+	if err := rsAPI.GetAliasesForRoomID(ctx, &queryReq, &queryRes); err == nil {
 		for _, alias := range queryRes.Aliases {
 			if appservice.IsInterestedInRoomAlias(alias) {
 				return true
@@ -259,30 +282,30 @@ func (s *OutputRoomEventConsumer) appserviceIsInterestedInEvent(ctx context.Cont
 	} else {
 		log.WithFields(log.Fields{
 			"appservice": appservice.ID,
-			"room_id":    event.RoomID(),
+			"room_id":    roomID,
 		}).WithError(err).Errorf("Unable to get aliases for room")
 	}
 
 	// Check if any of the members in the room match the appservice
-	return s.appserviceJoinedAtEvent(ctx, event, appservice)
+	return appserviceJoinedRoom(ctx, rsAPI, roomID, appservice)
 }
 
-// appserviceJoinedAtEvent returns a boolean depending on whether a given
-// appservice has membership at the time a given event was created.
-func (s *OutputRoomEventConsumer) appserviceJoinedAtEvent(ctx context.Context, event *gomatrixserverlib.HeaderedEvent, appservice *config.ApplicationService) bool {
+// appserviceJoinedRoom returns a boolean depending on whether a given
+// appservice has a user joined to the given room.
+func appserviceJoinedRoom(ctx context.Context, rsAPI api.AppserviceRoomserverAPI, roomID string, appservice *config.ApplicationService) bool {
 	// TODO: This is only checking the current room state, not the state at
 	// the event in question. Pretty sure this is what Synapse does too, but
 	// until we have a lighter way of checking the state before the event that
 	// doesn't involve state res, then this is probably OK.
 	membershipReq := &api.QueryMembershipsForRoomRequest{
-		RoomID:     event.RoomID(),
+		RoomID:     roomID,
 		JoinedOnly: true,
 	}
 	membershipRes := &api.QueryMembershipsForRoomResponse{}
 
 	// XXX: This could potentially race if the state for the event is not known yet
 	// e.g. the event came over federation but we do not have the full state persisted.
-	if err := s.rsAPI.QueryMembershipsForRoom(ctx, membershipReq, membershipRes); err == nil {
+	if err := rsAPI.QueryMembershipsForRoom(ctx, membershipReq, membershipRes); err == nil {
 		for _, ev := range membershipRes.JoinEvents {
 			switch {
 			case ev.StateKey == nil:
@@ -304,7 +327,7 @@ func (s *OutputRoomEventConsumer) appserviceJoinedAtEvent(ctx context.Context, e
 	} else {
 		log.WithFields(log.Fields{
 			"appservice": appservice.ID,
-			"room_id":    event.RoomID(),
+			"room_id":    roomID,
 		}).WithError(err).Errorf("Unable to get membership for room")
 	}
 	return false
@@ -0,0 +1,381 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+var (
+	transactionsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "appservice",
+			Name:      "transactions_sent",
+			Help:      "Number of transactions accepted by each application service",
+		},
+		[]string{"appservice"},
+	)
+	transactionFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "appservice",
+			Name:      "transaction_failures",
+			Help:      "Number of failed attempts to send a transaction to each application service",
+		},
+		[]string{"appservice"},
+	)
+	transactionBacklog = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dendrite",
+			Subsystem: "appservice",
+			Name:      "transaction_backlog",
+			Help:      "Number of transactions waiting to be sent to each application service",
+		},
+		[]string{"appservice"},
+	)
+	transactionBackoff = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dendrite",
+			Subsystem: "appservice",
+			Name:      "transaction_backoff_seconds",
+			Help:      "How long we are waiting before retrying a failed transaction to each application service",
+		},
+		[]string{"appservice"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		transactionsSent, transactionFailures, transactionBacklog, transactionBackoff,
+	)
+}
+
+// maxBackoff is the highest exponent for the backoff, so that we retry
+// at least every 2^6 = 64 seconds.
+const maxBackoff = 6
+
+// defaultMaxBacklog is the most transactions which may be waiting to be sent
+// to an application service. Once it is reached, new events are left in
+// JetStream until the application service catches up.
+const defaultMaxBacklog = 1000
+
+// backlogFullDelay is how long to hold on to events for an application
+// service whose backlog is full before refusing them, so that JetStream
+// doesn't redeliver them straight away.
+const backlogFullDelay = 5 * time.Second
+
+var (
+	errBacklogFull = errors.New("the appservice backlog is full")
+	errBackingOff  = errors.New("backing off from the appservice")
+)
+
+// TransactionQueue stores the transactions of events for each application
+// service in the database, and sends them in order, retrying each one with
+// the same transaction ID until the application service accepts it. This
+// means that the consumers don't have to wait for application services which
+// are slow or offline, and that transactions which weren't sent before a
+// restart are sent afterwards with the same transaction IDs. Ephemeral data
+// isn't worth keeping, so is sent straight away with SendEphemeral instead.
+type TransactionQueue struct {
+	db            storage.Database
+	client        *http.Client
+	cfg           *config.AppServiceAPI
+	maxBacklog    int64
+	ephemeralTxns uint64 // atomic, for ephemeral transaction IDs
+	mutex         sync.Mutex
+	senders       map[string]*transactionSender
+}
+
+// transactionSender sends the backlog of a single application service.
+type transactionSender struct {
+	queue        *TransactionQueue
+	appserviceID string
+	notify       chan struct{} // a new transaction was added
+	retry        chan struct{} // retry straight away, the application service is back
+	mutex        sync.Mutex
+	status       api.AppserviceStatus // without the backlog, which is in the database
+	backoff      int
+}
+
+// NewTransactionQueue creates a new TransactionQueue. Call StartAppservice
+// to begin sending to each application service.
+func NewTransactionQueue(
+	db storage.Database, client *http.Client, cfg *config.AppServiceAPI,
+) *TransactionQueue {
+	return &TransactionQueue{
+		db:         db,
+		client:     client,
+		cfg:        cfg,
+		maxBacklog: defaultMaxBacklog,
+		senders:    map[string]*transactionSender{},
+	}
+}
+
+// Enqueue adds a transaction to the backlog of the application service.
+// Once this returns, the transaction will be sent even if we restart. If the
+// backlog is full, an error is returned after a short delay, so that the
+// caller can leave the events in JetStream to be delivered again later.
+func (q *TransactionQueue) Enqueue(ctx context.Context, appserviceID string, transaction []byte) error {
+	backlog, err := q.db.GetBacklogSize(ctx, appserviceID)
+	if err != nil {
+		return err
+	}
+	if backlog >= q.maxBacklog {
+		log.WithField("appservice", appserviceID).Warnf("Appservice backlog has %d transactions, holding back new events", backlog)
+		wait(ctx, backlogFullDelay)
+		return errBacklogFull
+	}
+	if _, err = q.db.StoreTransaction(ctx, appserviceID, transaction); err != nil {
+		return err
+	}
+	transactionBacklog.WithLabelValues(appserviceID).Inc()
+	if sender := q.sender(appserviceID); sender != nil {
+		select {
+		case sender.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SendEphemeral sends a transaction straight to the application service,
+// without adding it to the backlog. It isn't retried, and isn't sent at all
+// while we are backing off from the application service.
+func (q *TransactionQueue) SendEphemeral(ctx context.Context, appserviceID string, transaction []byte) error {
+	appservice, ok := q.appservice(appserviceID)
+	if !ok {
+		return nil
+	}
+	if sender := q.sender(appserviceID); sender != nil {
+		sender.mutex.Lock()
+		backingOff := sender.status.BackoffMS > 0
+		sender.mutex.Unlock()
+		if backingOff {
+			return errBackingOff
+		}
+	}
+	// The transaction IDs of the backlog are numbers, so these can't clash
+	// with them, and the time keeps them unique across restarts.
+	txnID := fmt.Sprintf("ephemeral_%d_%d", time.Now().UnixNano(), atomic.AddUint64(&q.ephemeralTxns, 1))
+	if err := sendTransaction(ctx, q.client, appservice, txnID, transaction); err != nil {
+		transactionFailures.WithLabelValues(appserviceID).Inc()
+		return err
+	}
+	transactionsSent.WithLabelValues(appserviceID).Inc()
+	return nil
+}
+
+// StartAppservice starts sending the backlog of the application service,
+// until the given context expires.
+func (q *TransactionQueue) StartAppservice(ctx context.Context, appserviceID string) error {
+	backlog, err := q.db.GetBacklogSize(ctx, appserviceID)
+	if err != nil {
+		return err
+	}
+	transactionBacklog.WithLabelValues(appserviceID).Set(float64(backlog))
+	sender := &transactionSender{
+		queue:        q,
+		appserviceID: appserviceID,
+		notify:       make(chan struct{}, 1),
+		retry:        make(chan struct{}, 1),
+		status:       api.AppserviceStatus{ID: appserviceID},
+	}
+	q.mutex.Lock()
+	q.senders[appserviceID] = sender
+	q.mutex.Unlock()
+	go sender.run(ctx)
+	return nil
+}
+
+// DeleteAppservice throws away the backlog of an application service which
+// no longer exists. The sender should have been stopped first.
+func (q *TransactionQueue) DeleteAppservice(appserviceID string) error {
+	transactionsSent.DeleteLabelValues(appserviceID)
+	transactionFailures.DeleteLabelValues(appserviceID)
+	transactionBacklog.DeleteLabelValues(appserviceID)
+	transactionBackoff.DeleteLabelValues(appserviceID)
+	return q.db.RemoveBacklog(context.Background(), appserviceID)
+}
+
+// Retry makes the sender for the application service stop backing off and
+// try again straight away, for when we know that it's reachable again.
+func (q *TransactionQueue) Retry(appserviceID string) {
+	if sender := q.sender(appserviceID); sender != nil {
+		select {
+		case sender.retry <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Status returns the delivery status of the application service, or false
+// if we aren't sending to it.
+func (q *TransactionQueue) Status(ctx context.Context, appserviceID string) (api.AppserviceStatus, bool, error) {
+	sender := q.sender(appserviceID)
+	if sender == nil {
+		return api.AppserviceStatus{}, false, nil
+	}
+	sender.mutex.Lock()
+	status := sender.status
+	sender.mutex.Unlock()
+	backlog, err := q.db.GetBacklogSize(ctx, appserviceID)
+	if err != nil {
+		return status, false, err
+	}
+	status.Backlog = backlog
+	return status, true, nil
+}
+
+func (q *TransactionQueue) sender(appserviceID string) *transactionSender {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.senders[appserviceID]
+}
+
+// appservice returns the current registration of the application service.
+func (q *TransactionQueue) appservice(appserviceID string) (*config.ApplicationService, bool) {
+	for _, as := range q.cfg.Derived.AppServices() {
+		if as.ID == appserviceID {
+			return &as, true
+		}
+	}
+	return nil, false
+}
+
+func (s *transactionSender) run(ctx context.Context) {
+	defer func() {
+		s.queue.mutex.Lock()
+		if s.queue.senders[s.appserviceID] == s {
+			delete(s.queue.senders, s.appserviceID)
+		}
+		s.queue.mutex.Unlock()
+	}()
+	for {
+		var backoff time.Duration
+		txnID, transaction, err := s.queue.db.GetOldestTransaction(ctx, s.appserviceID)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			backoff = s.failed(err)
+		case txnID == 0:
+			// The backlog is empty, so wait for something to send.
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			case <-s.retry:
+			}
+			continue
+		default:
+			appservice, ok := s.queue.appservice(s.appserviceID)
+			if !ok {
+				// The application service is being removed.
+				return
+			}
+			if err = sendTransaction(ctx, s.queue.client, appservice, strconv.FormatInt(txnID, 10), transaction); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				backoff = s.failed(err)
+				break
+			}
+			s.succeeded()
+			if err = s.queue.db.RemoveTransaction(ctx, txnID); err != nil {
+				// We'll send the same transaction again, which is safe as the
+				// application service will recognise the transaction ID.
+				backoff = s.failed(err)
+			}
+		}
+		if backoff == 0 {
+			continue
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.retry:
+			timer.Stop()
+			s.resetBackoff()
+		case <-timer.C:
+		}
+	}
+}
+
+// succeeded records that the application service accepted a transaction.
+func (s *transactionSender) succeeded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.LastSuccessTS = gomatrixserverlib.AsTimestamp(time.Now())
+	s.status.BackoffMS, s.status.RetryTS, s.backoff = 0, 0, 0
+	transactionsSent.WithLabelValues(s.appserviceID).Inc()
+	transactionBacklog.WithLabelValues(s.appserviceID).Dec()
+	transactionBackoff.WithLabelValues(s.appserviceID).Set(0)
+}
+
+// failed records the error and works out how long to back off for, which
+// doubles with each consecutive failure.
+func (s *transactionSender) failed(err error) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.backoff < maxBackoff {
+		s.backoff++
+	}
+	duration := time.Second * time.Duration(math.Pow(2, float64(s.backoff)))
+	now := time.Now()
+	s.status.LastErrorTS = gomatrixserverlib.AsTimestamp(now)
+	s.status.LastError = err.Error()
+	s.status.BackoffMS = duration.Milliseconds()
+	s.status.RetryTS = gomatrixserverlib.AsTimestamp(now.Add(duration))
+	transactionFailures.WithLabelValues(s.appserviceID).Inc()
+	transactionBackoff.WithLabelValues(s.appserviceID).Set(duration.Seconds())
+	log.WithField("appservice", s.appserviceID).WithError(err).Errorf("Unable to send transaction to appservice, backing off for %s", duration.String())
+	return duration
+}
+
+func (s *transactionSender) resetBackoff() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.BackoffMS, s.status.RetryTS, s.backoff = 0, 0, 0
+	transactionBackoff.WithLabelValues(s.appserviceID).Set(0)
+}
+
+// wait blocks for the given duration, or until the context expires.
+func wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
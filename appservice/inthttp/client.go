@@ -13,6 +13,15 @@ import (
 const (
 	AppServiceRoomAliasExistsPath = "/appservice/RoomAliasExists"
 	AppServiceUserIDExistsPath    = "/appservice/UserIDExists"
+	AppServiceProtocolsPath       = "/appservice/Protocols"
+	AppServiceLocationsPath       = "/appservice/Locations"
+	AppServiceUserPath            = "/appservice/User"
+
+	AppServicePerformRegisterAppservicePath = "/appservice/PerformRegisterAppservice"
+	AppServicePerformRemoveAppservicePath   = "/appservice/PerformRemoveAppservice"
+	AppServiceQueryAppservicesPath          = "/appservice/QueryAppservices"
+	AppServicePerformAppservicePingPath     = "/appservice/PerformAppservicePing"
+	AppServiceQueryAppserviceStatusPath     = "/appservice/QueryAppserviceStatus"
 )
 
 // httpAppServiceQueryAPI contains the URL to an appservice query API and a
@@ -58,3 +67,99 @@ func (h *httpAppServiceQueryAPI) UserIDExists(
 		h.httpClient, ctx, request, response,
 	)
 }
+
+// Protocols implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) Protocols(
+	ctx context.Context,
+	request *api.ProtocolRequest,
+	response *api.ProtocolResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"Protocols", h.appserviceURL+AppServiceProtocolsPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// Locations implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) Locations(
+	ctx context.Context,
+	request *api.LocationRequest,
+	response *api.LocationResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"Locations", h.appserviceURL+AppServiceLocationsPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// User implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) User(
+	ctx context.Context,
+	request *api.UserRequest,
+	response *api.UserResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"User", h.appserviceURL+AppServiceUserPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// PerformRegisterAppservice implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) PerformRegisterAppservice(
+	ctx context.Context,
+	request *api.PerformRegisterAppserviceRequest,
+	response *api.PerformRegisterAppserviceResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformRegisterAppservice", h.appserviceURL+AppServicePerformRegisterAppservicePath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// PerformRemoveAppservice implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) PerformRemoveAppservice(
+	ctx context.Context,
+	request *api.PerformRemoveAppserviceRequest,
+	response *api.PerformRemoveAppserviceResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformRemoveAppservice", h.appserviceURL+AppServicePerformRemoveAppservicePath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// QueryAppservices implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) QueryAppservices(
+	ctx context.Context,
+	request *api.QueryAppservicesRequest,
+	response *api.QueryAppservicesResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryAppservices", h.appserviceURL+AppServiceQueryAppservicesPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// PerformAppservicePing implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) PerformAppservicePing(
+	ctx context.Context,
+	request *api.PerformAppservicePingRequest,
+	response *api.PerformAppservicePingResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformAppservicePing", h.appserviceURL+AppServicePerformAppservicePingPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+// QueryAppserviceStatus implements AppServiceQueryAPI
+func (h *httpAppServiceQueryAPI) QueryAppserviceStatus(
+	ctx context.Context,
+	request *api.QueryAppserviceStatusRequest,
+	response *api.QueryAppserviceStatusResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryAppserviceStatus", h.appserviceURL+AppServiceQueryAppserviceStatusPath,
+		h.httpClient, ctx, request, response,
+	)
+}
@@ -17,4 +17,44 @@ func AddRoutes(a api.AppServiceInternalAPI, internalAPIMux *mux.Router) {
 		AppServiceUserIDExistsPath,
 		httputil.MakeInternalRPCAPI("AppserviceUserIDExists", a.UserIDExists),
 	)
+
+	internalAPIMux.Handle(
+		AppServiceProtocolsPath,
+		httputil.MakeInternalRPCAPI("AppserviceProtocols", a.Protocols),
+	)
+
+	internalAPIMux.Handle(
+		AppServiceLocationsPath,
+		httputil.MakeInternalRPCAPI("AppserviceLocations", a.Locations),
+	)
+
+	internalAPIMux.Handle(
+		AppServiceUserPath,
+		httputil.MakeInternalRPCAPI("AppserviceUser", a.User),
+	)
+
+	internalAPIMux.Handle(
+		AppServicePerformRegisterAppservicePath,
+		httputil.MakeInternalRPCAPI("AppservicePerformRegisterAppservice", a.PerformRegisterAppservice),
+	)
+
+	internalAPIMux.Handle(
+		AppServicePerformRemoveAppservicePath,
+		httputil.MakeInternalRPCAPI("AppservicePerformRemoveAppservice", a.PerformRemoveAppservice),
+	)
+
+	internalAPIMux.Handle(
+		AppServiceQueryAppservicesPath,
+		httputil.MakeInternalRPCAPI("AppserviceQueryAppservices", a.QueryAppservices),
+	)
+
+	internalAPIMux.Handle(
+		AppServicePerformAppservicePingPath,
+		httputil.MakeInternalRPCAPI("AppservicePerformAppservicePing", a.PerformAppservicePing),
+	)
+
+	internalAPIMux.Handle(
+		AppServiceQueryAppserviceStatusPath,
+		httputil.MakeInternalRPCAPI("AppserviceQueryAppserviceStatus", a.QueryAppserviceStatus),
+	)
 }
@@ -18,22 +18,37 @@ package query
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/appservice/consumers"
+	"github.com/matrix-org/dendrite/appservice/storage"
 	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/nats-io/nats.go"
 	opentracing "github.com/opentracing/opentracing-go"
 	log "github.com/sirupsen/logrus"
 )
 
 const roomAliasExistsPath = "/rooms/"
 const userIDExistsPath = "/users/"
+const protocolPath = "/_matrix/app/v1/thirdparty/protocol/"
+const locationPath = "/_matrix/app/v1/thirdparty/location"
+const userPath = "/_matrix/app/v1/thirdparty/user"
 
 // AppServiceQueryAPI is an implementation of api.AppServiceQueryAPI
 type AppServiceQueryAPI struct {
-	HTTPClient *http.Client
-	Cfg        *config.AppServiceAPI
+	HTTPClient         *http.Client
+	Cfg                *config.AppServiceAPI
+	DB                 storage.Database
+	UserAPI            userapi.AppserviceUserAPI
+	JetStream          nats.JetStreamContext
+	Transactions       *consumers.TransactionQueue
+	registrationsMutex sync.Mutex
 }
 
 // RoomAliasExists performs a request to '/room/{roomAlias}' on all known
@@ -47,7 +62,7 @@ func (a *AppServiceQueryAPI) RoomAliasExists(
 	defer span.Finish()
 
 	// Determine which application service should handle this request
-	for _, appservice := range a.Cfg.Derived.ApplicationServices {
+	for _, appservice := range a.Cfg.Derived.AppServices() {
 		if appservice.URL != "" && appservice.IsInterestedInRoomAlias(request.Alias) {
 			// The full path to the rooms API, includes hs token
 			URL, err := url.Parse(appservice.URL + roomAliasExistsPath)
@@ -114,7 +129,7 @@ func (a *AppServiceQueryAPI) UserIDExists(
 	defer span.Finish()
 
 	// Determine which application service should handle this request
-	for _, appservice := range a.Cfg.Derived.ApplicationServices {
+	for _, appservice := range a.Cfg.Derived.AppServices() {
 		if appservice.URL != "" && appservice.IsInterestedInUserID(request.UserID) {
 			// The full path to the rooms API, includes hs token
 			URL, err := url.Parse(appservice.URL + userIDExistsPath)
@@ -165,3 +180,166 @@ func (a *AppServiceQueryAPI) UserIDExists(
 	response.UserIDExists = false
 	return nil
 }
+
+// Protocols queries '/thirdparty/protocol/{protocol}' on all application
+// services which provide the requested protocol, or every protocol if none
+// was requested, and merges the instances returned by each of them.
+func (a *AppServiceQueryAPI) Protocols(
+	ctx context.Context,
+	request *api.ProtocolRequest,
+	response *api.ProtocolResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApplicationServiceProtocols")
+	defer span.Finish()
+
+	response.Protocols = map[string]api.ASProtocolResponse{}
+	for _, appservice := range a.Cfg.Derived.AppServices() {
+		if appservice.URL == "" {
+			continue
+		}
+		for _, protocol := range appservice.Protocols {
+			if request.Protocol != "" && protocol != request.Protocol {
+				continue
+			}
+			var proto api.ASProtocolResponse
+			apiURL := appservice.URL + protocolPath + url.PathEscape(protocol)
+			if err := a.requestDo(ctx, &appservice, apiURL, "", &proto); err != nil {
+				log.WithFields(log.Fields{
+					"appservice_id": appservice.ID,
+					"protocol":      protocol,
+				}).WithError(err).Warn("Unable to query protocol on application service")
+				continue
+			}
+			for i := range proto.Instances {
+				proto.Instances[i].InstanceID = api.ThirdPartyInstanceID(appservice.ID, proto.Instances[i].NetworkID)
+			}
+			if existing, ok := response.Protocols[protocol]; ok {
+				existing.Instances = append(existing.Instances, proto.Instances...)
+				response.Protocols[protocol] = existing
+				continue
+			}
+			if proto.Instances == nil {
+				proto.Instances = []api.ProtocolInstance{}
+			}
+			response.Protocols[protocol] = proto
+		}
+	}
+
+	response.Exists = len(response.Protocols) > 0
+	return nil
+}
+
+// Locations queries '/thirdparty/location[/{protocol}]' on all application
+// services which provide the requested protocol, or all of them providing
+// any protocol if none was requested.
+func (a *AppServiceQueryAPI) Locations(
+	ctx context.Context,
+	request *api.LocationRequest,
+	response *api.LocationResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApplicationServiceLocations")
+	defer span.Finish()
+
+	path := locationPath
+	if request.Protocol != "" {
+		path += "/" + url.PathEscape(request.Protocol)
+	}
+
+	response.Locations = []api.ASLocationResponse{}
+	for _, appservice := range a.thirdPartyAppservices(request.Protocol) {
+		response.Exists = true
+		var locations []api.ASLocationResponse
+		if err := a.requestDo(ctx, &appservice, appservice.URL+path, request.Params, &locations); err != nil {
+			log.WithFields(log.Fields{
+				"appservice_id": appservice.ID,
+				"protocol":      request.Protocol,
+			}).WithError(err).Warn("Unable to query locations on application service")
+			continue
+		}
+		response.Locations = append(response.Locations, locations...)
+	}
+
+	return nil
+}
+
+// User queries '/thirdparty/user[/{protocol}]' on all application services
+// which provide the requested protocol, or all of them providing any
+// protocol if none was requested.
+func (a *AppServiceQueryAPI) User(
+	ctx context.Context,
+	request *api.UserRequest,
+	response *api.UserResponse,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApplicationServiceUser")
+	defer span.Finish()
+
+	path := userPath
+	if request.Protocol != "" {
+		path += "/" + url.PathEscape(request.Protocol)
+	}
+
+	response.Users = []api.ASUserResponse{}
+	for _, appservice := range a.thirdPartyAppservices(request.Protocol) {
+		response.Exists = true
+		var users []api.ASUserResponse
+		if err := a.requestDo(ctx, &appservice, appservice.URL+path, request.Params, &users); err != nil {
+			log.WithFields(log.Fields{
+				"appservice_id": appservice.ID,
+				"protocol":      request.Protocol,
+			}).WithError(err).Warn("Unable to query users on application service")
+			continue
+		}
+		response.Users = append(response.Users, users...)
+	}
+
+	return nil
+}
+
+// thirdPartyAppservices returns the application services which provide the
+// given protocol, or any protocol at all if the protocol is empty.
+func (a *AppServiceQueryAPI) thirdPartyAppservices(protocol string) []config.ApplicationService {
+	var appservices []config.ApplicationService
+	for _, appservice := range a.Cfg.Derived.AppServices() {
+		if appservice.URL == "" || len(appservice.Protocols) == 0 {
+			continue
+		}
+		if protocol == "" {
+			appservices = append(appservices, appservice)
+			continue
+		}
+		for _, p := range appservice.Protocols {
+			if p == protocol {
+				appservices = append(appservices, appservice)
+				break
+			}
+		}
+	}
+	return appservices
+}
+
+// requestDo performs a GET request against an application service, passing
+// the given query parameters along with the homeserver token, and decodes
+// the JSON response.
+func (a *AppServiceQueryAPI) requestDo(
+	ctx context.Context, appservice *config.ApplicationService,
+	apiURL, params string, response interface{},
+) error {
+	query, err := url.ParseQuery(params)
+	if err != nil {
+		return err
+	}
+	query.Set("access_token", appservice.HSToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received HTTP status code %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
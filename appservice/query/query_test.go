@@ -0,0 +1,347 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/test/testrig"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestProtocols(t *testing.T) {
+	newServer := func(networkID string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("access_token") != "hs_token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			switch r.URL.Path {
+			case "/_matrix/app/v1/thirdparty/protocol/irc":
+				_ = json.NewEncoder(w).Encode(api.ASProtocolResponse{
+					UserFields:     []string{"network", "nickname"},
+					LocationFields: []string{"network", "channel"},
+					Instances: []api.ProtocolInstance{
+						{Description: networkID, NetworkID: networkID},
+					},
+				})
+			case "/_matrix/app/v1/thirdparty/location/irc":
+				_ = json.NewEncoder(w).Encode([]api.ASLocationResponse{
+					{Alias: "#" + r.URL.Query().Get("channel") + "_" + networkID + ":test", Protocol: "irc"},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+	freenode, libera := newServer("freenode"), newServer("libera")
+	defer freenode.Close()
+	defer libera.Close()
+
+	asAPI := &AppServiceQueryAPI{
+		HTTPClient: http.DefaultClient,
+		Cfg: &config.AppServiceAPI{
+			Derived: &config.Derived{
+				ApplicationServices: []config.ApplicationService{
+					{ID: "irc_freenode", URL: freenode.URL, HSToken: "hs_token", Protocols: []string{"irc"}},
+					{ID: "irc_libera", URL: libera.URL, HSToken: "hs_token", Protocols: []string{"irc"}},
+					{ID: "other", URL: libera.URL, HSToken: "hs_token"},
+				},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	var protocols api.ProtocolResponse
+	if err := asAPI.Protocols(ctx, &api.ProtocolRequest{}, &protocols); err != nil {
+		t.Fatal(err)
+	}
+	if !protocols.Exists || len(protocols.Protocols) != 1 {
+		t.Fatalf("expected one protocol, got %+v", protocols)
+	}
+	instances := protocols.Protocols["irc"].Instances
+	if len(instances) != 2 {
+		t.Fatalf("expected instances from both application services, got %+v", instances)
+	}
+	wantIDs := map[string]bool{"irc_freenode|freenode": true, "irc_libera|libera": true}
+	for _, instance := range instances {
+		if !wantIDs[instance.InstanceID] {
+			t.Errorf("unexpected instance ID %q", instance.InstanceID)
+		}
+	}
+
+	protocols = api.ProtocolResponse{}
+	if err := asAPI.Protocols(ctx, &api.ProtocolRequest{Protocol: "slack"}, &protocols); err != nil {
+		t.Fatal(err)
+	}
+	if protocols.Exists {
+		t.Fatalf("expected unknown protocol to not exist, got %+v", protocols)
+	}
+
+	var locations api.LocationResponse
+	if err := asAPI.Locations(ctx, &api.LocationRequest{Protocol: "irc", Params: "channel=dendrite"}, &locations); err != nil {
+		t.Fatal(err)
+	}
+	if !locations.Exists || len(locations.Locations) != 2 {
+		t.Fatalf("expected locations from both application services, got %+v", locations)
+	}
+	if locations.Locations[0].Alias != "#dendrite_freenode:test" {
+		t.Errorf("unexpected alias %q", locations.Locations[0].Alias)
+	}
+}
+
+func TestParseThirdPartyInstanceID(t *testing.T) {
+	asID, networkID, err := api.ParseThirdPartyInstanceID(api.ThirdPartyInstanceID("irc", "freenode"))
+	if err != nil || asID != "irc" || networkID != "freenode" {
+		t.Fatalf("unexpected result %q %q %v", asID, networkID, err)
+	}
+	for _, invalid := range []string{"", "irc", "|freenode", "irc|"} {
+		if _, _, err = api.ParseThirdPartyInstanceID(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+type fakeAppserviceUserAPI struct {
+	userapi.AppserviceUserAPI
+	accounts       []string
+	existing       map[string]*userapi.Account
+	deletedDevices []string
+}
+
+func (f *fakeAppserviceUserAPI) QueryAccountByLocalpart(ctx context.Context, req *userapi.QueryAccountByLocalpartRequest, res *userapi.QueryAccountByLocalpartResponse) error {
+	res.Account = f.existing[req.Localpart]
+	return nil
+}
+
+func (f *fakeAppserviceUserAPI) PerformAccountCreation(ctx context.Context, req *userapi.PerformAccountCreationRequest, res *userapi.PerformAccountCreationResponse) error {
+	f.accounts = append(f.accounts, req.Localpart)
+	f.existing[req.Localpart] = &userapi.Account{Localpart: req.Localpart, AccountType: req.AccountType, AppServiceID: req.AppServiceID}
+	return nil
+}
+
+func (f *fakeAppserviceUserAPI) PerformDeviceCreation(ctx context.Context, req *userapi.PerformDeviceCreationRequest, res *userapi.PerformDeviceCreationResponse) error {
+	return nil
+}
+
+func (f *fakeAppserviceUserAPI) PerformDeviceDeletion(ctx context.Context, req *userapi.PerformDeviceDeletionRequest, res *userapi.PerformDeviceDeletionResponse) error {
+	f.deletedDevices = append(f.deletedDevices, req.UserID)
+	return nil
+}
+
+func TestRuntimeRegistrations(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, close := testrig.CreateBaseDendrite(t, dbType)
+		defer close()
+		js, _ := base.NATS.Prepare(base.ProcessContext, &base.Cfg.Global.JetStream)
+		defer jetstream.DeleteAllStreams(js, &base.Cfg.Global.JetStream)
+		db, err := storage.NewDatabase(base, &base.Cfg.AppServiceAPI.Database)
+		if err != nil {
+			t.Fatalf("failed to create database: %s", err)
+		}
+		userAPI := &fakeAppserviceUserAPI{existing: map[string]*userapi.Account{
+			"alice": {Localpart: "alice", AccountType: userapi.AccountTypeUser},
+		}}
+		asAPI := &AppServiceQueryAPI{
+			Cfg:       &base.Cfg.AppServiceAPI,
+			DB:        db,
+			UserAPI:   userAPI,
+			JetStream: js,
+		}
+		ctx := context.Background()
+		registration := `
+url: http://localhost:9000
+as_token: as_token
+hs_token: hs_token
+sender_localpart: ircbot
+namespaces:
+  users:
+    - exclusive: true
+      regex: "@irc_.*"
+`
+		register := func(id, registration string) string {
+			t.Helper()
+			res := &api.PerformRegisterAppserviceResponse{}
+			if err := asAPI.PerformRegisterAppservice(ctx, &api.PerformRegisterAppserviceRequest{
+				AppserviceID: id,
+				Registration: []byte(registration),
+			}, res); err != nil {
+				t.Fatalf("PerformRegisterAppservice failed: %s", err)
+			}
+			return res.Error
+		}
+		exclusive := func(userID string) bool {
+			return base.Cfg.Derived.ExclusiveAppServiceUsernameRegexp().MatchString(userID)
+		}
+
+		if errStr := register("irc", registration); errStr != "" {
+			t.Fatalf("failed to register appservice: %s", errStr)
+		}
+		if !exclusive("@irc_alice:test") {
+			t.Fatalf("expected the namespace to be exclusive after registering")
+		}
+		if len(userAPI.accounts) != 1 || userAPI.accounts[0] != "ircbot" {
+			t.Fatalf("expected the bot account to be created, got %v", userAPI.accounts)
+		}
+		queryRes := &api.QueryAppservicesResponse{}
+		if err = asAPI.QueryAppservices(ctx, &api.QueryAppservicesRequest{}, queryRes); err != nil {
+			t.Fatalf("QueryAppservices failed: %s", err)
+		}
+		if len(queryRes.Appservices) != 1 || queryRes.Appservices[0].ID != "irc" || !queryRes.Appservices[0].Runtime {
+			t.Fatalf("unexpected appservices: %+v", queryRes.Appservices)
+		}
+
+		// Mismatched IDs, clashing tokens and bad regexes are refused.
+		if errStr := register("irc", "id: slack\n"+registration); errStr == "" {
+			t.Fatalf("expected mismatched ID to be refused")
+		}
+		if errStr := register("slack", registration); errStr == "" {
+			t.Fatalf("expected duplicate token to be refused")
+		}
+		if errStr := register("irc", strings.Replace(registration, "@irc_.*", "@irc_(", 1)); errStr == "" {
+			t.Fatalf("expected bad regex to be refused")
+		}
+
+		// The bot user can't be an existing account, or another appservice's.
+		if errStr := register("irc", strings.Replace(registration, "sender_localpart: ircbot", "sender_localpart: alice", 1)); errStr == "" {
+			t.Fatalf("expected an existing user as the sender to be refused")
+		}
+		slack := strings.NewReplacer("as_token: as_token", "as_token: slack_as", "hs_token: hs_token", "hs_token: slack_hs", "@irc_.*", "@slack_.*").Replace(registration)
+		if errStr := register("slack", slack); errStr == "" {
+			t.Fatalf("expected another appservice's sender to be refused")
+		}
+		if len(userAPI.accounts) != 1 {
+			t.Fatalf("expected no more accounts to be created, got %v", userAPI.accounts)
+		}
+
+		// Changing the token logs out the old bot device.
+		if errStr := register("irc", strings.Replace(registration, "as_token: as_token", "as_token: new_token", 1)); errStr != "" {
+			t.Fatalf("failed to update appservice: %s", errStr)
+		}
+		if len(userAPI.deletedDevices) != 1 || userAPI.deletedDevices[0] != "@ircbot:test" {
+			t.Fatalf("expected the old bot device to be deleted, got %v", userAPI.deletedDevices)
+		}
+		appservices, err := asAPI.LoadRegistrations(ctx)
+		if err != nil {
+			t.Fatalf("LoadRegistrations failed: %s", err)
+		}
+		if len(appservices) != 1 || appservices[0].ID != "irc" || appservices[0].ASToken != "new_token" {
+			t.Fatalf("unexpected stored registrations: %+v", appservices)
+		}
+
+		remove := func(id string) bool {
+			t.Helper()
+			res := &api.PerformRemoveAppserviceResponse{}
+			if err := asAPI.PerformRemoveAppservice(ctx, &api.PerformRemoveAppserviceRequest{
+				AppserviceID: id,
+			}, res); err != nil {
+				t.Fatalf("PerformRemoveAppservice failed: %s", err)
+			}
+			return res.Removed
+		}
+		if !remove("irc") {
+			t.Fatalf("expected appservice to be removed")
+		}
+		if exclusive("@irc_alice:test") {
+			t.Fatalf("expected the namespace to be released after removing")
+		}
+		if remove("irc") {
+			t.Fatalf("expected unknown appservice not to be removed")
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	var txnID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/_matrix/app/v1/ping" || r.Method != http.MethodPost:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Header.Get("Authorization") != "Bearer hs_token":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errcode":"M_FORBIDDEN"}`))
+		default:
+			var body struct {
+				TransactionID string `json:"transaction_id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			txnID = body.TransactionID
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	asAPI := &AppServiceQueryAPI{
+		HTTPClient: &http.Client{Timeout: 100 * time.Millisecond},
+		Cfg: &config.AppServiceAPI{
+			Derived: &config.Derived{
+				ApplicationServices: []config.ApplicationService{
+					{ID: "ok", URL: srv.URL, HSToken: "hs_token"},
+					{ID: "badtoken", URL: srv.URL, HSToken: "wrong"},
+					{ID: "slow", URL: slow.URL, HSToken: "hs_token"},
+					{ID: "closed", URL: closed.URL, HSToken: "hs_token"},
+					{ID: "nourl", HSToken: "hs_token"},
+				},
+			},
+		},
+	}
+	ping := func(id string) *api.PerformAppservicePingResponse {
+		t.Helper()
+		res := &api.PerformAppservicePingResponse{}
+		if err := asAPI.PerformAppservicePing(context.Background(), &api.PerformAppservicePingRequest{
+			AppserviceID:  id,
+			TransactionID: "txn",
+		}, res); err != nil {
+			t.Fatalf("PerformAppservicePing failed: %s", err)
+		}
+		return res
+	}
+
+	if res := ping("ok"); res.ErrCode != "" || txnID != "txn" {
+		t.Fatalf("expected a successful ping with the transaction ID, got %+v (%q)", res, txnID)
+	}
+	if res := ping("badtoken"); res.ErrCode != api.PingErrBadStatus || res.StatusCode != http.StatusForbidden || res.Body != `{"errcode":"M_FORBIDDEN"}` {
+		t.Fatalf("expected M_BAD_STATUS, got %+v", res)
+	}
+	if res := ping("slow"); res.ErrCode != api.PingErrConnectionTimeout {
+		t.Fatalf("expected M_CONNECTION_TIMEOUT, got %+v", res)
+	}
+	if res := ping("closed"); res.ErrCode != api.PingErrConnectionFailed {
+		t.Fatalf("expected M_CONNECTION_FAILED, got %+v", res)
+	}
+	if res := ping("nourl"); res.ErrCode != api.PingErrURLNotSet {
+		t.Fatalf("expected M_URL_NOT_SET, got %+v", res)
+	}
+	if res := ping("unknown"); res.ErrCode != api.PingErrNotFound {
+		t.Fatalf("expected M_NOT_FOUND, got %+v", res)
+	}
+}
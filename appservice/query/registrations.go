@@ -0,0 +1,266 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// PerformRegisterAppservice registers or updates an application service at
+// runtime, storing the registration in the database.
+func (a *AppServiceQueryAPI) PerformRegisterAppservice(
+	ctx context.Context,
+	req *api.PerformRegisterAppserviceRequest,
+	res *api.PerformRegisterAppserviceResponse,
+) error {
+	appservice, err := config.ParseApplicationService(req.Registration)
+	if err != nil {
+		res.Error = fmt.Sprintf("Failed to parse registration: %s", err)
+		return nil
+	}
+	switch appservice.ID {
+	case "":
+		appservice.ID = req.AppserviceID
+	case req.AppserviceID:
+	default:
+		res.Error = fmt.Sprintf("Registration ID %q does not match %q", appservice.ID, req.AppserviceID)
+		return nil
+	}
+	if a.Cfg.Derived.IsConfigAppService(appservice.ID) {
+		res.Error = fmt.Sprintf("Application service %q is registered in the config file", appservice.ID)
+		return nil
+	}
+
+	a.registrationsMutex.Lock()
+	defer a.registrationsMutex.Unlock()
+
+	appservices, err := a.LoadRegistrations(ctx)
+	if err != nil {
+		return err
+	}
+	var previous *config.ApplicationService
+	for i := range appservices {
+		if appservices[i].ID == appservice.ID {
+			previous = &config.ApplicationService{}
+			*previous = appservices[i]
+			appservices[i] = *appservice
+			break
+		}
+	}
+	if previous == nil {
+		appservices = append(appservices, *appservice)
+	}
+	if err = a.Cfg.VerifyRuntimeAppServices(appservices); err != nil {
+		res.Error = err.Error()
+		return nil
+	}
+
+	// The bot user gets a device with the as_token, so it mustn't be
+	// somebody else's account.
+	var accRes userapi.QueryAccountByLocalpartResponse
+	if err = a.UserAPI.QueryAccountByLocalpart(ctx, &userapi.QueryAccountByLocalpartRequest{
+		Localpart: appservice.SenderLocalpart,
+	}, &accRes); err != nil {
+		return err
+	}
+	if acc := accRes.Account; acc != nil && (acc.AccountType != userapi.AccountTypeAppService || acc.AppServiceID != appservice.ID) {
+		res.Error = fmt.Sprintf("User %q already exists and does not belong to application service %q", appservice.SenderLocalpart, appservice.ID)
+		return nil
+	}
+
+	// If the bot user or its token changed then the old device needs to
+	// go, otherwise the old token would still work.
+	if previous != nil && (previous.SenderLocalpart != appservice.SenderLocalpart || previous.ASToken != appservice.ASToken) {
+		if err = a.removeAppServiceDevice(ctx, previous); err != nil {
+			return err
+		}
+	}
+	if err = GenerateAppServiceAccount(ctx, a.UserAPI, *appservice); err != nil {
+		return fmt.Errorf("failed to generate bot account: %w", err)
+	}
+
+	registration, err := yaml.Marshal(appservice)
+	if err != nil {
+		return err
+	}
+	if err = a.DB.StoreRegistration(ctx, appservice.ID, registration); err != nil {
+		return err
+	}
+	return a.applyRegistrations(ctx, appservices)
+}
+
+// PerformRemoveAppservice removes an application service which was
+// registered at runtime.
+func (a *AppServiceQueryAPI) PerformRemoveAppservice(
+	ctx context.Context,
+	req *api.PerformRemoveAppserviceRequest,
+	res *api.PerformRemoveAppserviceResponse,
+) error {
+	if a.Cfg.Derived.IsConfigAppService(req.AppserviceID) {
+		res.Error = fmt.Sprintf("Application service %q is registered in the config file", req.AppserviceID)
+		return nil
+	}
+
+	a.registrationsMutex.Lock()
+	defer a.registrationsMutex.Unlock()
+
+	appservices, err := a.LoadRegistrations(ctx)
+	if err != nil {
+		return err
+	}
+	for i, appservice := range appservices {
+		if appservice.ID != req.AppserviceID {
+			continue
+		}
+		if err = a.DB.RemoveRegistration(ctx, appservice.ID); err != nil {
+			return err
+		}
+		if err = a.removeAppServiceDevice(ctx, &appservice); err != nil {
+			return err
+		}
+		res.Removed = true
+		return a.applyRegistrations(ctx, append(appservices[:i], appservices[i+1:]...))
+	}
+	return nil
+}
+
+// QueryAppservices lists all of the application services, both those from
+// the config files and those registered at runtime.
+func (a *AppServiceQueryAPI) QueryAppservices(
+	ctx context.Context,
+	req *api.QueryAppservicesRequest,
+	res *api.QueryAppservicesResponse,
+) error {
+	for _, appservice := range a.Cfg.Derived.AppServices() {
+		res.Appservices = append(res.Appservices, api.AppserviceRegistration{
+			ID:              appservice.ID,
+			URL:             appservice.URL,
+			SenderLocalpart: appservice.SenderLocalpart,
+			Protocols:       appservice.Protocols,
+			Runtime:         !a.Cfg.Derived.IsConfigAppService(appservice.ID),
+		})
+	}
+	return nil
+}
+
+// LoadRegistrations returns the application services which were registered
+// at runtime, sorted by ID.
+func (a *AppServiceQueryAPI) LoadRegistrations(ctx context.Context) ([]config.ApplicationService, error) {
+	registrations, err := a.DB.GetRegistrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("a.DB.GetRegistrations: %w", err)
+	}
+	appservices := make([]config.ApplicationService, 0, len(registrations))
+	for id, registration := range registrations {
+		appservice, err := config.ParseApplicationService(registration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse registration for %q: %w", id, err)
+		}
+		appservices = append(appservices, *appservice)
+	}
+	sort.Slice(appservices, func(i, j int) bool {
+		return appservices[i].ID < appservices[j].ID
+	})
+	return appservices, nil
+}
+
+// PublishRegistrations tells the other components about the application
+// services registered at runtime, so that they can update without a restart.
+func (a *AppServiceQueryAPI) PublishRegistrations(ctx context.Context, appservices []config.ApplicationService) error {
+	output := api.OutputAppserviceRegistrations{
+		Registrations: make([]string, 0, len(appservices)),
+	}
+	for _, appservice := range appservices {
+		registration, err := yaml.Marshal(appservice)
+		if err != nil {
+			return err
+		}
+		output.Registrations = append(output.Registrations, string(registration))
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	msg := &nats.Msg{
+		Subject: a.Cfg.Matrix.JetStream.Prefixed(jetstream.OutputAppserviceRegistrations),
+		Header:  nats.Header{},
+		Data:    data,
+	}
+	_, err = a.JetStream.PublishMsg(msg, nats.Context(ctx))
+	return err
+}
+
+// applyRegistrations updates the application services in this process and
+// then in all of the others.
+func (a *AppServiceQueryAPI) applyRegistrations(ctx context.Context, appservices []config.ApplicationService) error {
+	if err := a.Cfg.SetRuntimeAppServices(appservices); err != nil {
+		return err
+	}
+	if err := a.PublishRegistrations(ctx, appservices); err != nil {
+		log.WithError(err).Error("Failed to publish appservice registrations")
+		return err
+	}
+	return nil
+}
+
+// removeAppServiceDevice removes the device of the application service's
+// bot user, which its access token belongs to.
+func (a *AppServiceQueryAPI) removeAppServiceDevice(ctx context.Context, as *config.ApplicationService) error {
+	return a.UserAPI.PerformDeviceDeletion(ctx, &userapi.PerformDeviceDeletionRequest{
+		UserID:    fmt.Sprintf("@%s:%s", as.SenderLocalpart, a.Cfg.Matrix.ServerName),
+		DeviceIDs: []string{as.SenderLocalpart},
+	}, &userapi.PerformDeviceDeletionResponse{})
+}
+
+// GenerateAppServiceAccount creates a dummy account based off the
+// `sender_localpart` field of the application service if it doesn't
+// exist already
+func GenerateAppServiceAccount(
+	ctx context.Context,
+	userAPI userapi.AppserviceUserAPI,
+	as config.ApplicationService,
+) error {
+	var accRes userapi.PerformAccountCreationResponse
+	err := userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
+		AccountType:  userapi.AccountTypeAppService,
+		Localpart:    as.SenderLocalpart,
+		AppServiceID: as.ID,
+		OnConflict:   userapi.ConflictUpdate,
+	}, &accRes)
+	if err != nil {
+		return err
+	}
+	var devRes userapi.PerformDeviceCreationResponse
+	err = userAPI.PerformDeviceCreation(ctx, &userapi.PerformDeviceCreationRequest{
+		Localpart:          as.SenderLocalpart,
+		AccessToken:        as.ASToken,
+		DeviceID:           &as.SenderLocalpart,
+		DeviceDisplayName:  &as.SenderLocalpart,
+		NoDeviceListUpdate: true,
+	}, &devRes)
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+const pingPath = "/_matrix/app/v1/ping"
+
+// How much of the application service's response to a failed ping we'll
+// pass back to the client.
+const maxPingBodySize = 64 * 1024
+
+// PerformAppservicePing checks that the application service is reachable,
+// as per MSC2659. If it is, then any transactions waiting to be sent to it
+// are retried straight away.
+func (a *AppServiceQueryAPI) PerformAppservicePing(
+	ctx context.Context,
+	req *api.PerformAppservicePingRequest,
+	res *api.PerformAppservicePingResponse,
+) error {
+	appservice := a.appservice(req.AppserviceID)
+	switch {
+	case appservice == nil:
+		res.ErrCode, res.Error = api.PingErrNotFound, "Unknown application service"
+		return nil
+	case appservice.URL == "":
+		res.ErrCode, res.Error = api.PingErrURLNotSet, "Application service doesn't have a URL"
+		return nil
+	}
+
+	body := map[string]string{}
+	if req.TransactionID != "" {
+		body["transaction_id"] = req.TransactionID
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, appservice.URL+pingPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+appservice.HSToken)
+
+	start := time.Now()
+	resp, err := a.HTTPClient.Do(httpReq)
+	res.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			res.ErrCode = api.PingErrConnectionTimeout
+		} else {
+			res.ErrCode = api.PingErrConnectionFailed
+		}
+		return nil
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxPingBodySize))
+		res.ErrCode, res.Error = api.PingErrBadStatus, "Application service returned a non-200 status code"
+		res.StatusCode, res.Body = resp.StatusCode, string(respBody)
+		return nil
+	}
+
+	if a.Transactions != nil {
+		a.Transactions.Retry(appservice.ID)
+	}
+	return nil
+}
+
+// QueryAppserviceStatus reports how well transactions are being delivered
+// to the application services.
+func (a *AppServiceQueryAPI) QueryAppserviceStatus(
+	ctx context.Context,
+	req *api.QueryAppserviceStatusRequest,
+	res *api.QueryAppserviceStatusResponse,
+) error {
+	res.Statuses = []api.AppserviceStatus{}
+	for _, appservice := range a.Cfg.Derived.AppServices() {
+		if req.AppserviceID != "" && appservice.ID != req.AppserviceID {
+			continue
+		}
+		status, ok, err := a.Transactions.Status(ctx, appservice.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			status.ID = appservice.ID
+		}
+		res.Statuses = append(res.Statuses, status)
+	}
+	return nil
+}
+
+// appservice returns the application service with the given ID, or nil if
+// there isn't one.
+func (a *AppServiceQueryAPI) appservice(appserviceID string) *config.ApplicationService {
+	for _, appservice := range a.Cfg.Derived.AppServices() {
+		if appservice.ID == appserviceID {
+			return &appservice
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+)
+
+// Database stores the application services which were registered at
+// runtime, rather than loaded from the config files.
+type Database interface {
+	// StoreRegistration stores or replaces the registration of the given
+	// application service.
+	StoreRegistration(ctx context.Context, appserviceID string, registration []byte) error
+	// RemoveRegistration removes the registration of the given application
+	// service, if there is one.
+	RemoveRegistration(ctx context.Context, appserviceID string) error
+	// GetRegistrations returns all registrations, keyed by application
+	// service ID.
+	GetRegistrations(ctx context.Context) (map[string][]byte, error)
+
+	// StoreTransaction adds a transaction to the end of the backlog of the
+	// given application service, returning its transaction ID.
+	StoreTransaction(ctx context.Context, appserviceID string, body []byte) (int64, error)
+	// GetOldestTransaction returns the transaction at the start of the
+	// backlog of the given application service, or a zero transaction ID if
+	// the backlog is empty.
+	GetOldestTransaction(ctx context.Context, appserviceID string) (int64, []byte, error)
+	// GetBacklogSize returns the number of transactions waiting to be sent
+	// to the given application service.
+	GetBacklogSize(ctx context.Context, appserviceID string) (int64, error)
+	// RemoveTransaction removes a transaction which has been sent.
+	RemoveTransaction(ctx context.Context, txnID int64) error
+	// RemoveBacklog removes all of the transactions waiting to be sent to
+	// the given application service.
+	RemoveBacklog(ctx context.Context, appserviceID string) error
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/appservice/storage/tables"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+const transactionsSchema = `
+-- Stores the transactions waiting to be sent to application services. The
+-- transaction ID is sent to the application service, so it must never be
+-- reused, even after a restart.
+CREATE TABLE IF NOT EXISTS appservice_transactions (
+    -- The transaction ID
+    txn_id BIGSERIAL PRIMARY KEY,
+    -- The ID of the application service the transaction is for
+    appservice_id TEXT NOT NULL,
+    -- The JSON body of the transaction
+    body TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS appservice_transactions_appservice_id_idx ON appservice_transactions(appservice_id, txn_id);
+`
+
+const insertTransactionSQL = "" +
+	"INSERT INTO appservice_transactions (appservice_id, body) VALUES ($1, $2)" +
+	" RETURNING txn_id"
+
+const selectOldestTransactionSQL = "" +
+	"SELECT txn_id, body FROM appservice_transactions WHERE appservice_id = $1" +
+	" ORDER BY txn_id ASC LIMIT 1"
+
+const selectTransactionCountSQL = "" +
+	"SELECT COUNT(*) FROM appservice_transactions WHERE appservice_id = $1"
+
+const deleteTransactionSQL = "" +
+	"DELETE FROM appservice_transactions WHERE txn_id = $1"
+
+const deleteTransactionsSQL = "" +
+	"DELETE FROM appservice_transactions WHERE appservice_id = $1"
+
+type transactionsStatements struct {
+	insertTransactionStmt       *sql.Stmt
+	selectOldestTransactionStmt *sql.Stmt
+	selectTransactionCountStmt  *sql.Stmt
+	deleteTransactionStmt       *sql.Stmt
+	deleteTransactionsStmt      *sql.Stmt
+}
+
+func NewPostgresTransactionsTable(db *sql.DB) (tables.Transactions, error) {
+	s := &transactionsStatements{}
+	_, err := db.Exec(transactionsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertTransactionStmt, insertTransactionSQL},
+		{&s.selectOldestTransactionStmt, selectOldestTransactionSQL},
+		{&s.selectTransactionCountStmt, selectTransactionCountSQL},
+		{&s.deleteTransactionStmt, deleteTransactionSQL},
+		{&s.deleteTransactionsStmt, deleteTransactionsSQL},
+	}.Prepare(db)
+}
+
+func (s *transactionsStatements) InsertTransaction(
+	ctx context.Context, txn *sql.Tx, appserviceID string, body []byte,
+) (txnID int64, err error) {
+	err = sqlutil.TxStmt(txn, s.insertTransactionStmt).QueryRowContext(ctx, appserviceID, string(body)).Scan(&txnID)
+	return
+}
+
+func (s *transactionsStatements) SelectOldestTransaction(
+	ctx context.Context, txn *sql.Tx, appserviceID string,
+) (txnID int64, body []byte, err error) {
+	var bodyStr string
+	err = sqlutil.TxStmt(txn, s.selectOldestTransactionStmt).QueryRowContext(ctx, appserviceID).Scan(&txnID, &bodyStr)
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	}
+	return txnID, []byte(bodyStr), err
+}
+
+func (s *transactionsStatements) SelectTransactionCount(
+	ctx context.Context, txn *sql.Tx, appserviceID string,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.selectTransactionCountStmt).QueryRowContext(ctx, appserviceID).Scan(&count)
+	return
+}
+
+func (s *transactionsStatements) DeleteTransaction(
+	ctx context.Context, txn *sql.Tx, txnID int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteTransactionStmt).ExecContext(ctx, txnID)
+	return err
+}
+
+func (s *transactionsStatements) DeleteTransactions(
+	ctx context.Context, txn *sql.Tx, appserviceID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteTransactionsStmt).ExecContext(ctx, appserviceID)
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/appservice/storage/tables"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+type Database struct {
+	DB            *sql.DB
+	Writer        sqlutil.Writer
+	Registrations tables.Registrations
+	Transactions  tables.Transactions
+}
+
+// StoreRegistration stores or replaces the registration of the given
+// application service.
+func (d *Database) StoreRegistration(ctx context.Context, appserviceID string, registration []byte) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Registrations.UpsertRegistration(ctx, txn, appserviceID, registration)
+	})
+}
+
+// RemoveRegistration removes the registration of the given application
+// service, if there is one.
+func (d *Database) RemoveRegistration(ctx context.Context, appserviceID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Registrations.DeleteRegistration(ctx, txn, appserviceID)
+	})
+}
+
+// GetRegistrations returns all registrations, keyed by application service ID.
+func (d *Database) GetRegistrations(ctx context.Context) (map[string][]byte, error) {
+	return d.Registrations.SelectRegistrations(ctx, nil)
+}
+
+func (d *Database) StoreTransaction(ctx context.Context, appserviceID string, body []byte) (txnID int64, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		txnID, err = d.Transactions.InsertTransaction(ctx, txn, appserviceID, body)
+		return err
+	})
+	return
+}
+
+func (d *Database) GetOldestTransaction(ctx context.Context, appserviceID string) (int64, []byte, error) {
+	return d.Transactions.SelectOldestTransaction(ctx, nil, appserviceID)
+}
+
+func (d *Database) GetBacklogSize(ctx context.Context, appserviceID string) (int64, error) {
+	return d.Transactions.SelectTransactionCount(ctx, nil, appserviceID)
+}
+
+func (d *Database) RemoveTransaction(ctx context.Context, txnID int64) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Transactions.DeleteTransaction(ctx, txn, txnID)
+	})
+}
+
+func (d *Database) RemoveBacklog(ctx context.Context, appserviceID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Transactions.DeleteTransactions(ctx, txn, appserviceID)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/appservice/storage/tables"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+)
+
+const registrationsSchema = `
+-- Stores the registrations of application services registered at runtime
+-- using the admin API, as opposed to those loaded from the config files.
+CREATE TABLE IF NOT EXISTS appservice_registrations (
+    -- The ID of the application service
+    appservice_id TEXT NOT NULL PRIMARY KEY,
+    -- The registration, in the same YAML format as the registration files
+    registration TEXT NOT NULL
+);
+`
+
+const upsertRegistrationSQL = "" +
+	"INSERT OR REPLACE INTO appservice_registrations (appservice_id, registration) VALUES ($1, $2)"
+
+const deleteRegistrationSQL = "" +
+	"DELETE FROM appservice_registrations WHERE appservice_id = $1"
+
+const selectRegistrationsSQL = "" +
+	"SELECT appservice_id, registration FROM appservice_registrations"
+
+type registrationsStatements struct {
+	upsertRegistrationStmt  *sql.Stmt
+	deleteRegistrationStmt  *sql.Stmt
+	selectRegistrationsStmt *sql.Stmt
+}
+
+func NewSQLiteRegistrationsTable(db *sql.DB) (tables.Registrations, error) {
+	s := &registrationsStatements{}
+	_, err := db.Exec(registrationsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertRegistrationStmt, upsertRegistrationSQL},
+		{&s.deleteRegistrationStmt, deleteRegistrationSQL},
+		{&s.selectRegistrationsStmt, selectRegistrationsSQL},
+	}.Prepare(db)
+}
+
+func (s *registrationsStatements) UpsertRegistration(
+	ctx context.Context, txn *sql.Tx, appserviceID string, registration []byte,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertRegistrationStmt).ExecContext(ctx, appserviceID, string(registration))
+	return err
+}
+
+func (s *registrationsStatements) DeleteRegistration(
+	ctx context.Context, txn *sql.Tx, appserviceID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteRegistrationStmt).ExecContext(ctx, appserviceID)
+	return err
+}
+
+func (s *registrationsStatements) SelectRegistrations(
+	ctx context.Context, txn *sql.Tx,
+) (map[string][]byte, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectRegistrationsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRegistrations: rows.close() failed")
+	registrations := make(map[string][]byte)
+	var appserviceID, registration string
+	for rows.Next() {
+		if err = rows.Scan(&appserviceID, &registration); err != nil {
+			return nil, err
+		}
+		registrations[appserviceID] = []byte(registration)
+	}
+	return registrations, rows.Err()
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/appservice/storage"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+)
+
+func mustCreateDatabase(t *testing.T, dbType test.DBType) (storage.Database, func()) {
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := storage.NewDatabase(nil, &config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase returned %s", err)
+	}
+	return db, close
+}
+
+func TestRegistrations(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		if err := db.StoreRegistration(ctx, "irc", []byte("id: irc")); err != nil {
+			t.Fatalf("failed to store registration: %s", err)
+		}
+		if err := db.StoreRegistration(ctx, "slack", []byte("id: slack")); err != nil {
+			t.Fatalf("failed to store registration: %s", err)
+		}
+		// Updating a registration replaces it
+		if err := db.StoreRegistration(ctx, "irc", []byte("id: irc\nurl: http://irc")); err != nil {
+			t.Fatalf("failed to update registration: %s", err)
+		}
+		registrations, err := db.GetRegistrations(ctx)
+		if err != nil {
+			t.Fatalf("failed to get registrations: %s", err)
+		}
+		want := map[string][]byte{
+			"irc":   []byte("id: irc\nurl: http://irc"),
+			"slack": []byte("id: slack"),
+		}
+		if !reflect.DeepEqual(registrations, want) {
+			t.Fatalf("unexpected registrations: got %v want %v", registrations, want)
+		}
+
+		if err = db.RemoveRegistration(ctx, "slack"); err != nil {
+			t.Fatalf("failed to remove registration: %s", err)
+		}
+		// Removing an unknown registration is not an error
+		if err = db.RemoveRegistration(ctx, "unknown"); err != nil {
+			t.Fatalf("failed to remove unknown registration: %s", err)
+		}
+		registrations, err = db.GetRegistrations(ctx)
+		if err != nil {
+			t.Fatalf("failed to get registrations: %s", err)
+		}
+		if len(registrations) != 1 || registrations["irc"] == nil {
+			t.Fatalf("unexpected registrations after removal: %v", registrations)
+		}
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+
+		first, err := db.StoreTransaction(ctx, "irc", []byte(`{"events":[1]}`))
+		if err != nil {
+			t.Fatalf("failed to store transaction: %s", err)
+		}
+		second, err := db.StoreTransaction(ctx, "irc", []byte(`{"events":[2]}`))
+		if err != nil {
+			t.Fatalf("failed to store transaction: %s", err)
+		}
+		if _, err = db.StoreTransaction(ctx, "slack", []byte(`{"events":[3]}`)); err != nil {
+			t.Fatalf("failed to store transaction: %s", err)
+		}
+		if second <= first {
+			t.Fatalf("expected increasing transaction IDs, got %d then %d", first, second)
+		}
+		if count, err := db.GetBacklogSize(ctx, "irc"); err != nil || count != 2 {
+			t.Fatalf("expected a backlog of 2, got %d (%v)", count, err)
+		}
+
+		// The backlog is sent in order.
+		txnID, body, err := db.GetOldestTransaction(ctx, "irc")
+		if err != nil || txnID != first || string(body) != `{"events":[1]}` {
+			t.Fatalf("unexpected oldest transaction %d %s (%v)", txnID, body, err)
+		}
+		if err = db.RemoveTransaction(ctx, first); err != nil {
+			t.Fatalf("failed to remove transaction: %s", err)
+		}
+		if txnID, _, err = db.GetOldestTransaction(ctx, "irc"); err != nil || txnID != second {
+			t.Fatalf("expected transaction %d next, got %d (%v)", second, txnID, err)
+		}
+		if err = db.RemoveTransaction(ctx, second); err != nil {
+			t.Fatalf("failed to remove transaction: %s", err)
+		}
+		if txnID, _, err = db.GetOldestTransaction(ctx, "irc"); err != nil || txnID != 0 {
+			t.Fatalf("expected an empty backlog, got %d (%v)", txnID, err)
+		}
+
+		// Transaction IDs are never reused, even once the backlog is empty.
+		third, err := db.StoreTransaction(ctx, "irc", []byte(`{"events":[4]}`))
+		if err != nil {
+			t.Fatalf("failed to store transaction: %s", err)
+		}
+		if third <= second {
+			t.Fatalf("expected transaction ID %d not to be reused", third)
+		}
+
+		if err = db.RemoveBacklog(ctx, "slack"); err != nil {
+			t.Fatalf("failed to remove backlog: %s", err)
+		}
+		if count, err := db.GetBacklogSize(ctx, "slack"); err != nil || count != 0 {
+			t.Fatalf("expected an empty backlog, got %d (%v)", count, err)
+		}
+		if count, err := db.GetBacklogSize(ctx, "irc"); err != nil || count != 1 {
+			t.Fatalf("expected the other backlog to be untouched, got %d (%v)", count, err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Registrations interface {
+	UpsertRegistration(ctx context.Context, txn *sql.Tx, appserviceID string, registration []byte) error
+	DeleteRegistration(ctx context.Context, txn *sql.Tx, appserviceID string) error
+	SelectRegistrations(ctx context.Context, txn *sql.Tx) (map[string][]byte, error)
+}
+
+type Transactions interface {
+	InsertTransaction(ctx context.Context, txn *sql.Tx, appserviceID string, body []byte) (txnID int64, err error)
+	// SelectOldestTransaction returns a zero transaction ID if there are no
+	// transactions for the application service.
+	SelectOldestTransaction(ctx context.Context, txn *sql.Tx, appserviceID string) (txnID int64, body []byte, err error)
+	SelectTransactionCount(ctx context.Context, txn *sql.Tx, appserviceID string) (count int64, err error)
+	DeleteTransaction(ctx context.Context, txn *sql.Tx, txnID int64) error
+	DeleteTransactions(ctx context.Context, txn *sql.Tx, appserviceID string) error
+}
@@ -11,4 +11,7 @@ const (
 	LoginTypeRecaptcha          = "m.login.recaptcha"
 	LoginTypeApplicationService = "m.login.application_service"
 	LoginTypeToken              = "m.login.token"
+	LoginTypeTerms              = "m.login.terms"
+	LoginTypeEmail              = "m.login.email.identity"
+	LoginTypeJWT                = "org.matrix.login.jwt"
 )
@@ -31,8 +31,8 @@ import (
 // some context. It returns the basic login information and a cleanup function to be
 // called after authorization has completed, with the result of the authorization.
 // If the final return value is non-nil, an error occurred and the cleanup function
-// is nil.
-func LoginFromJSONReader(ctx context.Context, r io.Reader, useraccountAPI uapi.UserLoginAPI, userAPI UserInternalAPIForLogin, cfg *config.ClientAPI) (*Login, LoginCleanupFunc, *util.JSONResponse) {
+// is nil. The remoteAddr is the IP address of the client, if known.
+func LoginFromJSONReader(ctx context.Context, r io.Reader, useraccountAPI uapi.UserLoginAPI, userAPI UserInternalAPIForLogin, cfg *config.ClientAPI, remoteAddr string) (*Login, LoginCleanupFunc, *util.JSONResponse) {
 	reqBytes, err := io.ReadAll(r)
 	if err != nil {
 		err := &util.JSONResponse{
@@ -57,14 +57,20 @@ func LoginFromJSONReader(ctx context.Context, r io.Reader, useraccountAPI uapi.U
 	switch header.Type {
 	case authtypes.LoginTypePassword:
 		typ = &LoginTypePassword{
-			GetAccountByPassword: useraccountAPI.QueryAccountByPassword,
+			GetAccountByPassword: NewGetAccountByPassword(cfg, useraccountAPI.QueryAccountByPassword, userAPI),
 			Config:               cfg,
+			RemoteAddr:           remoteAddr,
 		}
 	case authtypes.LoginTypeToken:
 		typ = &LoginTypeToken{
 			UserAPI: userAPI,
 			Config:  cfg,
 		}
+	case authtypes.LoginTypeJWT:
+		typ = &LoginTypeJWT{
+			UserAPI: userAPI,
+			Config:  cfg,
+		}
 	default:
 		err := util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -79,4 +85,5 @@ func LoginFromJSONReader(ctx context.Context, r io.Reader, useraccountAPI uapi.U
 // UserInternalAPIForLogin contains the aspects of UserAPI required for logging in.
 type UserInternalAPIForLogin interface {
 	uapi.LoginTokenInternalAPI
+	UserInternalAPIForPasswordProvider
 }
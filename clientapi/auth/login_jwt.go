@@ -0,0 +1,388 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// jwtClockSkew is how far the clocks of the server and the token issuer may
+// disagree when checking when tokens are valid.
+const jwtClockSkew = time.Minute
+
+var validJWTLocalpartRegex = regexp.MustCompile(`^[0-9a-z_\-=./]+$`)
+
+// jwtKeyCache holds the keys loaded for each JWT login configuration, so that
+// the key files are only read once.
+var jwtKeyCache sync.Map // *config.JWTLogin -> []jwtKey
+
+// jwtKey is a key which tokens can be signed with.
+type jwtKey struct {
+	alg string      // HS256, RS256 or ES256
+	kid string      // empty unless the key came from a JWKS file
+	key interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// LoginTypeJWT describes how to authenticate with a JSON web token signed by
+// a trusted service.
+type LoginTypeJWT struct {
+	UserAPI UserInternalAPIForLogin
+	Config  *config.ClientAPI
+}
+
+// Name implements Type.
+func (t *LoginTypeJWT) Name() string {
+	return authtypes.LoginTypeJWT
+}
+
+// LoginFromJSON implements Type.
+func (t *LoginTypeJWT) LoginFromJSON(ctx context.Context, reqBytes []byte) (*Login, LoginCleanupFunc, *util.JSONResponse) {
+	cfg := &t.Config.JWTLogin
+	if !cfg.Enabled {
+		return nil, nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidArgumentValue("unhandled login type: " + authtypes.LoginTypeJWT),
+		}
+	}
+	var r loginTokenRequest
+	if err := httputil.UnmarshalJSON(reqBytes, &r); err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := loadJWTKeys(cfg)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("Failed to load JWT login keys")
+		jsonErr := jsonerror.InternalServerError()
+		return nil, nil, &jsonErr
+	}
+	// Don't tell the client which check failed.
+	forbidden := &util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: jsonerror.Forbidden("Invalid JWT"),
+	}
+	logger := util.GetLogger(ctx)
+	claims, err := verifyJWT(r.Token, keys, cfg, time.Now())
+	if err != nil {
+		logger.WithError(err).Debug("Refusing JWT login")
+		return nil, nil, forbidden
+	}
+	subject, _ := claims[cfg.SubjectClaim].(string)
+	localpart, err := userutil.ParseUsernameParam(subject, &t.Config.Matrix.ServerName)
+	if err != nil || subject == "" {
+		logger.Debugf("Refusing JWT login: the %s claim is not a local user", cfg.SubjectClaim)
+		return nil, nil, forbidden
+	}
+	logger = logger.WithField("localpart", localpart)
+
+	var accRes uapi.QueryAccountByLocalpartResponse
+	if err = t.UserAPI.QueryAccountByLocalpart(ctx, &uapi.QueryAccountByLocalpartRequest{
+		Localpart: localpart,
+	}, &accRes); err != nil {
+		logger.WithError(err).Error("UserAPI.QueryAccountByLocalpart failed")
+		jsonErr := jsonerror.InternalServerError()
+		return nil, nil, &jsonErr
+	}
+	switch acc := accRes.Account; {
+	case acc == nil && !cfg.AutoRegister:
+		logger.Debug("Refusing JWT login: the user doesn't have an account")
+		return nil, nil, forbidden
+	case acc == nil:
+		if !validJWTLocalpartRegex.MatchString(localpart) {
+			logger.Debug("Refusing JWT login: the localpart is not a valid username")
+			return nil, nil, forbidden
+		}
+		// The account has no password, so that it can only be logged into
+		// with tokens.
+		var createRes uapi.PerformAccountCreationResponse
+		if err = t.UserAPI.PerformAccountCreation(ctx, &uapi.PerformAccountCreationRequest{
+			AccountType: uapi.AccountTypeUser,
+			Localpart:   localpart,
+			OnConflict:  uapi.ConflictAbort,
+		}, &createRes); err != nil {
+			logger.WithError(err).Error("UserAPI.PerformAccountCreation failed")
+			jsonErr := jsonerror.InternalServerError()
+			return nil, nil, &jsonErr
+		}
+		logger.Info("Created account for user logging in with a JWT")
+	case acc.Deactivated || acc.AccountType != uapi.AccountTypeUser:
+		// The token issuer can't bring back deactivated accounts or log
+		// into guest, admin or appservice ones.
+		logger.Warn("Refusing JWT login to a deactivated or non-user account")
+		return nil, nil, forbidden
+	}
+
+	r.Login.Identifier.Type = "m.id.user"
+	r.Login.Identifier.User = localpart
+	return &r.Login, func(context.Context, *util.JSONResponse) {}, nil
+}
+
+// verifyJWT checks the token's signature against the keys and its claims
+// against the configuration, and returns the claims if they are valid.
+func verifyJWT(token string, keys []jwtKey, cfg *config.JWTLogin, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.alg != header.Alg || (header.Kid != "" && key.kid != "" && key.kid != header.Kid) {
+			continue
+		}
+		if verifyJWTSignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("bad signature")
+	}
+
+	var claims map[string]interface{}
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	// Tokens which never expire could be replayed forever.
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtClockSkew)) {
+		return nil, errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); cfg.Issuer != "" && iss != cfg.Issuer {
+		return nil, errors.New("wrong issuer")
+	}
+	if aud, ok := claims["aud"]; ok || len(cfg.Audiences) > 0 {
+		if !jwtAudienceAllowed(aud, cfg.Audiences) {
+			return nil, errors.New("wrong audience")
+		}
+	}
+	return claims, nil
+}
+
+// jwtAudienceAllowed returns true if the "aud" claim, which is either a
+// string or a list of strings, contains one of the allowed audiences.
+func jwtAudienceAllowed(aud interface{}, allowed []string) bool {
+	var audiences []string
+	switch a := aud.(type) {
+	case string:
+		audiences = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	for _, a := range audiences {
+		for _, b := range allowed {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifyJWTSignature(key jwtKey, signed, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+	switch k := key.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed) // nolint:errcheck
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the 32 byte R and S values concatenated.
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, hash[:], r, s)
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// loadJWTKeys returns the keys which tokens can be signed with, reading them
+// from the key files the first time.
+func loadJWTKeys(cfg *config.JWTLogin) ([]jwtKey, error) {
+	if keys, ok := jwtKeyCache.Load(cfg); ok {
+		return keys.([]jwtKey), nil
+	}
+	var keys []jwtKey
+	switch {
+	case cfg.JWKSPath != "":
+		b, err := os.ReadFile(string(cfg.JWKSPath))
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		if keys, err = parseJWKS(b); err != nil {
+			return nil, fmt.Errorf("parseJWKS: %w", err)
+		}
+	case cfg.Algorithm == "HS256":
+		keys = []jwtKey{{alg: "HS256", key: []byte(cfg.Secret)}}
+	default:
+		b, err := os.ReadFile(string(cfg.PublicKeyPath))
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		key, err := parseJWTPublicKey(cfg.Algorithm, b)
+		if err != nil {
+			return nil, err
+		}
+		keys = []jwtKey{key}
+	}
+	jwtKeyCache.Store(cfg, keys)
+	return keys, nil
+}
+
+// parseJWTPublicKey parses a PEM encoded public key or certificate for RS256
+// or ES256.
+func parseJWTPublicKey(alg string, b []byte) (jwtKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return jwtKey{}, errors.New("no PEM data found in the public key file")
+	}
+	var pub interface{}
+	var err error
+	if block.Type == "CERTIFICATE" {
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	} else {
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("failed to parse the public key: %w", err)
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" {
+			return jwtKey{alg: alg, key: k}, nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == "ES256" && k.Curve == elliptic.P256() {
+			return jwtKey{alg: alg, key: k}, nil
+		}
+	}
+	return jwtKey{}, fmt.Errorf("the public key can't be used for %s", alg)
+}
+
+// parseJWKS parses a JSON web key set containing RSA, P-256 or symmetric keys.
+func parseJWKS(b []byte) ([]jwtKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, err
+	}
+	decode := func(s string) *big.Int {
+		v, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(v)
+	}
+	var keys []jwtKey
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key jwtKey
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			key = jwtKey{alg: "RS256", key: &rsa.PublicKey{N: decode(k.N), E: int(decode(k.E).Int64())}}
+		case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == "ES256"):
+			key = jwtKey{alg: "ES256", key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(k.X), Y: decode(k.Y)}}
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+			}
+			key = jwtKey{alg: "HS256", key: secret}
+		default:
+			continue
+		}
+		key.kid = k.Kid
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable keys")
+	}
+	return keys, nil
+}
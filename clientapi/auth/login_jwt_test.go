@@ -0,0 +1,333 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type fakeJWTUserAPI struct {
+	fakeUserInternalAPI
+	accounts map[string]*uapi.Account
+}
+
+func (ua *fakeJWTUserAPI) QueryAccountByLocalpart(ctx context.Context, req *uapi.QueryAccountByLocalpartRequest, res *uapi.QueryAccountByLocalpartResponse) error {
+	res.Account = ua.accounts[req.Localpart]
+	return nil
+}
+
+func (ua *fakeJWTUserAPI) PerformAccountCreation(ctx context.Context, req *uapi.PerformAccountCreationRequest, res *uapi.PerformAccountCreationResponse) error {
+	if ua.accounts[req.Localpart] != nil {
+		return fmt.Errorf("account %q already exists", req.Localpart)
+	}
+	res.AccountCreated = true
+	ua.accounts[req.Localpart] = &uapi.Account{Localpart: req.Localpart, AccountType: req.AccountType}
+	return nil
+}
+
+func newFakeJWTUserAPI() *fakeJWTUserAPI {
+	return &fakeJWTUserAPI{accounts: map[string]*uapi.Account{
+		"alice":       {Localpart: "alice", AccountType: uapi.AccountTypeUser},
+		"deactivated": {Localpart: "deactivated", AccountType: uapi.AccountTypeUser, Deactivated: true},
+	}}
+}
+
+// signJWT makes a token with the given claims, signed with the key.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(header) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed)) // nolint:errcheck
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hash[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestLoginTypeJWT(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemPath := filepath.Join(dir, "public.pem")
+	if err = os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","kid":"ec1","x":%q,"y":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err = os.WriteFile(jwksPath, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("secret")
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+
+	tsts := []struct {
+		Name        string
+		Config      config.JWTLogin
+		Alg, Kid    string
+		Key         interface{}
+		Claims      map[string]interface{}
+		WantUser    string
+		WantErrCode string
+	}{
+		{
+			Name:     "hs256",
+			Config:   config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:      "HS256",
+			Key:      secret,
+			Claims:   map[string]interface{}{"sub": "alice", "exp": now + 60},
+			WantUser: "alice",
+		},
+		{
+			Name:     "rs256UserID",
+			Config:   config.JWTLogin{Algorithm: "RS256", PublicKeyPath: config.Path(pemPath)},
+			Alg:      "RS256",
+			Key:      rsaKey,
+			Claims:   map[string]interface{}{"sub": "@alice:example.com", "exp": now + 60},
+			WantUser: "alice",
+		},
+		{
+			Name:     "es256JWKS",
+			Config:   config.JWTLogin{JWKSPath: config.Path(jwksPath)},
+			Alg:      "ES256",
+			Kid:      "ec1",
+			Key:      ecKey,
+			Claims:   map[string]interface{}{"sub": "alice", "exp": now + 60},
+			WantUser: "alice",
+		},
+		{
+			Name:     "subjectClaimIssuerAudience",
+			Config:   config.JWTLogin{Algorithm: "HS256", Secret: "secret", SubjectClaim: "username", Issuer: "portal", Audiences: []string{"matrix"}},
+			Alg:      "HS256",
+			Key:      secret,
+			Claims:   map[string]interface{}{"username": "alice", "iss": "portal", "aud": []string{"other", "matrix"}, "exp": now + 60},
+			WantUser: "alice",
+		},
+		{
+			Name:     "autoRegister",
+			Config:   config.JWTLogin{Algorithm: "HS256", Secret: "secret", AutoRegister: true},
+			Alg:      "HS256",
+			Key:      secret,
+			Claims:   map[string]interface{}{"sub": "bob", "exp": now + 60},
+			WantUser: "bob",
+		},
+		{
+			Name:        "unknownUser",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "bob", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "remoteUser",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "@alice:elsewhere.com", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "badSignature",
+			Config:      config.JWTLogin{Algorithm: "RS256", PublicKeyPath: config.Path(pemPath)},
+			Alg:         "RS256",
+			Key:         otherRSAKey,
+			Claims:      map[string]interface{}{"sub": "alice", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "wrongAlgorithm",
+			Config:      config.JWTLogin{Algorithm: "RS256", PublicKeyPath: config.Path(pemPath)},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "expired",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice", "exp": now - 3600},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "noExpiry",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice"},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "deactivated",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "deactivated", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "deactivatedAutoRegister",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret", AutoRegister: true},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "deactivated", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "notYetValid",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice", "nbf": now + 3600, "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "wrongIssuer",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret", Issuer: "portal"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice", "iss": "elsewhere", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+		{
+			Name:        "unexpectedAudience",
+			Config:      config.JWTLogin{Algorithm: "HS256", Secret: "secret"},
+			Alg:         "HS256",
+			Key:         secret,
+			Claims:      map[string]interface{}{"sub": "alice", "aud": "matrix", "exp": now + 60},
+			WantErrCode: "M_FORBIDDEN",
+		},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			userAPI := newFakeJWTUserAPI()
+			cfg := &config.ClientAPI{
+				Matrix: &config.Global{
+					ServerName: serverName,
+				},
+				JWTLogin: tst.Config,
+			}
+			cfg.JWTLogin.Enabled = true
+			if cfg.JWTLogin.SubjectClaim == "" {
+				cfg.JWTLogin.SubjectClaim = "sub"
+			}
+			body := fmt.Sprintf(`{"type": "org.matrix.login.jwt", "token": %q}`, signJWT(t, tst.Alg, tst.Kid, tst.Key, tst.Claims))
+			login, cleanup, errRes := LoginFromJSONReader(ctx, strings.NewReader(body), userAPI, userAPI, cfg, "")
+			if tst.WantErrCode != "" {
+				if errRes == nil {
+					t.Fatalf("expected error %q, got login for %q", tst.WantErrCode, login.Username())
+				}
+				if merr, ok := errRes.JSON.(*jsonerror.MatrixError); !ok || merr.ErrCode != tst.WantErrCode {
+					t.Fatalf("expected error %q, got %+v", tst.WantErrCode, errRes.JSON)
+				}
+				return
+			}
+			if errRes != nil {
+				t.Fatalf("LoginFromJSONReader failed: %+v", errRes.JSON)
+			}
+			cleanup(ctx, nil)
+			if login.Username() != tst.WantUser {
+				t.Errorf("Username: got %q, want %q", login.Username(), tst.WantUser)
+			}
+			if userAPI.accounts[tst.WantUser] == nil {
+				t.Errorf("expected account %q to exist", tst.WantUser)
+			}
+		})
+	}
+}
+
+func TestLoginTypeJWTDisabled(t *testing.T) {
+	userAPI := newFakeJWTUserAPI()
+	cfg := &config.ClientAPI{
+		Matrix: &config.Global{
+			ServerName: serverName,
+		},
+	}
+	token := signJWT(t, "HS256", "", []byte(""), map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() + 60})
+	body := fmt.Sprintf(`{"type": "org.matrix.login.jwt", "token": %q}`, token)
+	if _, _, errRes := LoginFromJSONReader(context.Background(), strings.NewReader(body), userAPI, userAPI, cfg, ""); errRes == nil {
+		t.Fatalf("expected JWT login to be refused when disabled")
+	}
+}
@@ -16,8 +16,11 @@ package auth
 
 import (
 	"context"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/clientapi/httputil"
@@ -25,6 +28,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/userutil"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
 )
 
@@ -39,6 +43,9 @@ type PasswordRequest struct {
 type LoginTypePassword struct {
 	GetAccountByPassword GetAccountByPassword
 	Config               *config.ClientAPI
+	// RemoteAddr is the IP address of the client, if known, which is locked
+	// out after too many failed logins.
+	RemoteAddr string
 }
 
 func (t *LoginTypePassword) Name() string {
@@ -75,34 +82,34 @@ func (t *LoginTypePassword) Login(ctx context.Context, req interface{}) (*Login,
 			JSON: jsonerror.InvalidUsername(err.Error()),
 		}
 	}
-	// Squash username to all lowercase letters
 	res := &api.QueryAccountByPasswordResponse{}
-	err = t.GetAccountByPassword(ctx, &api.QueryAccountByPasswordRequest{Localpart: strings.ToLower(localpart), PlaintextPassword: r.Password}, res)
+	err = t.GetAccountByPassword(ctx, &api.QueryAccountByPasswordRequest{
+		Localpart:         localpart,
+		PlaintextPassword: r.Password,
+		RemoteAddr:        t.RemoteAddr,
+	}, res)
 	if err != nil {
 		return nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: jsonerror.Unknown("unable to fetch account by password"),
 		}
 	}
-
-	if !res.Exists {
-		err = t.GetAccountByPassword(ctx, &api.QueryAccountByPasswordRequest{
-			Localpart:         localpart,
-			PlaintextPassword: r.Password,
-		}, res)
-		if err != nil {
-			return nil, &util.JSONResponse{
-				Code: http.StatusInternalServerError,
-				JSON: jsonerror.Unknown("unable to fetch account by password"),
-			}
+	if res.LockedUntilMS > 0 {
+		retryAfter := time.Until(gomatrixserverlib.Timestamp(res.LockedUntilMS).Time())
+		return nil, &util.JSONResponse{
+			Code: http.StatusTooManyRequests,
+			JSON: jsonerror.LimitExceeded("Too many failed login attempts, please try again later.", retryAfter.Milliseconds()),
+			Headers: map[string]string{
+				"Retry-After": strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10),
+			},
 		}
-		// Technically we could tell them if the user does not exist by checking if err == sql.ErrNoRows
-		// but that would leak the existence of the user.
-		if !res.Exists {
-			return nil, &util.JSONResponse{
-				Code: http.StatusForbidden,
-				JSON: jsonerror.Forbidden("The username or password was incorrect or the account does not exist."),
-			}
+	}
+	// Technically we could tell them if the user does not exist by checking if err == sql.ErrNoRows
+	// but that would leak the existence of the user.
+	if !res.Exists {
+		return nil, &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("The username or password was incorrect or the account does not exist."),
 		}
 	}
 	return &r.Login, nil
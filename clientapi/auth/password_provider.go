@@ -0,0 +1,253 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// PasswordProvider checks passwords against an external source of accounts.
+type PasswordProvider interface {
+	// CheckPassword returns whether the password is correct for the user,
+	// along with the profile the provider has for them, if any.
+	CheckPassword(ctx context.Context, userID, password string) (bool, *PasswordProviderProfile, error)
+}
+
+// PasswordProviderProfile is the profile of a user authenticated by a
+// PasswordProvider, which their account is created with.
+type PasswordProviderProfile struct {
+	DisplayName string                     `json:"display_name"`
+	ThreePIDs   []PasswordProviderThreePID `json:"three_pids"`
+}
+
+type PasswordProviderThreePID struct {
+	Medium  string `json:"medium"`
+	Address string `json:"address"`
+}
+
+// HTTPPasswordProvider checks passwords by POSTing them to an HTTP service,
+// using the same API as matrix-synapse-rest-password-provider.
+type HTTPPasswordProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+type httpPasswordProviderRequest struct {
+	User struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+	} `json:"user"`
+}
+
+type httpPasswordProviderResponse struct {
+	Auth struct {
+		Success bool                     `json:"success"`
+		MXID    string                   `json:"mxid"`
+		Profile *PasswordProviderProfile `json:"profile"`
+	} `json:"auth"`
+}
+
+func NewHTTPPasswordProvider(cfg *config.PasswordAuthProvider) *HTTPPasswordProvider {
+	return &HTTPPasswordProvider{
+		URL:    cfg.URL,
+		Client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (p *HTTPPasswordProvider) CheckPassword(ctx context.Context, userID, password string) (bool, *PasswordProviderProfile, error) {
+	var body httpPasswordProviderRequest
+	body.User.ID = userID
+	body.User.Password = password
+	reqBytes, err := json.Marshal(body)
+	if err != nil {
+		return false, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(reqBytes))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("password provider returned HTTP %d", resp.StatusCode)
+	}
+	var res httpPasswordProviderResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return false, nil, fmt.Errorf("failed to decode password provider response: %w", err)
+	}
+	// Only trust the provider for the user that was asked about.
+	if !res.Auth.Success || (res.Auth.MXID != "" && res.Auth.MXID != userID) {
+		return false, nil, nil
+	}
+	return true, res.Auth.Profile, nil
+}
+
+// UserInternalAPIForPasswordProvider contains the aspects of UserAPI required
+// for creating the accounts of users authenticated by a PasswordProvider, and
+// for locking them out after too many failed logins.
+type UserInternalAPIForPasswordProvider interface {
+	QueryAccountByLocalpart(ctx context.Context, req *api.QueryAccountByLocalpartRequest, res *api.QueryAccountByLocalpartResponse) error
+	QueryLoginLockout(ctx context.Context, req *api.QueryLoginLockoutRequest, res *api.QueryLoginLockoutResponse) error
+	PerformLoginAttempt(ctx context.Context, req *api.PerformLoginAttemptRequest, res *api.PerformLoginAttemptResponse) error
+	PerformAccountCreation(ctx context.Context, req *api.PerformAccountCreationRequest, res *api.PerformAccountCreationResponse) error
+	SetDisplayName(ctx context.Context, req *api.PerformUpdateDisplayNameRequest, res *struct{}) error
+	PerformSaveThreePIDAssociation(ctx context.Context, req *api.PerformSaveThreePIDAssociationRequest, res *struct{}) error
+}
+
+// NewGetAccountByPassword returns the function for checking passwords. If a
+// password provider is configured then passwords are checked with it, falling
+// back to the local check if configured. Otherwise only the local check is used.
+func NewGetAccountByPassword(
+	cfg *config.ClientAPI, local GetAccountByPassword, userAPI UserInternalAPIForPasswordProvider,
+) GetAccountByPassword {
+	if !cfg.PasswordAuthProvider.Enabled {
+		return local
+	}
+	c := &providerPasswordCheck{
+		provider: NewHTTPPasswordProvider(&cfg.PasswordAuthProvider),
+		local:    local,
+		userAPI:  userAPI,
+		cfg:      cfg,
+	}
+	return c.QueryAccountByPassword
+}
+
+type providerPasswordCheck struct {
+	provider PasswordProvider
+	local    GetAccountByPassword
+	userAPI  UserInternalAPIForPasswordProvider
+	cfg      *config.ClientAPI
+}
+
+func (c *providerPasswordCheck) QueryAccountByPassword(ctx context.Context, req *api.QueryAccountByPasswordRequest, res *api.QueryAccountByPasswordResponse) error {
+	logger := util.GetLogger(ctx).WithField("localpart", req.Localpart)
+
+	// Don't ask the provider at all while locked out, so that guessing the
+	// password gets no further than it would with a local account.
+	var lockoutRes api.QueryLoginLockoutResponse
+	if err := c.userAPI.QueryLoginLockout(ctx, &api.QueryLoginLockoutRequest{
+		Localpart:  req.Localpart,
+		RemoteAddr: req.RemoteAddr,
+	}, &lockoutRes); err != nil {
+		return fmt.Errorf("c.userAPI.QueryLoginLockout: %w", err)
+	}
+	if lockoutRes.LockedUntilMS > 0 {
+		res.LockedUntilMS = lockoutRes.LockedUntilMS
+		return nil
+	}
+
+	userID := userutil.MakeUserID(req.Localpart, c.cfg.Matrix.ServerName)
+	ok, profile, err := c.provider.CheckPassword(ctx, userID, req.PlaintextPassword)
+	switch {
+	case err != nil && !c.cfg.PasswordAuthProvider.LocalFallback:
+		return fmt.Errorf("c.provider.CheckPassword: %w", err)
+	case err != nil:
+		logger.WithError(err).Error("Failed to check password with the password provider, falling back to local accounts")
+		fallthrough
+	case !ok && c.cfg.PasswordAuthProvider.LocalFallback:
+		// The local check records its own failures.
+		return c.local(ctx, req, res)
+	case !ok:
+		return c.loginAttempt(ctx, req, false)
+	}
+
+	var accRes api.QueryAccountByLocalpartResponse
+	if err = c.userAPI.QueryAccountByLocalpart(ctx, &api.QueryAccountByLocalpartRequest{
+		Localpart: req.Localpart,
+	}, &accRes); err != nil {
+		return fmt.Errorf("c.userAPI.QueryAccountByLocalpart: %w", err)
+	}
+	acc := accRes.Account
+	switch {
+	case acc == nil:
+		if acc, err = c.createAccount(ctx, req.Localpart, profile); err != nil {
+			return err
+		}
+	case acc.Deactivated || acc.AccountType != api.AccountTypeUser:
+		// The provider only vouches for the password, so it can't bring back
+		// deactivated accounts or log into guest, admin or appservice ones.
+		logger.Warn("Refusing login authenticated by the password provider to a deactivated or non-user account")
+		return nil
+	}
+	if err = c.loginAttempt(ctx, req, true); err != nil {
+		return err
+	}
+	res.Exists = true
+	res.Account = acc
+	return nil
+}
+
+// createAccount creates the account the first time its user logs in. It has
+// no password, so that it can only be logged into with the provider.
+func (c *providerPasswordCheck) createAccount(ctx context.Context, localpart string, profile *PasswordProviderProfile) (*api.Account, error) {
+	logger := util.GetLogger(ctx).WithField("localpart", localpart)
+	var accRes api.PerformAccountCreationResponse
+	if err := c.userAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+		AccountType: api.AccountTypeUser,
+		Localpart:   localpart,
+		OnConflict:  api.ConflictAbort,
+	}, &accRes); err != nil {
+		return nil, fmt.Errorf("c.userAPI.PerformAccountCreation: %w", err)
+	}
+	logger.Info("Created account for user authenticated by the password provider")
+	if profile == nil {
+		return accRes.Account, nil
+	}
+	if profile.DisplayName != "" {
+		if err := c.userAPI.SetDisplayName(ctx, &api.PerformUpdateDisplayNameRequest{
+			Localpart:   localpart,
+			DisplayName: profile.DisplayName,
+		}, &struct{}{}); err != nil {
+			return nil, fmt.Errorf("c.userAPI.SetDisplayName: %w", err)
+		}
+	}
+	for _, threePID := range profile.ThreePIDs {
+		if err := c.userAPI.PerformSaveThreePIDAssociation(ctx, &api.PerformSaveThreePIDAssociationRequest{
+			ThreePID:  threePID.Address,
+			Localpart: localpart,
+			Medium:    threePID.Medium,
+		}, &struct{}{}); err != nil {
+			logger.WithError(err).Warnf("Failed to save %s from the password provider", threePID.Medium)
+		}
+	}
+	return accRes.Account, nil
+}
+
+// loginAttempt records the outcome of a login checked by the provider, so
+// that it counts towards locking out the account and the IP address.
+func (c *providerPasswordCheck) loginAttempt(ctx context.Context, req *api.QueryAccountByPasswordRequest, succeeded bool) error {
+	if err := c.userAPI.PerformLoginAttempt(ctx, &api.PerformLoginAttemptRequest{
+		Localpart:  req.Localpart,
+		RemoteAddr: req.RemoteAddr,
+		Succeeded:  succeeded,
+	}, &api.PerformLoginAttemptResponse{}); err != nil {
+		return fmt.Errorf("c.userAPI.PerformLoginAttempt: %w", err)
+	}
+	return nil
+}
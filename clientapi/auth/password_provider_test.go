@@ -0,0 +1,200 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type fakePasswordProviderUserAPI struct {
+	accounts     map[string]*uapi.Account
+	displayNames map[string]string
+	threePIDs    map[string]string
+	lockedOut    map[string]bool
+	failures     map[string]int
+}
+
+func (ua *fakePasswordProviderUserAPI) QueryAccountByLocalpart(ctx context.Context, req *uapi.QueryAccountByLocalpartRequest, res *uapi.QueryAccountByLocalpartResponse) error {
+	res.Account = ua.accounts[req.Localpart]
+	return nil
+}
+
+func (ua *fakePasswordProviderUserAPI) QueryLoginLockout(ctx context.Context, req *uapi.QueryLoginLockoutRequest, res *uapi.QueryLoginLockoutResponse) error {
+	if ua.lockedOut[req.Localpart] || ua.lockedOut[req.RemoteAddr] {
+		res.LockedUntilMS = 1
+	}
+	return nil
+}
+
+func (ua *fakePasswordProviderUserAPI) PerformLoginAttempt(ctx context.Context, req *uapi.PerformLoginAttemptRequest, res *uapi.PerformLoginAttemptResponse) error {
+	if req.Succeeded {
+		delete(ua.failures, req.Localpart)
+	} else {
+		ua.failures[req.Localpart]++
+	}
+	return nil
+}
+
+func (ua *fakePasswordProviderUserAPI) PerformAccountCreation(ctx context.Context, req *uapi.PerformAccountCreationRequest, res *uapi.PerformAccountCreationResponse) error {
+	if ua.accounts[req.Localpart] != nil {
+		return &uapi.ErrorConflict{Message: "account exists"}
+	}
+	res.AccountCreated = true
+	res.Account = &uapi.Account{Localpart: req.Localpart, AccountType: req.AccountType}
+	ua.accounts[req.Localpart] = res.Account
+	return nil
+}
+
+func (ua *fakePasswordProviderUserAPI) SetDisplayName(ctx context.Context, req *uapi.PerformUpdateDisplayNameRequest, res *struct{}) error {
+	ua.displayNames[req.Localpart] = req.DisplayName
+	return nil
+}
+
+func (ua *fakePasswordProviderUserAPI) PerformSaveThreePIDAssociation(ctx context.Context, req *uapi.PerformSaveThreePIDAssociationRequest, res *struct{}) error {
+	ua.threePIDs[req.ThreePID] = req.Localpart
+	return nil
+}
+
+func TestPasswordProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpPasswordProviderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+		}
+		var res httpPasswordProviderResponse
+		switch req.User.ID {
+		case "@deactivated:example.com", "@bridge:example.com", "@locked:example.com":
+			res.Auth.Success = true
+		case "@alice:example.com":
+			res.Auth.Success = req.User.Password == "corporate"
+			res.Auth.MXID = req.User.ID
+			res.Auth.Profile = &PasswordProviderProfile{
+				DisplayName: "Alice",
+				ThreePIDs:   []PasswordProviderThreePID{{Medium: "email", Address: "alice@example.com"}},
+			}
+		case "@mallory:example.com":
+			// The provider can't authenticate a different user than was asked about.
+			res.Auth.Success = true
+			res.Auth.MXID = "@alice:example.com"
+		case "@unreachable:example.com":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	localCalls := 0
+	local := func(ctx context.Context, req *uapi.QueryAccountByPasswordRequest, res *uapi.QueryAccountByPasswordResponse) error {
+		localCalls++
+		res.Exists = req.Localpart == "bob" && req.PlaintextPassword == "local"
+		return nil
+	}
+	userAPI := &fakePasswordProviderUserAPI{
+		accounts: map[string]*uapi.Account{
+			"deactivated": {Localpart: "deactivated", AccountType: uapi.AccountTypeUser, Deactivated: true},
+			"bridge":      {Localpart: "bridge", AccountType: uapi.AccountTypeAppService},
+			"locked":      {Localpart: "locked", AccountType: uapi.AccountTypeUser},
+		},
+		displayNames: map[string]string{},
+		threePIDs:    map[string]string{},
+		lockedOut:    map[string]bool{"locked": true},
+		failures:     map[string]int{},
+	}
+	cfg := &config.ClientAPI{
+		Matrix: &config.Global{ServerName: serverName},
+		PasswordAuthProvider: config.PasswordAuthProvider{
+			Enabled:       true,
+			URL:           srv.URL,
+			Timeout:       time.Second,
+			LocalFallback: true,
+		},
+	}
+
+	tests := []struct {
+		Name, Localpart, Password string
+		WantExists                bool
+		WantLockedOut             bool
+		WantLocalCalls            int
+	}{
+		{Name: "providerSuccess", Localpart: "alice", Password: "corporate", WantExists: true},
+		{Name: "providerSuccessAgain", Localpart: "alice", Password: "corporate", WantExists: true},
+		{Name: "localFallback", Localpart: "bob", Password: "local", WantExists: true, WantLocalCalls: 1},
+		{Name: "wrongPassword", Localpart: "alice", Password: "wrong", WantLocalCalls: 1},
+		{Name: "differentUser", Localpart: "mallory", Password: "anything", WantLocalCalls: 1},
+		{Name: "providerError", Localpart: "unreachable", Password: "anything", WantLocalCalls: 1},
+		{Name: "deactivatedAccount", Localpart: "deactivated", Password: "anything"},
+		{Name: "appserviceAccount", Localpart: "bridge", Password: "anything"},
+		{Name: "lockedOut", Localpart: "locked", Password: "anything", WantLockedOut: true},
+	}
+	ctx := context.Background()
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			localCalls = 0
+			check := NewGetAccountByPassword(cfg, local, userAPI)
+			res := &uapi.QueryAccountByPasswordResponse{}
+			if err := check(ctx, &uapi.QueryAccountByPasswordRequest{
+				Localpart: tst.Localpart, PlaintextPassword: tst.Password,
+			}, res); err != nil {
+				t.Fatalf("QueryAccountByPassword failed: %v", err)
+			}
+			if res.Exists != tst.WantExists {
+				t.Errorf("Exists: got %v, want %v", res.Exists, tst.WantExists)
+			}
+			if lockedOut := res.LockedUntilMS > 0; lockedOut != tst.WantLockedOut {
+				t.Errorf("locked out: got %v, want %v", lockedOut, tst.WantLockedOut)
+			}
+			if localCalls != tst.WantLocalCalls {
+				t.Errorf("local checks: got %d, want %d", localCalls, tst.WantLocalCalls)
+			}
+		})
+	}
+
+	if userAPI.accounts["alice"] == nil || userAPI.displayNames["alice"] != "Alice" || userAPI.threePIDs["alice@example.com"] != "alice" {
+		t.Errorf("expected alice's account to be provisioned from the provider's profile")
+	}
+	if userAPI.accounts["mallory"] != nil {
+		t.Errorf("expected mallory not to have an account")
+	}
+	if userAPI.accounts["deactivated"].AccountType != uapi.AccountTypeUser || !userAPI.accounts["deactivated"].Deactivated {
+		t.Errorf("expected the deactivated account to be left alone")
+	}
+
+	// Without the fallback, errors from the provider are returned and
+	// failures are recorded by the provider check itself.
+	cfg.PasswordAuthProvider.LocalFallback = false
+	check := NewGetAccountByPassword(cfg, local, userAPI)
+	if err := check(ctx, &uapi.QueryAccountByPasswordRequest{
+		Localpart: "unreachable", PlaintextPassword: "anything",
+	}, &uapi.QueryAccountByPasswordResponse{}); err == nil {
+		t.Errorf("expected an error when the provider fails without a fallback")
+	}
+	if err := check(ctx, &uapi.QueryAccountByPasswordRequest{
+		Localpart: "alice", PlaintextPassword: "wrong",
+	}, &uapi.QueryAccountByPasswordResponse{}); err != nil {
+		t.Fatalf("QueryAccountByPassword failed: %v", err)
+	}
+	if userAPI.failures["alice"] != 1 {
+		t.Errorf("expected the failed login to be recorded, got %d failures", userAPI.failures["alice"])
+	}
+}
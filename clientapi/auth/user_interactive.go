@@ -111,9 +111,16 @@ type UserInteractive struct {
 	Sessions map[string][]string
 }
 
-func NewUserInteractive(userAccountAPI api.UserLoginAPI, cfg *config.ClientAPI) *UserInteractive {
+// UserInternalAPIForUserInteractive contains the aspects of UserAPI required
+// for user-interactive authentication.
+type UserInternalAPIForUserInteractive interface {
+	api.UserLoginAPI
+	UserInternalAPIForPasswordProvider
+}
+
+func NewUserInteractive(userAccountAPI UserInternalAPIForUserInteractive, cfg *config.ClientAPI) *UserInteractive {
 	typePassword := &LoginTypePassword{
-		GetAccountByPassword: userAccountAPI.QueryAccountByPassword,
+		GetAccountByPassword: NewGetAccountByPassword(cfg, userAccountAPI.QueryAccountByPassword, userAccountAPI),
 		Config:               cfg,
 	}
 	return &UserInteractive{
@@ -45,6 +45,7 @@ func AddPublicRoutes(
 	cfg := &base.Cfg.ClientAPI
 	mscCfg := &base.Cfg.MSCs
 	js, natsClient := base.NATS.Prepare(base.ProcessContext, &cfg.Matrix.JetStream)
+	base.WatchAppServiceRegistrations()
 
 	syncProducer := &producers.SyncAPIProducer{
 		JetStream:              js,
@@ -65,5 +66,6 @@ func AddPublicRoutes(
 		userAPI, userDirectoryProvider, federation,
 		syncProducer, transactionsCache, fsAPI, keyAPI,
 		extRoomsProvider, mscCfg, natsClient,
+		base.ProcessContext,
 	)
 }
@@ -17,15 +17,19 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	userapi "github.com/matrix-org/dendrite/userapi/api"
 )
 
-// ParseTSParam takes a req (typically from an application service) and parses a Time object
-// from the req if it exists in the query parameters. If it doesn't exist, the
-// current time is returned.
-func ParseTSParam(req *http.Request) (time.Time, error) {
-	// Use the ts parameter's value for event time if present
+// ParseTSParam takes a req from an application service and parses a Time object
+// from the req if it exists in the query parameters. If it doesn't exist, or the
+// device doesn't belong to an application service, the current time is returned.
+func ParseTSParam(req *http.Request, device *userapi.Device) (time.Time, error) {
+	// Use the ts parameter's value for event time if present. Only application
+	// services may set the timestamp of the events that they send, e.g. when
+	// bridging messages from another network.
 	tsStr := req.URL.Query().Get("ts")
-	if tsStr == "" {
+	if tsStr == "" || device == nil || device.AppserviceID == "" {
 		return time.Now(), nil
 	}
 
@@ -35,5 +39,5 @@ func ParseTSParam(req *http.Request) (time.Time, error) {
 		return time.Time{}, fmt.Errorf("param 'ts' is no valid int (%s)", err.Error())
 	}
 
-	return time.Unix(ts/1000, 0), nil
+	return time.UnixMilli(ts), nil
 }
@@ -0,0 +1,41 @@
+package httputil
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestParseTSParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		device  *userapi.Device
+		wantTS  int64 // zero for the current time
+		wantErr bool
+	}{
+		{name: "appservice", query: "?ts=1234567", device: &userapi.Device{AppserviceID: "bridge"}, wantTS: 1234567},
+		{name: "appservice without ts", device: &userapi.Device{AppserviceID: "bridge"}},
+		{name: "appservice with invalid ts", query: "?ts=abc", device: &userapi.Device{AppserviceID: "bridge"}, wantErr: true},
+		{name: "user", query: "?ts=1234567", device: &userapi.Device{UserID: "@alice:test"}},
+		{name: "no device", query: "?ts=1234567"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			got, err := ParseTSParam(httptest.NewRequest("PUT", "/"+tt.query, nil), tt.device)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTSParam() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			switch {
+			case tt.wantErr:
+			case tt.wantTS != 0 && got.UnixMilli() != tt.wantTS:
+				t.Errorf("ParseTSParam() = %d, want %d", got.UnixMilli(), tt.wantTS)
+			case tt.wantTS == 0 && got.Before(before):
+				t.Errorf("ParseTSParam() = %v, want the current time", got)
+			}
+		})
+	}
+}
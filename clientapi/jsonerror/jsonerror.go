@@ -207,6 +207,52 @@ func LimitExceeded(msg string, retryAfterMS int64) *LimitExceededError {
 	}
 }
 
+// ResourceLimitExceededError is returned when the server has reached one
+// of its resource limits, e.g. its monthly active user limit.
+type ResourceLimitExceededError struct {
+	MatrixError
+	LimitType    string `json:"limit_type"`
+	AdminContact string `json:"admin_contact"`
+}
+
+// ResourceLimitExceeded is an error when the client can't use the server
+// because it has reached its monthly active user limit.
+func ResourceLimitExceeded(adminContact string) *ResourceLimitExceededError {
+	return &ResourceLimitExceededError{
+		MatrixError: MatrixError{
+			ErrCode: "M_RESOURCE_LIMIT_EXCEEDED",
+			Err:     "This server has exceeded its monthly active user limit",
+		},
+		LimitType:    "monthly_active_user",
+		AdminContact: adminContact,
+	}
+}
+
+// ExpiredAccount is an error returned when the user's account has expired
+// and must be renewed before it can be used again.
+func ExpiredAccount(msg string) *MatrixError {
+	return &MatrixError{"ORG_MATRIX_EXPIRED_ACCOUNT", msg}
+}
+
+// ConsentNotGivenError is returned when the user hasn't consented to the
+// current version of the server's terms of service.
+type ConsentNotGivenError struct {
+	MatrixError
+	ConsentURI string `json:"consent_uri"`
+}
+
+// ConsentNotGiven is an error when the user must consent to the server's
+// terms of service, at the given URI, before continuing.
+func ConsentNotGiven(msg, consentURI string) *ConsentNotGivenError {
+	return &ConsentNotGivenError{
+		MatrixError: MatrixError{
+			ErrCode: "M_CONSENT_NOT_GIVEN",
+			Err:     msg,
+		},
+		ConsentURI: consentURI,
+	}
+}
+
 // NotTrusted is an error which is returned when the client asks the server to
 // proxy a request (e.g. 3PID association) to a server that isn't trusted
 func NotTrusted(serverName string) *MatrixError {
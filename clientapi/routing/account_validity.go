@@ -0,0 +1,178 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/internal/mail"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// accountValidityReminderInterval is how often to check for accounts which
+// will soon expire.
+const accountValidityReminderInterval = time.Hour
+
+// accountValidityRenewPath is the path of the endpoint which renewal links
+// point to, relative to the public base URL.
+const accountValidityRenewPath = "/_matrix/client/unstable/account_validity/renew"
+
+type accountValidityResponse struct {
+	ExpirationTS int64 `json:"expiration_ts"`
+}
+
+// RenewAccountValidity implements GET /unstable/account_validity/renew, which
+// the links sent to users in renewal reminders point to.
+func RenewAccountValidity(req *http.Request, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingParam("Missing renewal token"),
+		}
+	}
+	var res userapi.PerformAccountValidityRenewalResponse
+	if err := userAPI.PerformAccountValidityRenewal(req.Context(), &userapi.PerformAccountValidityRenewalRequest{
+		RenewalToken: token,
+	}, &res); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if !res.Renewed {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown or already used renewal token"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: accountValidityResponse{ExpirationTS: res.ExpiresAtMS},
+	}
+}
+
+// runAccountValidityReminders periodically reminds the users whose accounts
+// will soon expire to renew them, by sending them a renewal link in a server
+// notice and by email. Server notices are only sent if senderDevice is set.
+// The renewal token is only stored once the reminder has been delivered, so
+// that users who couldn't be reached are tried again later.
+func runAccountValidityReminders(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) {
+	ticker := time.NewTicker(accountValidityReminderInterval)
+	defer ticker.Stop()
+	for {
+		var res userapi.PerformAccountValidityRemindersResponse
+		if err := userAPI.PerformAccountValidityReminders(ctx, &userapi.PerformAccountValidityRemindersRequest{}, &res); err != nil {
+			logrus.WithError(err).Error("Failed to get accounts due renewal")
+		}
+		for _, reminder := range res.Reminders {
+			if !sendAccountValidityReminder(ctx, cfg, userAPI, rsAPI, asAPI, senderDevice, reminder) {
+				continue
+			}
+			if err := userAPI.PerformAccountValidityReminderSent(ctx, &userapi.PerformAccountValidityReminderSentRequest{
+				UserID:       reminder.UserID,
+				RenewalToken: reminder.RenewalToken,
+			}, &userapi.PerformAccountValidityReminderSentResponse{}); err != nil {
+				logrus.WithError(err).WithField("user_id", reminder.UserID).Error("Failed to record account renewal reminder")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendAccountValidityReminder(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+	reminder userapi.AccountValidityReminder,
+) (delivered bool) {
+	logger := logrus.WithField("user_id", reminder.UserID)
+	link := strings.TrimSuffix(cfg.Matrix.AccountValidity.PublicBaseURL, "/") +
+		accountValidityRenewPath + "?token=" + url.QueryEscape(reminder.RenewalToken)
+	expiresAt := gomatrixserverlib.Timestamp(reminder.ExpiresAtMS).Time().UTC().Format(time.RFC1123)
+	body := fmt.Sprintf(
+		"Your account %s expires on %s. To keep using it, renew it by following this link: %s",
+		reminder.UserID, expiresAt, link,
+	)
+
+	if senderDevice != nil {
+		content := map[string]interface{}{
+			"msgtype": "m.text",
+			"body":    body,
+		}
+		res := sendServerNotice(
+			ctx, reminder.UserID, content, &cfg.Matrix.ServerNotices, cfg, userAPI, rsAPI, asAPI, senderDevice, nil,
+		)
+		if res.Code != http.StatusOK {
+			logger.Errorf("Failed to send account renewal reminder: %+v", res.JSON)
+		} else {
+			delivered = true
+		}
+	}
+
+	if !cfg.Matrix.SMTP.Enabled {
+		return
+	}
+	localpart, _, err := gomatrixserverlib.SplitID('@', reminder.UserID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to split user ID")
+		return
+	}
+	var threePIDs userapi.QueryThreePIDsForLocalpartResponse
+	if err = userAPI.QueryThreePIDsForLocalpart(ctx, &userapi.QueryThreePIDsForLocalpartRequest{
+		Localpart: localpart,
+	}, &threePIDs); err != nil {
+		logger.WithError(err).Error("Failed to get email addresses for account renewal reminder")
+		return
+	}
+	var emails []string
+	for _, threePID := range threePIDs.ThreePIDs {
+		if threePID.Medium == "email" {
+			emails = append(emails, threePID.Address)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+	if err = mail.Send(&cfg.Matrix.SMTP, emails, "Renew your Matrix account", body); err != nil {
+		logger.WithError(err).Error("Failed to email account renewal reminder")
+		return
+	}
+	return true
+}
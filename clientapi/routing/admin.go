@@ -3,6 +3,7 @@ package routing
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
 
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/auth"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/internal/httputil"
 	"github.com/matrix-org/dendrite/keyserver/api"
@@ -124,6 +127,9 @@ func AdminResetPassword(req *http.Request, cfg *config.ClientAPI, device *userap
 			JSON: jsonerror.MissingArgument("Expecting non-empty password."),
 		}
 	}
+	if resErr := validatePassword(req, request.Password, cfg); resErr != nil {
+		return *resErr
+	}
 	updateReq := &userapi.PerformPasswordUpdateRequest{
 		Localpart:     localpart,
 		Password:      request.Password,
@@ -146,6 +152,336 @@ func AdminResetPassword(req *http.Request, cfg *config.ClientAPI, device *userap
 	}
 }
 
+func AdminImpersonateUser(req *http.Request, cfg *config.ClientAPI, device *userapi.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	_, localpart, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	request := struct {
+		LifetimeMS int64 `json:"lifetime_ms"`
+	}{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.Unknown("Failed to decode request body: " + err.Error()),
+			}
+		}
+	}
+	if request.LifetimeMS < 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("lifetime_ms must not be negative"),
+		}
+	}
+	accessToken, err := auth.GenerateAccessToken()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("auth.GenerateAccessToken failed")
+		return jsonerror.InternalServerError()
+	}
+	impersonateReq := &userapi.PerformImpersonationTokenCreationRequest{
+		AdminUserID: device.UserID,
+		Localpart:   localpart,
+		AccessToken: accessToken,
+		Lifetime:    time.Duration(request.LifetimeMS) * time.Millisecond,
+	}
+	impersonateRes := &userapi.PerformImpersonationTokenCreationResponse{}
+	if err := userAPI.PerformImpersonationTokenCreation(req.Context(), impersonateReq, impersonateRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if impersonateRes.Device == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("User not found."),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"user_id":       impersonateRes.Device.UserID,
+			"device_id":     impersonateRes.Device.ID,
+			"access_token":  impersonateRes.Device.AccessToken,
+			"expires_at_ms": impersonateRes.ExpiresAtMS,
+		},
+	}
+}
+
+func AdminImpersonationAudit(req *http.Request, cfg *config.ClientAPI, device *userapi.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	_, localpart, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	auditRes := &userapi.QueryImpersonationAuditResponse{}
+	if err := userAPI.QueryImpersonationAudit(req.Context(), &userapi.QueryImpersonationAuditRequest{
+		Localpart: localpart,
+	}, auditRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	entries := auditRes.Entries
+	if entries == nil {
+		entries = []userapi.ImpersonationAuditEntry{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"impersonations": entries,
+		},
+	}
+}
+
+// adminLocalUserFromVars returns the user ID and localpart of the local
+// user named by the {userID} path parameter.
+func adminLocalUserFromVars(req *http.Request, cfg *config.ClientAPI) (string, string, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		res := util.ErrorResponse(err)
+		return "", "", &res
+	}
+	userID, ok := vars["userID"]
+	if !ok {
+		return "", "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("Expecting user ID."),
+		}
+	}
+	localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		res := util.MessageResponse(http.StatusBadRequest, err.Error())
+		return "", "", &res
+	}
+	if domain != cfg.Matrix.ServerName {
+		return "", "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("User ID must belong to this server."),
+		}
+	}
+	return userID, localpart, nil
+}
+
+// AdminShadowBanUser shadow-bans a user on POST, and lifts the shadow-ban on DELETE.
+func AdminShadowBanUser(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	_, localpart, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	banRes := &userapi.PerformShadowBanResponse{}
+	if err := userAPI.PerformShadowBan(req.Context(), &userapi.PerformShadowBanRequest{
+		Localpart:    localpart,
+		ShadowBanned: req.Method == http.MethodPost,
+	}, banRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if !banRes.AccountExists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("User not found."),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// AdminUnlockAccount forgets the failed logins to a user's account, lifting
+// the lockout if it is locked out.
+func AdminUnlockAccount(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	_, localpart, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	unlockRes := &userapi.PerformLoginLockoutResetResponse{}
+	if err := userAPI.PerformLoginLockoutReset(req.Context(), &userapi.PerformLoginLockoutResetRequest{
+		Localpart: localpart,
+	}, unlockRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if !unlockRes.AccountExists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("User not found."),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// AdminSetAccountValidity renews a user's account, optionally until the
+// expiration_ts given in the request body.
+func AdminSetAccountValidity(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	userID, _, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	request := struct {
+		ExpirationTS int64 `json:"expiration_ts"`
+	}{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.Unknown("Failed to decode request body: " + err.Error()),
+			}
+		}
+	}
+	if request.ExpirationTS < 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("expiration_ts must not be negative"),
+		}
+	}
+	if request.ExpirationTS == 0 && !cfg.Matrix.AccountValidity.Enabled {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingParam("expiration_ts is required when account validity is disabled"),
+		}
+	}
+	var res userapi.PerformAccountValidityRenewalResponse
+	if err := userAPI.PerformAccountValidityRenewal(req.Context(), &userapi.PerformAccountValidityRenewalRequest{
+		UserID:      userID,
+		ExpiresAtMS: request.ExpirationTS,
+	}, &res); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if !res.Renewed {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("User not found."),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: accountValidityResponse{ExpirationTS: res.ExpiresAtMS},
+	}
+}
+
+func AdminListDevices(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	userID, _, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	var queryRes userapi.QueryDevicesResponse
+	if err := userAPI.QueryDevices(req.Context(), &userapi.QueryDevicesRequest{
+		UserID: userID,
+	}, &queryRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	res := devicesJSON{
+		Devices: []deviceJSON{},
+	}
+	for _, dev := range queryRes.Devices {
+		res.Devices = append(res.Devices, deviceJSON{
+			DeviceID:    dev.ID,
+			DisplayName: dev.DisplayName,
+			LastSeenIP:  stripIPPort(dev.LastSeenIP),
+			LastSeenTS:  dev.LastSeenTS,
+		})
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func AdminUpdateDevice(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	userID, _, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	deviceID := vars["deviceID"]
+	var payload deviceUpdateJSON
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The request body could not be decoded into valid JSON. " + err.Error()),
+		}
+	}
+	// The update is performed on behalf of the user so that the usual
+	// device key display name updates are sent.
+	var updateRes userapi.PerformDeviceUpdateResponse
+	if err := userAPI.PerformDeviceUpdate(req.Context(), &userapi.PerformDeviceUpdateRequest{
+		RequestingUserID: userID,
+		DeviceID:         deviceID,
+		DisplayName:      payload.DisplayName,
+	}, &updateRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if !updateRes.DeviceExists || updateRes.Forbidden {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown device"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func AdminDeleteDevice(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	userID, _, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	deviceID := vars["deviceID"]
+	var queryRes userapi.QueryDevicesResponse
+	if err := userAPI.QueryDevices(req.Context(), &userapi.QueryDevicesRequest{
+		UserID: userID,
+	}, &queryRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	found := false
+	for _, dev := range queryRes.Devices {
+		if dev.ID == deviceID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown device"),
+		}
+	}
+	if err := userAPI.PerformDeviceDeletion(req.Context(), &userapi.PerformDeviceDeletionRequest{
+		UserID:    userID,
+		DeviceIDs: []string{deviceID},
+	}, &userapi.PerformDeviceDeletionResponse{}); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func AdminLogoutAllDevices(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	userID, _, errRes := adminLocalUserFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	// An empty list of device IDs deletes all of the user's devices.
+	if err := userAPI.PerformDeviceDeletion(req.Context(), &userapi.PerformDeviceDeletionRequest{
+		UserID: userID,
+	}, &userapi.PerformDeviceDeletionResponse{}); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
 func AdminReindex(req *http.Request, cfg *config.ClientAPI, device *userapi.Device, natsClient *nats.Conn) util.JSONResponse {
 	_, err := natsClient.RequestMsg(nats.NewMsg(cfg.Matrix.JetStream.Prefixed(jetstream.InputFulltextReindex)), time.Second*10)
 	if err != nil {
@@ -191,3 +527,122 @@ func AdminMarkAsStale(req *http.Request, cfg *config.ClientAPI, keyAPI api.Clien
 		JSON: struct{}{},
 	}
 }
+
+func AdminListAppservices(req *http.Request, asAPI appserviceAPI.AppServiceInternalAPI) util.JSONResponse {
+	var queryRes appserviceAPI.QueryAppservicesResponse
+	if err := asAPI.QueryAppservices(req.Context(), &appserviceAPI.QueryAppservicesRequest{}, &queryRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if queryRes.Appservices == nil {
+		queryRes.Appservices = []appserviceAPI.AppserviceRegistration{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: queryRes,
+	}
+}
+
+func AdminRegisterAppservice(req *http.Request, cfg *config.ClientAPI, asAPI appserviceAPI.AppServiceInternalAPI) util.JSONResponse {
+	appserviceID, errRes := adminAppserviceFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	// The registration is the same YAML as the files listed in the config,
+	// but as JSON is a subset of YAML it can be sent as JSON too.
+	registration, err := io.ReadAll(req.Body)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The request body could not be read. " + err.Error()),
+		}
+	}
+	var performRes appserviceAPI.PerformRegisterAppserviceResponse
+	if err = asAPI.PerformRegisterAppservice(req.Context(), &appserviceAPI.PerformRegisterAppserviceRequest{
+		AppserviceID: appserviceID,
+		Registration: registration,
+	}, &performRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if performRes.Error != "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam(performRes.Error),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func AdminRemoveAppservice(req *http.Request, cfg *config.ClientAPI, asAPI appserviceAPI.AppServiceInternalAPI) util.JSONResponse {
+	appserviceID, errRes := adminAppserviceFromVars(req, cfg)
+	if errRes != nil {
+		return *errRes
+	}
+	var performRes appserviceAPI.PerformRemoveAppserviceResponse
+	if err := asAPI.PerformRemoveAppservice(req.Context(), &appserviceAPI.PerformRemoveAppserviceRequest{
+		AppserviceID: appserviceID,
+	}, &performRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if performRes.Error != "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam(performRes.Error),
+		}
+	}
+	if !performRes.Removed {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown application service"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func AdminAppserviceStatus(req *http.Request, asAPI appserviceAPI.AppServiceInternalAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	var queryRes appserviceAPI.QueryAppserviceStatusResponse
+	if err = asAPI.QueryAppserviceStatus(req.Context(), &appserviceAPI.QueryAppserviceStatusRequest{
+		AppserviceID: vars["appserviceID"],
+	}, &queryRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if len(queryRes.Statuses) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Unknown application service"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: queryRes.Statuses[0],
+	}
+}
+
+// adminAppserviceFromVars returns the application service ID from the
+// request path, refusing to touch the ones loaded from the config files.
+func adminAppserviceFromVars(req *http.Request, cfg *config.ClientAPI) (string, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam(err.Error()),
+		}
+	}
+	appserviceID := vars["appserviceID"]
+	if cfg.Derived.IsConfigAppService(appserviceID) {
+		return "", &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Application services from the config file can't be changed at runtime"),
+		}
+	}
+	return appserviceID, nil
+}
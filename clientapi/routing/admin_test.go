@@ -0,0 +1,176 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// deviceAdminUserAPI records the device requests made by the admin device
+// endpoints. Any other user API call panics.
+type deviceAdminUserAPI struct {
+	userapi.ClientUserAPI
+	devices   []userapi.Device
+	updates   []userapi.PerformDeviceUpdateRequest
+	deletions []userapi.PerformDeviceDeletionRequest
+}
+
+func (a *deviceAdminUserAPI) QueryDevices(ctx context.Context, req *userapi.QueryDevicesRequest, res *userapi.QueryDevicesResponse) error {
+	res.UserExists = true
+	for _, dev := range a.devices {
+		if dev.UserID == req.UserID {
+			res.Devices = append(res.Devices, dev)
+		}
+	}
+	return nil
+}
+
+func (a *deviceAdminUserAPI) PerformDeviceUpdate(ctx context.Context, req *userapi.PerformDeviceUpdateRequest, res *userapi.PerformDeviceUpdateResponse) error {
+	a.updates = append(a.updates, *req)
+	for _, dev := range a.devices {
+		if dev.UserID == req.RequestingUserID && dev.ID == req.DeviceID {
+			res.DeviceExists = true
+		}
+	}
+	return nil
+}
+
+func (a *deviceAdminUserAPI) PerformDeviceDeletion(ctx context.Context, req *userapi.PerformDeviceDeletionRequest, res *userapi.PerformDeviceDeletionResponse) error {
+	a.deletions = append(a.deletions, *req)
+	return nil
+}
+
+func TestAdminDevices(t *testing.T) {
+	const alice = "@alice:test"
+	cfg := &config.ClientAPI{Matrix: &config.Global{}}
+	cfg.Matrix.ServerName = "test"
+
+	setup := func() (*deviceAdminUserAPI, *mux.Router) {
+		userAPI := &deviceAdminUserAPI{
+			devices: []userapi.Device{
+				{UserID: alice, ID: "PHONE", DisplayName: "Phone", LastSeenIP: "192.0.2.1:1234"},
+				{UserID: alice, ID: "LAP/TOP", DisplayName: "Laptop"},
+				{UserID: "@bob:test", ID: "BOBPHONE"},
+			},
+		}
+		// The admin router uses encoded paths, so the handlers have to decode
+		// the path variables themselves.
+		router := mux.NewRouter().UseEncodedPath()
+		router.HandleFunc("/admin/devices/{userID}", func(w http.ResponseWriter, req *http.Request) {
+			writeJSONResponse(w, AdminListDevices(req, cfg, userAPI))
+		}).Methods(http.MethodGet)
+		router.HandleFunc("/admin/devices/{userID}/{deviceID}", func(w http.ResponseWriter, req *http.Request) {
+			writeJSONResponse(w, AdminUpdateDevice(req, cfg, userAPI))
+		}).Methods(http.MethodPut)
+		router.HandleFunc("/admin/devices/{userID}/{deviceID}", func(w http.ResponseWriter, req *http.Request) {
+			writeJSONResponse(w, AdminDeleteDevice(req, cfg, userAPI))
+		}).Methods(http.MethodDelete)
+		router.HandleFunc("/admin/logoutAllDevices/{userID}", func(w http.ResponseWriter, req *http.Request) {
+			writeJSONResponse(w, AdminLogoutAllDevices(req, cfg, userAPI))
+		}).Methods(http.MethodPost)
+		return userAPI, router
+	}
+	request := func(router *mux.Router, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	t.Run("list", func(t *testing.T) {
+		_, router := setup()
+		rec := request(router, http.MethodGet, "/admin/devices/%40alice%3Atest", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var res devicesJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		want := devicesJSON{Devices: []deviceJSON{
+			{DeviceID: "PHONE", DisplayName: "Phone", LastSeenIP: "192.0.2.1"},
+			{DeviceID: "LAP/TOP", DisplayName: "Laptop"},
+		}}
+		if !reflect.DeepEqual(res, want) {
+			t.Fatalf("expected %+v, got %+v", want, res)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		userAPI, router := setup()
+		rec := request(router, http.MethodPut, "/admin/devices/%40alice%3Atest/LAP%2FTOP", `{"display_name":"Work laptop"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if len(userAPI.updates) != 1 {
+			t.Fatalf("expected one PerformDeviceUpdate call, got %d", len(userAPI.updates))
+		}
+		update := userAPI.updates[0]
+		if update.RequestingUserID != alice || update.DeviceID != "LAP/TOP" || update.DisplayName == nil || *update.DisplayName != "Work laptop" {
+			t.Fatalf("unexpected PerformDeviceUpdate request: %+v", update)
+		}
+
+		// Renaming another user's device, or one that doesn't exist, fails.
+		for _, deviceID := range []string{"BOBPHONE", "UNKNOWN"} {
+			if rec = request(router, http.MethodPut, "/admin/devices/%40alice%3Atest/"+deviceID, `{"display_name":"x"}`); rec.Code != http.StatusNotFound {
+				t.Fatalf("expected 404 for device %q, got %d", deviceID, rec.Code)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		userAPI, router := setup()
+		rec := request(router, http.MethodDelete, "/admin/devices/%40alice%3Atest/LAP%2FTOP", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		want := []userapi.PerformDeviceDeletionRequest{{UserID: alice, DeviceIDs: []string{"LAP/TOP"}}}
+		if !reflect.DeepEqual(userAPI.deletions, want) {
+			t.Fatalf("expected PerformDeviceDeletion calls %+v, got %+v", want, userAPI.deletions)
+		}
+
+		// Deleting another user's device doesn't reach the user API.
+		if rec = request(router, http.MethodDelete, "/admin/devices/%40alice%3Atest/BOBPHONE", ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", rec.Code)
+		}
+		if len(userAPI.deletions) != 1 {
+			t.Fatalf("expected no further PerformDeviceDeletion calls, got %+v", userAPI.deletions)
+		}
+	})
+
+	t.Run("logout all", func(t *testing.T) {
+		userAPI, router := setup()
+		rec := request(router, http.MethodPost, "/admin/logoutAllDevices/%40alice%3Atest", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		want := []userapi.PerformDeviceDeletionRequest{{UserID: alice}}
+		if !reflect.DeepEqual(userAPI.deletions, want) {
+			t.Fatalf("expected PerformDeviceDeletion calls %+v, got %+v", want, userAPI.deletions)
+		}
+	})
+
+	t.Run("remote user", func(t *testing.T) {
+		userAPI, router := setup()
+		if rec := request(router, http.MethodPost, "/admin/logoutAllDevices/%40alice%3Aremote", ""); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+		if len(userAPI.deletions) != 0 {
+			t.Fatalf("expected no PerformDeviceDeletion calls, got %+v", userAPI.deletions)
+		}
+	})
+}
+
+func writeJSONResponse(w http.ResponseWriter, res util.JSONResponse) {
+	w.WriteHeader(res.Code)
+	_ = json.NewEncoder(w).Encode(res.JSON)
+}
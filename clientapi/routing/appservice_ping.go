@@ -0,0 +1,101 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/matrix-org/util"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	clientutil "github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type appservicePingRequest struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+type appservicePingResponse struct {
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// appservicePingError is a Matrix error with the extra fields which MSC2659
+// returns when the application service responds with an error.
+type appservicePingError struct {
+	ErrCode    string `json:"errcode"`
+	Err        string `json:"error"`
+	StatusCode int    `json:"status,omitempty"`
+	Body       string `json:"body,omitempty"`
+}
+
+// AppservicePing implements POST /appservice/{appserviceID}/ping (MSC2659),
+// which lets an application service check that the homeserver can reach it.
+func AppservicePing(req *http.Request, device *userapi.Device, asAPI appserviceAPI.AppServiceInternalAPI, appserviceID string) util.JSONResponse {
+	if device.AppserviceID == "" || device.AppserviceID != appserviceID {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Only the application service itself can ping it"),
+		}
+	}
+
+	// The body is optional, as the transaction ID is.
+	var body appservicePingRequest
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return jsonerror.InternalServerError()
+	}
+	if len(data) > 0 {
+		if resErr := clientutil.UnmarshalJSON(data, &body); resErr != nil {
+			return *resErr
+		}
+	}
+
+	var pingRes appserviceAPI.PerformAppservicePingResponse
+	if err = asAPI.PerformAppservicePing(req.Context(), &appserviceAPI.PerformAppservicePingRequest{
+		AppserviceID:  appserviceID,
+		TransactionID: body.TransactionID,
+	}, &pingRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+
+	code := http.StatusOK
+	switch pingRes.ErrCode {
+	case "":
+		return util.JSONResponse{
+			Code: code,
+			JSON: appservicePingResponse{DurationMS: pingRes.DurationMS},
+		}
+	case appserviceAPI.PingErrNotFound:
+		code = http.StatusNotFound
+	case appserviceAPI.PingErrURLNotSet:
+		code = http.StatusBadRequest
+	case appserviceAPI.PingErrConnectionTimeout:
+		code = http.StatusGatewayTimeout
+	default:
+		code = http.StatusBadGateway
+	}
+	return util.JSONResponse{
+		Code: code,
+		JSON: appservicePingError{
+			ErrCode:    pingRes.ErrCode,
+			Err:        pingRes.Error,
+			StatusCode: pingRes.StatusCode,
+			Body:       pingRes.Body,
+		},
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type batchSendEvent struct {
+	Type           string                      `json:"type"`
+	Sender         string                      `json:"sender"`
+	OriginServerTS gomatrixserverlib.Timestamp `json:"origin_server_ts"`
+	StateKey       *string                     `json:"state_key,omitempty"`
+	Content        map[string]interface{}      `json:"content"`
+}
+
+type batchSendRequest struct {
+	StateEventsAtStart []batchSendEvent `json:"state_events_at_start"`
+	Events             []batchSendEvent `json:"events"`
+}
+
+type batchSendResponse struct {
+	StateEventIDs        []string `json:"state_event_ids"`
+	EventIDs             []string `json:"event_ids"`
+	NextBatchID          string   `json:"next_batch_id"`
+	InsertionEventID     string   `json:"insertion_event_id"`
+	BatchEventID         string   `json:"batch_event_id"`
+	BaseInsertionEventID string   `json:"base_insertion_event_id,omitempty"`
+}
+
+// BatchSend implements POST /rooms/{roomID}/batch_send (MSC2716), which lets
+// an application service import a batch of history into a room after the
+// event given by the prev_event_id query parameter.
+//
+// The imported events are chained together after the prev event, starting
+// with the state events, followed by an insertion event, the events of the
+// batch and finally a batch event which connects the batch to the insertion
+// event of the previous batch, given by the batch_id query parameter. If no
+// batch ID is given then a base insertion event is created for the batch to
+// connect to. The events are sent to the roomserver as old events, so they
+// don't change the current state of the room and aren't sent to other
+// servers: the application service should send a marker event pointing at
+// the base insertion event so that other servers backfill the history. The
+// application service's sender needs the room's "historical" power level.
+func BatchSend(
+	req *http.Request, device *userapi.Device, roomID string,
+	cfg *config.ClientAPI, rsAPI api.ClientRoomserverAPI,
+) util.JSONResponse {
+	var appservice *config.ApplicationService
+	if device.AppserviceID != "" {
+		for _, as := range cfg.Derived.AppServices() {
+			if as.ID == device.AppserviceID {
+				as := as
+				appservice = &as
+				break
+			}
+		}
+	}
+	if appservice == nil {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Only application services can import history into rooms"),
+		}
+	}
+
+	prevEventID := req.URL.Query().Get("prev_event_id")
+	if prevEventID == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingParam("The prev_event_id query parameter is required"),
+		}
+	}
+	batchID := req.URL.Query().Get("batch_id")
+
+	var body batchSendRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+	if len(body.Events) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The batch must contain at least one event"),
+		}
+	}
+	for i, ev := range append(body.StateEventsAtStart, body.Events...) {
+		isState := i < len(body.StateEventsAtStart)
+		switch {
+		case ev.Type == "" || ev.OriginServerTS == 0:
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("Events must have a type and an origin_server_ts"),
+			}
+		case isState && ev.StateKey == nil:
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("The state_events_at_start must all be state events"),
+			}
+		case !isState && ev.StateKey != nil:
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.BadJSON("The events of a batch must not be state events"),
+			}
+		}
+		_, domain, err := gomatrixserverlib.SplitID('@', ev.Sender)
+		if err != nil || domain != cfg.Matrix.ServerName || (ev.Sender != device.UserID && !appservice.IsInterestedInUserID(ev.Sender)) {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden("Application service cannot send events as " + ev.Sender),
+			}
+		}
+	}
+
+	prevEvent := api.GetEvent(req.Context(), rsAPI, prevEventID)
+	if prevEvent == nil || prevEvent.RoomID() != roomID {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("The prev_event_id was not found in the room"),
+		}
+	}
+	if batchID != "" {
+		var insertionRes api.QueryInsertionEventForBatchResponse
+		if err := rsAPI.QueryInsertionEventForBatch(req.Context(), &api.QueryInsertionEventForBatchRequest{
+			RoomID:  roomID,
+			BatchID: batchID,
+		}, &insertionRes); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryInsertionEventForBatch failed")
+			return jsonerror.InternalServerError()
+		}
+		if insertionRes.InsertionEventID == "" {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidParam("No insertion event was found for the batch_id"),
+			}
+		}
+	}
+
+	var stateRes api.QueryStateAfterEventsResponse
+	if err := rsAPI.QueryStateAfterEvents(req.Context(), &api.QueryStateAfterEventsRequest{
+		RoomID:       roomID,
+		PrevEventIDs: []string{prevEventID},
+	}, &stateRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryStateAfterEvents failed")
+		return jsonerror.InternalServerError()
+	}
+	if !stateRes.RoomExists || !stateRes.PrevEventsExist {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("The state at the prev_event_id is not known"),
+		}
+	}
+
+	// Importing history is limited to users with the "historical" power
+	// level, since it lets them rewrite what the room looks like to others.
+	var powerLevels *gomatrixserverlib.Event
+	for _, ev := range stateRes.StateEvents {
+		if ev.Type() == gomatrixserverlib.MRoomPowerLevels && ev.StateKeyEquals("") {
+			powerLevels = ev.Event
+		}
+	}
+	if !api.MSC2716CanSendHistorical(powerLevels, device.UserID) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("You don't have the historical power level needed to import history into this room"),
+		}
+	}
+
+	history := &historyBuilder{
+		ctx:         req.Context(),
+		cfg:         cfg,
+		roomID:      roomID,
+		roomVersion: stateRes.RoomVersion,
+		prevEvent:   prevEvent.EventReference(),
+		depth:       prevEvent.Depth(),
+		state:       map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent{},
+	}
+	for _, ev := range stateRes.StateEvents {
+		history.state[gomatrixserverlib.StateKeyTuple{EventType: ev.Type(), StateKey: *ev.StateKey()}] = ev
+	}
+	firstTS := body.Events[0].OriginServerTS.Time()
+	lastTS := body.Events[len(body.Events)-1].OriginServerTS.Time()
+
+	var events []*gomatrixserverlib.HeaderedEvent
+	var res batchSendResponse
+	var resErr *util.JSONResponse
+
+	// If we haven't been given a batch to connect to then create a base
+	// insertion event for this batch to connect to instead. It hangs off the
+	// prev event separately from the rest of the batch.
+	if batchID == "" {
+		batchID = util.RandomString(16)
+		var baseInsertion *gomatrixserverlib.HeaderedEvent
+		if baseInsertion, resErr = history.build(device.UserID, api.MSC2716Insertion, nil, map[string]interface{}{
+			api.MSC2716NextBatchIDKey: batchID,
+		}, lastTS); resErr != nil {
+			return *resErr
+		}
+		history.prevEvent = prevEvent.EventReference()
+		events = append(events, baseInsertion)
+		res.BaseInsertionEventID = baseInsertion.EventID()
+	}
+
+	for _, ev := range body.StateEventsAtStart {
+		var stateEvent *gomatrixserverlib.HeaderedEvent
+		if stateEvent, resErr = history.build(ev.Sender, ev.Type, ev.StateKey, ev.Content, ev.OriginServerTS.Time()); resErr != nil {
+			return *resErr
+		}
+		events = append(events, stateEvent)
+		res.StateEventIDs = append(res.StateEventIDs, stateEvent.EventID())
+	}
+
+	res.NextBatchID = util.RandomString(16)
+	insertion, resErr := history.build(device.UserID, api.MSC2716Insertion, nil, map[string]interface{}{
+		api.MSC2716NextBatchIDKey: res.NextBatchID,
+	}, firstTS)
+	if resErr != nil {
+		return *resErr
+	}
+	events = append(events, insertion)
+	res.InsertionEventID = insertion.EventID()
+
+	for _, ev := range body.Events {
+		var event *gomatrixserverlib.HeaderedEvent
+		if event, resErr = history.build(ev.Sender, ev.Type, nil, ev.Content, ev.OriginServerTS.Time()); resErr != nil {
+			return *resErr
+		}
+		events = append(events, event)
+		res.EventIDs = append(res.EventIDs, event.EventID())
+	}
+
+	batch, resErr := history.build(device.UserID, api.MSC2716Batch, nil, map[string]interface{}{
+		api.MSC2716BatchIDKey: batchID,
+	}, lastTS)
+	if resErr != nil {
+		return *resErr
+	}
+	events = append(events, batch)
+	res.BatchEventID = batch.EventID()
+
+	inputs := make([]api.InputRoomEvent, 0, len(events))
+	for _, event := range events {
+		inputs = append(inputs, api.InputRoomEvent{
+			Kind:         api.KindOld,
+			Event:        event,
+			Origin:       cfg.Matrix.ServerName,
+			SendAsServer: api.DoNotSendToOtherServers,
+		})
+	}
+	if err := api.SendInputRoomEvents(req.Context(), rsAPI, inputs, false); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("SendInputRoomEvents failed")
+		return jsonerror.InternalServerError()
+	}
+	util.GetLogger(req.Context()).WithFields(logrus.Fields{
+		"room_id":       roomID,
+		"appservice_id": appservice.ID,
+		"events":        len(events),
+	}).Info("Imported batch of history")
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// historyBuilder builds a chain of historical events, each of which follows
+// the previous one, authed against the state at the point in the room that
+// the history is being imported into.
+type historyBuilder struct {
+	ctx         context.Context
+	cfg         *config.ClientAPI
+	roomID      string
+	roomVersion gomatrixserverlib.RoomVersion
+	prevEvent   gomatrixserverlib.EventReference
+	depth       int64
+	state       map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent
+}
+
+func (h *historyBuilder) build(
+	sender, eventType string, stateKey *string, content map[string]interface{}, evTime time.Time,
+) (*gomatrixserverlib.HeaderedEvent, *util.JSONResponse) {
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	content[api.MSC2716HistoricalKey] = true
+	builder := gomatrixserverlib.EventBuilder{
+		Sender:   sender,
+		RoomID:   h.roomID,
+		Type:     eventType,
+		StateKey: stateKey,
+	}
+	if err := builder.SetContent(content); err != nil {
+		util.GetLogger(h.ctx).WithError(err).Error("builder.SetContent failed")
+		resErr := jsonerror.InternalServerError()
+		return nil, &resErr
+	}
+	eventsNeeded, err := gomatrixserverlib.StateNeededForEventBuilder(&builder)
+	if err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON(err.Error()),
+		}
+	}
+
+	// Historical events all take the depth of the event that the history is
+	// being imported after, so that they are ordered between it and the
+	// events that follow it.
+	queryRes := &api.QueryLatestEventsAndStateResponse{
+		RoomExists:   true,
+		RoomVersion:  h.roomVersion,
+		Depth:        h.depth,
+		LatestEvents: []gomatrixserverlib.EventReference{h.prevEvent},
+		StateEvents:  make([]*gomatrixserverlib.HeaderedEvent, 0, len(h.state)),
+	}
+	authEvents := gomatrixserverlib.NewAuthEvents(nil)
+	for _, ev := range h.state {
+		queryRes.StateEvents = append(queryRes.StateEvents, ev)
+		_ = authEvents.AddEvent(ev.Event)
+	}
+	event, err := eventutil.BuildEvent(h.ctx, &builder, h.cfg.Matrix, evTime, &eventsNeeded, queryRes)
+	if err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON(err.Error()),
+		}
+	}
+	if err = gomatrixserverlib.Allowed(event.Event, &authEvents); err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden(err.Error()),
+		}
+	}
+
+	h.prevEvent = event.EventReference()
+	if stateKey != nil {
+		h.state[gomatrixserverlib.StateKeyTuple{EventType: eventType, StateKey: *stateKey}] = event
+	}
+	return event, nil
+}
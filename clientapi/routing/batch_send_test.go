@@ -0,0 +1,168 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/roomserver"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/test/testrig"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestBatchSend(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+	prevEventID := room.Events()[len(room.Events())-1].EventID()
+	bridgeBob := "@bridge_bob:test"
+
+	body := map[string]interface{}{
+		"state_events_at_start": []map[string]interface{}{
+			{
+				"type":             gomatrixserverlib.MRoomMember,
+				"sender":           bridgeBob,
+				"state_key":        bridgeBob,
+				"origin_server_ts": 1000,
+				"content":          map[string]interface{}{"membership": gomatrixserverlib.Join},
+			},
+		},
+		"events": []map[string]interface{}{
+			{
+				"type":             "m.room.message",
+				"sender":           bridgeBob,
+				"origin_server_ts": 2000,
+				"content":          map[string]interface{}{"msgtype": "m.text", "body": "first"},
+			},
+			{
+				"type":             "m.room.message",
+				"sender":           bridgeBob,
+				"origin_server_ts": 3000,
+				"content":          map[string]interface{}{"msgtype": "m.text", "body": "second"},
+			},
+		},
+	}
+
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, close := testrig.CreateBaseDendrite(t, dbType)
+		defer close()
+
+		rsAPI := roomserver.NewInternalAPI(base)
+		// SetFederationAPI starts the room event input consumer
+		rsAPI.SetFederationAPI(nil, nil)
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		localpart, _, err := gomatrixserverlib.SplitID('@', alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = base.Cfg.AppServiceAPI.SetRuntimeAppServices([]config.ApplicationService{{
+			ID:              "bridge",
+			ASToken:         "as_token",
+			HSToken:         "hs_token",
+			SenderLocalpart: localpart,
+			NamespaceMap: map[string][]config.ApplicationServiceNamespace{
+				"users": {{Exclusive: true, Regex: "@bridge_.*"}},
+			},
+		}}); err != nil {
+			t.Fatalf("failed to register application service: %v", err)
+		}
+		asDevice := &userapi.Device{UserID: alice.ID, AppserviceID: "bridge"}
+
+		batchSend := func(device *userapi.Device, query string, body interface{}) util.JSONResponse {
+			data, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/?"+query, bytes.NewReader(data))
+			return BatchSend(req, device, room.ID, &base.Cfg.ClientAPI, rsAPI)
+		}
+
+		// Only application services can import history
+		res := batchSend(&userapi.Device{UserID: alice.ID}, "prev_event_id="+prevEventID, body)
+		if res.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for a normal user, got %d", res.Code)
+		}
+		// The sender needs the historical power level, which defaults to 100
+		bob := test.NewUser(t)
+		res = batchSend(&userapi.Device{UserID: bob.ID, AppserviceID: "bridge"}, "prev_event_id="+prevEventID, body)
+		if res.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 without the historical power level, got %d", res.Code)
+		}
+		// The prev event is required, and the batch must exist if given
+		if res = batchSend(asDevice, "", body); res.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 without prev_event_id, got %d", res.Code)
+		}
+		if res = batchSend(asDevice, "prev_event_id="+prevEventID+"&batch_id=unknown", body); res.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for an unknown batch_id, got %d", res.Code)
+		}
+		// The application service can't send as users outside of its namespace
+		outside := map[string]interface{}{
+			"events": []map[string]interface{}{{
+				"type":             "m.room.message",
+				"sender":           "@someone_else:test",
+				"origin_server_ts": 2000,
+				"content":          map[string]interface{}{"body": "hello"},
+			}},
+		}
+		if res = batchSend(asDevice, "prev_event_id="+prevEventID, outside); res.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for a sender outside the namespace, got %d", res.Code)
+		}
+
+		res = batchSend(asDevice, "prev_event_id="+prevEventID, body)
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %+v", res.Code, res.JSON)
+		}
+		first := res.JSON.(batchSendResponse)
+		if len(first.StateEventIDs) != 1 || len(first.EventIDs) != 2 {
+			t.Fatalf("unexpected number of events in response: %+v", first)
+		}
+		if first.BaseInsertionEventID == "" || first.InsertionEventID == "" || first.BatchEventID == "" || first.NextBatchID == "" {
+			t.Fatalf("expected insertion and batch events in response: %+v", first)
+		}
+
+		// The events are stored with the timestamps from the request.
+		var eventsRes api.QueryEventsByIDResponse
+		if err = rsAPI.QueryEventsByID(ctx, &api.QueryEventsByIDRequest{EventIDs: first.EventIDs}, &eventsRes); err != nil {
+			t.Fatal(err)
+		}
+		if len(eventsRes.Events) != 2 {
+			t.Fatalf("expected 2 events to be stored, got %d", len(eventsRes.Events))
+		}
+		for _, ev := range eventsRes.Events {
+			if ev.OriginServerTS() != 2000 && ev.OriginServerTS() != 3000 {
+				t.Fatalf("unexpected timestamp %d", ev.OriginServerTS())
+			}
+		}
+
+		// The next batch connects to the insertion event of the first one.
+		var insertionRes api.QueryInsertionEventForBatchResponse
+		if err = rsAPI.QueryInsertionEventForBatch(ctx, &api.QueryInsertionEventForBatchRequest{
+			RoomID:  room.ID,
+			BatchID: first.NextBatchID,
+		}, &insertionRes); err != nil {
+			t.Fatal(err)
+		}
+		if insertionRes.InsertionEventID != first.InsertionEventID {
+			t.Fatalf("expected insertion event %s, got %s", first.InsertionEventID, insertionRes.InsertionEventID)
+		}
+		res = batchSend(asDevice, "prev_event_id="+prevEventID+"&batch_id="+first.NextBatchID, body)
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected 200 for the next batch, got %d: %+v", res.Code, res.JSON)
+		}
+		if second := res.JSON.(batchSendResponse); second.BaseInsertionEventID != "" {
+			t.Fatalf("expected no base insertion event for the next batch, got %s", second.BaseInsertionEventID)
+		}
+	})
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 
 	"github.com/matrix-org/util"
 )
@@ -26,7 +27,7 @@ import (
 // GetCapabilities returns information about the server's supported feature set
 // and other relevant capabilities to an authenticated user.
 func GetCapabilities(
-	req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI,
+	req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI, cfg *config.ClientAPI,
 ) util.JSONResponse {
 	roomVersionsQueryReq := roomserverAPI.QueryRoomVersionCapabilitiesRequest{}
 	roomVersionsQueryRes := roomserverAPI.QueryRoomVersionCapabilitiesResponse{}
@@ -39,13 +40,20 @@ func GetCapabilities(
 		return jsonerror.InternalServerError()
 	}
 
-	response := map[string]interface{}{
-		"capabilities": map[string]interface{}{
-			"m.change_password": map[string]bool{
-				"enabled": true,
-			},
-			"m.room_versions": roomVersionsQueryRes,
+	capabilities := map[string]interface{}{
+		"m.change_password": map[string]bool{
+			"enabled": true,
 		},
+		"m.room_versions":                    roomVersionsQueryRes,
+		"org.matrix.msc2000.password_policy": passwordPolicy(cfg),
+	}
+	if loginTokenEnabled(cfg) {
+		capabilities["org.matrix.msc3882.get_login_token"] = map[string]bool{
+			"enabled": true,
+		}
+	}
+	response := map[string]interface{}{
+		"capabilities": capabilities,
 	}
 
 	return util.JSONResponse{
@@ -0,0 +1,212 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"crypto/hmac"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// consentNoticeInterval is how often to check for users who should be sent
+// a server notice asking them to consent to the terms of service.
+const consentNoticeInterval = time.Hour
+
+// consentTemplate is an HTML webpage template listing the policies which the
+// user is asked to consent to.
+var consentTemplate = template.Must(template.New("consent").Parse(`
+<html>
+<head>
+<title>Terms of service</title>
+<meta name='viewport' content='width=device-width, initial-scale=1,
+    user-scalable=no, minimum-scale=1.0, maximum-scale=1.0'>
+</head>
+<body>
+    <div>
+        {{if .HasConsented}}
+        <p>You have agreed to the following policies:</p>
+        {{else}}
+        <p>Please review and agree to the following policies to continue using this server:</p>
+        {{end}}
+        <ul>
+        {{range .Policies}}
+            <li><a href="{{.URL}}" target="_blank">{{.Name}}</a></li>
+        {{end}}
+        </ul>
+        {{if not .HasConsented}}
+        <form method="post">
+            <input type="hidden" name="u" value="{{.UserID}}" />
+            <input type="hidden" name="h" value="{{.UserHash}}" />
+            <input type="hidden" name="v" value="{{.Version}}" />
+            <input type="submit" value="I agree" />
+        </form>
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+type consentTemplateData struct {
+	UserID       string
+	UserHash     string
+	Version      string
+	Policies     []config.UserConsentPolicy
+	HasConsented bool
+}
+
+// Consent implements GET and POST /consent?u={userID}&h={hash}, which the
+// links sent to users who must consent to the terms of service point to.
+func Consent(
+	w http.ResponseWriter, req *http.Request,
+	userAPI userapi.ClientUserAPI, cfg *config.ClientAPI,
+) *util.JSONResponse {
+	consentCfg := &cfg.Matrix.UserConsent
+	if !consentCfg.Enabled {
+		return &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("User consent is not enabled on this server"),
+		}
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		return &util.JSONResponse{
+			Code: http.StatusMethodNotAllowed,
+			JSON: jsonerror.NotFound("Bad method"),
+		}
+	}
+	if err := req.ParseForm(); err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("Unable to parse form: " + err.Error()),
+		}
+	}
+
+	userID, userHash := req.Form.Get("u"), req.Form.Get("h")
+	if !hmac.Equal([]byte(userHash), []byte(consentCfg.UserConsentHash(userID))) {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Invalid consent link"),
+		}
+	}
+	localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil || domain != cfg.Matrix.ServerName {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidUsername("Consent can only be given by local users"),
+		}
+	}
+
+	data := consentTemplateData{
+		UserID:   userID,
+		UserHash: userHash,
+		Version:  consentCfg.Version,
+		Policies: consentCfg.Policies,
+	}
+	if req.Method == http.MethodPost {
+		// The user may have loaded the page before the policies were changed.
+		if req.Form.Get("v") != consentCfg.Version {
+			return &util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidArgumentValue("The policies have changed, please reload the page"),
+			}
+		}
+		if err = userAPI.PerformUpdatePolicyVersion(req.Context(), &userapi.PerformUpdatePolicyVersionRequest{
+			Localpart:     localpart,
+			PolicyVersion: consentCfg.Version,
+		}, &userapi.PerformUpdatePolicyVersionResponse{}); err != nil {
+			errRes := jsonerror.InternalAPIError(req.Context(), err)
+			return &errRes
+		}
+		data.HasConsented = true
+	} else {
+		var res userapi.QueryPolicyVersionResponse
+		if err = userAPI.QueryPolicyVersion(req.Context(), &userapi.QueryPolicyVersionRequest{
+			Localpart: localpart,
+		}, &res); err != nil {
+			errRes := jsonerror.InternalAPIError(req.Context(), err)
+			return &errRes
+		}
+		data.HasConsented = res.PolicyVersion == consentCfg.Version
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = consentTemplate.Execute(w, data); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("consentTemplate.Execute failed")
+	}
+	return nil
+}
+
+// runConsentNotices periodically sends a server notice, linking to the consent
+// page, to the users who haven't consented to the current version of the terms
+// of service. Each user is only sent one notice per version.
+func runConsentNotices(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) {
+	consentCfg := &cfg.Matrix.UserConsent
+	ticker := time.NewTicker(consentNoticeInterval)
+	defer ticker.Stop()
+	for {
+		var res userapi.QueryOutdatedPolicyResponse
+		if err := userAPI.QueryOutdatedPolicy(ctx, &userapi.QueryOutdatedPolicyRequest{
+			PolicyVersion: consentCfg.Version,
+		}, &res); err != nil {
+			logrus.WithError(err).Error("Failed to get users who haven't consented to the terms of service")
+		}
+		for _, localpart := range res.UserLocalparts {
+			userID := userutil.MakeUserID(localpart, cfg.Matrix.ServerName)
+			content := map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    strings.ReplaceAll(consentCfg.ServerNoticeContent, "%(consent_uri)s", consentCfg.ConsentURI(userID)),
+			}
+			noticeRes := sendServerNotice(
+				ctx, userID, content, &cfg.Matrix.ServerNotices, cfg, userAPI, rsAPI, asAPI, senderDevice, nil,
+			)
+			if noticeRes.Code != http.StatusOK {
+				logrus.WithField("user_id", userID).Errorf("Failed to send consent server notice: %+v", noticeRes.JSON)
+				continue
+			}
+			if err := userAPI.PerformUpdatePolicyVersion(ctx, &userapi.PerformUpdatePolicyVersionRequest{
+				Localpart:          localpart,
+				PolicyVersion:      consentCfg.Version,
+				ServerNoticeUpdate: true,
+			}, &userapi.PerformUpdatePolicyVersionResponse{}); err != nil {
+				logrus.WithError(err).WithField("user_id", userID).Error("Failed to record consent server notice")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
@@ -149,13 +150,30 @@ func CreateRoom(
 	if resErr = r.Validate(); resErr != nil {
 		return *resErr
 	}
-	evTime, err := httputil.ParseTSParam(req)
+	evTime, err := httputil.ParseTSParam(req, device)
 	if err != nil {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
 			JSON: jsonerror.InvalidArgumentValue(err.Error()),
 		}
 	}
+	spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+		Kind:   spamcheck.KindCreateRoom,
+		UserID: device.UserID,
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse()
+	}
+	// Shadow-banned users get a room ID back, but the room is never created.
+	// The same goes for rooms that a spam checker has asked us to drop.
+	if device.ShadowBanned || spam.Dropped() {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: createRoomResponse{
+				RoomID: fmt.Sprintf("!%s:%s", util.RandomString(16), cfg.Matrix.ServerName),
+			},
+		}
+	}
 	return createRoom(req.Context(), r, device, cfg, profileAPI, rsAPI, asAPI, evTime)
 }
 
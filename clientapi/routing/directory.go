@@ -75,7 +75,7 @@ func DirectoryRoom(
 	if res.RoomID == "" {
 		// If we don't know it locally, do a federation query.
 		// But don't send the query to ourselves.
-		if domain != cfg.Matrix.ServerName {
+		if domain != cfg.Matrix.ServerName && cfg.FederationAPI.IsFederationAllowed(domain) {
 			fedRes, fedErr := federation.LookupRoomAlias(req.Context(), domain, roomAlias)
 			if fedErr != nil {
 				// TODO: Return 502 if the remote server errored.
@@ -146,7 +146,7 @@ func SetLocalAlias(
 			JSON: jsonerror.BadJSON("User ID must be in the form '@localpart:domain'"),
 		}
 	}
-	for _, appservice := range cfg.Derived.ApplicationServices {
+	for _, appservice := range cfg.Derived.AppServices() {
 		// Don't prevent AS from creating aliases in its own namespace
 		// Note that Dendrite uses SenderLocalpart as UserID for AS users
 		if reqUserID != appservice.SenderLocalpart {
@@ -318,3 +318,42 @@ func SetVisibility(
 		JSON: struct{}{},
 	}
 }
+
+// SetVisibilityAS implements PUT /directory/list/appservice/{networkID}/{roomID},
+// which allows an application service to publish a room to one of its third
+// party networks.
+func SetVisibilityAS(
+	req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI, dev *userapi.Device,
+	networkID, roomID string,
+) util.JSONResponse {
+	if dev.AppserviceID == "" {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Only application services can publish rooms to third party networks"),
+		}
+	}
+
+	var v roomVisibility
+	if reqErr := httputil.UnmarshalJSONRequest(req, &v); reqErr != nil {
+		return *reqErr
+	}
+
+	var publishRes roomserverAPI.PerformPublishResponse
+	if err := rsAPI.PerformPublish(req.Context(), &roomserverAPI.PerformPublishRequest{
+		RoomID:       roomID,
+		Visibility:   v.Visibility,
+		AppserviceID: dev.AppserviceID,
+		NetworkID:    networkID,
+	}, &publishRes); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if publishRes.Error != nil {
+		util.GetLogger(req.Context()).WithError(publishRes.Error).Error("PerformPublish failed")
+		return publishRes.Error.JSONResponse()
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
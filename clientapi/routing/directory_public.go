@@ -26,6 +26,7 @@ import (
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
 
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
 	"github.com/matrix-org/dendrite/clientapi/api"
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
@@ -34,15 +35,19 @@ import (
 )
 
 var (
-	cacheMu          sync.Mutex
-	publicRoomsCache []gomatrixserverlib.PublicRoom
+	cacheMu sync.Mutex
+	// publicRoomsCache holds the public rooms for each network, keyed by
+	// the result of networkCacheKey.
+	publicRoomsCache = map[string][]gomatrixserverlib.PublicRoom{}
 )
 
 type PublicRoomReq struct {
-	Since  string `json:"since,omitempty"`
-	Limit  int16  `json:"limit,omitempty"`
-	Filter filter `json:"filter,omitempty"`
-	Server string `json:"server,omitempty"`
+	Since                string `json:"since,omitempty"`
+	Limit                int16  `json:"limit,omitempty"`
+	Filter               filter `json:"filter,omitempty"`
+	Server               string `json:"server,omitempty"`
+	IncludeAllNetworks   bool   `json:"include_all_networks,omitempty"`
+	ThirdPartyInstanceID string `json:"third_party_instance_id,omitempty"`
 }
 
 type filter struct {
@@ -60,15 +65,27 @@ func GetPostPublicRooms(
 	if fillErr := fillPublicRoomsReq(req, &request); fillErr != nil {
 		return *fillErr
 	}
+	if request.IncludeAllNetworks && request.ThirdPartyInstanceID != "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam("include_all_networks and third_party_instance_id can not be used together"),
+		}
+	}
 
 	serverName := gomatrixserverlib.ServerName(request.Server)
 
 	if serverName != "" && serverName != cfg.Matrix.ServerName {
+		if !cfg.FederationAPI.IsFederationAllowed(serverName) {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.Forbidden("Federation with this server is not allowed"),
+			}
+		}
 		res, err := federation.GetPublicRoomsFiltered(
 			req.Context(), serverName,
 			int(request.Limit), request.Since,
-			request.Filter.SearchTerms, false,
-			"",
+			request.Filter.SearchTerms, request.IncludeAllNetworks,
+			request.ThirdPartyInstanceID,
 		)
 		if err != nil {
 			util.GetLogger(req.Context()).WithError(err).Error("failed to get public rooms")
@@ -80,7 +97,14 @@ func GetPostPublicRooms(
 		}
 	}
 
-	response, err := publicRooms(req.Context(), request, rsAPI, extRoomsProvider)
+	queryReq, err := publishedRoomsRequest(request)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.InvalidParam(err.Error()),
+		}
+	}
+	response, err := publicRooms(req.Context(), request, queryReq, rsAPI, extRoomsProvider)
 	if err != nil {
 		util.GetLogger(req.Context()).WithError(err).Errorf("failed to work out public rooms")
 		return jsonerror.InternalServerError()
@@ -91,8 +115,40 @@ func GetPostPublicRooms(
 	}
 }
 
+// publishedRoomsRequest works out which network the public rooms should be
+// returned for. By default, only the rooms in the main room directory are
+// returned, not the ones which application services published to their
+// third party networks.
+func publishedRoomsRequest(request PublicRoomReq) (roomserverAPI.QueryPublishedRoomsRequest, error) {
+	queryReq := roomserverAPI.QueryPublishedRoomsRequest{
+		IncludeAllNetworks: request.IncludeAllNetworks,
+	}
+	if request.ThirdPartyInstanceID != "" {
+		appserviceID, networkID, err := appserviceAPI.ParseThirdPartyInstanceID(request.ThirdPartyInstanceID)
+		if err != nil {
+			return queryReq, err
+		}
+		queryReq.AppserviceID = appserviceID
+		queryReq.NetworkID = networkID
+	}
+	return queryReq, nil
+}
+
+// networkCacheKey returns the key in the public rooms cache for the network
+// requested.
+func networkCacheKey(queryReq roomserverAPI.QueryPublishedRoomsRequest) string {
+	if queryReq.IncludeAllNetworks {
+		return "*"
+	}
+	if queryReq.NetworkID == "" {
+		return ""
+	}
+	return appserviceAPI.ThirdPartyInstanceID(queryReq.AppserviceID, queryReq.NetworkID)
+}
+
 func publicRooms(
-	ctx context.Context, request PublicRoomReq, rsAPI roomserverAPI.ClientRoomserverAPI, extRoomsProvider api.ExtraPublicRoomsProvider,
+	ctx context.Context, request PublicRoomReq, queryReq roomserverAPI.QueryPublishedRoomsRequest,
+	rsAPI roomserverAPI.ClientRoomserverAPI, extRoomsProvider api.ExtraPublicRoomsProvider,
 ) (*gomatrixserverlib.RespPublicRooms, error) {
 
 	response := gomatrixserverlib.RespPublicRooms{
@@ -115,9 +171,9 @@ func publicRooms(
 
 	var rooms []gomatrixserverlib.PublicRoom
 	if request.Since == "" {
-		rooms = refreshPublicRoomCache(ctx, rsAPI, extRoomsProvider)
+		rooms = refreshPublicRoomCache(ctx, queryReq, rsAPI, extRoomsProvider)
 	} else {
-		rooms = getPublicRoomsFromCache()
+		rooms = getPublicRoomsFromCache(queryReq)
 	}
 
 	response.TotalRoomCountEstimate = len(rooms)
@@ -180,6 +236,8 @@ func fillPublicRoomsReq(httpReq *http.Request, request *PublicRoomReq) *util.JSO
 		request.Limit = int16(limit)
 		request.Since = httpReq.FormValue("since")
 		request.Server = httpReq.FormValue("server")
+		request.IncludeAllNetworks = httpReq.FormValue("include_all_networks") == "true"
+		request.ThirdPartyInstanceID = httpReq.FormValue("third_party_instance_id")
 	} else {
 		resErr := httputil.UnmarshalJSONRequest(httpReq, request)
 		if resErr != nil {
@@ -230,42 +288,47 @@ func sliceInto(slice []gomatrixserverlib.PublicRoom, since int64, limit int16) (
 }
 
 func refreshPublicRoomCache(
-	ctx context.Context, rsAPI roomserverAPI.ClientRoomserverAPI, extRoomsProvider api.ExtraPublicRoomsProvider,
+	ctx context.Context, queryReq roomserverAPI.QueryPublishedRoomsRequest,
+	rsAPI roomserverAPI.ClientRoomserverAPI, extRoomsProvider api.ExtraPublicRoomsProvider,
 ) []gomatrixserverlib.PublicRoom {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
+	key := networkCacheKey(queryReq)
+	// Extra rooms only belong in the main room directory, not on third
+	// party networks.
 	var extraRooms []gomatrixserverlib.PublicRoom
-	if extRoomsProvider != nil {
+	if extRoomsProvider != nil && queryReq.NetworkID == "" {
 		extraRooms = extRoomsProvider.Rooms()
 	}
 
 	var queryRes roomserverAPI.QueryPublishedRoomsResponse
-	err := rsAPI.QueryPublishedRooms(ctx, &roomserverAPI.QueryPublishedRoomsRequest{}, &queryRes)
+	err := rsAPI.QueryPublishedRooms(ctx, &queryReq, &queryRes)
 	if err != nil {
 		util.GetLogger(ctx).WithError(err).Error("QueryPublishedRooms failed")
-		return publicRoomsCache
+		return publicRoomsCache[key]
 	}
 	pubRooms, err := roomserverAPI.PopulatePublicRooms(ctx, queryRes.RoomIDs, rsAPI)
 	if err != nil {
 		util.GetLogger(ctx).WithError(err).Error("PopulatePublicRooms failed")
-		return publicRoomsCache
+		return publicRoomsCache[key]
 	}
-	publicRoomsCache = []gomatrixserverlib.PublicRoom{}
-	publicRoomsCache = append(publicRoomsCache, pubRooms...)
-	publicRoomsCache = append(publicRoomsCache, extraRooms...)
-	publicRoomsCache = dedupeAndShuffle(publicRoomsCache)
+	rooms := []gomatrixserverlib.PublicRoom{}
+	rooms = append(rooms, pubRooms...)
+	rooms = append(rooms, extraRooms...)
+	rooms = dedupeAndShuffle(rooms)
 
 	// sort by total joined member count (big to small)
-	sort.SliceStable(publicRoomsCache, func(i, j int) bool {
-		return publicRoomsCache[i].JoinedMembersCount > publicRoomsCache[j].JoinedMembersCount
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return rooms[i].JoinedMembersCount > rooms[j].JoinedMembersCount
 	})
-	return publicRoomsCache
+	publicRoomsCache[key] = rooms
+	return rooms
 }
 
-func getPublicRoomsFromCache() []gomatrixserverlib.PublicRoom {
+func getPublicRoomsFromCache(queryReq roomserverAPI.QueryPublishedRoomsRequest) []gomatrixserverlib.PublicRoom {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
-	return publicRoomsCache
+	return publicRoomsCache[networkCacheKey(queryReq)]
 }
 
 func dedupeAndShuffle(in []gomatrixserverlib.PublicRoom) []gomatrixserverlib.PublicRoom {
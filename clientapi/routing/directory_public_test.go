@@ -1,9 +1,12 @@
 package routing
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
 )
 
@@ -56,3 +59,20 @@ func TestSliceInto(t *testing.T) {
 		}
 	}
 }
+
+func TestGetPostPublicRoomsFederationNotAllowed(t *testing.T) {
+	global := &config.Global{ServerName: "localhost"}
+	cfg := &config.ClientAPI{
+		Matrix: global,
+		FederationAPI: &config.FederationAPI{
+			Matrix:        global,
+			DeniedServers: []string{"denied.example"},
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/publicRooms?server=denied.example", nil)
+	// The federation client must not be used for a denied server.
+	res := GetPostPublicRooms(req, nil, nil, nil, cfg)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected HTTP %d, got %d: %+v", http.StatusForbidden, res.Code, res.JSON)
+	}
+}
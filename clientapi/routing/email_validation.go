@@ -0,0 +1,237 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/threepid"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// submitTokenTemplate is an HTML webpage template shown after following the
+// link in a validation email.
+var submitTokenTemplate = template.Must(template.New("submit_token").Parse(`
+<html>
+<head>
+<title>Email validation</title>
+<meta name='viewport' content='width=device-width, initial-scale=1,
+    user-scalable=no, minimum-scale=1.0, maximum-scale=1.0'>
+</head>
+<body>
+    <div>
+        {{if .Validated}}
+        <p>Your email address has been validated. Return to your client to continue.</p>
+        {{else}}
+        <p>This link is invalid or has expired. Request a new email from your client to try again.</p>
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+type submitTokenRequest struct {
+	SID          string `json:"sid"`
+	ClientSecret string `json:"client_secret"`
+	Token        string `json:"token"`
+}
+
+type submitTokenResponse struct {
+	Success bool `json:"success"`
+}
+
+// SubmitEmailToken implements GET and POST
+// /{registration,add_threepid,password_reset}/email/submit_token, which
+// validates the email address of the session. The links in validation emails
+// use GET, and are shown a webpage or redirected to the client's next_link,
+// while clients which ask the user for the token use POST.
+func SubmitEmailToken(
+	w http.ResponseWriter, req *http.Request, userAPI api.ClientUserAPI, cfg *config.ClientAPI,
+) *util.JSONResponse {
+	if !cfg.Matrix.EmailValidation.Enabled {
+		return &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Email validation is not enabled on this server"),
+		}
+	}
+	var r submitTokenRequest
+	switch req.Method {
+	case http.MethodGet:
+		query := req.URL.Query()
+		r = submitTokenRequest{
+			SID:          query.Get("sid"),
+			ClientSecret: query.Get("client_secret"),
+			Token:        query.Get("token"),
+		}
+	case http.MethodPost:
+		if reqErr := httputil.UnmarshalJSONRequest(req, &r); reqErr != nil {
+			return reqErr
+		}
+	default:
+		return &util.JSONResponse{
+			Code: http.StatusMethodNotAllowed,
+			JSON: jsonerror.NotFound("Bad method"),
+		}
+	}
+
+	var res api.PerformThreePIDValidationResponse
+	if err := userAPI.PerformThreePIDValidation(req.Context(), &api.PerformThreePIDValidationRequest{
+		SessionID:    r.SID,
+		ClientSecret: r.ClientSecret,
+		Token:        r.Token,
+	}, &res); err != nil {
+		errRes := jsonerror.InternalAPIError(req.Context(), err)
+		return &errRes
+	}
+
+	if req.Method == http.MethodPost {
+		return &util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: submitTokenResponse{Success: res.Validated},
+		}
+	}
+	if res.Validated && res.NextLink != "" {
+		if u, err := url.Parse(res.NextLink); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			http.Redirect(w, req, u.String(), http.StatusFound)
+			return nil
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := submitTokenTemplate.Execute(w, res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("submitTokenTemplate.Execute failed")
+	}
+	return nil
+}
+
+type passwordResetRequest struct {
+	NewPassword   string            `json:"new_password"`
+	LogoutDevices bool              `json:"logout_devices"`
+	Auth          passwordResetAuth `json:"auth"`
+}
+
+type passwordResetAuth struct {
+	Type    string `json:"type"`
+	Session string `json:"session"`
+	// ThreePIDCreds are the credentials of the validation session.
+	ThreePIDCreds threepid.Credentials `json:"threepid_creds"`
+	// LegacyThreePIDCreds is the name used by older clients.
+	LegacyThreePIDCreds threepid.Credentials `json:"threepidCreds"`
+}
+
+// PasswordReset implements POST /account/password for users who aren't logged
+// in, who prove they own the account by validating one of its email addresses.
+func PasswordReset(
+	req *http.Request, userAPI api.ClientUserAPI, cfg *config.ClientAPI,
+) util.JSONResponse {
+	r := passwordResetRequest{LogoutDevices: true}
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if !cfg.Matrix.EmailValidation.Enabled {
+		return util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: jsonerror.MissingToken("Missing access token"),
+		}
+	}
+
+	creds := r.Auth.ThreePIDCreds
+	if creds.SID == "" {
+		creds = r.Auth.LegacyThreePIDCreds
+	}
+	sessionID := r.Auth.Session
+	if sessionID == "" {
+		sessionID = util.RandomString(sessionIDLength)
+	}
+	if r.Auth.Type != authtypes.LoginTypeEmail || creds.SID == "" {
+		return util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: newUserInteractiveResponse(
+				sessionID,
+				[]authtypes.Flow{
+					{
+						Stages: []authtypes.LoginType{authtypes.LoginTypeEmail},
+					},
+				},
+				nil,
+			),
+		}
+	}
+
+	validated, address, medium, err := checkThreePIDCredentials(req.Context(), userAPI, creds, cfg)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("checkThreePIDCredentials failed")
+		return jsonerror.InternalServerError()
+	}
+	if !validated {
+		return util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: jsonerror.MatrixError{
+				ErrCode: "M_THREEPID_AUTH_FAILED",
+				Err:     "Failed to auth 3pid",
+			},
+		}
+	}
+
+	var localpartRes api.QueryLocalpartForThreePIDResponse
+	if err = userAPI.QueryLocalpartForThreePID(req.Context(), &api.QueryLocalpartForThreePIDRequest{
+		ThreePID: address,
+		Medium:   medium,
+	}, &localpartRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryLocalpartForThreePID failed")
+		return jsonerror.InternalServerError()
+	}
+	if localpartRes.Localpart == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MatrixError{
+				ErrCode: "M_THREEPID_NOT_FOUND",
+				Err:     "Email address not found",
+			},
+		}
+	}
+
+	if resErr := validatePassword(req, r.NewPassword, cfg); resErr != nil {
+		return *resErr
+	}
+
+	passwordRes := &api.PerformPasswordUpdateResponse{}
+	if err = userAPI.PerformPasswordUpdate(req.Context(), &api.PerformPasswordUpdateRequest{
+		Localpart:     localpartRes.Localpart,
+		Password:      r.NewPassword,
+		LogoutDevices: r.LogoutDevices,
+	}, passwordRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("PerformPasswordUpdate failed")
+		return jsonerror.InternalServerError()
+	}
+	if !passwordRes.PasswordUpdated {
+		util.GetLogger(req.Context()).Error("Expected password to have been updated but wasn't")
+		return jsonerror.InternalServerError()
+	}
+	removeThreePIDValidationSession(req.Context(), userAPI, creds, cfg)
+
+	util.GetLogger(req.Context()).WithField("localpart", localpartRes.Localpart).Info("Password reset by email")
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net/http"
 
 	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/clientapi/userutil"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -40,30 +41,40 @@ type flows struct {
 
 type flow struct {
 	Type string `json:"type"`
+	// GetLoginToken is true for m.login.token if logged in users can get
+	// tokens with /login/get_token (MSC3882).
+	GetLoginToken bool `json:"org.matrix.msc3882.get_login_token,omitempty"`
 }
 
-func passwordLogin() flows {
+func passwordLogin(cfg *config.ClientAPI) flows {
 	f := flows{}
 	s := flow{
 		Type: "m.login.password",
 	}
 	f.Flows = append(f.Flows, s)
+	if loginTokenEnabled(cfg) {
+		f.Flows = append(f.Flows, flow{Type: authtypes.LoginTypeToken, GetLoginToken: true})
+	}
+	if cfg.JWTLogin.Enabled {
+		f.Flows = append(f.Flows, flow{Type: authtypes.LoginTypeJWT})
+	}
 	return f
 }
 
-// Login implements GET and POST /login
+// Login implements GET and POST /login. The clientIP is locked out after too
+// many failed logins, and is empty if the client's IP address isn't known.
 func Login(
 	req *http.Request, userAPI userapi.ClientUserAPI,
-	cfg *config.ClientAPI,
+	cfg *config.ClientAPI, clientIP string,
 ) util.JSONResponse {
 	if req.Method == http.MethodGet {
 		// TODO: support other forms of login other than password, depending on config options
 		return util.JSONResponse{
 			Code: http.StatusOK,
-			JSON: passwordLogin(),
+			JSON: passwordLogin(cfg),
 		}
 	} else if req.Method == http.MethodPost {
-		login, cleanup, authErr := auth.LoginFromJSONReader(req.Context(), req.Body, userAPI, userAPI, cfg)
+		login, cleanup, authErr := auth.LoginFromJSONReader(req.Context(), req.Body, userAPI, userAPI, cfg, clientIP)
 		if authErr != nil {
 			return *authErr
 		}
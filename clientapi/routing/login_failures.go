@@ -0,0 +1,88 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// loginFailureNoticeInterval is how often to check for users who should be
+// told about failed logins to their accounts.
+const loginFailureNoticeInterval = time.Minute
+
+// runLoginFailureNotices periodically sends a server notice to the users whose
+// accounts have had suspiciously many failed logins. Each user is only sent one
+// notice for each run of failures.
+func runLoginFailureNotices(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) {
+	ticker := time.NewTicker(loginFailureNoticeInterval)
+	defer ticker.Stop()
+	for {
+		var res userapi.PerformLoginFailureNoticesResponse
+		if err := userAPI.PerformLoginFailureNotices(ctx, &userapi.PerformLoginFailureNoticesRequest{}, &res); err != nil {
+			logrus.WithError(err).Error("Failed to get users to notify about failed logins")
+		}
+		for _, notice := range res.Notices {
+			content := map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    loginFailureNoticeBody(notice),
+			}
+			noticeRes := sendServerNotice(
+				ctx, notice.UserID, content, &cfg.Matrix.ServerNotices, cfg, userAPI, rsAPI, asAPI, senderDevice, nil,
+			)
+			if noticeRes.Code != http.StatusOK {
+				logrus.WithField("user_id", notice.UserID).Errorf("Failed to send login failure server notice: %+v", noticeRes.JSON)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func loginFailureNoticeBody(notice userapi.LoginFailureNotice) string {
+	body := fmt.Sprintf(
+		"There have been %d failed attempts to log into your account %s", notice.Failures, notice.UserID,
+	)
+	if notice.LastIP != "" {
+		body += fmt.Sprintf(", most recently from %s", notice.LastIP)
+	}
+	body += "."
+	if notice.LockedUntilMS > 0 {
+		lockedUntil := gomatrixserverlib.Timestamp(notice.LockedUntilMS).Time().UTC().Format(time.RFC1123)
+		body += fmt.Sprintf(" Logging in with a password has been blocked until %s.", lockedUntil)
+	}
+	body += " If this wasn't you, someone may be trying to guess your password. Consider changing it to a strong, unique password."
+	return body
+}
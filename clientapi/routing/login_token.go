@@ -0,0 +1,94 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type getLoginTokenResponse struct {
+	LoginToken  string `json:"login_token"`
+	ExpiresInMS int64  `json:"expires_in_ms"`
+	// ExpiresIn is the lifetime in seconds, which older clients expect.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// GetLoginToken implements POST /login/get_token (MSC3882), which gives a
+// logged in user a login token for signing in on another device, once they
+// have reauthenticated.
+func GetLoginToken(
+	req *http.Request,
+	userInteractiveAuth *auth.UserInteractive,
+	userAPI userapi.ClientUserAPI,
+	device *userapi.Device,
+	cfg *config.ClientAPI,
+) util.JSONResponse {
+	ctx := req.Context()
+	defer req.Body.Close() // nolint:errcheck
+	bodyBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The request body could not be read: " + err.Error()),
+		}
+	}
+
+	login, errRes := userInteractiveAuth.Verify(ctx, bodyBytes, device)
+	if errRes != nil {
+		return *errRes
+	}
+	// The user must reauthenticate as themselves, not as someone else.
+	localpart, err := userutil.ParseUsernameParam(login.Username(), &cfg.Matrix.ServerName)
+	if err != nil || userutil.MakeUserID(localpart, cfg.Matrix.ServerName) != device.UserID {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Reauthenticated as a different user"),
+		}
+	}
+
+	var res userapi.PerformLoginTokenCreationResponse
+	if err = userAPI.PerformLoginTokenCreation(ctx, &userapi.PerformLoginTokenCreationRequest{
+		Data: userapi.LoginTokenData{UserID: device.UserID},
+	}, &res); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.PerformLoginTokenCreation failed")
+		return jsonerror.InternalServerError()
+	}
+
+	expiresIn := time.Until(res.Metadata.Expiration)
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: getLoginTokenResponse{
+			LoginToken:  res.Metadata.Token,
+			ExpiresInMS: expiresIn.Milliseconds(),
+			ExpiresIn:   int64(expiresIn.Seconds()),
+		},
+	}
+}
+
+// loginTokenEnabled returns true if users can get login tokens for signing
+// in on other devices.
+func loginTokenEnabled(cfg *config.ClientAPI) bool {
+	return cfg.MSCs != nil && cfg.MSCs.Enabled("msc3882")
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// mauWarningInterval is how often to check whether users should be warned
+// about the monthly active user limit.
+const mauWarningInterval = time.Hour
+
+// mauWarningLevel is how close the server is to its monthly active user limit.
+type mauWarningLevel int
+
+const (
+	mauWarningNone mauWarningLevel = iota
+	mauWarningApproaching
+	mauWarningReached
+)
+
+// checkMAULimit returns an error response if the server has reached its
+// monthly active user limit and the user isn't reserved.
+func checkMAULimit(
+	ctx context.Context, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI, userID string,
+) *util.JSONResponse {
+	mau := &cfg.Matrix.MAU
+	if !mau.Enabled || mau.IsReservedUser(userID) {
+		return nil
+	}
+	var res userapi.QueryMonthlyActiveUsersResponse
+	if err := userAPI.QueryMonthlyActiveUsers(ctx, &userapi.QueryMonthlyActiveUsersRequest{}, &res); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.QueryMonthlyActiveUsers failed")
+		jsonErr := jsonerror.InternalServerError()
+		return &jsonErr
+	}
+	if res.Count < mau.MaxMAU {
+		return nil
+	}
+	return &util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: jsonerror.ResourceLimitExceeded(mau.AdminContact),
+	}
+}
+
+// runMAUWarnings periodically checks how close the server is to its monthly
+// active user limit, and sends a server notice to the active users when it is
+// approaching or has reached the limit. Users are warned again if the number
+// of active users drops and then rises again.
+func runMAUWarnings(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) {
+	ticker := time.NewTicker(mauWarningInterval)
+	defer ticker.Stop()
+	warned := mauWarningNone
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var res userapi.QueryMonthlyActiveUsersResponse
+		if err := userAPI.QueryMonthlyActiveUsers(ctx, &userapi.QueryMonthlyActiveUsersRequest{
+			IncludeUserIDs: true,
+		}, &res); err != nil {
+			logrus.WithError(err).Error("Failed to query monthly active users")
+			continue
+		}
+		level := mauWarningLevelFor(&cfg.Matrix.MAU, res.Count)
+		if level > warned {
+			sendMAUWarnings(ctx, cfg, userAPI, rsAPI, asAPI, senderDevice, level, res.UserIDs)
+		}
+		warned = level
+	}
+}
+
+func mauWarningLevelFor(mau *config.MAULimits, count int64) mauWarningLevel {
+	switch {
+	case count >= mau.MaxMAU:
+		return mauWarningReached
+	case count*100 >= mau.MaxMAU*int64(mau.WarningThreshold):
+		return mauWarningApproaching
+	default:
+		return mauWarningNone
+	}
+}
+
+func sendMAUWarnings(
+	ctx context.Context,
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+	level mauWarningLevel,
+	userIDs []string,
+) {
+	mau := &cfg.Matrix.MAU
+	var content map[string]interface{}
+	switch level {
+	case mauWarningApproaching:
+		content = map[string]interface{}{
+			"msgtype": "m.text",
+			"body": fmt.Sprintf(
+				"This server is approaching its limit of %d monthly active users. Once the limit is reached, "+
+					"users who haven't been active recently won't be able to use the server. Please contact %s for more information.",
+				mau.MaxMAU, mau.AdminContact,
+			),
+		}
+	case mauWarningReached:
+		content = map[string]interface{}{
+			"msgtype":            "m.server_notice",
+			"server_notice_type": "m.server_notice.usage_limit_reached",
+			"admin_contact":      mau.AdminContact,
+			"limit_type":         "monthly_active_user",
+			"body": fmt.Sprintf(
+				"This server has reached its limit of %d monthly active users, so users who haven't been "+
+					"active recently can't use the server. Please contact %s for more information.",
+				mau.MaxMAU, mau.AdminContact,
+			),
+		}
+	default:
+		return
+	}
+	for _, userID := range userIDs {
+		if userID == senderDevice.UserID {
+			continue
+		}
+		res := sendServerNotice(
+			ctx, userID, content, &cfg.Matrix.ServerNotices, cfg, userAPI, rsAPI, asAPI, senderDevice, nil,
+		)
+		if res.Code != http.StatusOK {
+			logrus.WithField("user_id", userID).Errorf("Failed to send monthly active user warning: %+v", res.JSON)
+		}
+	}
+}
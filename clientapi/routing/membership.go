@@ -27,6 +27,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/clientapi/threepid"
 	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -43,7 +44,7 @@ func SendBan(
 	roomID string, cfg *config.ClientAPI,
 	rsAPI roomserverAPI.ClientRoomserverAPI, asAPI appserviceAPI.AppServiceInternalAPI,
 ) util.JSONResponse {
-	body, evTime, roomVer, reqErr := extractRequestData(req, roomID, rsAPI)
+	body, evTime, roomVer, reqErr := extractRequestData(req, device, roomID, rsAPI)
 	if reqErr != nil {
 		return *reqErr
 	}
@@ -129,7 +130,7 @@ func SendKick(
 	roomID string, cfg *config.ClientAPI,
 	rsAPI roomserverAPI.ClientRoomserverAPI, asAPI appserviceAPI.AppServiceInternalAPI,
 ) util.JSONResponse {
-	body, evTime, roomVer, reqErr := extractRequestData(req, roomID, rsAPI)
+	body, evTime, roomVer, reqErr := extractRequestData(req, device, roomID, rsAPI)
 	if reqErr != nil {
 		return *reqErr
 	}
@@ -169,7 +170,7 @@ func SendUnban(
 	roomID string, cfg *config.ClientAPI,
 	rsAPI roomserverAPI.ClientRoomserverAPI, asAPI appserviceAPI.AppServiceInternalAPI,
 ) util.JSONResponse {
-	body, evTime, roomVer, reqErr := extractRequestData(req, roomID, rsAPI)
+	body, evTime, roomVer, reqErr := extractRequestData(req, device, roomID, rsAPI)
 	if reqErr != nil {
 		return *reqErr
 	}
@@ -210,7 +211,7 @@ func SendInvite(
 	roomID string, cfg *config.ClientAPI,
 	rsAPI roomserverAPI.ClientRoomserverAPI, asAPI appserviceAPI.AppServiceInternalAPI,
 ) util.JSONResponse {
-	body, evTime, _, reqErr := extractRequestData(req, roomID, rsAPI)
+	body, evTime, _, reqErr := extractRequestData(req, device, roomID, rsAPI)
 	if reqErr != nil {
 		return *reqErr
 	}
@@ -266,6 +267,25 @@ func sendInvite(
 		return jsonerror.InternalServerError(), err
 	}
 
+	spam := spamcheck.Check(ctx, &spamcheck.Request{
+		Kind:         spamcheck.KindInvite,
+		UserID:       device.UserID,
+		RoomID:       roomID,
+		TargetUserID: userID,
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse(), nil
+	}
+
+	// Invites from shadow-banned users, or which a spam checker has asked us
+	// to drop, are never sent.
+	if device.ShadowBanned || spam.Dropped() {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}, nil
+	}
+
 	var inviteRes api.PerformInviteResponse
 	if err := rsAPI.PerformInvite(ctx, &api.PerformInviteRequest{
 		Event:           event,
@@ -350,7 +370,7 @@ func loadProfile(
 	return profile, err
 }
 
-func extractRequestData(req *http.Request, roomID string, rsAPI roomserverAPI.ClientRoomserverAPI) (
+func extractRequestData(req *http.Request, device *userapi.Device, roomID string, rsAPI roomserverAPI.ClientRoomserverAPI) (
 	body *threepid.MembershipRequest, evTime time.Time, roomVer gomatrixserverlib.RoomVersion, resErr *util.JSONResponse,
 ) {
 	verReq := roomserverAPI.QueryRoomVersionForRoomRequest{RoomID: roomID}
@@ -369,7 +389,7 @@ func extractRequestData(req *http.Request, roomID string, rsAPI roomserverAPI.Cl
 		return
 	}
 
-	evTime, err := httputil.ParseTSParam(req)
+	evTime, err := httputil.ParseTSParam(req, device)
 	if err != nil {
 		resErr = &util.JSONResponse{
 			Code: http.StatusBadRequest,
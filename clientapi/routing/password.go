@@ -72,7 +72,7 @@ func Password(
 
 	// Check if the existing password is correct.
 	typePassword := auth.LoginTypePassword{
-		GetAccountByPassword: userAPI.QueryAccountByPassword,
+		GetAccountByPassword: auth.NewGetAccountByPassword(cfg, userAPI.QueryAccountByPassword, userAPI),
 		Config:               cfg,
 	}
 	if _, authErr := typePassword.Login(req.Context(), &r.Auth.PasswordRequest); authErr != nil {
@@ -81,7 +81,7 @@ func Password(
 	sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypePassword)
 
 	// Check the new password strength.
-	if resErr = validatePassword(r.NewPassword); resErr != nil {
+	if resErr = validatePassword(req, r.NewPassword, cfg); resErr != nil {
 		return *resErr
 	}
 
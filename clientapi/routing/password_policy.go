@@ -0,0 +1,189 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// passwordPolicyResponse describes the password policy to clients, as in MSC2000.
+type passwordPolicyResponse struct {
+	MinimumLength    int  `json:"m.minimum_length"`
+	RequireDigit     bool `json:"m.require_digit"`
+	RequireSymbol    bool `json:"m.require_symbol"`
+	RequireLowercase bool `json:"m.require_lowercase"`
+	RequireUppercase bool `json:"m.require_uppercase"`
+}
+
+func passwordPolicy(cfg *config.ClientAPI) passwordPolicyResponse {
+	policy := &cfg.PasswordPolicy
+	if !policy.Enabled {
+		return passwordPolicyResponse{MinimumLength: minPasswordLength}
+	}
+	return passwordPolicyResponse{
+		MinimumLength:    policy.MinimumLength,
+		RequireDigit:     policy.RequireDigit,
+		RequireSymbol:    policy.RequireSymbol,
+		RequireLowercase: policy.RequireLowercase,
+		RequireUppercase: policy.RequireUppercase,
+	}
+}
+
+// GetPasswordPolicy implements GET /password_policy
+func GetPasswordPolicy(cfg *config.ClientAPI) util.JSONResponse {
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: passwordPolicy(cfg),
+	}
+}
+
+// checkPasswordPolicy returns an M_WEAK_PASSWORD error if the password doesn't
+// meet the configured password policy.
+func checkPasswordPolicy(req *http.Request, password string, cfg *config.ClientAPI) *util.JSONResponse {
+	policy := &cfg.PasswordPolicy
+	weak := func(msg string) *util.JSONResponse {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.WeakPassword(msg),
+		}
+	}
+	if utf8.RuneCountInString(password) < policy.MinimumLength {
+		return weak(fmt.Sprintf("Password must be at least %d characters long", policy.MinimumLength))
+	}
+	var digit, symbol, lower, upper bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	switch {
+	case policy.RequireDigit && !digit:
+		return weak("Password must contain at least one digit")
+	case policy.RequireSymbol && !symbol:
+		return weak("Password must contain at least one symbol")
+	case policy.RequireLowercase && !lower:
+		return weak("Password must contain at least one lowercase letter")
+	case policy.RequireUppercase && !upper:
+		return weak("Password must contain at least one uppercase letter")
+	}
+	for _, banned := range policy.BannedPasswords {
+		if strings.EqualFold(password, banned) {
+			return weak("This password is not allowed")
+		}
+	}
+	if policy.BreachedPasswordsFile != "" {
+		breached, err := isBreachedPassword(string(policy.BreachedPasswordsFile), password)
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("Failed to check the breached passwords file")
+			res := jsonerror.InternalServerError()
+			return &res
+		}
+		if breached {
+			return weak("This password has appeared in a data breach, please choose a different one")
+		}
+	}
+	return nil
+}
+
+// isBreachedPassword returns true if the SHA-1 hash of the password is in the
+// sorted file of breached password hashes at path. The file is binary searched
+// rather than read into memory, since such files can be very large.
+func isBreachedPassword(path, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	want := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close() // nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// The line we're looking for, if it exists, starts somewhere in [lo, hi).
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := breachedPasswordsLine(f, info.Size(), mid)
+		if err != nil {
+			return false, err
+		}
+		if line == "" {
+			hi = mid
+			continue
+		}
+		hash := strings.ToUpper(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+		switch {
+		case hash == want:
+			return true, nil
+		case hash < want:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// breachedPasswordsLine returns the first line of the file which starts at or
+// after off, including its trailing newline, or an empty line if there isn't one.
+func breachedPasswordsLine(f *os.File, size, off int64) (int64, string, error) {
+	start := off
+	r := bufio.NewReader(io.NewSectionReader(f, off, size-off))
+	if off > 0 {
+		// Unless off is at the start of a line, skip to the start of the next one.
+		prev := make([]byte, 1)
+		if _, err := f.ReadAt(prev, off-1); err != nil {
+			return 0, "", err
+		}
+		if prev[0] != '\n' {
+			skipped, err := r.ReadString('\n')
+			if err == io.EOF {
+				return 0, "", nil
+			} else if err != nil {
+				return 0, "", err
+			}
+			start += int64(len(skipped))
+		}
+	}
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	return start, line, err
+}
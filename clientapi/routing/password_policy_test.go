@@ -0,0 +1,90 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	// Write a sorted breached passwords file in the same format as Have I Been Pwned.
+	var lines []string
+	for _, password := range []string{"Password1!", "Tr0ub4dor&3", "hunter2", "letmein", "correct horse"} {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":42")
+	}
+	sort.Strings(lines)
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breachedFile, []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+		t.Fatalf("failed to write breached passwords file: %s", err)
+	}
+
+	cfg := &config.ClientAPI{
+		PasswordPolicy: config.PasswordPolicy{
+			Enabled:               true,
+			MinimumLength:         10,
+			RequireDigit:          true,
+			RequireSymbol:         true,
+			RequireLowercase:      true,
+			RequireUppercase:      true,
+			BannedPasswords:       []string{"Matrix.0rg!"},
+			BreachedPasswordsFile: config.Path(breachedFile),
+		},
+	}
+	tests := map[string]bool{
+		"":                 true, // the password is optional in the first registration request
+		"Sh0rt!":           false,
+		"nouppercase1!":    false,
+		"NOLOWERCASE1!":    false,
+		"NoDigitsHere!":    false,
+		"NoSymbols1234":    false,
+		"matrix.0RG!":      false,
+		"Password1!":       false,
+		"Tr0ub4dor&3":      false,
+		"Un1que&Str0ng!":   true,
+		"Ünïcödé-Pässw0rd": true,
+	}
+	req := httptest.NewRequest("POST", "/register", nil)
+	for password, wantOK := range tests {
+		res := validatePassword(req, password, cfg)
+		if wantOK && res != nil {
+			t.Errorf("validatePassword(%q): got %+v, want success", password, res.JSON)
+		}
+		if !wantOK {
+			if res == nil {
+				t.Errorf("validatePassword(%q): got success, want M_WEAK_PASSWORD", password)
+			} else if e, ok := res.JSON.(*jsonerror.MatrixError); !ok || e.ErrCode != "M_WEAK_PASSWORD" {
+				t.Errorf("validatePassword(%q): got %+v, want M_WEAK_PASSWORD", password, res.JSON)
+			}
+		}
+	}
+
+	// Every breached password is found, wherever it is in the file.
+	for _, password := range []string{"Password1!", "Tr0ub4dor&3", "hunter2", "letmein", "correct horse"} {
+		if breached, err := isBreachedPassword(breachedFile, password); err != nil || !breached {
+			t.Errorf("isBreachedPassword(%q): got %v, %v, want true", password, breached, err)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
@@ -112,13 +113,32 @@ func SetAvatarURL(
 		}
 	}
 
+	spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+		Kind:   spamcheck.KindProfile,
+		UserID: userID,
+		Field:  "avatar_url",
+		Value:  r.AvatarURL,
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse()
+	}
+
+	// Profile changes from shadow-banned users, or which a spam checker has
+	// asked us to drop, are dropped.
+	if device.ShadowBanned || spam.Dropped() {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}
+	}
+
 	localpart, _, err := gomatrixserverlib.SplitID('@', userID)
 	if err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("gomatrixserverlib.SplitID failed")
 		return jsonerror.InternalServerError()
 	}
 
-	evTime, err := httputil.ParseTSParam(req)
+	evTime, err := httputil.ParseTSParam(req, device)
 	if err != nil {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -241,13 +261,32 @@ func SetDisplayName(
 		}
 	}
 
+	spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+		Kind:   spamcheck.KindProfile,
+		UserID: userID,
+		Field:  "displayname",
+		Value:  r.DisplayName,
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse()
+	}
+
+	// Profile changes from shadow-banned users, or which a spam checker has
+	// asked us to drop, are dropped.
+	if device.ShadowBanned || spam.Dropped() {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}
+	}
+
 	localpart, _, err := gomatrixserverlib.SplitID('@', userID)
 	if err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("gomatrixserverlib.SplitID failed")
 		return jsonerror.InternalServerError()
 	}
 
-	evTime, err := httputil.ParseTSParam(req)
+	evTime, err := httputil.ParseTSParam(req, device)
 	if err != nil {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -336,6 +375,9 @@ func getProfile(
 	}
 
 	if domain != cfg.Matrix.ServerName {
+		if !cfg.FederationAPI.IsFederationAllowed(domain) {
+			return nil, eventutil.ErrProfileNoExists
+		}
 		profile, fedErr := federation.LookupProfile(ctx, domain, userID, "")
 		if fedErr != nil {
 			if x, ok := fedErr.(gomatrix.HTTPError); ok {
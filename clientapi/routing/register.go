@@ -32,6 +32,7 @@ import (
 	"github.com/tidwall/gjson"
 
 	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/setup/config"
 
 	"github.com/matrix-org/gomatrixserverlib"
@@ -44,6 +45,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/clientapi/threepid"
 	"github.com/matrix-org/dendrite/clientapi/userutil"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 )
@@ -77,6 +79,17 @@ type sessionsDict struct {
 	// If a UIA session is started by trying to delete device1, and then UIA is completed by deleting device2,
 	// the delete request will fail for device2 since the UIA was initiated by trying to delete device1.
 	deleteSessionToDeviceID map[string]string
+	// threePIDs are the third-party identifiers validated during registration,
+	// which are added to the account once it has been created.
+	threePIDs map[string]validatedThreePID
+}
+
+// validatedThreePID is a third-party identifier which was validated during
+// user-interactive authentication.
+type validatedThreePID struct {
+	creds   threepid.Credentials
+	medium  string
+	address string
 }
 
 // defaultTimeout is the timeout used to clean up sessions
@@ -118,6 +131,7 @@ func (d *sessionsDict) deleteSession(sessionID string) {
 	delete(d.sessions, sessionID)
 	delete(d.deleteSessionToDeviceID, sessionID)
 	delete(d.sessionCompletedResult, sessionID)
+	delete(d.threePIDs, sessionID)
 	// stop the timer, e.g. because the registration was completed
 	if t, ok := d.timer[sessionID]; ok {
 		if !t.Stop() {
@@ -137,6 +151,7 @@ func newSessionsDict() *sessionsDict {
 		params:                  make(map[string]registerRequest),
 		timer:                   make(map[string]*time.Timer),
 		deleteSessionToDeviceID: make(map[string]string),
+		threePIDs:               make(map[string]validatedThreePID),
 	}
 }
 
@@ -190,6 +205,20 @@ func (d *sessionsDict) getCompletedRegistration(sessionID string) (registerRespo
 	return result, ok
 }
 
+func (d *sessionsDict) addThreePID(sessionID string, threePID validatedThreePID) {
+	d.startTimer(defaultTimeOut, sessionID)
+	d.Lock()
+	defer d.Unlock()
+	d.threePIDs[sessionID] = threePID
+}
+
+func (d *sessionsDict) getThreePID(sessionID string) (validatedThreePID, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	threePID, ok := d.threePIDs[sessionID]
+	return threePID, ok
+}
+
 func (d *sessionsDict) getDeviceToDelete(sessionID string) (string, bool) {
 	d.RLock()
 	defer d.RUnlock()
@@ -236,6 +265,11 @@ type authDict struct {
 
 	// Recaptcha
 	Response string `json:"response"`
+
+	// Email identity
+	ThreePIDCreds threepid.Credentials `json:"threepid_creds"`
+	// LegacyThreePIDCreds is the name used by older clients.
+	LegacyThreePIDCreds threepid.Credentials `json:"threepidCreds"`
 	// TODO: Lots of custom keys depending on the type
 }
 
@@ -314,13 +348,15 @@ func validateApplicationServiceUsername(localpart string, domain gomatrixserverl
 }
 
 // validatePassword returns an error response if the password is invalid
-func validatePassword(password string) *util.JSONResponse {
+func validatePassword(req *http.Request, password string, cfg *config.ClientAPI) *util.JSONResponse {
 	// https://github.com/matrix-org/synapse/blob/v0.20.0/synapse/rest/client/v2_alpha/register.py#L161
 	if len(password) > maxPasswordLength {
 		return &util.JSONResponse{
 			Code: http.StatusBadRequest,
 			JSON: jsonerror.BadJSON(fmt.Sprintf("'password' >%d characters", maxPasswordLength)),
 		}
+	} else if len(password) > 0 && cfg.PasswordPolicy.Enabled {
+		return checkPasswordPolicy(req, password, cfg)
 	} else if len(password) > 0 && len(password) < minPasswordLength {
 		return &util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -432,7 +468,7 @@ func UserIDIsWithinApplicationServiceNamespace(
 	}
 
 	// Loop through all known application service's namespaces and see if any match
-	for _, knownAppService := range cfg.Derived.ApplicationServices {
+	for _, knownAppService := range cfg.Derived.AppServices() {
 		if knownAppService.SenderLocalpart == local {
 			return true
 		}
@@ -456,7 +492,7 @@ func UsernameMatchesMultipleExclusiveNamespaces(
 
 	// Check namespaces and see if more than one match
 	matchCount := 0
-	for _, appservice := range cfg.Derived.ApplicationServices {
+	for _, appservice := range cfg.Derived.AppServices() {
 		if appservice.OwnsNamespaceCoveringUserId(userID) {
 			if matchCount++; matchCount > 1 {
 				return true
@@ -473,7 +509,7 @@ func UsernameMatchesExclusiveNamespaces(
 	username string,
 ) bool {
 	userID := userutil.MakeUserID(username, cfg.Matrix.ServerName)
-	return cfg.Derived.ExclusiveApplicationServicesUsernameRegexp.MatchString(userID)
+	return cfg.Derived.ExclusiveAppServiceUsernameRegexp().MatchString(userID)
 }
 
 // validateApplicationService checks if a provided application service token
@@ -488,7 +524,7 @@ func validateApplicationService(
 	// Check if the token if the application service is valid with one we have
 	// registered in the config.
 	var matchedApplicationService *config.ApplicationService
-	for _, appservice := range cfg.Derived.ApplicationServices {
+	for _, appservice := range cfg.Derived.AppServices() {
 		if appservice.ASToken == accessToken {
 			matchedApplicationService = &appservice
 			break
@@ -621,7 +657,7 @@ func Register(
 			return *resErr
 		}
 	}
-	if resErr := validatePassword(r.Password); resErr != nil {
+	if resErr := validatePassword(req, r.Password, cfg); resErr != nil {
 		return *resErr
 	}
 
@@ -714,7 +750,7 @@ func handleRegistrationFlow(
 	// TODO: Handle loading of previous session parameters from database.
 	// TODO: Handle mapping registrationRequest parameters into session parameters
 
-	// TODO: email / msisdn auth types.
+	// TODO: msisdn auth type.
 
 	// Appservices are special and are not affected by disabled
 	// registration or user exclusivity. We'll go onto the appservice
@@ -737,7 +773,7 @@ func handleRegistrationFlow(
 	// service namespace. Skip this check if no app services are registered.
 	// If an access token is provided, ignore this check this is an appservice
 	// request and we will validate in validateApplicationService
-	if len(cfg.Derived.ApplicationServices) != 0 &&
+	if len(cfg.Derived.AppServices()) != 0 &&
 		UsernameMatchesExclusiveNamespaces(cfg, r.Username) {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -745,6 +781,15 @@ func handleRegistrationFlow(
 		}
 	}
 
+	// Refuse to create new accounts once the server has reached its monthly
+	// active user limit, since they wouldn't be allowed to use the server.
+	if r.Auth.Type != authtypes.LoginTypeSharedSecret {
+		userID := userutil.MakeUserID(r.Username, cfg.Matrix.ServerName)
+		if resErr := checkMAULimit(req.Context(), cfg, userAPI, userID); resErr != nil {
+			return *resErr
+		}
+	}
+
 	switch r.Auth.Type {
 	case authtypes.LoginTypeRecaptcha:
 		// Check given captcha response
@@ -761,6 +806,37 @@ func handleRegistrationFlow(
 		// Add Dummy to the list of completed registration stages
 		sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypeDummy)
 
+	case authtypes.LoginTypeTerms:
+		// The user has consented to the policies listed in the stage params
+		// Add Terms to the list of completed registration stages
+		sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypeTerms)
+
+	case authtypes.LoginTypeEmail:
+		// Check that the email address has been validated
+		creds := r.Auth.ThreePIDCreds
+		if creds.SID == "" {
+			creds = r.Auth.LegacyThreePIDCreds
+		}
+		validated, address, medium, err := checkThreePIDCredentials(req.Context(), userAPI, creds, cfg)
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("checkThreePIDCredentials failed")
+			return jsonerror.InternalServerError()
+		}
+		if !validated {
+			return util.JSONResponse{
+				Code: http.StatusUnauthorized,
+				JSON: jsonerror.MatrixError{
+					ErrCode: "M_THREEPID_AUTH_FAILED",
+					Err:     "Failed to auth 3pid",
+				},
+			}
+		}
+
+		// Add Email to the list of completed registration stages, and remember
+		// the address so that it can be added to the account
+		sessions.addThreePID(sessionID, validatedThreePID{creds: creds, medium: medium, address: address})
+		sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypeEmail)
+
 	case "":
 		// An empty auth type means that we want to fetch the available
 		// flows. It can also mean that we want to register as an appservice
@@ -818,7 +894,7 @@ func handleApplicationServiceRegistration(
 	// application service registration is entirely separate.
 	return completeRegistration(
 		req.Context(), userAPI, r.Username, "", appserviceID, req.RemoteAddr, req.UserAgent(), r.Auth.Session,
-		r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService,
+		r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService, "",
 	)
 }
 
@@ -834,11 +910,31 @@ func checkAndCompleteFlow(
 	userAPI userapi.ClientUserAPI,
 ) util.JSONResponse {
 	if checkFlowCompleted(flow, cfg.Derived.Registration.Flows) {
+		// We can't pretend to register an account, so spam checkers asking
+		// for the registration to be dropped are treated as rejections.
+		spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+			Kind:   spamcheck.KindRegistration,
+			UserID: userutil.MakeUserID(r.Username, cfg.Matrix.ServerName),
+		})
+		if spam.Rejected() || spam.Dropped() {
+			return spam.JSONResponse()
+		}
+		// Record the version of the terms of service the user consented to
+		var policyVersion string
+		for _, stage := range flow {
+			if stage == authtypes.LoginTypeTerms {
+				policyVersion = cfg.Matrix.UserConsent.Version
+			}
+		}
 		// This flow was completed, registration can continue
-		return completeRegistration(
+		res := completeRegistration(
 			req.Context(), userAPI, r.Username, r.Password, "", req.RemoteAddr, req.UserAgent(), sessionID,
-			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeUser,
+			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeUser, policyVersion,
 		)
+		if threePID, ok := sessions.getThreePID(sessionID); ok && res.Code == http.StatusOK {
+			saveRegistrationThreePID(req.Context(), userAPI, r.Username, threePID, cfg)
+		}
+		return res
 	}
 	sessions.addParams(sessionID, r)
 	// There are still more stages to complete.
@@ -850,6 +946,23 @@ func checkAndCompleteFlow(
 	}
 }
 
+// saveRegistrationThreePID adds the third-party identifier validated during
+// registration to the new account.
+func saveRegistrationThreePID(
+	ctx context.Context, userAPI userapi.ClientUserAPI, localpart string,
+	threePID validatedThreePID, cfg *config.ClientAPI,
+) {
+	if err := userAPI.PerformSaveThreePIDAssociation(ctx, &userapi.PerformSaveThreePIDAssociationRequest{
+		ThreePID:  threePID.address,
+		Localpart: localpart,
+		Medium:    threePID.medium,
+	}, &struct{}{}); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.PerformSaveThreePIDAssociation failed")
+		return
+	}
+	removeThreePIDValidationSession(ctx, userAPI, threePID.creds, cfg)
+}
+
 // completeRegistration runs some rudimentary checks against the submitted
 // input, then if successful creates an account and a newly associated device
 // We pass in each individual part of the request here instead of just passing a
@@ -863,6 +976,7 @@ func completeRegistration(
 	inhibitLogin eventutil.WeakBoolean,
 	displayName, deviceID *string,
 	accType userapi.AccountType,
+	policyVersion string,
 ) util.JSONResponse {
 	if username == "" {
 		return util.JSONResponse{
@@ -879,11 +993,12 @@ func completeRegistration(
 	}
 	var accRes userapi.PerformAccountCreationResponse
 	err := userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
-		AppServiceID: appserviceID,
-		Localpart:    username,
-		Password:     password,
-		AccountType:  accType,
-		OnConflict:   userapi.ConflictAbort,
+		AppServiceID:  appserviceID,
+		Localpart:     username,
+		Password:      password,
+		AccountType:   accType,
+		OnConflict:    userapi.ConflictAbort,
+		PolicyVersion: policyVersion,
 	}, &accRes)
 	if err != nil {
 		if _, ok := err.(*userapi.ErrorConflict); ok { // user already exists
@@ -1024,7 +1139,7 @@ func RegisterAvailable(
 
 	// Check if this username is reserved by an application service
 	userID := userutil.MakeUserID(username, cfg.Matrix.ServerName)
-	for _, appservice := range cfg.Derived.ApplicationServices {
+	for _, appservice := range cfg.Derived.AppServices() {
 		if appservice.OwnsNamespaceCoveringUserId(userID) {
 			return util.JSONResponse{
 				Code: http.StatusBadRequest,
@@ -1083,7 +1198,7 @@ func handleSharedSecretRegistration(cfg *config.ClientAPI, userAPI userapi.Clien
 	if resErr := validateUsername(ssrr.User, cfg.Matrix.ServerName); resErr != nil {
 		return *resErr
 	}
-	if resErr := validatePassword(ssrr.Password); resErr != nil {
+	if resErr := validatePassword(req, ssrr.Password, cfg); resErr != nil {
 		return *resErr
 	}
 	deviceID := "shared_secret_registration"
@@ -1092,5 +1207,5 @@ func handleSharedSecretRegistration(cfg *config.ClientAPI, userAPI userapi.Clien
 	if ssrr.Admin {
 		accType = userapi.AccountTypeAdmin
 	}
-	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, &ssrr.User, &deviceID, accType)
+	return completeRegistration(req.Context(), userAPI, ssrr.User, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, &ssrr.User, &deviceID, accType, "")
 }
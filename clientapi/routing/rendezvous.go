@@ -0,0 +1,266 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+)
+
+const (
+	// rendezvousSessionLifetime is how long rendezvous sessions last for
+	// after they were last written to.
+	rendezvousSessionLifetime = time.Minute
+	// rendezvousMaxContentLength is the most data a session can hold.
+	rendezvousMaxContentLength = 4096
+	// rendezvousMaxSessions is the most sessions which can exist at once.
+	rendezvousMaxSessions = 10000
+)
+
+// rendezvousContentTypes are the content types which rendezvous data is
+// served back with. Anyone can create a session, so anything else is served
+// as application/octet-stream, to stop the endpoint from being used to host
+// e.g. HTML pages on the homeserver's origin.
+var rendezvousContentTypes = map[string]bool{
+	"application/json": true,
+	"text/plain":       true,
+}
+
+// rendezvousSessions holds the sessions which devices use to exchange the
+// data needed to sign in a new device (MSC3886), e.g. after it has scanned a
+// QR code. Sessions are only held in memory, as they are short-lived, so they
+// aren't shared between client API processes. Only one client API instance
+// is supported with MSC3886 enabled, or all of the requests for a session
+// must be routed to the same instance.
+type rendezvousSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*rendezvousSession
+}
+
+type rendezvousSession struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	expires      time.Time
+}
+
+func newRendezvousSessions() *rendezvousSessions {
+	return &rendezvousSessions{
+		sessions: make(map[string]*rendezvousSession),
+	}
+}
+
+// Rendezvous implements:
+//
+//	POST   /org.matrix.msc3886/rendezvous
+//	GET    /org.matrix.msc3886/rendezvous/{sessionID}
+//	PUT    /org.matrix.msc3886/rendezvous/{sessionID}
+//	DELETE /org.matrix.msc3886/rendezvous/{sessionID}
+func (s *rendezvousSessions) Rendezvous(w http.ResponseWriter, req *http.Request, sessionID string) *util.JSONResponse {
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, Expires, Last-Modified")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	now := time.Now()
+
+	if sessionID == "" {
+		if req.Method != http.MethodPost {
+			return &util.JSONResponse{
+				Code: http.StatusMethodNotAllowed,
+				JSON: jsonerror.NotFound("Bad method"),
+			}
+		}
+		session, errRes := newRendezvousSession(req, now)
+		if errRes != nil {
+			return errRes
+		}
+		if sessionID, errRes = s.create(session, now); errRes != nil {
+			return errRes
+		}
+		location := strings.TrimSuffix(req.URL.Path, "/") + "/" + sessionID
+		w.Header().Set("Location", location)
+		writeRendezvousHeaders(w, session)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"url":"` + location + `"}`))
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok || now.After(session.expires) {
+		delete(s.sessions, sessionID)
+		return &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("Rendezvous session not found"),
+		}
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeRendezvousHeaders(w, session)
+		if etagMatches(req.Header.Get("If-None-Match"), session.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		w.Header().Set("Content-Type", session.contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(session.data)
+		return nil
+
+	case http.MethodPut:
+		// Writes must be based on the latest data, so that devices don't
+		// overwrite each other's data without having read it.
+		if ifMatch := req.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, session.etag) {
+			writeRendezvousHeaders(w, session)
+			return &util.JSONResponse{
+				Code: http.StatusPreconditionFailed,
+				JSON: jsonerror.MatrixError{
+					ErrCode: "M_CONCURRENT_WRITE",
+					Err:     "The rendezvous session has been changed by another device",
+				},
+			}
+		}
+		updated, errRes := newRendezvousSession(req, now)
+		if errRes != nil {
+			return errRes
+		}
+		s.sessions[sessionID] = updated
+		writeRendezvousHeaders(w, updated)
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+
+	case http.MethodDelete:
+		delete(s.sessions, sessionID)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return &util.JSONResponse{
+		Code: http.StatusMethodNotAllowed,
+		JSON: jsonerror.NotFound("Bad method"),
+	}
+}
+
+// create stores a new session and returns its ID, first removing expired
+// sessions.
+func (s *rendezvousSessions) create(session *rendezvousSession, now time.Time) (string, *util.JSONResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existing := range s.sessions {
+		if now.After(existing.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	if len(s.sessions) >= rendezvousMaxSessions {
+		return "", &util.JSONResponse{
+			Code: http.StatusServiceUnavailable,
+			JSON: jsonerror.LimitExceeded("Too many rendezvous sessions", rendezvousSessionLifetime.Milliseconds()),
+		}
+	}
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: jsonerror.Unknown("Failed to create rendezvous session"),
+		}
+	}
+	s.sessions[sessionID] = session
+	return sessionID, nil
+}
+
+// newRendezvousSession returns a session holding the request body.
+func newRendezvousSession(req *http.Request, now time.Time) (*rendezvousSession, *util.JSONResponse) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, rendezvousMaxContentLength+1))
+	if err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.BadJSON("The request body could not be read: " + err.Error()),
+		}
+	}
+	if len(data) > rendezvousMaxContentLength {
+		return nil, &util.JSONResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			JSON: jsonerror.MatrixError{
+				ErrCode: "M_TOO_LARGE",
+				Err:     "The rendezvous data is too large",
+			},
+		}
+	}
+	etag, err := randomHex(16)
+	if err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: jsonerror.Unknown("Failed to store rendezvous data"),
+		}
+	}
+	return &rendezvousSession{
+		data:         data,
+		contentType:  rendezvousContentType(req.Header.Get("Content-Type")),
+		etag:         `"` + etag + `"`,
+		lastModified: now,
+		expires:      now.Add(rendezvousSessionLifetime),
+	}, nil
+}
+
+// rendezvousContentType returns the content type to serve the data with,
+// which is application/octet-stream unless the uploader gave a safe one.
+func rendezvousContentType(header string) string {
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil || !rendezvousContentTypes[mediaType] {
+		return "application/octet-stream"
+	}
+	if charset, ok := params["charset"]; ok {
+		return mime.FormatMediaType(mediaType, map[string]string{"charset": charset})
+	}
+	return mediaType
+}
+
+func writeRendezvousHeaders(w http.ResponseWriter, session *rendezvousSession) {
+	w.Header().Set("ETag", session.etag)
+	w.Header().Set("Last-Modified", session.lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", session.expires.UTC().Format(http.TimeFormat))
+}
+
+// etagMatches returns true if the If-Match or If-None-Match header contains
+// the ETag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag || `"`+candidate+`"` == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
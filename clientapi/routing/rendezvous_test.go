@@ -0,0 +1,125 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/util"
+)
+
+func TestRendezvous(t *testing.T) {
+	sessions := newRendezvousSessions()
+	router := mux.NewRouter()
+	handler := httputil.MakeHTMLAPI("rendezvous", func(w http.ResponseWriter, req *http.Request) *util.JSONResponse {
+		return sessions.Rendezvous(w, req, mux.Vars(req)["sessionID"])
+	})
+	router.Handle("/rendezvous", handler)
+	router.Handle("/rendezvous/{sessionID}", handler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	do := func(method, path, body string, headers map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close() // nolint:errcheck
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(b)
+	}
+
+	// The new device creates a session with its data.
+	res, _ := do(http.MethodPost, "/rendezvous", "hello", map[string]string{"Content-Type": "text/plain"})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 creating the session, got %d", res.StatusCode)
+	}
+	location := res.Header.Get("Location")
+	etag := res.Header.Get("ETag")
+	if !strings.HasPrefix(location, "/rendezvous/") || etag == "" || res.Header.Get("Expires") == "" {
+		t.Fatalf("unexpected headers %+v", res.Header)
+	}
+
+	// The existing device reads it.
+	res, body := do(http.MethodGet, location, "", nil)
+	if res.StatusCode != http.StatusOK || body != "hello" || res.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected response %d %q %+v", res.StatusCode, body, res.Header)
+	}
+	if res, _ = do(http.MethodGet, location, "", map[string]string{"If-None-Match": etag}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for an unchanged session, got %d", res.StatusCode)
+	}
+
+	// Writes must be based on the latest data.
+	res, _ = do(http.MethodPut, location, "reply", map[string]string{"If-Match": etag})
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("ETag") == etag {
+		t.Fatalf("expected 202 with a new ETag, got %d %+v", res.StatusCode, res.Header)
+	}
+	if res, _ = do(http.MethodPut, location, "stale", map[string]string{"If-Match": etag}); res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale write, got %d", res.StatusCode)
+	}
+	if _, body = do(http.MethodGet, location, "", nil); body != "reply" {
+		t.Fatalf("expected the updated data, got %q", body)
+	}
+
+	// Only safe content types are served back as they were uploaded, and
+	// browsers mustn't sniff or run the data.
+	for contentType, want := range map[string]string{
+		"application/json":          "application/json",
+		"text/plain; charset=utf-8": "text/plain; charset=utf-8",
+		"text/html":                 "application/octet-stream",
+		"image/svg+xml":             "application/octet-stream",
+		"":                          "application/octet-stream",
+	} {
+		res, _ = do(http.MethodPost, "/rendezvous", "<script>alert(1)</script>", map[string]string{"Content-Type": contentType})
+		res, _ = do(http.MethodGet, res.Header.Get("Location"), "", nil)
+		if got := res.Header.Get("Content-Type"); got != want {
+			t.Errorf("expected %q to be served as %q, got %q", contentType, want, got)
+		}
+		if res.Header.Get("X-Content-Type-Options") != "nosniff" || res.Header.Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("expected nosniff and sandbox headers, got %+v", res.Header)
+		}
+	}
+
+	// Data which is too large is refused.
+	if res, _ = do(http.MethodPost, "/rendezvous", strings.Repeat("a", rendezvousMaxContentLength+1), nil); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for too much data, got %d", res.StatusCode)
+	}
+
+	// Deleted sessions are gone.
+	if res, _ = do(http.MethodDelete, location, "", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting the session, got %d", res.StatusCode)
+	}
+	if res, _ = do(http.MethodGet, location, "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a deleted session, got %d", res.StatusCode)
+	}
+}
@@ -39,6 +39,7 @@ import (
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 )
 
@@ -62,6 +63,7 @@ func Setup(
 	keyAPI keyserverAPI.ClientKeyAPI,
 	extRoomsProvider api.ExtraPublicRoomsProvider,
 	mscCfg *config.MSCs, natsClient *nats.Conn,
+	processCtx *process.ProcessContext,
 ) {
 	prometheus.MustRegister(amtRegUsers, sendEventDuration)
 
@@ -162,6 +164,60 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	dendriteAdminRouter.Handle("/admin/impersonate/{userID}",
+		httputil.MakeAdminAPI("admin_impersonate_user", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminImpersonateUser(req, cfg, device, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/impersonations/{userID}",
+		httputil.MakeAdminAPI("admin_impersonation_audit", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminImpersonationAudit(req, cfg, device, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/shadowBan/{userID}",
+		httputil.MakeAdminAPI("admin_shadow_ban", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminShadowBanUser(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodDelete, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/accountValidity/{userID}",
+		httputil.MakeAdminAPI("admin_account_validity", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminSetAccountValidity(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/unlockAccount/{userID}",
+		httputil.MakeAdminAPI("admin_unlock_account", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminUnlockAccount(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/devices/{userID}",
+		httputil.MakeAdminAPI("admin_list_devices", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListDevices(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/devices/{userID}/{deviceID}",
+		httputil.MakeAdminAPI("admin_update_device", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminUpdateDevice(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodPut, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/devices/{userID}/{deviceID}",
+		httputil.MakeAdminAPI("admin_delete_device", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminDeleteDevice(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodDelete)
+
+	dendriteAdminRouter.Handle("/admin/logoutAllDevices/{userID}",
+		httputil.MakeAdminAPI("admin_logout_all_devices", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminLogoutAllDevices(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
 	dendriteAdminRouter.Handle("/admin/fulltext/reindex",
 		httputil.MakeAdminAPI("admin_fultext_reindex", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return AdminReindex(req, cfg, device, natsClient)
@@ -174,13 +230,42 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	dendriteAdminRouter.Handle("/admin/appservices",
+		httputil.MakeAdminAPI("admin_list_appservices", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListAppservices(req, asAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/appservices/{appserviceID}/status",
+		httputil.MakeAdminAPI("admin_appservice_status", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminAppserviceStatus(req, asAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/appservices/{appserviceID}",
+		httputil.MakeAdminAPI("admin_register_appservice", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminRegisterAppservice(req, cfg, asAPI)
+		}),
+	).Methods(http.MethodPut, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/appservices/{appserviceID}",
+		httputil.MakeAdminAPI("admin_remove_appservice", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminRemoveAppservice(req, cfg, asAPI)
+		}),
+	).Methods(http.MethodDelete, http.MethodOptions)
+
 	// server notifications
+	var serverNotificationSender *userapi.Device
 	if cfg.Matrix.ServerNotices.Enabled {
 		logrus.Info("Enabling server notices at /_synapse/admin/v1/send_server_notice")
-		serverNotificationSender, err := getSenderDevice(context.Background(), userAPI, cfg)
+		var err error
+		serverNotificationSender, err = getSenderDevice(context.Background(), userAPI, cfg)
 		if err != nil {
 			logrus.WithError(err).Fatal("unable to get account for sending sending server notices")
 		}
+		if cfg.Matrix.MAU.Enabled && cfg.Matrix.MAU.WarningThreshold > 0 {
+			go runMAUWarnings(processCtx.Context(), cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+		}
 
 		synapseAdminRouter.Handle("/admin/v1/send_server_notice/{txnID}",
 			httputil.MakeAuthAPI("send_server_notice", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -227,15 +312,20 @@ func Setup(
 	v3mux := publicAPIMux.PathPrefix("/{apiversion:(?:r0|v3)}/").Subrouter()
 
 	unstableMux := publicAPIMux.PathPrefix("/unstable").Subrouter()
+	v1mux := publicAPIMux.PathPrefix("/v1/").Subrouter()
+
+	// Users who haven't consented to the terms of service can't send events
+	// or join rooms until they do.
+	consentCheck := httputil.WithConsentCheck(&cfg.Matrix.UserConsent)
 
 	v3mux.Handle("/createRoom",
 		httputil.MakeAuthAPI("createRoom", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return CreateRoom(req, device, cfg, userAPI, rsAPI, asAPI)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/join/{roomIDOrAlias}",
 		httputil.MakeAuthAPI(gomatrixserverlib.Join, userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
-			if r := rateLimits.Limit(req, device); r != nil {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitJoin); r != nil {
 				return *r
 			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
@@ -245,7 +335,7 @@ func Setup(
 			return JoinRoomByIDOrAlias(
 				req, device, rsAPI, userAPI, vars["roomIDOrAlias"],
 			)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	if mscCfg.Enabled("msc2753") {
@@ -271,7 +361,7 @@ func Setup(
 	).Methods(http.MethodGet, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/join",
 		httputil.MakeAuthAPI(gomatrixserverlib.Join, userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
-			if r := rateLimits.Limit(req, device); r != nil {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitJoin); r != nil {
 				return *r
 			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
@@ -281,7 +371,7 @@ func Setup(
 			return JoinRoomByIDOrAlias(
 				req, device, rsAPI, userAPI, vars["roomID"],
 			)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/leave",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -319,7 +409,7 @@ func Setup(
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/invite",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
-			if r := rateLimits.Limit(req, device); r != nil {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitInvite); r != nil {
 				return *r
 			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
@@ -327,7 +417,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return SendInvite(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/kick",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -349,15 +439,21 @@ func Setup(
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/send/{eventType}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitMessage); r != nil {
+				return *r
+			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
 			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, nil, cfg, rsAPI, nil)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/send/{eventType}/{txnID}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitMessage); r != nil {
+				return *r
+			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 			if err != nil {
 				return util.ErrorResponse(err)
@@ -365,7 +461,7 @@ func Setup(
 			txnID := vars["txnID"]
 			return SendEvent(req, device, vars["roomID"], vars["eventType"], &txnID,
 				nil, cfg, rsAPI, transactionsCache)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPut, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/event/{eventID}",
 		httputil.MakeAuthAPI("rooms_get_event", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -415,6 +511,9 @@ func Setup(
 
 	v3mux.Handle("/rooms/{roomID}/state/{eventType:[^/]+/?}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitMessage); r != nil {
+				return *r
+			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 			if err != nil {
 				return util.ErrorResponse(err)
@@ -422,22 +521,25 @@ func Setup(
 			emptyString := ""
 			eventType := strings.TrimSuffix(vars["eventType"], "/")
 			return SendEvent(req, device, vars["roomID"], eventType, nil, &emptyString, cfg, rsAPI, nil)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	v3mux.Handle("/rooms/{roomID}/state/{eventType}/{stateKey}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.LimitClass(req, device, config.RateLimitMessage); r != nil {
+				return *r
+			}
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
 			stateKey := vars["stateKey"]
 			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, &stateKey, cfg, rsAPI, nil)
-		}),
+		}, consentCheck),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	v3mux.Handle("/register", httputil.MakeExternalAPI("register", func(req *http.Request) util.JSONResponse {
-		if r := rateLimits.Limit(req, nil); r != nil {
+		if r := rateLimits.LimitClass(req, nil, config.RateLimitRegistration); r != nil {
 			return *r
 		}
 		return Register(req, userAPI, cfg)
@@ -488,7 +590,6 @@ func Setup(
 			return GetVisibility(req, rsAPI, vars["roomID"])
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
-	// TODO: Add AS support
 	v3mux.Handle("/directory/list/room/{roomID}",
 		httputil.MakeAuthAPI("directory_list", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
@@ -498,6 +599,15 @@ func Setup(
 			return SetVisibility(req, rsAPI, device, vars["roomID"])
 		}),
 	).Methods(http.MethodPut, http.MethodOptions)
+	v3mux.Handle("/directory/list/appservice/{networkID}/{roomID}",
+		httputil.MakeAuthAPI("directory_list", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return SetVisibilityAS(req, rsAPI, device, vars["networkID"], vars["roomID"])
+		}),
+	).Methods(http.MethodPut, http.MethodOptions)
 	v3mux.Handle("/publicRooms",
 		httputil.MakeExternalAPI("public_rooms", func(req *http.Request) util.JSONResponse {
 			return GetPostPublicRooms(req, rsAPI, extRoomsProvider, federation, cfg)
@@ -582,12 +692,26 @@ func Setup(
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	changePassword := httputil.MakeAuthAPI("password", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		if r := rateLimits.Limit(req, device); r != nil {
+			return *r
+		}
+		return Password(req, userAPI, device, cfg)
+	})
+	resetPassword := httputil.MakeExternalAPI("password_reset", func(req *http.Request) util.JSONResponse {
+		if r := rateLimits.Limit(req, nil); r != nil {
+			return *r
+		}
+		return PasswordReset(req, userAPI, cfg)
+	})
+	// Users who have forgotten their password reset it without an access token.
 	v3mux.Handle("/account/password",
-		httputil.MakeAuthAPI("password", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
-			if r := rateLimits.Limit(req, device); r != nil {
-				return *r
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, err := auth.ExtractAccessToken(req); err != nil {
+				resetPassword.ServeHTTP(w, req)
+				return
 			}
-			return Password(req, userAPI, device, cfg)
+			changePassword.ServeHTTP(w, req)
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
@@ -604,13 +728,46 @@ func Setup(
 
 	v3mux.Handle("/login",
 		httputil.MakeExternalAPI("login", func(req *http.Request) util.JSONResponse {
-			if r := rateLimits.Limit(req, nil); r != nil {
+			if r := rateLimits.LimitClass(req, nil, config.RateLimitLogin); r != nil {
 				return *r
 			}
-			return Login(req, userAPI, cfg)
+			return Login(req, userAPI, cfg, rateLimits.TrustedClientIP(req))
 		}),
 	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
 
+	if loginTokenEnabled(cfg) {
+		unstableMux.Handle("/org.matrix.msc3882/login/{path:(?:get_token|token)}",
+			httputil.MakeAuthAPI("login_get_token", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				if r := rateLimits.Limit(req, device); r != nil {
+					return *r
+				}
+				return GetLoginToken(req, userInteractiveAuth, userAPI, device, cfg)
+			}),
+		).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	if mscCfg.Enabled("msc3886") {
+		rendezvous := newRendezvousSessions()
+		rendezvousHandler := httputil.MakeHTMLAPI("rendezvous", func(w http.ResponseWriter, req *http.Request) *util.JSONResponse {
+			if req.Method == http.MethodPost {
+				if r := rateLimits.Limit(req, nil); r != nil {
+					return r
+				}
+			}
+			return rendezvous.Rendezvous(w, req, mux.Vars(req)["sessionID"])
+		})
+		unstableMux.Handle("/org.matrix.msc3886/rendezvous", rendezvousHandler).
+			Methods(http.MethodPost, http.MethodOptions)
+		unstableMux.Handle("/org.matrix.msc3886/rendezvous/{sessionID}", rendezvousHandler).
+			Methods(http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodOptions)
+	}
+
+	v3mux.Handle("/password_policy",
+		httputil.MakeExternalAPI("password_policy", func(req *http.Request) util.JSONResponse {
+			return GetPasswordPolicy(cfg)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	v3mux.Handle("/auth/{authType}/fallback/web",
 		httputil.MakeHTMLAPI("auth_fallback", func(w http.ResponseWriter, req *http.Request) *util.JSONResponse {
 			vars := mux.Vars(req)
@@ -828,18 +985,55 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	if cfg.Matrix.AccountValidity.Enabled {
+		go runAccountValidityReminders(processCtx.Context(), cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+	}
+
+	if cfg.Matrix.LoginLockout.Enabled && cfg.Matrix.LoginLockout.NotifyAfter > 0 && serverNotificationSender != nil {
+		go runLoginFailureNotices(processCtx.Context(), cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+	}
+
+	if cfg.Matrix.UserConsent.Enabled && cfg.Matrix.UserConsent.ServerNoticeContent != "" && serverNotificationSender != nil {
+		go runConsentNotices(processCtx.Context(), cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+	}
+
+	publicAPIMux.Handle("/consent",
+		httputil.MakeHTMLAPI("consent", func(w http.ResponseWriter, req *http.Request) *util.JSONResponse {
+			return Consent(w, req, userAPI, cfg)
+		}),
+	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+
+	unstableMux.Handle("/account_validity/renew",
+		httputil.MakeExternalAPI("account_validity_renew", func(req *http.Request) util.JSONResponse {
+			return RenewAccountValidity(req, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	unstableMux.Handle("/account/3pid/delete",
 		httputil.MakeAuthAPI("account_3pid", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return Forget3PID(req, userAPI)
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
-	v3mux.Handle("/{path:(?:account/3pid|register)}/email/requestToken",
+	v3mux.Handle("/{path:(?:account/3pid|register|account/password)}/email/requestToken",
 		httputil.MakeExternalAPI("account_3pid_request_token", func(req *http.Request) util.JSONResponse {
-			return RequestEmailToken(req, userAPI, cfg)
+			if r := rateLimits.LimitClass(req, nil, config.RateLimitThreePID); r != nil {
+				return *r
+			}
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return RequestEmailToken(req, userAPI, cfg, vars["path"])
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	unstableMux.Handle("/{kind:(?:registration|add_threepid|password_reset)}/email/submit_token",
+		httputil.MakeHTMLAPI("email_submit_token", func(w http.ResponseWriter, req *http.Request) *util.JSONResponse {
+			return SubmitEmailToken(w, req, userAPI, cfg)
+		}),
+	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+
 	v3mux.Handle("/voip/turnServer",
 		httputil.MakeAuthAPI("turn_server", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			if r := rateLimits.Limit(req, device); r != nil {
@@ -850,15 +1044,75 @@ func Setup(
 	).Methods(http.MethodGet, http.MethodOptions)
 
 	v3mux.Handle("/thirdparty/protocols",
-		httputil.MakeExternalAPI("thirdparty_protocols", func(req *http.Request) util.JSONResponse {
-			// TODO: Return the third party protcols
-			return util.JSONResponse{
-				Code: http.StatusOK,
-				JSON: struct{}{},
+		httputil.MakeAuthAPI("thirdparty_protocols", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return Protocols(req, asAPI, "")
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/thirdparty/protocol/{protocolID}",
+		httputil.MakeAuthAPI("thirdparty_protocol", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return Protocols(req, asAPI, vars["protocolID"])
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/thirdparty/location",
+		httputil.MakeAuthAPI("thirdparty_location", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return Location(req, asAPI, "")
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/thirdparty/location/{protocolID}",
+		httputil.MakeAuthAPI("thirdparty_location", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return Location(req, asAPI, vars["protocolID"])
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/thirdparty/user",
+		httputil.MakeAuthAPI("thirdparty_user", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return User(req, asAPI, "")
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/thirdparty/user/{protocolID}",
+		httputil.MakeAuthAPI("thirdparty_user", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
 			}
+			return User(req, asAPI, vars["protocolID"])
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	appservicePing := httputil.MakeAuthAPI("appservice_ping", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+		if err != nil {
+			return util.ErrorResponse(err)
+		}
+		return AppservicePing(req, device, asAPI, vars["appserviceID"])
+	})
+	v1mux.Handle("/appservice/{appserviceID}/ping", appservicePing).Methods(http.MethodPost, http.MethodOptions)
+	unstableMux.Handle("/fi.mau.msc2659/appservice/{appserviceID}/ping", appservicePing).Methods(http.MethodPost, http.MethodOptions)
+
+	if mscCfg.Enabled("msc2716") {
+		unstableMux.Handle("/org.matrix.msc2716/rooms/{roomID}/batch_send",
+			httputil.MakeAuthAPI("batch_send", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+				if err != nil {
+					return util.ErrorResponse(err)
+				}
+				return BatchSend(req, device, vars["roomID"], cfg, rsAPI)
+			}),
+		).Methods(http.MethodPost, http.MethodOptions)
+	}
+
 	v3mux.Handle("/rooms/{roomID}/initialSync",
 		httputil.MakeExternalAPI("rooms_initial_sync", func(req *http.Request) util.JSONResponse {
 			// TODO: Allow people to peek into rooms.
@@ -953,7 +1207,7 @@ func Setup(
 				postContent.SearchString,
 				postContent.Limit,
 				federation,
-				cfg.Matrix.ServerName,
+				cfg,
 			)
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
@@ -1131,7 +1385,7 @@ func Setup(
 			if r := rateLimits.Limit(req, device); r != nil {
 				return *r
 			}
-			return GetCapabilities(req, rsAPI)
+			return GetCapabilities(req, rsAPI, cfg)
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
 
@@ -31,6 +31,7 @@ import (
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/internal/transactions"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -120,7 +121,7 @@ func SendEvent(
 		delete(r, "join_authorised_via_users_server")
 	}
 
-	evTime, err := httputil.ParseTSParam(req)
+	evTime, err := httputil.ParseTSParam(req, device)
 	if err != nil {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -168,6 +169,36 @@ func SendEvent(
 		}
 	}
 
+	spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+		Kind:   spamcheck.KindEvent,
+		UserID: device.UserID,
+		RoomID: roomID,
+		Event:  e.JSON(),
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse()
+	}
+
+	// Shadow-banned users get the ID of the event they would have sent, but
+	// the event is never persisted or federated. The same goes for events
+	// that a spam checker has asked us to drop.
+	if device.ShadowBanned || spam.Dropped() {
+		logger := util.GetLogger(req.Context()).WithField("room_id", roomID)
+		if device.ShadowBanned {
+			logger.Info("Dropping event from shadow-banned user")
+		} else {
+			logger.Info("Dropping event as requested by the spam checker")
+		}
+		res := util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: sendEventResponse{e.EventID()},
+		}
+		if txnID != nil {
+			txnCache.AddTransaction(device.AccessToken, *txnID, &res)
+		}
+		return res
+	}
+
 	var txnAndSessionID *api.TransactionID
 	if txnID != nil {
 		txnAndSessionID = &api.TransactionID{
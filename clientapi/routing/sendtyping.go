@@ -55,6 +55,14 @@ func SendTyping(
 		return *resErr
 	}
 
+	// Typing notifications from shadow-banned users are dropped.
+	if device.ShadowBanned {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}
+	}
+
 	if err := syncProducer.SendTyping(req.Context(), userID, roomID, r.Typing, r.Timeout); err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("eduProducer.Send failed")
 		return jsonerror.InternalServerError()
@@ -93,13 +93,50 @@ func SendServerNotice(
 		}
 	}
 
+	var txnAndSessionID *api.TransactionID
+	if txnID != nil {
+		txnAndSessionID = &api.TransactionID{
+			TransactionID: *txnID,
+			SessionID:     device.SessionID,
+		}
+	}
+
+	content := map[string]interface{}{
+		"body":    r.Content.Body,
+		"msgtype": r.Content.MsgType,
+	}
+	res := sendServerNotice(
+		ctx, r.UserID, content, cfgNotices, cfgClient, userAPI, rsAPI, asAPI, senderDevice, txnAndSessionID,
+	)
+	// Add response to transactionsCache
+	if txnID != nil && res.Code == http.StatusOK {
+		txnCache.AddTransaction(device.AccessToken, *txnID, &res)
+	}
+	return res
+}
+
+// sendServerNotice sends a message with the given content to the user in
+// their server notices room, creating the room or re-inviting the user if
+// needed.
+func sendServerNotice(
+	ctx context.Context,
+	userID string,
+	content map[string]interface{},
+	cfgNotices *config.ServerNotices,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+	txnAndSessionID *api.TransactionID,
+) util.JSONResponse {
 	// get rooms for specified user
 	allUserRooms := []string{}
 	userRooms := api.QueryRoomsForUserResponse{}
 	// Get rooms the user is either joined, invited or has left.
 	for _, membership := range []string{"join", "invite", "leave"} {
 		if err := rsAPI.QueryRoomsForUser(ctx, &api.QueryRoomsForUserRequest{
-			UserID:         r.UserID,
+			UserID:         userID,
 			WantMembership: membership,
 		}, &userRooms); err != nil {
 			return util.ErrorResponse(err)
@@ -139,7 +176,7 @@ func SendServerNotice(
 	// create a new room for the user
 	if len(commonRooms) == 0 {
 		powerLevelContent := eventutil.InitialPowerLevelsContent(senderUserID)
-		powerLevelContent.Users[r.UserID] = -10 // taken from Synapse
+		powerLevelContent.Users[userID] = -10 // taken from Synapse
 		pl, err := json.Marshal(powerLevelContent)
 		if err != nil {
 			return util.ErrorResponse(err)
@@ -151,7 +188,7 @@ func SendServerNotice(
 			return util.ErrorResponse(err)
 		}
 		crReq := createRoomRequest{
-			Invite:                    []string{r.UserID},
+			Invite:                    []string{userID},
 			Name:                      cfgNotices.RoomName,
 			Visibility:                "private",
 			Preset:                    presetPrivateChat,
@@ -173,7 +210,7 @@ func SendServerNotice(
 					Order: 1.0,
 				},
 			}}
-			if err = saveTagData(req, r.UserID, roomID, userAPI, serverAlertTag); err != nil {
+			if err = saveTagData(ctx, userID, roomID, userAPI, serverAlertTag); err != nil {
 				util.GetLogger(ctx).WithError(err).Error("saveTagData failed")
 				return jsonerror.InternalServerError()
 			}
@@ -186,14 +223,14 @@ func SendServerNotice(
 		// we've found a room in common, check the membership
 		roomID = commonRooms[0]
 		membershipRes := api.QueryMembershipForUserResponse{}
-		err := rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: r.UserID, RoomID: roomID}, &membershipRes)
+		err := rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: userID, RoomID: roomID}, &membershipRes)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("unable to query membership for user")
 			return jsonerror.InternalServerError()
 		}
 		if !membershipRes.IsInRoom {
 			// re-invite the user
-			res, err := sendInvite(ctx, userAPI, senderDevice, roomID, r.UserID, "Server notice room", cfgClient, rsAPI, asAPI, time.Now())
+			res, err := sendInvite(ctx, userAPI, senderDevice, roomID, userID, "Server notice room", cfgClient, rsAPI, asAPI, time.Now())
 			if err != nil {
 				return res
 			}
@@ -202,25 +239,13 @@ func SendServerNotice(
 
 	startedGeneratingEvent := time.Now()
 
-	request := map[string]interface{}{
-		"body":    r.Content.Body,
-		"msgtype": r.Content.MsgType,
-	}
-	e, resErr := generateSendEvent(ctx, request, senderDevice, roomID, "m.room.message", nil, cfgClient, rsAPI, time.Now())
+	e, resErr := generateSendEvent(ctx, content, senderDevice, roomID, "m.room.message", nil, cfgClient, rsAPI, time.Now())
 	if resErr != nil {
 		logrus.Errorf("failed to send message: %+v", resErr)
 		return *resErr
 	}
 	timeToGenerateEvent := time.Since(startedGeneratingEvent)
 
-	var txnAndSessionID *api.TransactionID
-	if txnID != nil {
-		txnAndSessionID = &api.TransactionID{
-			TransactionID: *txnID,
-			SessionID:     device.SessionID,
-		}
-	}
-
 	// pass the new event to the roomserver and receive the correct event ID
 	// event ID in case of duplicate transaction is discarded
 	startedSubmittingEvent := time.Now()
@@ -245,21 +270,15 @@ func SendServerNotice(
 	}).Info("Sent event to roomserver")
 	timeToSubmitEvent := time.Since(startedSubmittingEvent)
 
-	res := util.JSONResponse{
-		Code: http.StatusOK,
-		JSON: sendEventResponse{e.EventID()},
-	}
-	// Add response to transactionsCache
-	if txnID != nil {
-		txnCache.AddTransaction(device.AccessToken, *txnID, &res)
-	}
-
 	// Take a note of how long it took to generate the event vs submit
 	// it to the roomserver.
 	sendEventDuration.With(prometheus.Labels{"action": "build"}).Observe(float64(timeToGenerateEvent.Milliseconds()))
 	sendEventDuration.With(prometheus.Labels{"action": "submit"}).Observe(float64(timeToSubmitEvent.Milliseconds()))
 
-	return res
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: sendEventResponse{e.EventID()},
+	}
 }
 
 func (r sendServerNoticeRequest) valid() (ok bool) {
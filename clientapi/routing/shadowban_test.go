@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+
+	"github.com/matrix-org/dendrite/roomserver"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/test/testrig"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// readOnlyRoomserverAPI passes queries through to the roomserver, but fails
+// the test if anything is sent to it. Invites and joins are sent over
+// federation by the roomserver, so this also covers federation.
+type readOnlyRoomserverAPI struct {
+	api.ClientRoomserverAPI
+	t *testing.T
+}
+
+func (r *readOnlyRoomserverAPI) unexpected(method string) error {
+	r.t.Errorf("unexpected call to the roomserver's %s", method)
+	return fmt.Errorf("unexpected call to %s", method)
+}
+
+func (r *readOnlyRoomserverAPI) InputRoomEvents(ctx context.Context, req *api.InputRoomEventsRequest, res *api.InputRoomEventsResponse) error {
+	return r.unexpected("InputRoomEvents")
+}
+
+func (r *readOnlyRoomserverAPI) PerformInvite(ctx context.Context, req *api.PerformInviteRequest, res *api.PerformInviteResponse) error {
+	return r.unexpected("PerformInvite")
+}
+
+func (r *readOnlyRoomserverAPI) PerformJoin(ctx context.Context, req *api.PerformJoinRequest, res *api.PerformJoinResponse) error {
+	return r.unexpected("PerformJoin")
+}
+
+func (r *readOnlyRoomserverAPI) PerformLeave(ctx context.Context, req *api.PerformLeaveRequest, res *api.PerformLeaveResponse) error {
+	return r.unexpected("PerformLeave")
+}
+
+func (r *readOnlyRoomserverAPI) PerformPublish(ctx context.Context, req *api.PerformPublishRequest, res *api.PerformPublishResponse) error {
+	return r.unexpected("PerformPublish")
+}
+
+func (r *readOnlyRoomserverAPI) SetRoomAlias(ctx context.Context, req *api.SetRoomAliasRequest, res *api.SetRoomAliasResponse) error {
+	return r.unexpected("SetRoomAlias")
+}
+
+// readOnlyProfileAPI returns an empty profile for every user, and fails the
+// test if a profile is changed.
+type readOnlyProfileAPI struct {
+	userapi.ClientUserAPI
+	t *testing.T
+}
+
+func (a *readOnlyProfileAPI) QueryProfile(ctx context.Context, req *userapi.QueryProfileRequest, res *userapi.QueryProfileResponse) error {
+	res.UserExists = true
+	return nil
+}
+
+func (a *readOnlyProfileAPI) SetAvatarURL(ctx context.Context, req *userapi.PerformSetAvatarURLRequest, res *userapi.PerformSetAvatarURLResponse) error {
+	a.t.Errorf("unexpected call to SetAvatarURL")
+	return fmt.Errorf("unexpected call to SetAvatarURL")
+}
+
+func (a *readOnlyProfileAPI) SetDisplayName(ctx context.Context, req *userapi.PerformUpdateDisplayNameRequest, res *struct{}) error {
+	a.t.Errorf("unexpected call to SetDisplayName")
+	return fmt.Errorf("unexpected call to SetDisplayName")
+}
+
+func TestShadowBannedRequests(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, close := testrig.CreateBaseDendrite(t, dbType)
+		defer close()
+		cfg := &base.Cfg.ClientAPI
+
+		realRSAPI := roomserver.NewInternalAPI(base)
+		// SetFederationAPI starts the room event input consumer
+		realRSAPI.SetFederationAPI(nil, nil)
+		if err := api.SendEvents(ctx, realRSAPI, api.KindNew, room.Events(), "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+		rsAPI := &readOnlyRoomserverAPI{ClientRoomserverAPI: realRSAPI, t: t}
+		profileAPI := &readOnlyProfileAPI{t: t}
+		device := &userapi.Device{UserID: alice.ID, ID: "ALICEDEVICE", AccessToken: "token", ShadowBanned: true}
+
+		request := func(method, body string) *http.Request {
+			return httptest.NewRequest(method, "/", strings.NewReader(body))
+		}
+
+		t.Run("send event", func(t *testing.T) {
+			stateKey := ""
+			for _, eventType := range []string{"m.room.message", "m.room.topic"} {
+				var key *string
+				body := `{"msgtype":"m.text","body":"spam"}`
+				if eventType == "m.room.topic" {
+					key, body = &stateKey, `{"topic":"spam"}`
+				}
+				res := SendEvent(request(http.MethodPut, body), device, room.ID, eventType, nil, key, cfg, rsAPI, nil)
+				if res.Code != http.StatusOK {
+					t.Fatalf("expected fake success sending %s, got %d: %+v", eventType, res.Code, res.JSON)
+				}
+				if sendRes, ok := res.JSON.(sendEventResponse); !ok || sendRes.EventID == "" {
+					t.Fatalf("expected an event ID sending %s, got %+v", eventType, res.JSON)
+				}
+			}
+		})
+
+		t.Run("invite", func(t *testing.T) {
+			res := SendInvite(request(http.MethodPost, `{"user_id":"`+bob.ID+`"}`), profileAPI, device, room.ID, cfg, rsAPI, nil)
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected fake success, got %d: %+v", res.Code, res.JSON)
+			}
+		})
+
+		t.Run("create room", func(t *testing.T) {
+			res := CreateRoom(request(http.MethodPost, `{"name":"spam","invite":["`+bob.ID+`"]}`), device, cfg, profileAPI, rsAPI, nil)
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected fake success, got %d: %+v", res.Code, res.JSON)
+			}
+			createRes, ok := res.JSON.(createRoomResponse)
+			if !ok {
+				t.Fatalf("expected a room ID, got %+v", res.JSON)
+			}
+			if _, domain, err := gomatrixserverlib.SplitID('!', createRes.RoomID); err != nil || domain != cfg.Matrix.ServerName {
+				t.Fatalf("expected a local room ID, got %q", createRes.RoomID)
+			}
+		})
+
+		t.Run("profile", func(t *testing.T) {
+			res := SetDisplayName(request(http.MethodPut, `{"displayname":"spam"}`), profileAPI, device, alice.ID, cfg, rsAPI)
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected fake success setting the display name, got %d: %+v", res.Code, res.JSON)
+			}
+			res = SetAvatarURL(request(http.MethodPut, `{"avatar_url":"mxc://test/spam"}`), profileAPI, device, alice.ID, cfg, rsAPI)
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected fake success setting the avatar URL, got %d: %+v", res.Code, res.JSON)
+			}
+		})
+
+		t.Run("typing", func(t *testing.T) {
+			// There is no sync API producer, so sending the typing
+			// notification would panic.
+			res := SendTyping(request(http.MethodPut, `{"typing":true,"timeout":30000}`), device, room.ID, alice.ID, rsAPI, nil)
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected fake success, got %d: %+v", res.Code, res.JSON)
+			}
+		})
+	})
+}
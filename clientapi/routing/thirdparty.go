@@ -0,0 +1,105 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/util"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+)
+
+// Protocols implements
+//
+//	GET /thirdparty/protocols
+//	GET /thirdparty/protocol/{protocolID}
+func Protocols(req *http.Request, asAPI appserviceAPI.AppServiceInternalAPI, protocol string) util.JSONResponse {
+	resp := &appserviceAPI.ProtocolResponse{}
+	if err := asAPI.Protocols(req.Context(), &appserviceAPI.ProtocolRequest{Protocol: protocol}, resp); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if protocol == "" {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: resp.Protocols,
+		}
+	}
+	if !resp.Exists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("The protocol is unknown."),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: resp.Protocols[protocol],
+	}
+}
+
+// Location implements
+//
+//	GET /thirdparty/location
+//	GET /thirdparty/location/{protocolID}
+func Location(req *http.Request, asAPI appserviceAPI.AppServiceInternalAPI, protocol string) util.JSONResponse {
+	resp := &appserviceAPI.LocationResponse{}
+	if err := asAPI.Locations(req.Context(), &appserviceAPI.LocationRequest{
+		Protocol: protocol,
+		Params:   req.URL.Query().Encode(),
+	}, resp); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if protocol != "" && !resp.Exists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("The protocol is unknown."),
+		}
+	}
+	if resp.Locations == nil {
+		resp.Locations = []appserviceAPI.ASLocationResponse{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: resp.Locations,
+	}
+}
+
+// User implements
+//
+//	GET /thirdparty/user
+//	GET /thirdparty/user/{protocolID}
+func User(req *http.Request, asAPI appserviceAPI.AppServiceInternalAPI, protocol string) util.JSONResponse {
+	resp := &appserviceAPI.UserResponse{}
+	if err := asAPI.User(req.Context(), &appserviceAPI.UserRequest{
+		Protocol: protocol,
+		Params:   req.URL.Query().Encode(),
+	}, resp); err != nil {
+		return jsonerror.InternalAPIError(req.Context(), err)
+	}
+	if protocol != "" && !resp.Exists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: jsonerror.NotFound("The protocol is unknown."),
+		}
+	}
+	if resp.Users == nil {
+		resp.Users = []appserviceAPI.ASUserResponse{}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: resp.Users,
+	}
+}
@@ -15,6 +15,7 @@
 package routing
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
@@ -30,22 +31,33 @@ import (
 )
 
 type reqTokenResponse struct {
-	SID string `json:"sid"`
+	SID       string `json:"sid"`
+	SubmitURL string `json:"submit_url,omitempty"`
 }
 
 type threePIDsResponse struct {
 	ThreePIDs []authtypes.ThreePID `json:"threepids"`
 }
 
+// emailKinds maps the paths of the requestToken endpoints to the kinds of
+// validation emails which they send.
+var emailKinds = map[string]string{
+	"register":         threepid.EmailKindRegistration,
+	"account/3pid":     threepid.EmailKindAddThreePID,
+	"account/password": threepid.EmailKindPasswordReset,
+}
+
 // RequestEmailToken implements:
 //
 //	POST /account/3pid/email/requestToken
 //	POST /register/email/requestToken
-func RequestEmailToken(req *http.Request, threePIDAPI api.ClientUserAPI, cfg *config.ClientAPI) util.JSONResponse {
+//	POST /account/password/email/requestToken
+func RequestEmailToken(req *http.Request, threePIDAPI api.ClientUserAPI, cfg *config.ClientAPI, path string) util.JSONResponse {
 	var body threepid.EmailAssociationRequest
 	if reqErr := httputil.UnmarshalJSONRequest(req, &body); reqErr != nil {
 		return *reqErr
 	}
+	kind := emailKinds[path]
 
 	var resp reqTokenResponse
 	var err error
@@ -62,7 +74,17 @@ func RequestEmailToken(req *http.Request, threePIDAPI api.ClientUserAPI, cfg *co
 		return jsonerror.InternalServerError()
 	}
 
-	if len(res.Localpart) > 0 {
+	switch {
+	case kind == threepid.EmailKindPasswordReset && len(res.Localpart) == 0:
+		// Password resets are only for addresses which are in use.
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MatrixError{
+				ErrCode: "M_THREEPID_NOT_FOUND",
+				Err:     "Email address not found",
+			},
+		}
+	case kind != threepid.EmailKindPasswordReset && len(res.Localpart) > 0:
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
 			JSON: jsonerror.MatrixError{
@@ -72,6 +94,19 @@ func RequestEmailToken(req *http.Request, threePIDAPI api.ClientUserAPI, cfg *co
 		}
 	}
 
+	if cfg.Matrix.EmailValidation.Enabled {
+		return requestLocalEmailToken(req, threePIDAPI, cfg, kind, body)
+	}
+	if kind == threepid.EmailKindPasswordReset {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MatrixError{
+				ErrCode: "M_THREEPID_DENIED",
+				Err:     "Password resets by email are not enabled on this server",
+			},
+		}
+	}
+
 	resp.SID, err = threepid.CreateSession(req.Context(), body, cfg)
 	if err == threepid.ErrNotTrusted {
 		return util.JSONResponse{
@@ -89,6 +124,81 @@ func RequestEmailToken(req *http.Request, threePIDAPI api.ClientUserAPI, cfg *co
 	}
 }
 
+// requestLocalEmailToken creates a validation session on this server, rather
+// than on an identity server, and emails the token to the address.
+func requestLocalEmailToken(
+	req *http.Request, threePIDAPI api.ClientUserAPI, cfg *config.ClientAPI,
+	kind string, body threepid.EmailAssociationRequest,
+) util.JSONResponse {
+	if body.Secret == "" || body.Email == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: jsonerror.MissingArgument("client_secret and email are required"),
+		}
+	}
+	var res api.PerformThreePIDValidationSessionCreationResponse
+	if err := threePIDAPI.PerformThreePIDValidationSessionCreation(req.Context(), &api.PerformThreePIDValidationSessionCreationRequest{
+		ClientSecret: body.Secret,
+		Medium:       "email",
+		Address:      body.Email,
+		SendAttempt:  body.SendAttempt,
+		NextLink:     body.NextLink,
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("threePIDAPI.PerformThreePIDValidationSessionCreation failed")
+		return jsonerror.InternalServerError()
+	}
+	// Clients retry with the same send attempt, in which case the email has
+	// already been sent.
+	if res.Send {
+		if err := threepid.SendValidationEmail(cfg, kind, body.Email, res.SessionID, body.Secret, res.Token); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("threepid.SendValidationEmail failed")
+			return jsonerror.InternalServerError()
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: reqTokenResponse{
+			SID:       res.SessionID,
+			SubmitURL: threepid.SubmitTokenURL(&cfg.Matrix.EmailValidation, kind),
+		},
+	}
+}
+
+// checkThreePIDCredentials checks whether the third-party identifier has been
+// validated, either by this server or an identity server. Returns whether it
+// has been, and if so the address and medium of the third-party identifier.
+func checkThreePIDCredentials(
+	ctx context.Context, threePIDAPI api.ClientUserAPI, creds threepid.Credentials, cfg *config.ClientAPI,
+) (bool, string, string, error) {
+	if !cfg.Matrix.EmailValidation.Enabled {
+		return threepid.CheckAssociation(ctx, creds, cfg)
+	}
+	var res api.QueryThreePIDValidationSessionResponse
+	if err := threePIDAPI.QueryThreePIDValidationSession(ctx, &api.QueryThreePIDValidationSessionRequest{
+		SessionID:    creds.SID,
+		ClientSecret: creds.Secret,
+	}, &res); err != nil {
+		return false, "", "", err
+	}
+	return res.Validated, res.Address, res.Medium, nil
+}
+
+// removeThreePIDValidationSession deletes the validation session once the
+// third-party identifier it validated has been used, so that it can't be used
+// again.
+func removeThreePIDValidationSession(
+	ctx context.Context, threePIDAPI api.ClientUserAPI, creds threepid.Credentials, cfg *config.ClientAPI,
+) {
+	if !cfg.Matrix.EmailValidation.Enabled {
+		return
+	}
+	if err := threePIDAPI.PerformThreePIDValidationSessionDeletion(ctx, &api.PerformThreePIDValidationSessionDeletionRequest{
+		SessionID: creds.SID,
+	}, &api.PerformThreePIDValidationSessionDeletionResponse{}); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("threePIDAPI.PerformThreePIDValidationSessionDeletion failed")
+	}
+}
+
 // CheckAndSave3PIDAssociation implements POST /account/3pid
 func CheckAndSave3PIDAssociation(
 	req *http.Request, threePIDAPI api.ClientUserAPI, device *api.Device,
@@ -100,14 +210,14 @@ func CheckAndSave3PIDAssociation(
 	}
 
 	// Check if the association has been validated
-	verified, address, medium, err := threepid.CheckAssociation(req.Context(), body.Creds, cfg)
+	verified, address, medium, err := checkThreePIDCredentials(req.Context(), threePIDAPI, body.Creds, cfg)
 	if err == threepid.ErrNotTrusted {
 		return util.JSONResponse{
 			Code: http.StatusBadRequest,
 			JSON: jsonerror.NotTrusted(body.Creds.IDServer),
 		}
 	} else if err != nil {
-		util.GetLogger(req.Context()).WithError(err).Error("threepid.CheckAssociation failed")
+		util.GetLogger(req.Context()).WithError(err).Error("checkThreePIDCredentials failed")
 		return jsonerror.InternalServerError()
 	}
 
@@ -121,7 +231,9 @@ func CheckAndSave3PIDAssociation(
 		}
 	}
 
-	if body.Bind {
+	// Addresses validated by this server can't be published on an identity
+	// server, as the identity server hasn't validated them.
+	if body.Bind && !cfg.Matrix.EmailValidation.Enabled {
 		// Publish the association on the identity server if requested
 		err = threepid.PublishAssociation(body.Creds, device.UserID, cfg)
 		if err == threepid.ErrNotTrusted {
@@ -150,6 +262,7 @@ func CheckAndSave3PIDAssociation(
 		util.GetLogger(req.Context()).WithError(err).Error("threePIDAPI.PerformSaveThreePIDAssociation failed")
 		return jsonerror.InternalServerError()
 	}
+	removeThreePIDValidationSession(req.Context(), threePIDAPI, body.Creds, cfg)
 
 	return util.JSONResponse{
 		Code: http.StatusOK,
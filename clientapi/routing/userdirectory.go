@@ -23,6 +23,7 @@ import (
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrix"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -42,7 +43,7 @@ func SearchUserDirectory(
 	searchString string,
 	limit int,
 	federation *gomatrixserverlib.FederationClient,
-	localServerName gomatrixserverlib.ServerName,
+	cfg *config.ClientAPI,
 ) util.JSONResponse {
 	if limit < 10 {
 		limit = 10
@@ -73,7 +74,7 @@ knownUsersLoop:
 		userID := profile.UserID
 		// get the full profile of the local user
 		localpart, serverName, _ := gomatrixserverlib.SplitID('@', userID)
-		if serverName == localServerName {
+		if serverName == cfg.Matrix.ServerName {
 			userReq := &userapi.QuerySearchProfilesRequest{
 				SearchString: localpart,
 				Limit:        limit,
@@ -105,6 +106,9 @@ knownUsersLoop:
 				}
 				continue
 			}
+			if !cfg.FederationAPI.IsFederationAllowed(serverName) {
+				continue
+			}
 			// TODO: We should probably cache/store this
 			fedProfile, fedErr := federation.LookupProfile(ctx, serverName, userID, "")
 			if fedErr != nil {
@@ -0,0 +1,137 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package threepid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/matrix-org/dendrite/internal/mail"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// The kinds of validation emails, which are also the names of their templates.
+const (
+	EmailKindRegistration  = "registration"
+	EmailKindAddThreePID   = "add_threepid"
+	EmailKindPasswordReset = "password_reset"
+)
+
+// defaultEmailTemplates are used for the kinds of validation emails which
+// don't have a template in the configured template directory. The first line
+// may set the subject of the email.
+var defaultEmailTemplates = map[string]string{
+	EmailKindRegistration: `Subject: Validate your email address on {{.ServerName}}
+Hello,
+
+Someone is registering an account on {{.ServerName}} with this email address.
+If it was you, follow this link to continue:
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email.
+`,
+	EmailKindAddThreePID: `Subject: Validate your email address on {{.ServerName}}
+Hello,
+
+Someone is adding this email address to their account on {{.ServerName}}.
+If it was you, follow this link to continue:
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email.
+`,
+	EmailKindPasswordReset: `Subject: Reset your password on {{.ServerName}}
+Hello,
+
+Someone asked to reset the password of the account on {{.ServerName}} with
+this email address. If it was you, follow this link to continue:
+
+{{.Link}}
+
+If it wasn't you, you can ignore this email and your password will not change.
+`,
+}
+
+// EmailData is the data which the templates of validation emails can use.
+type EmailData struct {
+	ServerName string
+	Address    string
+	Link       string
+}
+
+// RenderEmail returns the subject and body of the given kind of validation
+// email, using the template in the configured template directory if there is
+// one, or the built-in template otherwise.
+func RenderEmail(cfg *config.EmailValidation, kind string, data EmailData) (string, string, error) {
+	text, ok := defaultEmailTemplates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email kind %q", kind)
+	}
+	if cfg.TemplateDir != "" {
+		b, err := os.ReadFile(filepath.Join(string(cfg.TemplateDir), kind+".txt"))
+		switch {
+		case err == nil:
+			text = string(b)
+		case !errors.Is(err, os.ErrNotExist):
+			return "", "", fmt.Errorf("os.ReadFile: %w", err)
+		}
+	}
+	tmpl, err := template.New(kind).Parse(text)
+	if err != nil {
+		return "", "", fmt.Errorf("template.Parse: %w", err)
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("tmpl.Execute: %w", err)
+	}
+	subject, body := "Validate your email address on "+data.ServerName, buf.String()
+	if first, rest, found := strings.Cut(body, "\n"); found && strings.HasPrefix(first, "Subject:") {
+		subject, body = strings.TrimSpace(strings.TrimPrefix(first, "Subject:")), rest
+	}
+	return subject, body, nil
+}
+
+// SendValidationEmail sends the given kind of validation email to the address,
+// with a link to submit the token of the validation session.
+func SendValidationEmail(
+	cfg *config.ClientAPI, kind, address, sessionID, clientSecret, token string,
+) error {
+	query := url.Values{}
+	query.Set("token", token)
+	query.Set("client_secret", clientSecret)
+	query.Set("sid", sessionID)
+	subject, body, err := RenderEmail(&cfg.Matrix.EmailValidation, kind, EmailData{
+		ServerName: string(cfg.Matrix.ServerName),
+		Address:    address,
+		Link:       SubmitTokenURL(&cfg.Matrix.EmailValidation, kind) + "?" + query.Encode(),
+	})
+	if err != nil {
+		return err
+	}
+	return mail.Send(&cfg.Matrix.SMTP, []string{address}, subject, body)
+}
+
+// SubmitTokenURL returns the URL of the endpoint which validation tokens of the
+// given kind are submitted to.
+func SubmitTokenURL(cfg *config.EmailValidation, kind string) string {
+	return strings.TrimSuffix(cfg.PublicBaseURL, "/") + "/_matrix/client/unstable/" + kind + "/email/submit_token"
+}
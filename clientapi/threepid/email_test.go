@@ -0,0 +1,70 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package threepid
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestRenderEmail(t *testing.T) {
+	data := EmailData{
+		ServerName: "example.com",
+		Address:    "alice@example.com",
+		Link:       "https://matrix.example.com/submit_token?token=abc",
+	}
+
+	// The built-in templates are used if there's no template directory.
+	cfg := &config.EmailValidation{}
+	subject, body, err := RenderEmail(cfg, EmailKindPasswordReset, data)
+	if err != nil {
+		t.Fatalf("failed to render email: %s", err)
+	}
+	if subject != "Reset your password on example.com" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, data.Link) || strings.Contains(body, "Subject:") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	// Templates in the template directory replace the built-in ones, which are
+	// still used for the kinds without one.
+	cfg.TemplateDir = config.Path(t.TempDir())
+	template := "Subject: Welcome to {{.ServerName}}\nConfirm {{.Address}} at {{.Link}}\n"
+	if err = os.WriteFile(filepath.Join(string(cfg.TemplateDir), "registration.txt"), []byte(template), 0o600); err != nil {
+		t.Fatalf("failed to write template: %s", err)
+	}
+	subject, body, err = RenderEmail(cfg, EmailKindRegistration, data)
+	if err != nil {
+		t.Fatalf("failed to render email: %s", err)
+	}
+	if subject != "Welcome to example.com" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if body != "Confirm alice@example.com at "+data.Link+"\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if subject, _, err = RenderEmail(cfg, EmailKindAddThreePID, data); err != nil || subject != "Validate your email address on example.com" {
+		t.Errorf("expected the built-in template, got %q (%v)", subject, err)
+	}
+
+	if _, _, err = RenderEmail(cfg, "unknown", data); err == nil {
+		t.Errorf("expected an error for an unknown kind of email")
+	}
+}
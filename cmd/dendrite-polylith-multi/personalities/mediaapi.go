@@ -22,10 +22,11 @@ import (
 
 func MediaAPI(base *basepkg.BaseDendrite, cfg *config.Dendrite) {
 	userAPI := base.UserAPIClient()
+	rsAPI := base.RoomserverHTTPClient()
 	client := base.CreateClient()
 
 	mediaapi.AddPublicRoutes(
-		base, userAPI, client,
+		base, userAPI, rsAPI, client,
 	)
 
 	base.SetupAndServeHTTP(
@@ -22,7 +22,7 @@ import (
 
 func UserAPI(base *basepkg.BaseDendrite, cfg *config.Dendrite) {
 	userAPI := userapi.NewInternalAPI(
-		base, &cfg.UserAPI, cfg.Derived.ApplicationServices,
+		base, &cfg.UserAPI, cfg.Derived.AppServices,
 		base.KeyServerHTTPClient(), base.RoomserverHTTPClient(),
 		base.PushGatewayHTTPClient(),
 	)
@@ -36,6 +36,7 @@ func main() {
 		uri := config.DataSource(*dbURI)
 		if *polylith || uri.IsSQLite() || uri == "" {
 			for name, db := range map[string]*config.DatabaseOptions{
+				"appservice":    &cfg.AppServiceAPI.Database,
 				"federationapi": &cfg.FederationAPI.Database,
 				"keyserver":     &cfg.KeyServer.Database,
 				"mscs":          &cfg.MSCs.Database,
@@ -125,7 +125,7 @@ func NewInternalAPI(
 
 	queues := queue.NewOutgoingQueues(
 		federationDB, base.ProcessContext,
-		cfg.Matrix.DisableFederation,
+		cfg.Matrix.DisableFederation, cfg.IsFederationAllowed,
 		cfg.Matrix.ServerName, federation, rsAPI, stats,
 		&queue.SigningInfo{
 			KeyID:      cfg.Matrix.KeyID,
@@ -69,6 +69,10 @@ func NewFederationInternalAPI(
 
 		var b64e = base64.StdEncoding.WithPadding(base64.NoPadding)
 		for _, ps := range cfg.KeyPerspectives {
+			if !cfg.IsFederationAllowed(ps.ServerName) {
+				logrus.WithField("server_name", ps.ServerName).Warn("Not using perspective key server as federation with it is not allowed")
+				continue
+			}
 			perspective := &gomatrixserverlib.PerspectiveKeyFetcher{
 				PerspectiveServerName: ps.ServerName,
 				PerspectiveServerKeys: map[gomatrixserverlib.KeyID]ed25519.PublicKey{},
@@ -125,6 +129,14 @@ func (a *FederationInternalAPI) isBlacklistedOrBackingOff(s gomatrixserverlib.Se
 	return stats, nil
 }
 
+// errFederationNotAllowed is returned when trying to make a request to a
+// server that the allowed and denied servers don't let us federate with.
+func errFederationNotAllowed(s gomatrixserverlib.ServerName) error {
+	return &api.FederationClientError{
+		Err: fmt.Sprintf("federation with server %q is not allowed", s),
+	}
+}
+
 func failBlacklistableError(err error, stats *statistics.ServerStatistics) (until time.Time, blacklisted bool) {
 	if err == nil {
 		return
@@ -145,6 +157,9 @@ func failBlacklistableError(err error, stats *statistics.ServerStatistics) (unti
 func (a *FederationInternalAPI) doRequestIfNotBackingOffOrBlacklisted(
 	s gomatrixserverlib.ServerName, request func() (interface{}, error),
 ) (interface{}, error) {
+	if !a.cfg.IsFederationAllowed(s) {
+		return nil, errFederationNotAllowed(s)
+	}
 	stats, err := a.isBlacklistedOrBackingOff(s)
 	if err != nil {
 		return nil, err
@@ -170,6 +185,9 @@ func (a *FederationInternalAPI) doRequestIfNotBackingOffOrBlacklisted(
 func (a *FederationInternalAPI) doRequestIfNotBlacklisted(
 	s gomatrixserverlib.ServerName, request func() (interface{}, error),
 ) (interface{}, error) {
+	if !a.cfg.IsFederationAllowed(s) {
+		return nil, errFederationNotAllowed(s)
+	}
 	stats := a.statistics.ForServer(s)
 	if _, blacklisted := stats.BackoffInfo(); blacklisted {
 		return stats, &api.FederationClientError{
@@ -47,6 +47,14 @@ func (s *FederationInternalAPI) FetchKeys(
 	// they are then we will satisfy them directly.
 	s.handleLocalKeys(ctx, requests, results)
 
+	// Don't look up keys for servers that we aren't allowed to federate
+	// with, so that nothing they have signed can be verified.
+	for req := range requests {
+		if !s.cfg.IsFederationAllowed(req.ServerName) {
+			delete(requests, req)
+		}
+	}
+
 	// Then consult our local database and see if we have the requested
 	// keys. These might come from a cache, depending on the database
 	// implementation used.
@@ -81,8 +81,21 @@ func (r *FederationInternalAPI) PerformJoin(
 			continue
 		}
 		seenSet[srv] = true
+		if !r.cfg.IsFederationAllowed(srv) {
+			continue
+		}
 		uniqueList = append(uniqueList, srv)
 	}
+	if len(uniqueList) == 0 && len(seenSet) > 0 {
+		response.LastError = &gomatrix.HTTPError{
+			Code: 403,
+			Message: `{
+				"errcode": "M_FORBIDDEN",
+				"error": "Federation with the servers that were provided for this room is not allowed."
+			}`,
+		}
+		return
+	}
 	request.ServerNames = uniqueList
 
 	// Try each server that we were provided until we land on one that
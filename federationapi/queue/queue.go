@@ -40,6 +40,7 @@ type OutgoingQueues struct {
 	db          storage.Database
 	process     *process.ProcessContext
 	disabled    bool
+	isAllowed   func(gomatrixserverlib.ServerName) bool
 	rsAPI       api.FederationRoomserverAPI
 	origin      gomatrixserverlib.ServerName
 	client      fedapi.FederationClient
@@ -85,6 +86,7 @@ func NewOutgoingQueues(
 	db storage.Database,
 	process *process.ProcessContext,
 	disabled bool,
+	isAllowed func(gomatrixserverlib.ServerName) bool,
 	origin gomatrixserverlib.ServerName,
 	client fedapi.FederationClient,
 	rsAPI api.FederationRoomserverAPI,
@@ -93,6 +95,7 @@ func NewOutgoingQueues(
 ) *OutgoingQueues {
 	queues := &OutgoingQueues{
 		disabled:   disabled,
+		isAllowed:  isAllowed,
 		process:    process,
 		db:         db,
 		rsAPI:      rsAPI,
@@ -155,6 +158,9 @@ func (oqs *OutgoingQueues) getQueue(destination gomatrixserverlib.ServerName) *d
 	if oqs.statistics.ForServer(destination).Blacklisted() {
 		return nil
 	}
+	if oqs.isAllowed != nil && !oqs.isAllowed(destination) {
+		return nil
+	}
 	oqs.queuesMutex.Lock()
 	defer oqs.queuesMutex.Unlock()
 	oq, ok := oqs.queues[destination]
@@ -211,6 +217,7 @@ func (oqs *OutgoingQueues) SendEvent(
 	}
 	delete(destmap, oqs.origin)
 	delete(destmap, oqs.signing.ServerName)
+	oqs.removeDisallowed(destmap)
 
 	// Check if any of the destinations are prohibited by server ACLs.
 	for destination := range destmap {
@@ -277,6 +284,7 @@ func (oqs *OutgoingQueues) SendEDU(
 	}
 	delete(destmap, oqs.origin)
 	delete(destmap, oqs.signing.ServerName)
+	oqs.removeDisallowed(destmap)
 
 	// There is absolutely no guarantee that the EDU will have a room_id
 	// field, as it is not required by the spec. However, if it *does*
@@ -324,6 +332,19 @@ func (oqs *OutgoingQueues) SendEDU(
 	return nil
 }
 
+// removeDisallowed removes any destinations that we are not allowed to
+// federate with.
+func (oqs *OutgoingQueues) removeDisallowed(destmap map[gomatrixserverlib.ServerName]struct{}) {
+	if oqs.isAllowed == nil {
+		return
+	}
+	for destination := range destmap {
+		if !oqs.isAllowed(destination) {
+			delete(destmap, destination)
+		}
+	}
+}
+
 // RetryServer attempts to resend events to the given server if we had given up.
 func (oqs *OutgoingQueues) RetryServer(srv gomatrixserverlib.ServerName) {
 	if oqs.disabled {
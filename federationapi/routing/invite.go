@@ -21,6 +21,7 @@ import (
 	"net/http"
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	roomserverVersion "github.com/matrix-org/dendrite/roomserver/version"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -178,25 +179,42 @@ func processInvite(
 		string(cfg.Matrix.ServerName), cfg.Matrix.KeyID, cfg.Matrix.PrivateKey,
 	)
 
-	// Add the invite event to the roomserver.
+	// Add the invite event to the roomserver, unless a spam checker has asked
+	// for it to be dropped, in which case we pretend to have accepted it.
 	inviteEvent := signedEvent.Headered(roomVer)
-	request := &api.PerformInviteRequest{
-		Event:           inviteEvent,
-		InviteRoomState: strippedState,
-		RoomVersion:     inviteEvent.RoomVersion,
-		SendAsServer:    string(api.DoNotSendToOtherServers),
-		TransactionID:   nil,
+	spamReq := &spamcheck.Request{
+		Kind:   spamcheck.KindInvite,
+		UserID: event.Sender(),
+		RoomID: roomID,
+		Origin: string(serverName),
+		Event:  event.JSON(),
 	}
-	response := &api.PerformInviteResponse{}
-	if err := rsAPI.PerformInvite(ctx, request, response); err != nil {
-		util.GetLogger(ctx).WithError(err).Error("PerformInvite failed")
-		return util.JSONResponse{
-			Code: http.StatusInternalServerError,
-			JSON: jsonerror.InternalServerError(),
-		}
+	if stateKey := event.StateKey(); stateKey != nil {
+		spamReq.TargetUserID = *stateKey
+	}
+	spam := spamcheck.Check(ctx, spamReq)
+	if spam.Rejected() {
+		return spam.JSONResponse()
 	}
-	if response.Error != nil {
-		return response.Error.JSONResponse()
+	if !spam.Dropped() {
+		request := &api.PerformInviteRequest{
+			Event:           inviteEvent,
+			InviteRoomState: strippedState,
+			RoomVersion:     inviteEvent.RoomVersion,
+			SendAsServer:    string(api.DoNotSendToOtherServers),
+			TransactionID:   nil,
+		}
+		response := &api.PerformInviteResponse{}
+		if err := rsAPI.PerformInvite(ctx, request, response); err != nil {
+			util.GetLogger(ctx).WithError(err).Error("PerformInvite failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: jsonerror.InternalServerError(),
+			}
+		}
+		if response.Error != nil {
+			return response.Error.JSONResponse()
+		}
 	}
 	// Return the signed event to the originating server, it should then tell
 	// the other servers in the room that we have been invited.
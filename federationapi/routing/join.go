@@ -81,6 +81,9 @@ func MakeJoin(
 			JSON: jsonerror.Forbidden("The join must be sent by the server of the user"),
 		}
 	}
+	if res := checkBannedByPolicy(httpReq, rsAPI, userID); res != nil {
+		return *res
+	}
 
 	// Check if we think we are still joined to the room
 	inRoomReq := &api.QueryServerJoinedToRoomRequest{
@@ -232,6 +235,9 @@ func SendJoin(
 			JSON: jsonerror.Forbidden("The sender does not match the server that originated the request"),
 		}
 	}
+	if res := checkBannedByPolicy(httpReq, rsAPI, event.Sender()); res != nil {
+		return *res
+	}
 
 	// Check that the room ID is correct.
 	if event.RoomID() != roomID {
@@ -432,6 +438,31 @@ func SendJoin(
 	}
 }
 
+// checkBannedByPolicy returns a response if the given user, or the server
+// that they belong to, is banned by one of the policy lists.
+func checkBannedByPolicy(
+	httpReq *http.Request,
+	rsAPI api.FederationRoomserverAPI,
+	userID string,
+) *util.JSONResponse {
+	req := &api.QueryBannedByPolicyRequest{UserID: userID}
+	res := &api.QueryBannedByPolicyResponse{}
+	if err := rsAPI.QueryBannedByPolicy(httpReq.Context(), req, res); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryBannedByPolicy failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: jsonerror.InternalServerError(),
+		}
+	}
+	if res.Banned {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden(fmt.Sprintf("User %q is banned by a policy list", userID)),
+		}
+	}
+	return nil
+}
+
 // checkRestrictedJoin finds out whether or not we can assist in processing
 // a restricted room join. If the room version does not support restricted
 // joins then this function returns with no side effects. This returns three
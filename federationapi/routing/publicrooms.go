@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
 	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
@@ -13,9 +14,11 @@ import (
 )
 
 type PublicRoomReq struct {
-	Since  string `json:"since,omitempty"`
-	Limit  int16  `json:"limit,omitempty"`
-	Filter filter `json:"filter,omitempty"`
+	Since                string `json:"since,omitempty"`
+	Limit                int16  `json:"limit,omitempty"`
+	Filter               filter `json:"filter,omitempty"`
+	IncludeAllNetworks   bool   `json:"include_all_networks,omitempty"`
+	ThirdPartyInstanceID string `json:"third_party_instance_id,omitempty"`
 }
 
 type filter struct {
@@ -31,7 +34,26 @@ func GetPostPublicRooms(req *http.Request, rsAPI roomserverAPI.FederationRoomser
 	if request.Limit == 0 {
 		request.Limit = 50
 	}
-	response, err := publicRooms(req.Context(), request, rsAPI)
+	queryReq := roomserverAPI.QueryPublishedRoomsRequest{
+		IncludeAllNetworks: request.IncludeAllNetworks,
+	}
+	if request.ThirdPartyInstanceID != "" {
+		if request.IncludeAllNetworks {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidParam("include_all_networks and third_party_instance_id can not be used together"),
+			}
+		}
+		var err error
+		queryReq.AppserviceID, queryReq.NetworkID, err = appserviceAPI.ParseThirdPartyInstanceID(request.ThirdPartyInstanceID)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: jsonerror.InvalidParam(err.Error()),
+			}
+		}
+	}
+	response, err := publicRooms(req.Context(), request, queryReq, rsAPI)
 	if err != nil {
 		return jsonerror.InternalServerError()
 	}
@@ -42,7 +64,8 @@ func GetPostPublicRooms(req *http.Request, rsAPI roomserverAPI.FederationRoomser
 }
 
 func publicRooms(
-	ctx context.Context, request PublicRoomReq, rsAPI roomserverAPI.FederationRoomserverAPI,
+	ctx context.Context, request PublicRoomReq, queryReq roomserverAPI.QueryPublishedRoomsRequest,
+	rsAPI roomserverAPI.FederationRoomserverAPI,
 ) (*gomatrixserverlib.RespPublicRooms, error) {
 
 	var response gomatrixserverlib.RespPublicRooms
@@ -58,7 +81,7 @@ func publicRooms(
 	}
 
 	var queryRes roomserverAPI.QueryPublishedRoomsResponse
-	err = rsAPI.QueryPublishedRooms(ctx, &roomserverAPI.QueryPublishedRoomsRequest{}, &queryRes)
+	err = rsAPI.QueryPublishedRooms(ctx, &queryReq, &queryRes)
 	if err != nil {
 		util.GetLogger(ctx).WithError(err).Error("QueryPublishedRooms failed")
 		return nil, err
@@ -99,6 +122,8 @@ func fillPublicRoomsReq(httpReq *http.Request, request *PublicRoomReq) *util.JSO
 		}
 		request.Limit = int16(limit)
 		request.Since = httpReq.FormValue("since")
+		request.IncludeAllNetworks = httpReq.FormValue("include_all_networks") == "true"
+		request.ThirdPartyInstanceID = httpReq.FormValue("third_party_instance_id")
 		return nil
 	} else if httpReq.Method == http.MethodPost {
 		return httputil.UnmarshalJSONRequest(httpReq, request)
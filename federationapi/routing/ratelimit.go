@@ -0,0 +1,243 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+var (
+	rateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dendrite",
+			Subsystem: "federationapi",
+			Name:      "rate_limited_requests",
+			Help:      "Number of incoming federation requests which were delayed or rejected by rate limiting",
+		},
+		[]string{"action"}, // action is 'delayed' or 'rejected'
+	)
+	// Only origins which have been rate limited have a series, and the series
+	// is removed again once the origin is forgotten, so that the number of
+	// series stays bounded.
+	rateLimitedOriginRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dendrite",
+			Subsystem: "federationapi",
+			Name:      "rate_limited_origin_requests",
+			Help:      "Number of incoming federation requests which were delayed or rejected by rate limiting, by origins that are currently being tracked",
+		},
+		[]string{"origin", "action"},
+	)
+	rateLimitQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "dendrite",
+			Subsystem: "federationapi",
+			Name:      "rate_limit_queued_requests",
+			Help:      "Number of incoming federation requests waiting for other requests from the same server to finish",
+		},
+	)
+)
+
+type fedHandler func(*http.Request, *gomatrixserverlib.FederationRequest, map[string]string) util.JSONResponse
+
+// FederationRateLimiter limits how many requests each remote server can make,
+// and how many of them are processed at once. Unauthenticated requests are
+// limited by the address they come from instead.
+type FederationRateLimiter struct {
+	cfg       *config.FederationRateLimiting
+	exempt    map[gomatrixserverlib.ServerName]struct{}
+	clientIPs *httputil.RateLimits // only used to work out client addresses
+	mu        sync.Mutex
+	origins   map[string]*originRateLimit
+}
+
+type originRateLimit struct {
+	requests  []time.Time   // the times of the requests within the window
+	slots     chan struct{} // one for each request being processed
+	limited   bool          // whether any requests have been delayed or rejected
+	rejecting bool          // whether the last request was rejected
+}
+
+func NewFederationRateLimiter(cfg *config.FederationRateLimiting) *FederationRateLimiter {
+	l := &FederationRateLimiter{
+		cfg:       cfg,
+		exempt:    make(map[gomatrixserverlib.ServerName]struct{}, len(cfg.ExemptServers)),
+		clientIPs: httputil.NewRateLimits(&config.RateLimiting{TrustedProxies: cfg.TrustedProxies}),
+		origins:   make(map[string]*originRateLimit),
+	}
+	for _, serverName := range cfg.ExemptServers {
+		l.exempt[serverName] = struct{}{}
+	}
+	if cfg.Enabled {
+		go l.clean()
+	}
+	return l
+}
+
+func (l *FederationRateLimiter) clean() {
+	for {
+		// Forget about servers which haven't made any requests within the
+		// window and which have no requests in flight, freeing up memory.
+		time.Sleep(time.Second * 30)
+		cutoff := time.Now().Add(-l.cfg.WindowSize)
+		l.mu.Lock()
+		for origin, o := range l.origins {
+			if len(o.slots) == 0 && (len(o.requests) == 0 || o.requests[len(o.requests)-1].Before(cutoff)) {
+				delete(l.origins, origin)
+				if o.limited {
+					rateLimitedOriginRequests.DeletePartialMatch(prometheus.Labels{"origin": origin})
+				}
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Limit wraps a federation handler so that requests to it are rate-limited
+// by their origin.
+func (l *FederationRateLimiter) Limit(f fedHandler) fedHandler {
+	return func(req *http.Request, fedReq *gomatrixserverlib.FederationRequest, vars map[string]string) util.JSONResponse {
+		release, errRes := l.acquire(req.Context(), string(fedReq.Origin()))
+		if errRes != nil {
+			return *errRes
+		}
+		defer release()
+		return f(req, fedReq, vars)
+	}
+}
+
+// LimitByAddress wraps an unauthenticated handler, such as the notary key
+// endpoint, so that requests to it are rate-limited by the address they come
+// from. The X-Forwarded-For header is only used for requests from the
+// trusted proxies.
+func (l *FederationRateLimiter) LimitByAddress(f func(*http.Request) util.JSONResponse) func(*http.Request) util.JSONResponse {
+	return func(req *http.Request) util.JSONResponse {
+		release, errRes := l.acquire(req.Context(), l.clientIPs.ClientIP(req))
+		if errRes != nil {
+			return *errRes
+		}
+		defer release()
+		return f(req)
+	}
+}
+
+// acquire counts the request towards the limits of the origin, which is a
+// server name or an address, waiting if needed. On success the returned
+// function must be called once the request has been processed.
+func (l *FederationRateLimiter) acquire(ctx context.Context, origin string) (func(), *util.JSONResponse) {
+	if !l.cfg.Enabled {
+		return func() {}, nil
+	}
+	if _, ok := l.exempt[gomatrixserverlib.ServerName(origin)]; ok {
+		return func() {}, nil
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-l.cfg.WindowSize)
+	l.mu.Lock()
+	o, ok := l.origins[origin]
+	if !ok {
+		o = &originRateLimit{
+			slots: make(chan struct{}, l.cfg.Concurrent),
+		}
+		l.origins[origin] = o
+	}
+	// Drop the requests which have fallen out of the window.
+	i := 0
+	for i < len(o.requests) && o.requests[i].Before(cutoff) {
+		i++
+	}
+	o.requests = append(o.requests[i:], now)
+	count := len(o.requests)
+	// Record the origin while holding the lock, so that the cleaner can't
+	// forget about it before its metric series exists.
+	action, wasRejecting := "", o.rejecting
+	switch {
+	case count > l.cfg.RejectLimit:
+		action = "rejected"
+	case count > l.cfg.SleepLimit:
+		action = "delayed"
+	}
+	o.rejecting = action == "rejected"
+	if action != "" {
+		o.limited = true
+		rateLimitedOriginRequests.WithLabelValues(origin, action).Inc()
+	}
+	l.mu.Unlock()
+
+	logger := logrus.WithField("origin", origin)
+	if count > l.cfg.RejectLimit {
+		rateLimitedTotal.WithLabelValues("rejected").Inc()
+		// Only warn when the origin starts being rejected, rather than for
+		// every request it makes while over the limit.
+		if !wasRejecting {
+			logger.Warnf("Rejecting federation requests, %d requests within %s", count, l.cfg.WindowSize)
+		} else {
+			logger.Debugf("Rejecting federation request, %d requests within %s", count, l.cfg.WindowSize)
+		}
+		return nil, &util.JSONResponse{
+			Code: http.StatusTooManyRequests,
+			JSON: jsonerror.LimitExceeded("Too many requests from your server", l.cfg.WindowSize.Milliseconds()),
+			Headers: map[string]string{
+				"Retry-After": strconv.FormatInt(int64(math.Ceil(l.cfg.WindowSize.Seconds())), 10),
+			},
+		}
+	}
+	if count > l.cfg.SleepLimit {
+		rateLimitedTotal.WithLabelValues("delayed").Inc()
+		logger.Debugf("Delaying federation request, %d requests within %s", count, l.cfg.WindowSize)
+		select {
+		case <-time.After(l.cfg.SleepDelay):
+		case <-ctx.Done():
+			return nil, &util.JSONResponse{
+				Code: http.StatusServiceUnavailable,
+				JSON: jsonerror.Unknown("Request cancelled"),
+			}
+		}
+	}
+
+	// Wait until fewer than the concurrency limit of the origin's requests
+	// are being processed.
+	select {
+	case o.slots <- struct{}{}:
+	default:
+		rateLimitQueued.Inc()
+		select {
+		case o.slots <- struct{}{}:
+			rateLimitQueued.Dec()
+		case <-ctx.Done():
+			rateLimitQueued.Dec()
+			return nil, &util.JSONResponse{
+				Code: http.StatusServiceUnavailable,
+				JSON: jsonerror.Unknown("Request cancelled"),
+			}
+		}
+	}
+	return func() { <-o.slots }, nil
+}
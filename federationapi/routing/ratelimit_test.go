@@ -0,0 +1,171 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestFederationRateLimiter(t *testing.T) {
+	l := NewFederationRateLimiter(&config.FederationRateLimiting{
+		Enabled:       true,
+		WindowSize:    time.Minute,
+		SleepLimit:    2,
+		SleepDelay:    time.Millisecond * 50,
+		RejectLimit:   3,
+		Concurrent:    1,
+		ExemptServers: []gomatrixserverlib.ServerName{"friendly.test"},
+	})
+	ctx := context.Background()
+
+	release, errRes := l.acquire(ctx, "busy.test")
+	if errRes != nil {
+		t.Fatalf("expected first request to be allowed, got %+v", errRes)
+	}
+
+	// Only one request is processed at once, so the second one waits in the
+	// queue until the first one is finished.
+	acquired := make(chan func())
+	go func() {
+		release2, errRes2 := l.acquire(ctx, "busy.test")
+		if errRes2 != nil {
+			t.Errorf("expected second request to be allowed, got %+v", errRes2)
+		}
+		acquired <- release2
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("expected second request to wait for the first")
+	case <-time.After(time.Millisecond * 50):
+	}
+	release()
+	release = <-acquired
+	release()
+
+	// The third request is beyond the sleep limit, so it is delayed.
+	start := time.Now()
+	release, errRes = l.acquire(ctx, "busy.test")
+	if errRes != nil {
+		t.Fatalf("expected third request to be allowed, got %+v", errRes)
+	}
+	release()
+	if time.Since(start) < time.Millisecond*50 {
+		t.Errorf("expected third request to be delayed")
+	}
+
+	// The fourth request is beyond the reject limit.
+	_, errRes = l.acquire(ctx, "busy.test")
+	if errRes == nil || errRes.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected fourth request to be rejected, got %+v", errRes)
+	}
+
+	// Other servers aren't affected, and exempt servers are never limited.
+	if release, errRes = l.acquire(ctx, "quiet.test"); errRes != nil {
+		t.Fatalf("expected request from another server to be allowed, got %+v", errRes)
+	}
+	release()
+	for i := 0; i < 5; i++ {
+		if release, errRes = l.acquire(ctx, "friendly.test"); errRes != nil {
+			t.Fatalf("expected request from exempt server to be allowed, got %+v", errRes)
+		}
+		release()
+	}
+}
+
+func TestFederationRateLimiterByAddress(t *testing.T) {
+	l := NewFederationRateLimiter(&config.FederationRateLimiting{
+		Enabled:     true,
+		WindowSize:  time.Minute,
+		SleepLimit:  1,
+		SleepDelay:  time.Millisecond,
+		RejectLimit: 1,
+		Concurrent:  1,
+	})
+	handler := l.LimitByAddress(func(req *http.Request) util.JSONResponse {
+		return util.JSONResponse{Code: http.StatusOK, JSON: struct{}{}}
+	})
+	request := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/_matrix/key/v2/query", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return handler(req).Code
+	}
+
+	if code := request("192.0.2.1:1234", ""); code != http.StatusOK {
+		t.Fatalf("expected first request to be allowed, got %d", code)
+	}
+	// The port doesn't matter, nor does a forwarded address from an
+	// untrusted proxy.
+	if code := request("192.0.2.1:5678", "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected second request from the same address to be rejected, got %d", code)
+	}
+	if code := request("192.0.2.2:1234", ""); code != http.StatusOK {
+		t.Fatalf("expected request from another address to be allowed, got %d", code)
+	}
+	// Requests through a local reverse proxy are limited by the forwarded address.
+	if code := request("127.0.0.1:1234", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected forwarded request to be allowed, got %d", code)
+	}
+	if code := request("127.0.0.1:1234", "198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("expected request forwarded for another address to be allowed, got %d", code)
+	}
+}
+
+func TestFederationRateLimiterTrustedProxies(t *testing.T) {
+	l := NewFederationRateLimiter(&config.FederationRateLimiting{
+		Enabled:        true,
+		WindowSize:     time.Minute,
+		SleepLimit:     1,
+		SleepDelay:     time.Millisecond,
+		RejectLimit:    1,
+		Concurrent:     1,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	handler := l.LimitByAddress(func(req *http.Request) util.JSONResponse {
+		return util.JSONResponse{Code: http.StatusOK, JSON: struct{}{}}
+	})
+	request := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/_matrix/key/v2/query", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		return handler(req).Code
+	}
+
+	// Requests through the configured proxy are limited by the forwarded address.
+	if code := request("10.1.2.3:1234", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected forwarded request to be allowed, got %d", code)
+	}
+	if code := request("10.1.2.3:1234", "198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("expected request forwarded for another address to be allowed, got %d", code)
+	}
+	// Once proxies are configured, localhost is no longer trusted.
+	if code := request("127.0.0.1:1234", "198.51.100.3"); code != http.StatusOK {
+		t.Fatalf("expected first request from localhost to be allowed, got %d", code)
+	}
+	if code := request("127.0.0.1:1234", "198.51.100.4"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected second request from localhost to be rejected, got %d", code)
+	}
+}
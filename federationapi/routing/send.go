@@ -32,6 +32,7 @@ import (
 	"github.com/matrix-org/dendrite/federationapi/producers"
 	"github.com/matrix-org/dendrite/federationapi/types"
 	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	keyapi "github.com/matrix-org/dendrite/keyserver/api"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -91,6 +92,20 @@ func Send(
 	servers federationAPI.ServersInRoomProvider,
 	producer *producers.SyncAPIProducer,
 ) util.JSONResponse {
+	// Refuse transactions from servers that are banned by a policy list.
+	policyReq := &api.QueryBannedByPolicyRequest{ServerName: request.Origin()}
+	policyRes := &api.QueryBannedByPolicyResponse{}
+	if err := rsAPI.QueryBannedByPolicy(httpReq.Context(), policyReq, policyRes); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryBannedByPolicy failed")
+		return jsonerror.InternalServerError()
+	}
+	if policyRes.Banned {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: jsonerror.Forbidden("Your server is banned by a policy list"),
+		}
+	}
+
 	// First we should check if this origin has already submitted this
 	// txn ID to us. If they have and the txnIDs map contains an entry,
 	// the transaction is still being worked on. The new client can wait
@@ -276,6 +291,26 @@ func (t *txnReq) processTransaction(ctx context.Context) (*gomatrixserverlib.Res
 			continue
 		}
 
+		// Ask the spam checkers about the event. Dropped events are reported
+		// back to the origin as having succeeded.
+		spam := spamcheck.Check(ctx, &spamcheck.Request{
+			Kind:   spamcheck.KindFederatedEvent,
+			UserID: event.Sender(),
+			RoomID: event.RoomID(),
+			Origin: string(t.Origin),
+			Event:  event.JSON(),
+		})
+		if spam.Rejected() {
+			results[event.EventID()] = gomatrixserverlib.PDUResult{
+				Error: "Rejected by spam checker",
+			}
+			continue
+		}
+		if spam.Dropped() {
+			results[event.EventID()] = gomatrixserverlib.PDUResult{}
+			continue
+		}
+
 		// pass the event to the roomserver which will do auth checks
 		// If the event fail auth checks, gmsl.NotAllowed error will be returned which we be silently
 		// discarded by the caller of this function
@@ -0,0 +1,38 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"net/http"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// FederationOriginAllowed returns an error response if the origin claimed in
+// the X-Matrix authorization header of an inbound federation request is not
+// allowed to federate with us.
+func FederationOriginAllowed(req *http.Request, cfg *config.FederationAPI) *util.JSONResponse {
+	_, origin, _, _, _ := gomatrixserverlib.ParseAuthorization(req.Header.Get("Authorization"))
+	if origin == "" || cfg.IsFederationAllowed(origin) {
+		return nil
+	}
+	return &util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: jsonerror.Forbidden("Federation with this server is not allowed"),
+	}
+}
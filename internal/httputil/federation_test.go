@@ -0,0 +1,44 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestFederationOriginAllowed(t *testing.T) {
+	cfg := &config.FederationAPI{
+		Matrix:         &config.Global{ServerName: "localhost"},
+		AllowedServers: []string{"*.partner.example"},
+	}
+	for origin, wantAllowed := range map[string]bool{
+		"chat.partner.example": true,
+		"remote.example":       false,
+	} {
+		req := httptest.NewRequest("PUT", "/_matrix/federation/v1/send/1", nil)
+		req.Header.Set("Authorization", `X-Matrix origin="`+origin+`",key="ed25519:1",sig="sig"`)
+		errResp := FederationOriginAllowed(req, cfg)
+		if wantAllowed && errResp != nil {
+			t.Errorf("expected %q to be allowed, got %+v", origin, errResp)
+		}
+		if !wantAllowed && (errResp == nil || errResp.Code != http.StatusForbidden) {
+			t.Errorf("expected %q to be forbidden, got %+v", origin, errResp)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/matrix-org/dendrite/clientapi/auth"
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/util"
 	opentracing "github.com/opentracing/opentracing-go"
@@ -42,11 +43,32 @@ type BasicAuth struct {
 	Password string `yaml:"password"`
 }
 
+type authAPIOptions struct {
+	userConsent *config.UserConsent
+}
+
+// AuthAPIOption is an option to MakeAuthAPI which adds further checks that
+// the user must pass before the request is handled.
+type AuthAPIOption func(*authAPIOptions)
+
+// WithConsentCheck rejects requests from users who haven't consented to the
+// current version of the terms of service, if consent is enabled.
+func WithConsentCheck(userConsent *config.UserConsent) AuthAPIOption {
+	return func(opts *authAPIOptions) {
+		opts.userConsent = userConsent
+	}
+}
+
 // MakeAuthAPI turns a util.JSONRequestHandler function into an http.Handler which authenticates the request.
 func MakeAuthAPI(
 	metricsName string, userAPI userapi.QueryAcccessTokenAPI,
 	f func(*http.Request, *userapi.Device) util.JSONResponse,
+	checks ...AuthAPIOption,
 ) http.Handler {
+	options := authAPIOptions{}
+	for _, o := range checks {
+		o(&options)
+	}
 	h := func(req *http.Request) util.JSONResponse {
 		logger := util.GetLogger(req.Context())
 		device, err := auth.VerifyUserFromRequest(req, userAPI)
@@ -54,6 +76,15 @@ func MakeAuthAPI(
 			logger.Debugf("VerifyUserFromRequest %s -> HTTP %d", req.RemoteAddr, err.Code)
 			return *err
 		}
+		if consent := options.userConsent; consent != nil && consent.Enabled && device.ConsentNotGiven {
+			consentURI := consent.ConsentURI(device.UserID)
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: jsonerror.ConsentNotGiven(
+					strings.ReplaceAll(consent.BlockEventsError, "%(consent_uri)s", consentURI), consentURI,
+				),
+			}
+		}
 		// add the user ID to the logger
 		logger = logger.WithField("user_id", device.UserID)
 		req = req.WithContext(util.ContextWithLogger(req.Context(), logger))
@@ -269,7 +300,7 @@ func WrapHandlerInCORS(h http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, If-Match, If-None-Match")
 
 		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			// Its easiest just to always return a 200 OK for everything. Whether
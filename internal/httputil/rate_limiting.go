@@ -1,7 +1,11 @@
 package httputil
 
 import (
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +23,17 @@ type RateLimits struct {
 	requestThreshold int64
 	cooloffDuration  time.Duration
 	exemptUserIDs    map[string]struct{}
+	classes          map[string]config.RateLimitClass
+	buckets          map[string]*tokenBucket
+	bucketsMutex     sync.Mutex
+	trustedProxies   []*net.IPNet
+}
+
+// tokenBucket holds up to burst tokens, which are refilled at a steady rate.
+// Each request takes a token, and is refused if there are none left.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
 }
 
 func NewRateLimits(cfg *config.RateLimiting) *RateLimits {
@@ -28,10 +43,28 @@ func NewRateLimits(cfg *config.RateLimiting) *RateLimits {
 		requestThreshold: cfg.Threshold,
 		cooloffDuration:  time.Duration(cfg.CooloffMS) * time.Millisecond,
 		exemptUserIDs:    map[string]struct{}{},
+		classes:          cfg.Classes,
+		buckets:          make(map[string]*tokenBucket),
 	}
 	for _, userID := range cfg.ExemptUserIDs {
 		l.exemptUserIDs[userID] = struct{}{}
 	}
+	trustedProxies := cfg.TrustedProxies
+	if len(trustedProxies) == 0 {
+		trustedProxies = config.DefaultTrustedProxies
+	}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			l.trustedProxies = append(l.trustedProxies, ipNet)
+		}
+	}
 	if l.enabled {
 		go l.clean()
 	}
@@ -55,9 +88,91 @@ func (l *RateLimits) clean() {
 		}
 		l.limitsMutex.Unlock()
 		l.cleanMutex.Unlock()
+
+		// Token buckets which have refilled completely are the same as
+		// new ones, so they can be removed too.
+		now := time.Now()
+		l.bucketsMutex.Lock()
+		for k, b := range l.buckets {
+			class := l.classes[strings.SplitN(k, " ", 2)[0]]
+			if b.tokens+now.Sub(b.updated).Seconds()*class.PerSecond >= float64(class.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.bucketsMutex.Unlock()
 	}
 }
 
+// exempt returns true if the device is never rate-limited.
+func (l *RateLimits) exempt(device *userapi.Device) bool {
+	if device == nil {
+		return false
+	}
+	switch device.AccountType {
+	case userapi.AccountTypeAdmin:
+		return true // don't rate-limit server administrators
+	case userapi.AccountTypeAppService:
+		return true // don't rate-limit appservice users
+	}
+	// If the user is exempt from rate limiting then do nothing.
+	_, ok := l.exemptUserIDs[device.UserID]
+	return ok
+}
+
+// ClientIP returns the IP address of the client which made the request. The
+// X-Forwarded-For header is only used if the request came from a trusted
+// proxy, in which case the last address in it which isn't a trusted proxy
+// is the client.
+func (l *RateLimits) ClientIP(req *http.Request) string {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !l.trustedProxy(remote) {
+		return remote
+	}
+	forwardedFor := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwardedFor[i])
+		if addr == "" {
+			continue
+		}
+		remote = addr
+		if !l.trustedProxy(addr) {
+			break
+		}
+	}
+	return remote
+}
+
+// TrustedClientIP returns the IP address of the client which made the request,
+// like ClientIP, or an empty string if it isn't known. This is the case if the
+// request was forwarded by a proxy which isn't trusted, since the address would
+// be the proxy's, which is shared by all of its clients.
+func (l *RateLimits) TrustedClientIP(req *http.Request) string {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if req.Header.Get("X-Forwarded-For") != "" && !l.trustedProxy(remote) {
+		return ""
+	}
+	return l.ClientIP(req)
+}
+
+func (l *RateLimits) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range l.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSONResponse {
 	// If rate limiting is disabled then do nothing.
 	if !l.enabled {
@@ -71,28 +186,16 @@ func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSON
 	l.cleanMutex.RLock()
 	defer l.cleanMutex.RUnlock()
 
-	// First of all, work out if X-Forwarded-For was sent to us. If not
-	// then we'll just use the IP address of the caller.
+	// Work out who the caller is: either the device making the request,
+	// or the client's IP address if the request isn't authenticated.
+	if l.exempt(device) {
+		return nil
+	}
 	var caller string
 	if device != nil {
-		switch device.AccountType {
-		case userapi.AccountTypeAdmin:
-			return nil // don't rate-limit server administrators
-		case userapi.AccountTypeAppService:
-			return nil // don't rate-limit appservice users
-		default:
-			if _, ok := l.exemptUserIDs[device.UserID]; ok {
-				// If the user is exempt from rate limiting then do nothing.
-				return nil
-			}
-			caller = device.UserID + device.ID
-		}
+		caller = device.UserID + device.ID
 	} else {
-		if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-			caller = forwardedFor
-		} else {
-			caller = req.RemoteAddr
-		}
+		caller = l.ClientIP(req)
 	}
 
 	// Look up the caller's channel, if they have one.
@@ -116,10 +219,7 @@ func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSON
 	case rateLimit <- struct{}{}:
 	default:
 		// We hit the rate limit. Tell the client to back off.
-		return &util.JSONResponse{
-			Code: http.StatusTooManyRequests,
-			JSON: jsonerror.LimitExceeded("You are sending too many requests too quickly!", l.cooloffDuration.Milliseconds()),
-		}
+		return limitExceeded(l.cooloffDuration)
 	}
 
 	// After the time interval, drain a resource from the rate limiting
@@ -130,3 +230,64 @@ func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSON
 	}()
 	return nil
 }
+
+// LimitClass rate-limits the request using the token bucket configured for
+// the given class, e.g. config.RateLimitLogin. Classes which aren't configured
+// fall back to Limit.
+func (l *RateLimits) LimitClass(req *http.Request, device *userapi.Device, class string) *util.JSONResponse {
+	if !l.enabled {
+		return nil
+	}
+	classCfg, ok := l.classes[class]
+	if !ok {
+		return l.Limit(req, device)
+	}
+	if l.exempt(device) {
+		return nil
+	}
+
+	key := l.ClientIP(req)
+	if device != nil {
+		switch classCfg.Key {
+		case config.RateLimitKeyUser:
+			key = device.UserID
+		case config.RateLimitKeyDevice:
+			key = device.UserID + " " + device.ID
+		}
+	}
+	// Buckets are keyed by class first, so that the cleaner can find the
+	// class configuration again.
+	key = class + " " + key
+
+	l.bucketsMutex.Lock()
+	defer l.bucketsMutex.Unlock()
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(classCfg.Burst), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(
+		float64(classCfg.Burst),
+		bucket.tokens+now.Sub(bucket.updated).Seconds()*classCfg.PerSecond,
+	)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		// Tell the client how long it'll be until there is a token for them.
+		return limitExceeded(time.Duration((1 - bucket.tokens) / classCfg.PerSecond * float64(time.Second)))
+	}
+	bucket.tokens--
+	return nil
+}
+
+// limitExceeded returns an M_LIMIT_EXCEEDED error, which tells the client to
+// back off for the given duration, both in the body and with Retry-After.
+func limitExceeded(retryAfter time.Duration) *util.JSONResponse {
+	return &util.JSONResponse{
+		Code: http.StatusTooManyRequests,
+		JSON: jsonerror.LimitExceeded("You are sending too many requests too quickly!", retryAfter.Milliseconds()),
+		Headers: map[string]string{
+			"Retry-After": strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10),
+		},
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestLimitClass(t *testing.T) {
+	l := NewRateLimits(&config.RateLimiting{
+		Enabled:   true,
+		Threshold: 5,
+		CooloffMS: 500,
+		Classes: map[string]config.RateLimitClass{
+			config.RateLimitJoin: {Burst: 2, PerSecond: 0.5, Key: config.RateLimitKeyUser},
+		},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/join/!room:test", nil)
+	alice := &userapi.Device{UserID: "@alice:test", ID: "A"}
+	aliceOtherDevice := &userapi.Device{UserID: "@alice:test", ID: "B"}
+	bob := &userapi.Device{UserID: "@bob:test", ID: "A"}
+
+	for i := 0; i < 2; i++ {
+		if res := l.LimitClass(req, alice, config.RateLimitJoin); res != nil {
+			t.Fatalf("request %d: expected burst to be allowed, got %+v", i, res)
+		}
+	}
+	// Joins are counted per user, so Alice's other device is limited too.
+	res := l.LimitClass(req, aliceOtherDevice, config.RateLimitJoin)
+	if res == nil || res.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected request to be rate-limited, got %+v", res)
+	}
+	if retryAfter := res.Headers["Retry-After"]; retryAfter != "2" {
+		t.Errorf("Retry-After: got %q, want 2", retryAfter)
+	}
+	if res = l.LimitClass(req, bob, config.RateLimitJoin); res != nil {
+		t.Fatalf("expected other user to be allowed, got %+v", res)
+	}
+	// Admins are never rate-limited.
+	admin := &userapi.Device{UserID: "@admin:test", AccountType: userapi.AccountTypeAdmin}
+	for i := 0; i < 5; i++ {
+		if res = l.LimitClass(req, admin, config.RateLimitJoin); res != nil {
+			t.Fatalf("expected admin to be allowed, got %+v", res)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l := NewRateLimits(&config.RateLimiting{
+		TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"},
+	})
+	for _, tc := range []struct {
+		name, remoteAddr, forwardedFor, want string
+	}{
+		{"direct", "1.2.3.4:5678", "", "1.2.3.4"},
+		{"untrusted proxy", "1.2.3.4:5678", "5.6.7.8", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5678", "5.6.7.8", "5.6.7.8"},
+		{"proxy chain", "10.0.0.1:5678", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"only proxies", "10.0.0.1:5678", "192.168.1.1", "192.168.1.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if got := l.ClientIP(req); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// Proxies on the same host are trusted if no proxies are configured.
+	l = NewRateLimits(&config.RateLimiting{})
+	for _, tc := range []struct {
+		name, remoteAddr, forwardedFor, want string
+	}{
+		{"local IPv4 proxy", "127.0.0.1:5678", "5.6.7.8", "5.6.7.8"},
+		{"local IPv6 proxy", "[::1]:5678", "5.6.7.8", "5.6.7.8"},
+		{"remote proxy", "10.0.0.1:5678", "5.6.7.8", "10.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			if got := l.ClientIP(req); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrustedClientIP(t *testing.T) {
+	l := NewRateLimits(&config.RateLimiting{
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+	for _, tc := range []struct {
+		name, remoteAddr, forwardedFor, want string
+	}{
+		{"direct", "1.2.3.4:5678", "", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5678", "5.6.7.8", "5.6.7.8"},
+		{"untrusted proxy", "10.0.0.2:5678", "5.6.7.8", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if got := l.TrustedClientIP(req); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
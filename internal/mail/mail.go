@@ -0,0 +1,62 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mail sends emails to users through the configured SMTP server.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// Send sends a plain text email to the given addresses. It does nothing if
+// SMTP is disabled.
+func Send(cfg *config.SMTP, to []string, subject, body string) error {
+	if !cfg.Enabled || len(to) == 0 {
+		return nil
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, err := net.SplitHostPort(cfg.Host)
+		if err != nil {
+			return fmt.Errorf("net.SplitHostPort: %w", err)
+		}
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	msg := buildMessage(cfg.From, to, subject, body, time.Now())
+	if err := smtp.SendMail(cfg.Host, auth, cfg.From, to, msg); err != nil {
+		return fmt.Errorf("smtp.SendMail: %w", err)
+	}
+	return nil
+}
+
+func buildMessage(from string, to []string, subject, body string, now time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return msg.Bytes()
+}
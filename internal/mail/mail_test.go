@@ -0,0 +1,148 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestBuildMessage(t *testing.T) {
+	now := time.Date(2022, 11, 10, 12, 0, 0, 0, time.UTC)
+	msg := string(buildMessage(
+		"dendrite@example.com", []string{"alice@example.com", "bob@example.com"},
+		"Renew your account", "Hello\nWorld", now,
+	))
+	for _, want := range []string{
+		"From: dendrite@example.com\r\n",
+		"To: alice@example.com, bob@example.com\r\n",
+		"Subject: Renew your account\r\n",
+		"Date: Thu, 10 Nov 2022 12:00:00 +0000\r\n",
+		"\r\n\r\nHello\r\nWorld",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected message to contain %q, got %q", want, msg)
+		}
+	}
+}
+
+// smtpSink is a minimal SMTP server which accepts a single email.
+type smtpSink struct {
+	listener   net.Listener
+	from       string
+	recipients []string
+	data       string
+	done       chan struct{}
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	s := &smtpSink{listener: listener, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpSink) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close() // nolint:errcheck
+	text := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) {
+		_ = text.PrintfLine(format, args...)
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			s.recipients = append(s.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			data, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.data = strings.Join(data, "\n")
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	defer sink.listener.Close() // nolint:errcheck
+
+	cfg := &config.SMTP{
+		Enabled: true,
+		Host:    sink.listener.Addr().String(),
+		From:    "dendrite@example.com",
+	}
+	if err := Send(cfg, []string{"alice@example.com"}, "Validate your email", "Follow this link"); err != nil {
+		t.Fatalf("failed to send email: %s", err)
+	}
+	select {
+	case <-sink.done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timed out waiting for the email")
+	}
+
+	if sink.from != "dendrite@example.com" {
+		t.Errorf("expected email from %q, got %q", "dendrite@example.com", sink.from)
+	}
+	if fmt.Sprint(sink.recipients) != "[alice@example.com]" {
+		t.Errorf("expected email to alice@example.com, got %v", sink.recipients)
+	}
+	for _, want := range []string{"Subject: Validate your email", "Follow this link"} {
+		if !strings.Contains(sink.data, want) {
+			t.Errorf("expected email to contain %q, got %q", want, sink.data)
+		}
+	}
+}
+
+func TestSendDisabled(t *testing.T) {
+	// Nothing is listening, so sending would fail if it were attempted.
+	cfg := &config.SMTP{Enabled: false, Host: "127.0.0.1:1"}
+	if err := Send(cfg, []string{"alice@example.com"}, "Subject", "Body"); err != nil {
+		t.Fatalf("expected nothing to be sent, got %s", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retention implements message retention policies, as set by the
+// m.room.retention state event and the server-wide configuration.
+package retention
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// EventType is the type of the state event which holds a room's policy.
+const EventType = "m.room.retention"
+
+// Content is the content of a m.room.retention state event. Lifetimes are
+// in milliseconds.
+type Content struct {
+	MinLifetime *int64 `json:"min_lifetime,omitempty"`
+	MaxLifetime *int64 `json:"max_lifetime,omitempty"`
+}
+
+// MaxLifetime returns how long events in a room should be kept for, given
+// the content of the room's m.room.retention state event, which may be nil.
+// Returns zero if retention is disabled or events should be kept forever.
+func MaxLifetime(cfg *config.Retention, content []byte) time.Duration {
+	if !cfg.Enabled {
+		return 0
+	}
+	minLifetime, maxLifetime := cfg.DefaultPolicy.MinLifetime, cfg.DefaultPolicy.MaxLifetime
+	if len(content) > 0 {
+		var c Content
+		if err := json.Unmarshal(content, &c); err == nil {
+			if c.MinLifetime != nil && *c.MinLifetime >= 0 {
+				minLifetime = time.Duration(*c.MinLifetime) * time.Millisecond
+			}
+			if c.MaxLifetime != nil && *c.MaxLifetime > 0 {
+				maxLifetime = time.Duration(*c.MaxLifetime) * time.Millisecond
+			}
+		}
+	}
+	// Clamp the lifetime to the bounds allowed by the server. The upper
+	// bound also applies to rooms which would otherwise keep events forever.
+	if cfg.AllowedLifetimeMax > 0 && (maxLifetime == 0 || maxLifetime > cfg.AllowedLifetimeMax) {
+		maxLifetime = cfg.AllowedLifetimeMax
+	}
+	if maxLifetime == 0 {
+		return 0
+	}
+	if maxLifetime < cfg.AllowedLifetimeMin {
+		maxLifetime = cfg.AllowedLifetimeMin
+	}
+	// Never purge events before the minimum lifetime of the room.
+	if maxLifetime < minLifetime {
+		maxLifetime = minLifetime
+	}
+	return maxLifetime
+}
+
+// ExpiryTimestamp returns the timestamp before which events have expired,
+// given the max lifetime of the room. Returns zero if nothing expires.
+func ExpiryTimestamp(maxLifetime time.Duration, now time.Time) gomatrixserverlib.Timestamp {
+	if maxLifetime <= 0 {
+		return 0
+	}
+	return gomatrixserverlib.AsTimestamp(now.Add(-maxLifetime))
+}
+
+// IsExpired returns true if the event was sent before the expiry timestamp.
+// State events never expire, as they are needed to calculate room state.
+func IsExpired(event *gomatrixserverlib.Event, expiry gomatrixserverlib.Timestamp) bool {
+	if expiry == 0 || event.StateKey() != nil {
+		return false
+	}
+	return event.OriginServerTS() < expiry
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestMaxLifetime(t *testing.T) {
+	day := 24 * time.Hour
+	cfg := &config.Retention{
+		Enabled: true,
+		DefaultPolicy: config.RetentionPolicy{
+			MaxLifetime: 30 * day,
+		},
+		AllowedLifetimeMin: day,
+		AllowedLifetimeMax: 365 * day,
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    time.Duration
+	}{
+		{name: "no policy uses default", content: "", want: 30 * day},
+		{name: "room policy", content: `{"max_lifetime":604800000}`, want: 7 * day},
+		{name: "clamped to allowed minimum", content: `{"max_lifetime":1000}`, want: day},
+		{name: "clamped to allowed maximum", content: `{"max_lifetime":63072000000}`, want: 365 * day},
+		{name: "floored at min lifetime", content: `{"min_lifetime":864000000,"max_lifetime":172800000}`, want: 10 * day},
+		{name: "invalid content uses default", content: `{"max_lifetime":"forever"}`, want: 30 * day},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaxLifetime(cfg, []byte(tt.content)); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	cfg.Enabled = false
+	if got := MaxLifetime(cfg, []byte(`{"max_lifetime":1000}`)); got != 0 {
+		t.Fatalf("expected no lifetime when disabled, got %s", got)
+	}
+}
+
+func TestExpiryTimestamp(t *testing.T) {
+	if ts := ExpiryTimestamp(0, time.Now()); ts != 0 {
+		t.Fatalf("expected zero expiry for unlimited lifetime, got %d", ts)
+	}
+	now := time.Now()
+	if ts := ExpiryTimestamp(time.Hour, now); ts.Time().After(now.Add(-time.Hour)) {
+		t.Fatalf("expected expiry to be an hour ago, got %s", ts.Time())
+	}
+}
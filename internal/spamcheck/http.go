@@ -0,0 +1,86 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spamcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/util"
+)
+
+// HTTPChecker consults a spam checking service by POSTing the request as
+// JSON to the configured URL. The service must respond with a JSON Result.
+type HTTPChecker struct {
+	cfg    *config.SpamCheckerHTTP
+	client *http.Client
+}
+
+func NewHTTPChecker(cfg *config.SpamCheckerHTTP) *HTTPChecker {
+	return &HTTPChecker{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (c *HTTPChecker) Check(ctx context.Context, req *Request) Result {
+	res, err := c.check(ctx, req)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("Failed to consult HTTP spam checker")
+		if c.cfg.FailClosed {
+			return Result{
+				Action:  Reject,
+				ErrCode: "M_UNKNOWN",
+				Error:   "Unable to check this action for spam",
+			}
+		}
+		return Result{Action: Allow}
+	}
+	return res
+}
+
+func (c *HTTPChecker) check(ctx context.Context, req *Request) (Result, error) {
+	var res Result
+	body, err := json.Marshal(req)
+	if err != nil {
+		return res, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return res, err
+	}
+	defer httpRes.Body.Close() // nolint: errcheck
+	if httpRes.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("spam checker returned HTTP %d", httpRes.StatusCode)
+	}
+	if err = json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("json.Decode: %w", err)
+	}
+	switch res.Action {
+	case Allow, Reject, ShadowDrop:
+	default:
+		return res, fmt.Errorf("spam checker returned unknown action %q", res.Action)
+	}
+	return res, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spamcheck exposes places in Dendrite where spam checkers can veto
+// an action before it is accepted. Unlike hooks, spam checkers can reject an
+// action with an error or silently drop it.
+//
+// Spam checkers can either be compiled into the binary and registered with
+// Register, or run as a separate service which is consulted over HTTP. In
+// both cases they must be enabled in the spam_checker section of the config.
+package spamcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Kind is the kind of action that is being checked.
+type Kind string
+
+const (
+	// KindEvent is a new event sent by a local user. Event is set.
+	KindEvent Kind = "event"
+	// KindInvite is an invite from UserID to TargetUserID into RoomID.
+	KindInvite Kind = "invite"
+	// KindCreateRoom is a room creation by UserID.
+	KindCreateRoom Kind = "create_room"
+	// KindRegistration is a registration of the UserID.
+	KindRegistration Kind = "registration"
+	// KindProfile is a change of the Field in the profile of UserID to Value.
+	KindProfile Kind = "profile"
+	// KindMediaUpload is a media upload by UserID.
+	KindMediaUpload Kind = "media_upload"
+	// KindFederatedEvent is an event received over federation from Origin.
+	// Event is set.
+	KindFederatedEvent Kind = "federated_event"
+)
+
+// Action is the outcome of a check.
+type Action string
+
+const (
+	// Allow the action to go ahead.
+	Allow Action = "allow"
+	// Reject the action, returning an error to the user.
+	Reject Action = "reject"
+	// ShadowDrop pretends to the user that the action succeeded but
+	// doesn't perform it.
+	ShadowDrop Action = "shadow_drop"
+)
+
+// Request describes the action that is being checked. Only the fields
+// relevant to the Kind are set.
+type Request struct {
+	Kind         Kind            `json:"kind"`
+	UserID       string          `json:"user_id,omitempty"`
+	RoomID       string          `json:"room_id,omitempty"`
+	TargetUserID string          `json:"target_user_id,omitempty"`
+	Origin       string          `json:"origin,omitempty"`
+	Event        json.RawMessage `json:"event,omitempty"`
+	Field        string          `json:"field,omitempty"`
+	Value        string          `json:"value,omitempty"`
+	ContentType  string          `json:"content_type,omitempty"`
+	FileSize     int64           `json:"file_size,omitempty"`
+}
+
+// Result is the outcome of a check. ErrCode and Error are only used when
+// rejecting, defaulting to M_FORBIDDEN.
+type Result struct {
+	Action  Action `json:"action"`
+	ErrCode string `json:"errcode,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Rejected returns true if the action should be refused with an error.
+func (r Result) Rejected() bool {
+	return r.Action == Reject
+}
+
+// Dropped returns true if the action should be silently dropped.
+func (r Result) Dropped() bool {
+	return r.Action == ShadowDrop
+}
+
+// JSONResponse returns the error response to send for a rejected action.
+func (r Result) JSONResponse() util.JSONResponse {
+	errCode, msg := r.ErrCode, r.Error
+	if errCode == "" {
+		errCode = "M_FORBIDDEN"
+	}
+	if msg == "" {
+		msg = "This action has been rejected as spam"
+	}
+	return util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: &jsonerror.MatrixError{ErrCode: errCode, Err: msg},
+	}
+}
+
+// Checker is implemented by spam checkers.
+type Checker interface {
+	Check(ctx context.Context, req *Request) Result
+}
+
+// CheckerFunc allows a function to be used as a Checker.
+type CheckerFunc func(ctx context.Context, req *Request) Result
+
+func (f CheckerFunc) Check(ctx context.Context, req *Request) Result {
+	return f(ctx, req)
+}
+
+var (
+	modules   = make(map[string]Checker) // registered modules
+	checkers  []Checker                  // enabled checkers
+	checkerMu sync.RWMutex               // protects the above
+)
+
+// Register an in-process spam checker module under the given name. It will
+// only be consulted if it is listed in the config. This should be called
+// before the server is set up, typically from an init function.
+func Register(name string, checker Checker) {
+	checkerMu.Lock()
+	defer checkerMu.Unlock()
+	modules[name] = checker
+}
+
+// Setup enables the spam checkers listed in the config.
+func Setup(cfg *config.SpamChecker) {
+	checkerMu.Lock()
+	defer checkerMu.Unlock()
+	checkers = nil
+	for _, name := range cfg.Modules {
+		checker, ok := modules[name]
+		if !ok {
+			logrus.Fatalf("Spam checker module %q is not registered", name)
+		}
+		checkers = append(checkers, checker)
+	}
+	if cfg.HTTP.Enabled {
+		checkers = append(checkers, NewHTTPChecker(&cfg.HTTP))
+	}
+	if len(checkers) > 0 {
+		logrus.Infof("Enabled %d spam checker(s)", len(checkers))
+	}
+}
+
+// Check asks each of the enabled spam checkers about the action. The first
+// result which isn't Allow is returned.
+func Check(ctx context.Context, req *Request) Result {
+	checkerMu.RLock()
+	enabled := checkers
+	checkerMu.RUnlock()
+	for _, checker := range enabled {
+		if res := checker.Check(ctx, req); res.Action != Allow && res.Action != "" {
+			util.GetLogger(ctx).WithFields(logrus.Fields{
+				"kind":    req.Kind,
+				"user_id": req.UserID,
+				"room_id": req.RoomID,
+				"origin":  req.Origin,
+				"action":  res.Action,
+			}).Info("Spam checker refused action")
+			return res
+		}
+	}
+	return Result{Action: Allow}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spamcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestCheck(t *testing.T) {
+	Register("test_module", CheckerFunc(func(ctx context.Context, req *Request) Result {
+		if req.Kind == KindCreateRoom {
+			return Result{Action: Reject, ErrCode: "M_LIMIT_EXCEEDED", Error: "too many rooms"}
+		}
+		return Result{Action: Allow}
+	}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := Result{Action: Allow}
+		if req.Kind == KindEvent && req.UserID == "@spammer:test" {
+			res.Action = ShadowDrop
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	cfg := &config.SpamChecker{}
+	cfg.Defaults()
+	cfg.Modules = []string{"test_module"}
+	cfg.HTTP.Enabled = true
+	cfg.HTTP.URL = srv.URL
+	Setup(cfg)
+	defer Setup(&config.SpamChecker{})
+
+	ctx := context.Background()
+	if res := Check(ctx, &Request{Kind: KindCreateRoom, UserID: "@alice:test"}); !res.Rejected() {
+		t.Fatalf("expected room creation to be rejected, got %+v", res)
+	} else if res.ErrCode != "M_LIMIT_EXCEEDED" {
+		t.Fatalf("expected errcode M_LIMIT_EXCEEDED, got %q", res.ErrCode)
+	}
+	if res := Check(ctx, &Request{Kind: KindEvent, UserID: "@spammer:test"}); !res.Dropped() {
+		t.Fatalf("expected event to be dropped, got %+v", res)
+	}
+	if res := Check(ctx, &Request{Kind: KindEvent, UserID: "@alice:test"}); res.Action != Allow {
+		t.Fatalf("expected event to be allowed, got %+v", res)
+	}
+}
+
+func TestHTTPCheckerUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	cfg := &config.SpamCheckerHTTP{
+		Enabled: true,
+		URL:     srv.URL,
+		Timeout: time.Second,
+	}
+	ctx := context.Background()
+	if res := NewHTTPChecker(cfg).Check(ctx, &Request{Kind: KindEvent}); res.Action != Allow {
+		t.Fatalf("expected failing checker to fail open, got %+v", res)
+	}
+	cfg.FailClosed = true
+	if res := NewHTTPChecker(cfg).Check(ctx, &Request{Kind: KindEvent}); !res.Rejected() {
+		t.Fatalf("expected failing checker to fail closed, got %+v", res)
+	}
+}
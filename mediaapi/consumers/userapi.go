@@ -0,0 +1,106 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+)
+
+// OutputUserErasureConsumer consumes user erasures from the user API and
+// removes all media uploaded by the erased user.
+type OutputUserErasureConsumer struct {
+	ctx         context.Context
+	jetstream   nats.JetStreamContext
+	durable     string
+	topic       string
+	db          storage.Database
+	absBasePath config.Path
+}
+
+// NewOutputUserErasureConsumer creates a new OutputUserErasureConsumer.
+// Call Start() to begin consuming from the user API.
+func NewOutputUserErasureConsumer(
+	process *process.ProcessContext,
+	cfg *config.MediaAPI,
+	js nats.JetStreamContext,
+	store storage.Database,
+) *OutputUserErasureConsumer {
+	return &OutputUserErasureConsumer{
+		ctx:         process.Context(),
+		jetstream:   js,
+		topic:       cfg.Matrix.JetStream.Prefixed(jetstream.OutputUserErasure),
+		durable:     cfg.Matrix.JetStream.Durable("MediaAPIUserErasureConsumer"),
+		db:          store,
+		absBasePath: cfg.AbsBasePath,
+	}
+}
+
+// Start consuming user erasures.
+func (s *OutputUserErasureConsumer) Start() error {
+	return jetstream.JetStreamConsumer(
+		s.ctx, s.jetstream, s.topic, s.durable, 1,
+		s.onMessage, nats.DeliverAll(), nats.ManualAck(),
+	)
+}
+
+func (s *OutputUserErasureConsumer) onMessage(ctx context.Context, msgs []*nats.Msg) bool {
+	msg := msgs[0] // Guaranteed to exist if onMessage is called
+	userID := msg.Header.Get(jetstream.UserID)
+	if userID == "" {
+		return true
+	}
+	logger := log.WithField("user_id", userID)
+
+	media, err := s.db.GetMediaMetadataByUser(ctx, types.MatrixUserID(userID))
+	if err != nil {
+		logger.WithError(err).Error("mediaapi user erasure consumer: failed to get media for user")
+		return false
+	}
+	for _, metadata := range media {
+		if err = s.db.DeleteMedia(ctx, metadata.MediaID, metadata.Origin); err != nil {
+			logger.WithError(err).Error("mediaapi user erasure consumer: failed to delete media")
+			return false
+		}
+		// Files are deduplicated by hash, so only remove the file (and with
+		// it any thumbnails) once nothing else refers to it.
+		inUse, err := s.db.IsMediaHashInUse(ctx, metadata.Base64Hash)
+		if err != nil {
+			logger.WithError(err).Error("mediaapi user erasure consumer: failed to check whether media is in use")
+			return false
+		}
+		if inUse {
+			continue
+		}
+		filePath, err := fileutils.GetPathFromBase64Hash(metadata.Base64Hash, s.absBasePath)
+		if err != nil {
+			logger.WithError(err).Warn("mediaapi user erasure consumer: failed to get media path")
+			continue
+		}
+		fileutils.RemoveDir(types.Path(filepath.Dir(filePath)), logger)
+	}
+	logger.Infof("Removed %d media uploads for erased user", len(media))
+	return true
+}
@@ -15,8 +15,10 @@
 package mediaapi
 
 import (
+	"github.com/matrix-org/dendrite/mediaapi/consumers"
 	"github.com/matrix-org/dendrite/mediaapi/routing"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/base"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -27,6 +29,7 @@ import (
 func AddPublicRoutes(
 	base *base.BaseDendrite,
 	userAPI userapi.MediaUserAPI,
+	rsAPI roomserverAPI.QueryBannedByPolicyAPI,
 	client *gomatrixserverlib.Client,
 ) {
 	cfg := &base.Cfg.MediaAPI
@@ -37,7 +40,15 @@ func AddPublicRoutes(
 		logrus.WithError(err).Panicf("failed to connect to media db")
 	}
 
+	js, _ := base.NATS.Prepare(base.ProcessContext, &cfg.Matrix.JetStream)
+	erasureConsumer := consumers.NewOutputUserErasureConsumer(
+		base.ProcessContext, cfg, js, mediaDB,
+	)
+	if err = erasureConsumer.Start(); err != nil {
+		logrus.WithError(err).Panic("failed to start media API user erasure consumer")
+	}
+
 	routing.Setup(
-		base.PublicMediaAPIMux, cfg, rateCfg, mediaDB, userAPI, client,
+		base.PublicMediaAPIMux, cfg, rateCfg, mediaDB, userAPI, rsAPI, client,
 	)
 }
@@ -547,6 +547,9 @@ func (r *downloadRequest) getRemoteFile(
 		}
 
 		if mediaMetadata == nil {
+			if !cfg.FederationAPI.IsFederationAllowed(r.MediaMetadata.Origin) {
+				return fmt.Errorf("federation with %s is not allowed", r.MediaMetadata.Origin)
+			}
 			// If we do not have a record, we need to fetch the remote file first and then respond from the local file
 			err := r.fetchRemoteFileAndStoreMetadata(
 				ctx, client,
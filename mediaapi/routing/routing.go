@@ -23,6 +23,7 @@ import (
 	"github.com/matrix-org/dendrite/internal/httputil"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -49,6 +50,7 @@ func Setup(
 	rateLimit *config.RateLimiting,
 	db storage.Database,
 	userAPI userapi.MediaUserAPI,
+	rsAPI roomserverAPI.QueryBannedByPolicyAPI,
 	client *gomatrixserverlib.Client,
 ) {
 	rateLimits := httputil.NewRateLimits(rateLimit)
@@ -62,7 +64,7 @@ func Setup(
 	uploadHandler := httputil.MakeAuthAPI(
 		"upload", userAPI,
 		func(req *http.Request, dev *userapi.Device) util.JSONResponse {
-			if r := rateLimits.Limit(req, dev); r != nil {
+			if r := rateLimits.LimitClass(req, dev, config.RateLimitMediaUpload); r != nil {
 				return *r
 			}
 			return Upload(req, cfg, dev, db, activeThumbnailGeneration)
@@ -90,12 +92,12 @@ func Setup(
 		MXCToResult: map[string]*types.RemoteRequestResult{},
 	}
 
-	downloadHandler := makeDownloadAPI("download", cfg, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration)
+	downloadHandler := makeDownloadAPI("download", cfg, rateLimits, db, rsAPI, client, activeRemoteRequests, activeThumbnailGeneration)
 	v3mux.Handle("/download/{serverName}/{mediaId}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 	v3mux.Handle("/download/{serverName}/{mediaId}/{downloadName}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 
 	v3mux.Handle("/thumbnail/{serverName}/{mediaId}",
-		makeDownloadAPI("thumbnail", cfg, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration),
+		makeDownloadAPI("thumbnail", cfg, rateLimits, db, rsAPI, client, activeRemoteRequests, activeThumbnailGeneration),
 	).Methods(http.MethodGet, http.MethodOptions)
 }
 
@@ -104,6 +106,7 @@ func makeDownloadAPI(
 	cfg *config.MediaAPI,
 	rateLimits *httputil.RateLimits,
 	db storage.Database,
+	rsAPI roomserverAPI.QueryBannedByPolicyAPI,
 	client *gomatrixserverlib.Client,
 	activeRemoteRequests *types.ActiveRemoteRequests,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -149,6 +152,12 @@ func makeDownloadAPI(
 			}
 		}
 
+		// Hide media from servers and users that are banned by a policy list.
+		if isMediaBannedByPolicy(req, db, rsAPI, serverName, types.MediaID(vars["mediaId"])) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
 		// Cache media for at least one day.
 		w.Header().Set("Cache-Control", "public,max-age=86400,s-maxage=86400")
 
@@ -168,3 +177,24 @@ func makeDownloadAPI(
 	}
 	return promhttp.InstrumentHandlerCounter(counterVec, http.HandlerFunc(httpHandler))
 }
+
+// isMediaBannedByPolicy returns true if the media originates from a server,
+// or was uploaded by a user, that is banned by a policy list.
+func isMediaBannedByPolicy(
+	req *http.Request,
+	db storage.Database,
+	rsAPI roomserverAPI.QueryBannedByPolicyAPI,
+	origin gomatrixserverlib.ServerName,
+	mediaID types.MediaID,
+) bool {
+	policyReq := &roomserverAPI.QueryBannedByPolicyRequest{ServerName: origin}
+	if metadata, err := db.GetMediaMetadata(req.Context(), mediaID, origin); err == nil && metadata != nil {
+		policyReq.UserID = string(metadata.UserID)
+	}
+	policyRes := &roomserverAPI.QueryBannedByPolicyResponse{}
+	if err := rsAPI.QueryBannedByPolicy(req.Context(), policyReq, policyRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryBannedByPolicy failed")
+		return false
+	}
+	return policyRes.Banned
+}
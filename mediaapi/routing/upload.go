@@ -27,6 +27,7 @@ import (
 	"strings"
 
 	"github.com/matrix-org/dendrite/clientapi/jsonerror"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/mediaapi/fileutils"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/thumbnailer"
@@ -63,6 +64,25 @@ func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db sto
 		return *resErr
 	}
 
+	spam := spamcheck.Check(req.Context(), &spamcheck.Request{
+		Kind:        spamcheck.KindMediaUpload,
+		UserID:      dev.UserID,
+		ContentType: string(r.MediaMetadata.ContentType),
+		FileSize:    int64(r.MediaMetadata.FileSizeBytes),
+	})
+	if spam.Rejected() {
+		return spam.JSONResponse()
+	}
+	if spam.Dropped() {
+		// Give the uploader a content URI which will never resolve.
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: uploadResponse{
+				ContentURI: fmt.Sprintf("mxc://%s/%s", cfg.Matrix.ServerName, util.RandomString(24)),
+			},
+		}
+	}
+
 	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, activeThumbnailGeneration); resErr != nil {
 		return *resErr
 	}
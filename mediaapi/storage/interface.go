@@ -30,6 +30,9 @@ type MediaRepository interface {
 	StoreMediaMetadata(ctx context.Context, mediaMetadata *types.MediaMetadata) error
 	GetMediaMetadata(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName) (*types.MediaMetadata, error)
 	GetMediaMetadataByHash(ctx context.Context, mediaHash types.Base64Hash, mediaOrigin gomatrixserverlib.ServerName) (*types.MediaMetadata, error)
+	GetMediaMetadataByUser(ctx context.Context, userID types.MatrixUserID) ([]*types.MediaMetadata, error)
+	DeleteMedia(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName) error
+	IsMediaHashInUse(ctx context.Context, mediaHash types.Base64Hash) (bool, error)
 }
 
 type Thumbnails interface {
@@ -61,6 +61,29 @@ func (d Database) GetMediaMetadataByHash(ctx context.Context, mediaHash types.Ba
 	return mediaMetadata, err
 }
 
+// GetMediaMetadataByUser returns metadata about all media uploaded by the given user.
+func (d Database) GetMediaMetadataByUser(ctx context.Context, userID types.MatrixUserID) ([]*types.MediaMetadata, error) {
+	return d.MediaRepository.SelectMediaByUser(ctx, nil, userID)
+}
+
+// DeleteMedia removes the metadata for the given media and all of its thumbnails.
+// The files themselves are not removed, as they may be shared with other media
+// with the same hash, see IsMediaHashInUse.
+func (d Database) DeleteMedia(ctx context.Context, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if err := d.Thumbnails.DeleteThumbnails(ctx, txn, mediaID, mediaOrigin); err != nil {
+			return err
+		}
+		return d.MediaRepository.DeleteMedia(ctx, txn, mediaID, mediaOrigin)
+	})
+}
+
+// IsMediaHashInUse returns whether any media, from any origin, still refers to
+// the file with the given hash.
+func (d Database) IsMediaHashInUse(ctx context.Context, mediaHash types.Base64Hash) (bool, error) {
+	return d.MediaRepository.SelectMediaHashInUse(ctx, nil, mediaHash)
+}
+
 // StoreThumbnail inserts the metadata about the thumbnail into the database.
 // Returns an error if the combination of MediaID and Origin are not unique in the table.
 func (d Database) StoreThumbnail(ctx context.Context, thumbnailMetadata *types.ThumbnailMetadata) error {
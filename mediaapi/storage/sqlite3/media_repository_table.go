@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -65,11 +66,26 @@ const selectMediaByHashSQL = `
 SELECT content_type, file_size_bytes, creation_ts, upload_name, media_id, user_id FROM mediaapi_media_repository WHERE base64hash = $1 AND media_origin = $2
 `
 
+const selectMediaByUserSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash FROM mediaapi_media_repository WHERE user_id = $1
+`
+
+const selectMediaHashInUseSQL = `
+SELECT COUNT(*) > 0 FROM mediaapi_media_repository WHERE base64hash = $1
+`
+
+const deleteMediaSQL = `
+DELETE FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
+`
+
 type mediaStatements struct {
 	db                    *sql.DB
 	insertMediaStmt       *sql.Stmt
 	selectMediaStmt       *sql.Stmt
 	selectMediaByHashStmt *sql.Stmt
+	selectMediaByUserStmt *sql.Stmt
+	selectHashInUseStmt   *sql.Stmt
+	deleteMediaStmt       *sql.Stmt
 }
 
 func NewSQLiteMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error) {
@@ -85,6 +101,9 @@ func NewSQLiteMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error) {
 		{&s.insertMediaStmt, insertMediaSQL},
 		{&s.selectMediaStmt, selectMediaSQL},
 		{&s.selectMediaByHashStmt, selectMediaByHashSQL},
+		{&s.selectMediaByUserStmt, selectMediaByUserSQL},
+		{&s.selectHashInUseStmt, selectMediaHashInUseSQL},
+		{&s.deleteMediaStmt, deleteMediaSQL},
 	}.Prepare(db)
 }
 
@@ -145,3 +164,47 @@ func (s *mediaStatements) SelectMediaByHash(
 	)
 	return &mediaMetadata, err
 }
+
+func (s *mediaStatements) SelectMediaByUser(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID,
+) ([]*types.MediaMetadata, error) {
+	rows, err := sqlutil.TxStmtContext(ctx, txn, s.selectMediaByUserStmt).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMediaByUser: rows.close() failed")
+
+	var result []*types.MediaMetadata
+	for rows.Next() {
+		mediaMetadata := types.MediaMetadata{
+			UserID: userID,
+		}
+		if err = rows.Scan(
+			&mediaMetadata.MediaID,
+			&mediaMetadata.Origin,
+			&mediaMetadata.ContentType,
+			&mediaMetadata.FileSizeBytes,
+			&mediaMetadata.CreationTimestamp,
+			&mediaMetadata.UploadName,
+			&mediaMetadata.Base64Hash,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, &mediaMetadata)
+	}
+	return result, rows.Err()
+}
+
+func (s *mediaStatements) SelectMediaHashInUse(
+	ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash,
+) (inUse bool, err error) {
+	err = sqlutil.TxStmtContext(ctx, txn, s.selectHashInUseStmt).QueryRowContext(ctx, mediaHash).Scan(&inUse)
+	return
+}
+
+func (s *mediaStatements) DeleteMedia(
+	ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin gomatrixserverlib.ServerName,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.deleteMediaStmt).ExecContext(ctx, mediaID, mediaOrigin)
+	return err
+}
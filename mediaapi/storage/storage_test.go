@@ -141,3 +141,95 @@ func TestThumbnailsStorage(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteMedia(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+		aliceMedia := &types.MediaMetadata{
+			MediaID:       "alice",
+			Origin:        "localhost",
+			ContentType:   "image/png",
+			FileSizeBytes: 10,
+			UploadName:    "alice upload",
+			Base64Hash:    "dGVzdGluZw==",
+			UserID:        "@alice:localhost",
+		}
+		bobMedia := &types.MediaMetadata{
+			MediaID:       "bob",
+			Origin:        "localhost",
+			ContentType:   "image/png",
+			FileSizeBytes: 10,
+			UploadName:    "bob upload",
+			Base64Hash:    "dGVzdGluZw==",
+			UserID:        "@bob:localhost",
+		}
+		for _, metadata := range []*types.MediaMetadata{aliceMedia, bobMedia} {
+			if err := db.StoreMediaMetadata(ctx, metadata); err != nil {
+				t.Fatalf("unable to store media metadata: %v", err)
+			}
+		}
+		thumbnail := &types.ThumbnailMetadata{
+			MediaMetadata: &types.MediaMetadata{
+				MediaID:       aliceMedia.MediaID,
+				Origin:        aliceMedia.Origin,
+				ContentType:   "image/png",
+				FileSizeBytes: 6,
+			},
+			ThumbnailSize: types.ThumbnailSize{
+				Width:        5,
+				Height:       5,
+				ResizeMethod: types.Crop,
+			},
+		}
+		if err := db.StoreThumbnail(ctx, thumbnail); err != nil {
+			t.Fatalf("unable to store thumbnail metadata: %v", err)
+		}
+
+		gotMedia, err := db.GetMediaMetadataByUser(ctx, aliceMedia.UserID)
+		if err != nil {
+			t.Fatalf("unable to query media metadata by user: %v", err)
+		}
+		if len(gotMedia) != 1 || !reflect.DeepEqual(aliceMedia, gotMedia[0]) {
+			t.Fatalf("expected metadata %+v, got %+v", aliceMedia, gotMedia)
+		}
+
+		if err = db.DeleteMedia(ctx, aliceMedia.MediaID, aliceMedia.Origin); err != nil {
+			t.Fatalf("unable to delete media: %v", err)
+		}
+		gotMetadata, err := db.GetMediaMetadata(ctx, aliceMedia.MediaID, aliceMedia.Origin)
+		if err != nil {
+			t.Fatalf("unable to query media metadata: %v", err)
+		}
+		if gotMetadata != nil {
+			t.Fatalf("expected media to be deleted, got %+v", gotMetadata)
+		}
+		gotThumbnails, err := db.GetThumbnails(ctx, aliceMedia.MediaID, aliceMedia.Origin)
+		if err != nil {
+			t.Fatalf("unable to query thumbnails: %v", err)
+		}
+		if len(gotThumbnails) != 0 {
+			t.Fatalf("expected thumbnails to be deleted, got %d", len(gotThumbnails))
+		}
+
+		// Bob's media still uses the same file
+		inUse, err := db.IsMediaHashInUse(ctx, aliceMedia.Base64Hash)
+		if err != nil {
+			t.Fatalf("unable to query whether hash is in use: %v", err)
+		}
+		if !inUse {
+			t.Fatalf("expected hash to still be in use")
+		}
+		if err = db.DeleteMedia(ctx, bobMedia.MediaID, bobMedia.Origin); err != nil {
+			t.Fatalf("unable to delete media: %v", err)
+		}
+		inUse, err = db.IsMediaHashInUse(ctx, aliceMedia.Base64Hash)
+		if err != nil {
+			t.Fatalf("unable to query whether hash is in use: %v", err)
+		}
+		if inUse {
+			t.Fatalf("expected hash to no longer be in use")
+		}
+	})
+}
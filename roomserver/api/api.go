@@ -53,6 +53,12 @@ type QueryBulkStateContentAPI interface {
 	QueryBulkStateContent(ctx context.Context, req *QueryBulkStateContentRequest, res *QueryBulkStateContentResponse) error
 }
 
+type QueryBannedByPolicyAPI interface {
+	// QueryBannedByPolicy returns whether a user or server is banned by one of
+	// the configured policy lists.
+	QueryBannedByPolicy(ctx context.Context, req *QueryBannedByPolicyRequest, res *QueryBannedByPolicyResponse) error
+}
+
 type QueryEventsAPI interface {
 	// Query a list of events by event ID.
 	QueryEventsByID(
@@ -126,6 +132,8 @@ type AppserviceRoomserverAPI interface {
 		req *GetAliasesForRoomIDRequest,
 		res *GetAliasesForRoomIDResponse,
 	) error
+	// Query the rooms that a user has a given membership in
+	QueryRoomsForUser(ctx context.Context, req *QueryRoomsForUserRequest, res *QueryRoomsForUserResponse) error
 }
 
 type ClientRoomserverAPI interface {
@@ -141,6 +149,8 @@ type ClientRoomserverAPI interface {
 	QueryKnownUsers(ctx context.Context, req *QueryKnownUsersRequest, res *QueryKnownUsersResponse) error
 	QueryRoomVersionForRoom(ctx context.Context, req *QueryRoomVersionForRoomRequest, res *QueryRoomVersionForRoomResponse) error
 	QueryPublishedRooms(ctx context.Context, req *QueryPublishedRoomsRequest, res *QueryPublishedRoomsResponse) error
+	// QueryInsertionEventForBatch looks up the MSC2716 insertion event that a batch of history connects to.
+	QueryInsertionEventForBatch(ctx context.Context, req *QueryInsertionEventForBatchRequest, res *QueryInsertionEventForBatchResponse) error
 	QueryRoomVersionCapabilities(ctx context.Context, req *QueryRoomVersionCapabilitiesRequest, res *QueryRoomVersionCapabilitiesResponse) error
 
 	GetRoomIDForAlias(ctx context.Context, req *GetRoomIDForAliasRequest, res *GetRoomIDForAliasResponse) error
@@ -150,6 +160,7 @@ type ClientRoomserverAPI interface {
 	PerformRoomUpgrade(ctx context.Context, req *PerformRoomUpgradeRequest, resp *PerformRoomUpgradeResponse) error
 	PerformAdminEvacuateRoom(ctx context.Context, req *PerformAdminEvacuateRoomRequest, res *PerformAdminEvacuateRoomResponse) error
 	PerformAdminEvacuateUser(ctx context.Context, req *PerformAdminEvacuateUserRequest, res *PerformAdminEvacuateUserResponse) error
+	PerformAdminEraseUser(ctx context.Context, req *PerformAdminEraseUserRequest, res *PerformAdminEraseUserResponse) error
 	PerformPeek(ctx context.Context, req *PerformPeekRequest, res *PerformPeekResponse) error
 	PerformUnpeek(ctx context.Context, req *PerformUnpeekRequest, res *PerformUnpeekResponse) error
 	PerformInvite(ctx context.Context, req *PerformInviteRequest, res *PerformInviteResponse) error
@@ -167,12 +178,14 @@ type UserRoomserverAPI interface {
 	QueryCurrentState(ctx context.Context, req *QueryCurrentStateRequest, res *QueryCurrentStateResponse) error
 	QueryMembershipsForRoom(ctx context.Context, req *QueryMembershipsForRoomRequest, res *QueryMembershipsForRoomResponse) error
 	PerformAdminEvacuateUser(ctx context.Context, req *PerformAdminEvacuateUserRequest, res *PerformAdminEvacuateUserResponse) error
+	PerformAdminEraseUser(ctx context.Context, req *PerformAdminEraseUserRequest, res *PerformAdminEraseUserResponse) error
 }
 
 type FederationRoomserverAPI interface {
 	InputRoomEventsAPI
 	QueryLatestEventsAndStateAPI
 	QueryBulkStateContentAPI
+	QueryBannedByPolicyAPI
 	// QueryServerBannedFromRoom returns whether a server is banned from a room by server ACLs.
 	QueryServerBannedFromRoom(ctx context.Context, req *QueryServerBannedFromRoomRequest, res *QueryServerBannedFromRoomResponse) error
 	QueryRoomVersionForRoom(ctx context.Context, req *QueryRoomVersionForRoomRequest, res *QueryRoomVersionForRoomResponse) error
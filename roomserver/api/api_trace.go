@@ -121,6 +121,16 @@ func (t *RoomserverInternalAPITrace) PerformAdminEvacuateRoom(
 	return err
 }
 
+func (t *RoomserverInternalAPITrace) PerformAdminEraseUser(
+	ctx context.Context,
+	req *PerformAdminEraseUserRequest,
+	res *PerformAdminEraseUserResponse,
+) error {
+	err := t.Impl.PerformAdminEraseUser(ctx, req, res)
+	util.GetLogger(ctx).WithError(err).Infof("PerformAdminEraseUser req=%+v res=%+v", js(req), js(res))
+	return err
+}
+
 func (t *RoomserverInternalAPITrace) PerformAdminEvacuateUser(
 	ctx context.Context,
 	req *PerformAdminEvacuateUserRequest,
@@ -151,6 +161,16 @@ func (t *RoomserverInternalAPITrace) QueryPublishedRooms(
 	return err
 }
 
+func (t *RoomserverInternalAPITrace) QueryInsertionEventForBatch(
+	ctx context.Context,
+	req *QueryInsertionEventForBatchRequest,
+	res *QueryInsertionEventForBatchResponse,
+) error {
+	err := t.Impl.QueryInsertionEventForBatch(ctx, req, res)
+	util.GetLogger(ctx).WithError(err).Infof("QueryInsertionEventForBatch req=%+v res=%+v", js(req), js(res))
+	return err
+}
+
 func (t *RoomserverInternalAPITrace) QueryLatestEventsAndState(
 	ctx context.Context,
 	req *QueryLatestEventsAndStateRequest,
@@ -355,6 +375,13 @@ func (t *RoomserverInternalAPITrace) QueryKnownUsers(ctx context.Context, req *Q
 	return err
 }
 
+// QueryBannedByPolicy returns whether a user or server is banned by a policy list.
+func (t *RoomserverInternalAPITrace) QueryBannedByPolicy(ctx context.Context, req *QueryBannedByPolicyRequest, res *QueryBannedByPolicyResponse) error {
+	err := t.Impl.QueryBannedByPolicy(ctx, req, res)
+	util.GetLogger(ctx).WithError(err).Infof("QueryBannedByPolicy req=%+v res=%+v", js(req), js(res))
+	return err
+}
+
 // QueryServerBannedFromRoom returns whether a server is banned from a room by server ACLs.
 func (t *RoomserverInternalAPITrace) QueryServerBannedFromRoom(ctx context.Context, req *QueryServerBannedFromRoomRequest, res *QueryServerBannedFromRoomResponse) error {
 	err := t.Impl.QueryServerBannedFromRoom(ctx, req, res)
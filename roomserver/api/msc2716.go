@@ -0,0 +1,91 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// Event types and content keys for importing batches of history into a room,
+// as described in MSC2716. These use the unstable prefixes from the MSC.
+const (
+	// MSC2716Insertion is the type of an insertion event, which marks a point
+	// in the room DAG where a batch of history can be attached.
+	MSC2716Insertion = "org.matrix.msc2716.insertion"
+	// MSC2716Batch is the type of a batch event, which connects a batch of
+	// history to the insertion event with the matching next batch ID.
+	MSC2716Batch = "org.matrix.msc2716.batch"
+	// MSC2716Marker is the type of a marker state event, which is sent into
+	// the main timeline to tell other servers about an insertion event.
+	MSC2716Marker = "org.matrix.msc2716.marker"
+
+	MSC2716NextBatchIDKey     = "org.matrix.msc2716.next_batch_id"
+	MSC2716BatchIDKey         = "org.matrix.msc2716.batch_id"
+	MSC2716MarkerInsertionKey = "org.matrix.msc2716.marker.insertion"
+	MSC2716HistoricalKey      = "org.matrix.msc2716.historical"
+)
+
+// MSC2716Content is the content of an insertion, batch or marker event.
+type MSC2716Content struct {
+	NextBatchID     string `json:"org.matrix.msc2716.next_batch_id,omitempty"`
+	BatchID         string `json:"org.matrix.msc2716.batch_id,omitempty"`
+	MarkerInsertion string `json:"org.matrix.msc2716.marker.insertion,omitempty"`
+	Historical      bool   `json:"org.matrix.msc2716.historical,omitempty"`
+}
+
+// msc2716DefaultHistoricalLevel is the power level needed to import history
+// when the room's power levels don't say otherwise.
+const msc2716DefaultHistoricalLevel = 100
+
+// MSC2716CanSendHistorical returns true if the user has the "historical"
+// power level in the room with the given power levels event, which is needed
+// both to import history and to send marker events pointing at it.
+func MSC2716CanSendHistorical(powerLevels *gomatrixserverlib.Event, userID string) bool {
+	if powerLevels == nil {
+		return false
+	}
+	plContent, err := powerLevels.PowerLevels()
+	if err != nil {
+		return false
+	}
+	var content struct {
+		Historical json.RawMessage `json:"historical"`
+	}
+	if err = json.Unmarshal(powerLevels.Content(), &content); err != nil {
+		return false
+	}
+	required := int64(msc2716DefaultHistoricalLevel)
+	if len(content.Historical) > 0 {
+		// Older room versions allow levels to be strings.
+		var level interface{}
+		if err = json.Unmarshal(content.Historical, &level); err != nil {
+			return false
+		}
+		switch l := level.(type) {
+		case float64:
+			required = int64(l)
+		case string:
+			if required, err = strconv.ParseInt(l, 10, 64); err != nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return plContent.UserLevel(userID) >= required
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestMSC2716CanSendHistorical(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		userID  string
+		want    bool
+	}{
+		{
+			name:    "default level allows admins",
+			content: `{"users":{"@admin:test":100,"@mod:test":50}}`,
+			userID:  "@admin:test",
+			want:    true,
+		},
+		{
+			name:    "default level denies moderators",
+			content: `{"users":{"@admin:test":100,"@mod:test":50}}`,
+			userID:  "@mod:test",
+		},
+		{
+			name:    "lowered level allows moderators",
+			content: `{"historical":50,"users":{"@admin:test":100,"@mod:test":50}}`,
+			userID:  "@mod:test",
+			want:    true,
+		},
+		{
+			name:    "string level",
+			content: `{"historical":"50","users":{"@mod:test":"50"}}`,
+			userID:  "@mod:test",
+			want:    true,
+		},
+		{
+			name:    "users default",
+			content: `{"historical":0}`,
+			userID:  "@anyone:test",
+			want:    true,
+		},
+		{
+			name:    "invalid level",
+			content: `{"historical":{},"users_default":100}`,
+			userID:  "@anyone:test",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventJSON := `{"type":"m.room.power_levels","state_key":"","sender":"@admin:test","room_id":"!room:test",` +
+				`"event_id":"$pl:test","origin_server_ts":1,"content":` + tt.content + `}`
+			ev, err := gomatrixserverlib.NewEventFromTrustedJSON([]byte(eventJSON), false, gomatrixserverlib.RoomVersionV1)
+			if err != nil {
+				t.Fatalf("failed to create event: %v", err)
+			}
+			if got := MSC2716CanSendHistorical(ev, tt.userID); got != tt.want {
+				t.Errorf("MSC2716CanSendHistorical() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if MSC2716CanSendHistorical(nil, "@admin:test") {
+		t.Errorf("expected rooms without power levels to deny history")
+	}
+}
@@ -170,6 +170,10 @@ type PerformBackfillResponse struct {
 type PerformPublishRequest struct {
 	RoomID     string
 	Visibility string
+	// Optional. If set, the room is published to the given application
+	// service's third party network rather than the main room directory.
+	AppserviceID string
+	NetworkID    string
 }
 
 type PerformPublishResponse struct {
@@ -235,3 +239,11 @@ type PerformAdminEvacuateUserResponse struct {
 	Affected []string `json:"affected"`
 	Error    *PerformError
 }
+
+type PerformAdminEraseUserRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type PerformAdminEraseUserResponse struct {
+	Error *PerformError
+}
@@ -258,6 +258,13 @@ type QueryRoomVersionForRoomResponse struct {
 type QueryPublishedRoomsRequest struct {
 	// Optional. If specified, returns whether this room is published or not.
 	RoomID string
+	// Optional. The application service and third party network to return
+	// published rooms for. If empty, the main room directory is used.
+	AppserviceID string
+	NetworkID    string
+	// Optional. If true, returns rooms published to any network, including
+	// the main room directory.
+	IncludeAllNetworks bool
 }
 
 type QueryPublishedRoomsResponse struct {
@@ -265,6 +272,19 @@ type QueryPublishedRoomsResponse struct {
 	RoomIDs []string
 }
 
+// QueryInsertionEventForBatchRequest is a request to QueryInsertionEventForBatch
+type QueryInsertionEventForBatchRequest struct {
+	RoomID  string `json:"room_id"`
+	BatchID string `json:"batch_id"`
+}
+
+// QueryInsertionEventForBatchResponse is a response to QueryInsertionEventForBatch
+type QueryInsertionEventForBatchResponse struct {
+	// The ID of the MSC2716 insertion event in the room with the requested
+	// next batch ID, or empty if there is no such event.
+	InsertionEventID string `json:"insertion_event_id"`
+}
+
 type QueryAuthChainRequest struct {
 	EventIDs []string
 }
@@ -350,6 +370,19 @@ type QueryServerBannedFromRoomResponse struct {
 	Banned bool `json:"banned"`
 }
 
+// QueryBannedByPolicyRequest asks whether a user or a server is banned by
+// one of the policy lists. If both are set then either being banned will
+// result in a ban.
+type QueryBannedByPolicyRequest struct {
+	UserID     string                       `json:"user_id,omitempty"`
+	ServerName gomatrixserverlib.ServerName `json:"server_name,omitempty"`
+}
+
+type QueryBannedByPolicyResponse struct {
+	Banned bool   `json:"banned"`
+	Reason string `json:"reason,omitempty"`
+}
+
 type QueryRestrictedJoinAllowedRequest struct {
 	UserID string `json:"user_id"`
 	RoomID string `json:"room_id"`
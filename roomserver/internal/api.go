@@ -12,6 +12,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/internal/input"
 	"github.com/matrix-org/dendrite/roomserver/internal/perform"
 	"github.com/matrix-org/dendrite/roomserver/internal/query"
+	"github.com/matrix-org/dendrite/roomserver/policylist"
 	"github.com/matrix-org/dendrite/roomserver/producers"
 	"github.com/matrix-org/dendrite/roomserver/storage"
 	"github.com/matrix-org/dendrite/setup/base"
@@ -47,6 +48,7 @@ type RoomserverInternalAPI struct {
 	ServerName             gomatrixserverlib.ServerName
 	KeyRing                gomatrixserverlib.JSONVerifier
 	ServerACLs             *acls.ServerACLs
+	PolicyLists            *policylist.PolicyLists
 	fsAPI                  fsAPI.RoomserverFederationAPI
 	asAPI                  asAPI.AppServiceInternalAPI
 	NATSClient             *nats.Conn
@@ -67,10 +69,12 @@ func NewRoomserverAPI(
 	}
 
 	serverACLs := acls.NewServerACLs(roomserverDB)
+	policyLists := policylist.NewPolicyLists(roomserverDB, base.Cfg.RoomServer.PolicyListRooms)
 	producer := &producers.RoomEventProducer{
 		Topic:     string(base.Cfg.Global.JetStream.Prefixed(jetstream.OutputRoomEvent)),
 		JetStream: js,
 		ACLs:      serverACLs,
+		Policies:  policyLists,
 	}
 	a := &RoomserverInternalAPI{
 		ProcessContext:         base.ProcessContext,
@@ -86,11 +90,13 @@ func NewRoomserverAPI(
 		NATSClient:             nc,
 		Durable:                base.Cfg.Global.JetStream.Durable("RoomserverInputConsumer"),
 		ServerACLs:             serverACLs,
+		PolicyLists:            policyLists,
 		Queryer: &query.Queryer{
-			DB:         roomserverDB,
-			Cache:      base.Caches,
-			ServerName: base.Cfg.Global.ServerName,
-			ServerACLs: serverACLs,
+			DB:          roomserverDB,
+			Cache:       base.Caches,
+			ServerName:  base.Cfg.Global.ServerName,
+			ServerACLs:  serverACLs,
+			PolicyLists: policyLists,
 		},
 		// perform-er structs get initialised when we have a federation sender to use
 	}
@@ -106,6 +112,7 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 
 	r.Inputer = &input.Inputer{
 		Cfg:                 &r.Base.Cfg.RoomServer,
+		MSCs:                &r.Base.Cfg.MSCs,
 		Base:                r.Base,
 		ProcessContext:      r.Base.ProcessContext,
 		DB:                  r.DB,
@@ -121,10 +128,11 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		Queryer:             r.Queryer,
 	}
 	r.Inviter = &perform.Inviter{
-		DB:      r.DB,
-		Cfg:     r.Cfg,
-		FSAPI:   r.fsAPI,
-		Inputer: r.Inputer,
+		DB:          r.DB,
+		Cfg:         r.Cfg,
+		FSAPI:       r.fsAPI,
+		Inputer:     r.Inputer,
+		PolicyLists: r.PolicyLists,
 	}
 	r.Joiner = &perform.Joiner{
 		ServerName: r.Cfg.Matrix.ServerName,
@@ -190,10 +198,16 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 	if err := r.Inputer.Start(); err != nil {
 		logrus.WithError(err).Panic("failed to start roomserver input API")
 	}
+
+	go r.Admin.RunRetentionPurges(r.ProcessContext.Context())
 }
 
 func (r *RoomserverInternalAPI) SetUserAPI(userAPI userapi.RoomserverUserAPI) {
 	r.Leaver.UserAPI = userAPI
+
+	// Erasures make users leave rooms, which needs the user API, so we can
+	// only resume any interrupted erasures once it is available.
+	go r.Admin.ResumeUserErasures(r.ProcessContext.Context())
 }
 
 func (r *RoomserverInternalAPI) SetAppserviceAPI(asAPI asAPI.AppServiceInternalAPI) {
@@ -3,6 +3,7 @@ package helpers
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -320,10 +321,16 @@ func slowGetHistoryVisibilityState(
 	return LoadStateEvents(ctx, db, filteredEntries)
 }
 
+// ScanEventTree walks backwards from the given events through their prev events,
+// returning the events that the server is allowed to see, up to the limit. If
+// followHistoricalBatches is set then it also walks from MSC2716 insertion events
+// to the batch events which connect batches of imported history to them, so that
+// the history can be backfilled.
+//
 // TODO: Remove this when we have tests to assert correctness of this function
 func ScanEventTree(
 	ctx context.Context, db storage.Database, info *types.RoomInfo, front []string, visited map[string]bool, limit int,
-	serverName gomatrixserverlib.ServerName,
+	serverName gomatrixserverlib.ServerName, followHistoricalBatches bool,
 ) ([]types.EventNID, error) {
 	var resultNIDs []types.EventNID
 	var err error
@@ -382,8 +389,17 @@ BFSLoop:
 				// Update the list of events to retrieve.
 				resultNIDs = append(resultNIDs, ev.EventNID)
 			}
+			parents := ev.PrevEventIDs()
+			if followHistoricalBatches && ev.Type() == api.MSC2716Insertion && ev.StateKey() == nil {
+				var batchEventIDs []string
+				batchEventIDs, err = historicalBatchEventIDs(ctx, db, info, ev.Event)
+				if err != nil {
+					return resultNIDs, err
+				}
+				parents = append(parents[:len(parents):len(parents)], batchEventIDs...)
+			}
 			// Loop through the event's parents.
-			for _, pre = range ev.PrevEventIDs() {
+			for _, pre = range parents {
 				// Only add an event to the list of next events to process if it
 				// hasn't been seen before.
 				if !visited[pre] {
@@ -416,6 +432,18 @@ BFSLoop:
 	return resultNIDs, err
 }
 
+// historicalBatchEventIDs returns the IDs of the MSC2716 batch events which connect
+// batches of history to the given insertion event.
+func historicalBatchEventIDs(
+	ctx context.Context, db storage.Database, info *types.RoomInfo, insertionEvent *gomatrixserverlib.Event,
+) ([]string, error) {
+	var content api.MSC2716Content
+	if err := json.Unmarshal(insertionEvent.Content(), &content); err != nil || content.NextBatchID == "" {
+		return nil, nil
+	}
+	return db.BatchEventsForBatch(ctx, info.RoomNID, content.NextBatchID)
+}
+
 func QueryLatestEventsAndState(
 	ctx context.Context, db storage.Database,
 	request *api.QueryLatestEventsAndStateRequest,
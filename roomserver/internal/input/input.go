@@ -72,6 +72,7 @@ import (
 // or C.
 type Inputer struct {
 	Cfg                 *config.RoomServer
+	MSCs                *config.MSCs
 	Base                *base.BaseDendrite
 	ProcessContext      *process.ProcessContext
 	DB                  storage.Database
@@ -85,6 +86,7 @@ type Inputer struct {
 	InputRoomEventTopic string
 	OutputProducer      *producers.RoomEventProducer
 	workers             sync.Map // room ID -> *worker
+	markerBackfills     markerBackfills
 
 	Queryer *query.Queryer
 }
@@ -392,6 +392,10 @@ func (r *Inputer) processRoomEvent(
 		); err != nil {
 			return fmt.Errorf("r.updateLatestEvents: %w", err)
 		}
+		if event.Type() == api.MSC2716Marker && event.StateKey() != nil && r.MSCs.Enabled("msc2716") {
+			powerLevels, _ := authEvents.PowerLevels()
+			r.processMarkerEvent(logger, input, powerLevels)
+		}
 	case api.KindOld:
 		err = r.OutputProducer.ProduceRoomEvents(event.RoomID(), []api.OutputEvent{
 			{
@@ -0,0 +1,268 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+)
+
+// historicalBackfillLimit is the number of events to request in each
+// /backfill request when fetching history pointed to by a marker event.
+const historicalBackfillLimit = 100
+
+// maxHistoricalBackfillEvents is the most events that will be fetched when
+// backfilling the history that a single marker event points to.
+const maxHistoricalBackfillEvents = 2000
+
+// maxPendingMarkerBackfills is the most marker events in a room which can be
+// waiting for their history to be backfilled. Any more are ignored.
+const maxPendingMarkerBackfills = 10
+
+// markerBackfills are the marker events waiting for their history to be
+// backfilled, by room ID. Only one backfill runs at a time for each room,
+// for the marker at the front of the room's queue.
+type markerBackfills struct {
+	sync.Mutex
+	rooms map[string][]pendingMarker
+}
+
+type pendingMarker struct {
+	input            *api.InputRoomEvent
+	insertionEventID string
+	logger           *logrus.Entry
+}
+
+// processMarkerEvent handles an MSC2716 marker event. The marker points to an
+// insertion event where a batch of history was imported into the room. If we
+// don't already have the insertion event then we backfill the imported history
+// in the background, from the server that sent us the marker. Markers are only
+// honoured from senders with the historical power level, given the power
+// levels event that the marker was authed against.
+func (r *Inputer) processMarkerEvent(logger *logrus.Entry, input *api.InputRoomEvent, powerLevels *gomatrixserverlib.Event) {
+	var content api.MSC2716Content
+	if err := json.Unmarshal(input.Event.Content(), &content); err != nil || content.MarkerInsertion == "" {
+		return
+	}
+	if !api.MSC2716CanSendHistorical(powerLevels, input.Event.Sender()) {
+		logger.Debug("Ignoring marker event from sender without the historical power level")
+		return
+	}
+
+	roomID := input.Event.RoomID()
+	r.markerBackfills.Lock()
+	defer r.markerBackfills.Unlock()
+	if r.markerBackfills.rooms == nil {
+		r.markerBackfills.rooms = map[string][]pendingMarker{}
+	}
+	queue := r.markerBackfills.rooms[roomID]
+	for _, pending := range queue {
+		if pending.insertionEventID == content.MarkerInsertion {
+			return
+		}
+	}
+	if len(queue) >= maxPendingMarkerBackfills {
+		logger.Warn("Too many marker events waiting to be backfilled, ignoring marker event")
+		return
+	}
+	r.markerBackfills.rooms[roomID] = append(queue, pendingMarker{
+		input:            input,
+		insertionEventID: content.MarkerInsertion,
+		logger:           logger,
+	})
+	if len(queue) == 0 {
+		go r.backfillMarkers(roomID)
+	}
+}
+
+// backfillMarkers backfills the history for each of the marker events queued
+// for the room in turn, until there are none left.
+func (r *Inputer) backfillMarkers(roomID string) {
+	for {
+		r.markerBackfills.Lock()
+		queue := r.markerBackfills.rooms[roomID]
+		if len(queue) == 0 {
+			delete(r.markerBackfills.rooms, roomID)
+			r.markerBackfills.Unlock()
+			return
+		}
+		marker := queue[0]
+		r.markerBackfills.Unlock()
+
+		ctx, cancel := context.WithTimeout(r.ProcessContext.Context(), time.Minute*5)
+		if err := r.backfillHistoricalBatches(ctx, marker.logger, marker.input, marker.insertionEventID); err != nil {
+			marker.logger.WithError(err).Warn("Failed to backfill history for marker event")
+		}
+		cancel()
+
+		r.markerBackfills.Lock()
+		r.markerBackfills.rooms[roomID] = r.markerBackfills.rooms[roomID][1:]
+		r.markerBackfills.Unlock()
+	}
+}
+
+// backfillHistoricalBatches fetches the history reachable from the given
+// insertion event and queues it as old events in the roomserver input. Only
+// events which connect back to events that we already know about are queued,
+// since anything else would be rejected for missing prev events.
+func (r *Inputer) backfillHistoricalBatches(
+	ctx context.Context, logger *logrus.Entry, input *api.InputRoomEvent, insertionEventID string,
+) error {
+	roomID := input.Event.RoomID()
+	roomVersion := input.Event.RoomVersion
+	if known, err := r.DB.EventNIDs(ctx, []string{insertionEventID}); err != nil {
+		return fmt.Errorf("r.DB.EventNIDs: %w", err)
+	} else if _, ok := known[insertionEventID]; ok {
+		return nil
+	}
+
+	// Ask the server that sent us the marker first, followed by the server of
+	// the marker's sender if that is different.
+	var servers []gomatrixserverlib.ServerName
+	if input.Origin != "" && input.Origin != r.ServerName {
+		servers = append(servers, input.Origin)
+	}
+	if _, senderDomain, err := gomatrixserverlib.SplitID('@', input.Event.Sender()); err == nil {
+		if senderDomain != input.Origin && senderDomain != r.ServerName {
+			servers = append(servers, senderDomain)
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	// Walk backwards from the insertion event. The remote server will follow
+	// the batch events for any insertion events that it returns, but we may
+	// also need to ask again for prev events that didn't fit into a response.
+	fetched := map[string]*gomatrixserverlib.Event{}
+	requested := map[string]bool{}
+	front := []string{insertionEventID}
+	for len(front) > 0 && len(fetched) < maxHistoricalBackfillEvents {
+		for _, eventID := range front {
+			requested[eventID] = true
+		}
+		var txn gomatrixserverlib.Transaction
+		var err error
+		for _, server := range servers {
+			if txn, err = r.FSAPI.Backfill(ctx, server, roomID, historicalBackfillLimit, front); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("r.FSAPI.Backfill: %w", err)
+		}
+
+		var events []*gomatrixserverlib.Event
+		eventIDs := make([]string, 0, len(txn.PDUs))
+		for _, pdu := range txn.PDUs {
+			event, err := gomatrixserverlib.NewEventFromUntrustedJSON(pdu, roomVersion)
+			if err != nil || event.RoomID() != roomID {
+				continue
+			}
+			if _, ok := fetched[event.EventID()]; ok {
+				continue
+			}
+			events = append(events, event)
+			eventIDs = append(eventIDs, event.EventID())
+		}
+		known, err := r.DB.EventNIDs(ctx, eventIDs)
+		if err != nil {
+			return fmt.Errorf("r.DB.EventNIDs: %w", err)
+		}
+
+		var next []string
+		for _, event := range events {
+			if _, ok := known[event.EventID()]; ok {
+				continue
+			}
+			if err = event.VerifyEventSignatures(ctx, r.KeyRing); err != nil {
+				logger.WithError(err).WithField("event_id", event.EventID()).Warn("Backfilled historical event failed signature check")
+				continue
+			}
+			fetched[event.EventID()] = event
+			next = append(next, event.PrevEventIDs()...)
+			if event.Type() == api.MSC2716Insertion {
+				next = append(next, event.EventID())
+			}
+		}
+		known, err = r.DB.EventNIDs(ctx, next)
+		if err != nil {
+			return fmt.Errorf("r.DB.EventNIDs: %w", err)
+		}
+		front = nil
+		for _, eventID := range next {
+			if _, ok := known[eventID]; ok || requested[eventID] {
+				continue
+			}
+			// Events that we've already fetched don't need to be requested again,
+			// except for insertion events, so that the remote server returns the
+			// batches that connect to them.
+			if event, ok := fetched[eventID]; ok && event.Type() != api.MSC2716Insertion {
+				continue
+			}
+			requested[eventID] = true
+			front = append(front, eventID)
+		}
+	}
+
+	events := make([]*gomatrixserverlib.Event, 0, len(fetched))
+	var prevEventIDs []string
+	for _, event := range fetched {
+		events = append(events, event)
+		prevEventIDs = append(prevEventIDs, event.PrevEventIDs()...)
+	}
+	knownPrevEvents, err := r.DB.EventNIDs(ctx, prevEventIDs)
+	if err != nil {
+		return fmt.Errorf("r.DB.EventNIDs: %w", err)
+	}
+	connected := map[string]bool{}
+	inputs := make([]api.InputRoomEvent, 0, len(events))
+	for _, event := range gomatrixserverlib.ReverseTopologicalOrdering(events, gomatrixserverlib.TopologicalOrderByPrevEvents) {
+		isConnected := true
+		for _, prevEventID := range event.PrevEventIDs() {
+			if _, ok := knownPrevEvents[prevEventID]; !ok && !connected[prevEventID] {
+				isConnected = false
+				break
+			}
+		}
+		if !isConnected {
+			continue
+		}
+		connected[event.EventID()] = true
+		inputs = append(inputs, api.InputRoomEvent{
+			Kind:         api.KindOld,
+			Event:        event.Headered(roomVersion),
+			Origin:       input.Origin,
+			SendAsServer: api.DoNotSendToOtherServers,
+		})
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	logger.Infof("Backfilled %d historical events for marker event", len(inputs))
+	_, err = r.queueInputRoomEvents(ctx, &api.InputRoomEventsRequest{
+		InputRoomEvents: inputs,
+		Asynchronous:    true,
+	})
+	return err
+}
@@ -0,0 +1,124 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+
+	fedapi "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/test/testrig"
+)
+
+type blockingBackfillFSAPI struct {
+	fedapi.RoomserverFederationAPI
+	release chan struct{}
+	mu      sync.Mutex
+	calls   int
+}
+
+func (f *blockingBackfillFSAPI) Backfill(ctx context.Context, s gomatrixserverlib.ServerName, roomID string, limit int, eventIDs []string) (gomatrixserverlib.Transaction, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	<-f.release
+	return gomatrixserverlib.Transaction{}, errors.New("unreachable")
+}
+
+func (f *blockingBackfillFSAPI) backfillCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func mustTrustedEvent(t *testing.T, eventJSON string) *gomatrixserverlib.Event {
+	t.Helper()
+	ev, err := gomatrixserverlib.NewEventFromTrustedJSON([]byte(eventJSON), false, gomatrixserverlib.RoomVersionV9)
+	if err != nil {
+		t.Fatalf("failed to create event: %v", err)
+	}
+	return ev
+}
+
+func TestProcessMarkerEvent(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, closeBase := testrig.CreateBaseDendrite(t, dbType)
+		defer closeBase()
+		db, err := storage.Open(base, &base.Cfg.RoomServer.Database, base.Caches)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+
+		fsAPI := &blockingBackfillFSAPI{release: make(chan struct{})}
+		r := &Inputer{
+			DB:             db,
+			ServerName:     "local",
+			FSAPI:          fsAPI,
+			ProcessContext: process.NewProcessContext(),
+		}
+		powerLevels := mustTrustedEvent(t, `{"type":"m.room.power_levels","state_key":"","sender":"@bridge:remote",`+
+			`"room_id":"!room:remote","event_id":"$pl","origin_server_ts":1,"content":{"users":{"@bridge:remote":100}}}`)
+		marker := func(sender, insertionEventID string) *api.InputRoomEvent {
+			ev := mustTrustedEvent(t, fmt.Sprintf(`{"type":%q,"state_key":"","sender":%q,"room_id":"!room:remote",`+
+				`"event_id":"$marker","origin_server_ts":1,"content":{%q:%q}}`,
+				api.MSC2716Marker, sender, api.MSC2716MarkerInsertionKey, insertionEventID))
+			return &api.InputRoomEvent{
+				Kind:   api.KindNew,
+				Event:  ev.Headered(gomatrixserverlib.RoomVersionV9),
+				Origin: "remote",
+			}
+		}
+		logger := logrus.WithField("test", t.Name())
+		queued := func() int {
+			r.markerBackfills.Lock()
+			defer r.markerBackfills.Unlock()
+			return len(r.markerBackfills.rooms["!room:remote"])
+		}
+
+		// Markers from senders without the historical power level are ignored.
+		r.processMarkerEvent(logger, marker("@someone:remote", "$insertion"), powerLevels)
+		if n := queued(); n != 0 {
+			t.Fatalf("expected marker to be ignored, got %d queued", n)
+		}
+
+		// Markers for the same insertion event are only backfilled once, and
+		// only so many can be waiting at a time.
+		for i := 0; i < 3; i++ {
+			r.processMarkerEvent(logger, marker("@bridge:remote", "$insertion"), powerLevels)
+		}
+		for i := 0; i < maxPendingMarkerBackfills*2; i++ {
+			r.processMarkerEvent(logger, marker("@bridge:remote", fmt.Sprintf("$insertion%d", i)), powerLevels)
+		}
+		if n := queued(); n != maxPendingMarkerBackfills {
+			t.Fatalf("expected %d queued markers, got %d", maxPendingMarkerBackfills, n)
+		}
+
+		// Only one backfill runs at a time for the room.
+		deadline := time.Now().Add(5 * time.Second)
+		for fsAPI.backfillCalls() == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if calls := fsAPI.backfillCalls(); calls != 1 {
+			t.Fatalf("expected one backfill in flight, got %d", calls)
+		}
+		close(fsAPI.release)
+		for queued() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := queued(); n != 0 {
+			t.Fatalf("expected all queued markers to be processed, got %d left", n)
+		}
+		if calls := fsAPI.backfillCalls(); calls != maxPendingMarkerBackfills {
+			t.Fatalf("expected %d backfills, got %d", maxPendingMarkerBackfills, calls)
+		}
+	})
+}
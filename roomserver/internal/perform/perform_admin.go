@@ -28,6 +28,8 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/storage"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
 )
 
 type Admin struct {
@@ -231,3 +233,222 @@ func (r *Admin) PerformAdminEvacuateUser(
 	}
 	return nil
 }
+
+// PerformAdminEraseUser marks a local user as erased and then starts redacting
+// all of the events that they have sent in the background. Once their events
+// have been redacted in a room, the user will leave it. If the erasure is
+// interrupted then it will be resumed by ResumeUserErasures on next startup.
+func (r *Admin) PerformAdminEraseUser(
+	ctx context.Context,
+	req *api.PerformAdminEraseUserRequest,
+	res *api.PerformAdminEraseUserResponse,
+) error {
+	_, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		res.Error = &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Malformed user ID: %s", err),
+		}
+		return nil
+	}
+	if domain != r.Cfg.Matrix.ServerName {
+		res.Error = &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  "Can only erase local users using this endpoint",
+		}
+		return nil
+	}
+
+	if err = r.DB.MarkUserErased(ctx, req.UserID); err != nil {
+		return fmt.Errorf("r.DB.MarkUserErased: %w", err)
+	}
+
+	go r.eraseUser(req.UserID)
+	return nil
+}
+
+// ResumeUserErasures restarts any user erasures which didn't complete,
+// e.g. because the roomserver was shut down part way through.
+func (r *Admin) ResumeUserErasures(ctx context.Context) {
+	userIDs, err := r.DB.GetIncompleteUserErasures(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get incomplete user erasures")
+		return
+	}
+	for _, userID := range userIDs {
+		logrus.WithField("user_id", userID).Info("Resuming user erasure")
+		go r.eraseUser(userID)
+	}
+}
+
+// eraseUser redacts all of the events sent by the given user. In rooms that the
+// user is still joined to, redaction events are sent through the input API so
+// that other servers will also redact them, and then the user leaves the room.
+// Afterwards, the events that the user sent in all rooms that they have been in
+// are erased from the database, so that they are served redacted to new joiners
+// and over federation, even in rooms where we can no longer send redactions.
+func (r *Admin) eraseUser(userID string) {
+	ctx := r.Inputer.ProcessContext.Context()
+	logger := logrus.WithField("user_id", userID)
+
+	joinedRoomIDs, err := r.DB.GetRoomsByMembership(ctx, userID, gomatrixserverlib.Join)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Failed to get joined rooms for user erasure")
+		return
+	}
+	for _, roomID := range joinedRoomIDs {
+		if err = r.redactUserEventsInRoom(ctx, roomID, userID); err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to redact events for user erasure")
+			return
+		}
+		if err = r.leaveRoom(ctx, roomID, userID); err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to leave room for user erasure")
+			return
+		}
+	}
+
+	inviteRoomIDs, err := r.DB.GetRoomsByMembership(ctx, userID, gomatrixserverlib.Invite)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Failed to get invited rooms for user erasure")
+		return
+	}
+	for _, roomID := range inviteRoomIDs {
+		if err = r.leaveRoom(ctx, roomID, userID); err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to reject invite for user erasure")
+			return
+		}
+	}
+
+	leftRoomIDs, err := r.DB.GetRoomsByMembership(ctx, userID, gomatrixserverlib.Leave)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Failed to get left rooms for user erasure")
+		return
+	}
+	for _, roomID := range append(joinedRoomIDs, leftRoomIDs...) {
+		roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+		if err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to get room info for user erasure")
+			return
+		}
+		if roomInfo == nil || roomInfo.IsStub() {
+			continue
+		}
+		eventNIDs, err := r.DB.EventNIDsForSender(ctx, roomInfo.RoomNID, userID)
+		if err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to get events for user erasure")
+			return
+		}
+		if err = r.DB.EraseEvents(ctx, eventNIDs); err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("Failed to erase events for user erasure")
+			return
+		}
+	}
+
+	if err = r.DB.MarkUserErasureComplete(ctx, userID); err != nil {
+		logger.WithError(err).Error("Failed to mark user erasure as complete")
+		return
+	}
+	logger.Info("User erasure complete")
+}
+
+func (r *Admin) leaveRoom(ctx context.Context, roomID, userID string) error {
+	leaveReq := &api.PerformLeaveRequest{
+		RoomID: roomID,
+		UserID: userID,
+	}
+	leaveRes := &api.PerformLeaveResponse{}
+	outputEvents, err := r.Leaver.PerformLeave(ctx, leaveReq, leaveRes)
+	if err != nil {
+		return fmt.Errorf("r.Leaver.PerformLeave: %w", err)
+	}
+	if len(outputEvents) == 0 {
+		return nil
+	}
+	return r.Inputer.OutputProducer.ProduceRoomEvents(roomID, outputEvents)
+}
+
+// redactUserEventsInRoom sends a redaction for every event that the user has
+// sent in the room which hasn't already been redacted. The redactions are
+// sent as the user themselves, so they must still be joined to the room, and
+// are processed synchronously so that they are in the room before the user
+// leaves it.
+func (r *Admin) redactUserEventsInRoom(ctx context.Context, roomID, userID string) error {
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return nil
+	}
+	eventNIDs, err := r.DB.EventNIDsForSender(ctx, roomInfo.RoomNID, userID)
+	if err != nil {
+		return fmt.Errorf("r.DB.EventNIDsForSender: %w", err)
+	}
+	events, err := r.DB.Events(ctx, eventNIDs)
+	if err != nil {
+		return fmt.Errorf("r.DB.Events: %w", err)
+	}
+
+	latestReq := &api.QueryLatestEventsAndStateRequest{
+		RoomID: roomID,
+	}
+	latestRes := &api.QueryLatestEventsAndStateResponse{}
+	if err = r.Queryer.QueryLatestEventsAndState(ctx, latestReq, latestRes); err != nil {
+		return fmt.Errorf("r.Queryer.QueryLatestEventsAndState: %w", err)
+	}
+
+	inputEvents := make([]api.InputRoomEvent, 0, len(events))
+	for _, event := range events {
+		switch event.Type() {
+		case gomatrixserverlib.MRoomCreate, gomatrixserverlib.MRoomRedaction:
+			continue
+		}
+		if gjson.GetBytes(event.Unsigned(), "redacted_because").Exists() {
+			continue
+		}
+
+		fledglingEvent := &gomatrixserverlib.EventBuilder{
+			RoomID:  roomID,
+			Type:    gomatrixserverlib.MRoomRedaction,
+			Sender:  userID,
+			Redacts: event.EventID(),
+			Content: []byte("{}"),
+		}
+		eventsNeeded, err := gomatrixserverlib.StateNeededForEventBuilder(fledglingEvent)
+		if err != nil {
+			return fmt.Errorf("gomatrixserverlib.StateNeededForEventBuilder: %w", err)
+		}
+		redaction, err := eventutil.BuildEvent(ctx, fledglingEvent, r.Cfg.Matrix, time.Now(), &eventsNeeded, latestRes)
+		if err != nil {
+			return fmt.Errorf("eventutil.BuildEvent: %w", err)
+		}
+
+		inputEvents = append(inputEvents, api.InputRoomEvent{
+			Kind:         api.KindNew,
+			Event:        redaction,
+			Origin:       r.Cfg.Matrix.ServerName,
+			SendAsServer: string(r.Cfg.Matrix.ServerName),
+		})
+		// Chain the redactions together rather than creating lots
+		// of forward extremities.
+		latestRes.LatestEvents = []gomatrixserverlib.EventReference{
+			redaction.EventReference(),
+		}
+		latestRes.Depth = redaction.Depth() + 1
+	}
+	if len(inputEvents) == 0 {
+		return nil
+	}
+
+	inputReq := &api.InputRoomEventsRequest{
+		InputRoomEvents: inputEvents,
+	}
+	inputRes := &api.InputRoomEventsResponse{}
+	if err = r.Inputer.InputRoomEvents(ctx, inputReq, inputRes); err != nil {
+		return fmt.Errorf("r.Inputer.InputRoomEvents: %w", err)
+	}
+	if inputRes.ErrMsg != "" {
+		return fmt.Errorf("r.Inputer.InputRoomEvents: %s", inputRes.ErrMsg)
+	}
+	return nil
+}
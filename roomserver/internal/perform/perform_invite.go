@@ -22,6 +22,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
 	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/policylist"
 	"github.com/matrix-org/dendrite/roomserver/state"
 	"github.com/matrix-org/dendrite/roomserver/storage"
 	"github.com/matrix-org/dendrite/roomserver/storage/shared"
@@ -37,6 +38,8 @@ type Inviter struct {
 	Cfg     *config.RoomServer
 	FSAPI   federationAPI.RoomserverFederationAPI
 	Inputer *input.Inputer
+	// PolicyLists may be nil, in which case nothing is banned.
+	PolicyLists *policylist.PolicyLists
 }
 
 // nolint:gocyclo
@@ -79,6 +82,15 @@ func (r *Inviter) PerformInvite(
 		}
 		return nil, nil
 	}
+	for _, userID := range []string{event.Sender(), targetUserID} {
+		if banned, reason := r.PolicyLists.IsUserBanned(userID); banned {
+			res.Error = &api.PerformError{
+				Code: api.PerformErrorNotAllowed,
+				Msg:  fmt.Sprintf("User %q is banned by a policy list: %s", userID, reason),
+			}
+			return nil, nil
+		}
+	}
 
 	logger := util.GetLogger(ctx).WithFields(map[string]interface{}{
 		"inviter":  event.Sender(),
@@ -98,6 +98,12 @@ func (r *Joiner) performJoin(
 			Msg:  fmt.Sprintf("User %q does not belong to this homeserver", req.UserID),
 		}
 	}
+	if banned, reason := r.Queryer.PolicyLists.IsUserBanned(req.UserID); banned {
+		return "", "", &rsAPI.PerformError{
+			Code: rsAPI.PerformErrorNotAllowed,
+			Msg:  fmt.Sprintf("User %q is banned by a policy list: %s", req.UserID, reason),
+		}
+	}
 	if strings.HasPrefix(req.RoomIDOrAlias, "!") {
 		return r.performJoinRoomByID(ctx, req)
 	}
@@ -30,7 +30,7 @@ func (r *Publisher) PerformPublish(
 	req *api.PerformPublishRequest,
 	res *api.PerformPublishResponse,
 ) error {
-	err := r.DB.PublishRoom(ctx, req.RoomID, req.Visibility == "public")
+	err := r.DB.PublishRoom(ctx, req.RoomID, req.AppserviceID, req.NetworkID, req.Visibility == "public")
 	if err != nil {
 		res.Error = &api.PerformError{
 			Msg: err.Error(),
@@ -0,0 +1,112 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perform
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/retention"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/sirupsen/logrus"
+)
+
+// retentionPurgeBatchSize is how many events are erased at a time.
+const retentionPurgeBatchSize = 100
+
+// RunRetentionPurges periodically erases events which have outlived the
+// retention policy of their room, until the context is done. It does
+// nothing if retention policies are disabled.
+func (r *Admin) RunRetentionPurges(ctx context.Context) {
+	cfg := &r.Cfg.Matrix.Retention
+	if !cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		r.purgeExpiredEvents(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Admin) purgeExpiredEvents(ctx context.Context) {
+	roomIDs, err := r.DB.GetKnownRooms(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get known rooms for retention purge")
+		return
+	}
+	for _, roomID := range roomIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		count, err := r.purgeExpiredEventsInRoom(ctx, roomID)
+		if err != nil {
+			logrus.WithError(err).WithField("room_id", roomID).Error("Failed to purge expired events")
+			continue
+		}
+		if count > 0 {
+			logrus.WithField("room_id", roomID).Infof("Purged %d expired events", count)
+		}
+	}
+}
+
+// purgeExpiredEventsInRoom erases the non-state events in the room which
+// have expired. The events themselves are kept, since they are part of the
+// room DAG, but their content is removed as if they had been redacted.
+func (r *Admin) purgeExpiredEventsInRoom(ctx context.Context, roomID string) (int, error) {
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return 0, fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return 0, nil
+	}
+	var content []byte
+	policy, err := r.DB.GetStateEvent(ctx, roomID, retention.EventType, "")
+	if err != nil {
+		return 0, fmt.Errorf("r.DB.GetStateEvent: %w", err)
+	}
+	if policy != nil {
+		content = policy.Content()
+	}
+	expiry := retention.ExpiryTimestamp(retention.MaxLifetime(&r.Cfg.Matrix.Retention, content), time.Now())
+	if expiry == 0 {
+		return 0, nil
+	}
+	count := 0
+	var lastEventNID types.EventNID
+	for {
+		eventNIDs, err := r.DB.ExpiredEventNIDs(ctx, roomInfo.RoomNID, expiry, retentionPurgeBatchSize)
+		if err != nil {
+			return count, fmt.Errorf("r.DB.ExpiredEventNIDs: %w", err)
+		}
+		// Stop if erasing the last batch didn't remove its content, since
+		// we'd otherwise keep getting the same events back.
+		if len(eventNIDs) == 0 || eventNIDs[len(eventNIDs)-1] == lastEventNID {
+			return count, nil
+		}
+		lastEventNID = eventNIDs[len(eventNIDs)-1]
+		if err = r.DB.EraseEvents(ctx, eventNIDs); err != nil {
+			return count, fmt.Errorf("r.DB.EraseEvents: %w", err)
+		}
+		count += len(eventNIDs)
+	}
+}
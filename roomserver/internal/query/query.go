@@ -30,6 +30,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/acls"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/policylist"
 	"github.com/matrix-org/dendrite/roomserver/state"
 	"github.com/matrix-org/dendrite/roomserver/storage"
 	"github.com/matrix-org/dendrite/roomserver/types"
@@ -41,6 +42,8 @@ type Queryer struct {
 	Cache      caching.RoomServerCaches
 	ServerName gomatrixserverlib.ServerName
 	ServerACLs *acls.ServerACLs
+	// PolicyLists may be nil, in which case nothing is banned.
+	PolicyLists *policylist.PolicyLists
 }
 
 // QueryLatestEventsAndState implements api.RoomserverInternalAPI
@@ -453,7 +456,7 @@ func (r *Queryer) QueryMissingEvents(
 		return fmt.Errorf("missing RoomInfo for room %s", events[0].RoomID())
 	}
 
-	resultNIDs, err := helpers.ScanEventTree(ctx, r.DB, info, front, visited, request.Limit, request.ServerName)
+	resultNIDs, err := helpers.ScanEventTree(ctx, r.DB, info, front, visited, request.Limit, request.ServerName, false)
 	if err != nil {
 		return err
 	}
@@ -700,7 +703,7 @@ func (r *Queryer) QueryPublishedRooms(
 		}
 		return err
 	}
-	rooms, err := r.DB.GetPublishedRooms(ctx)
+	rooms, err := r.DB.GetPublishedRooms(ctx, req.AppserviceID, req.NetworkID, req.IncludeAllNetworks)
 	if err != nil {
 		return err
 	}
@@ -708,6 +711,22 @@ func (r *Queryer) QueryPublishedRooms(
 	return nil
 }
 
+func (r *Queryer) QueryInsertionEventForBatch(
+	ctx context.Context,
+	req *api.QueryInsertionEventForBatchRequest,
+	res *api.QueryInsertionEventForBatchResponse,
+) error {
+	info, err := r.DB.RoomInfo(ctx, req.RoomID)
+	if err != nil {
+		return err
+	}
+	if info == nil || info.IsStub() {
+		return nil
+	}
+	res.InsertionEventID, err = r.DB.InsertionEventForBatch(ctx, info.RoomNID, req.BatchID)
+	return err
+}
+
 func (r *Queryer) QueryCurrentState(ctx context.Context, req *api.QueryCurrentStateRequest, res *api.QueryCurrentStateResponse) error {
 	res.StateEvents = make(map[gomatrixserverlib.StateKeyTuple]*gomatrixserverlib.HeaderedEvent)
 	for _, tuple := range req.StateTuples {
@@ -815,6 +834,18 @@ func (r *Queryer) QueryServerBannedFromRoom(ctx context.Context, req *api.QueryS
 	return nil
 }
 
+func (r *Queryer) QueryBannedByPolicy(ctx context.Context, req *api.QueryBannedByPolicyRequest, res *api.QueryBannedByPolicyResponse) error {
+	if req.UserID != "" {
+		if res.Banned, res.Reason = r.PolicyLists.IsUserBanned(req.UserID); res.Banned {
+			return nil
+		}
+	}
+	if req.ServerName != "" {
+		res.Banned, res.Reason = r.PolicyLists.IsServerBanned(req.ServerName)
+	}
+	return nil
+}
+
 func (r *Queryer) QueryAuthChain(ctx context.Context, req *api.QueryAuthChainRequest, res *api.QueryAuthChainResponse) error {
 	chain, err := GetAuthChain(ctx, r.DB.EventsFromIDs, req.EventIDs)
 	if err != nil {
@@ -39,6 +39,7 @@ const (
 	RoomserverPerformForgetPath            = "/roomserver/performForget"
 	RoomserverPerformAdminEvacuateRoomPath = "/roomserver/performAdminEvacuateRoom"
 	RoomserverPerformAdminEvacuateUserPath = "/roomserver/performAdminEvacuateUser"
+	RoomserverPerformAdminEraseUserPath    = "/roomserver/performAdminEraseUser"
 
 	// Query operations
 	RoomserverQueryLatestEventsAndStatePath    = "/roomserver/queryLatestEventsAndState"
@@ -59,9 +60,11 @@ const (
 	RoomserverQuerySharedUsersPath             = "/roomserver/querySharedUsers"
 	RoomserverQueryKnownUsersPath              = "/roomserver/queryKnownUsers"
 	RoomserverQueryServerBannedFromRoomPath    = "/roomserver/queryServerBannedFromRoom"
+	RoomserverQueryBannedByPolicyPath          = "/roomserver/queryBannedByPolicy"
 	RoomserverQueryAuthChainPath               = "/roomserver/queryAuthChain"
 	RoomserverQueryRestrictedJoinAllowed       = "/roomserver/queryRestrictedJoinAllowed"
 	RoomserverQueryMembershipAtEventPath       = "/roomserver/queryMembershipAtEvent"
+	RoomserverQueryInsertionEventForBatchPath  = "/roomserver/queryInsertionEventForBatch"
 )
 
 type httpRoomserverInternalAPI struct {
@@ -272,6 +275,17 @@ func (h *httpRoomserverInternalAPI) PerformAdminEvacuateUser(
 	)
 }
 
+func (h *httpRoomserverInternalAPI) PerformAdminEraseUser(
+	ctx context.Context,
+	request *api.PerformAdminEraseUserRequest,
+	response *api.PerformAdminEraseUserResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformAdminEraseUser", h.roomserverURL+RoomserverPerformAdminEraseUserPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
 // QueryLatestEventsAndState implements RoomserverQueryAPI
 func (h *httpRoomserverInternalAPI) QueryLatestEventsAndState(
 	ctx context.Context,
@@ -319,6 +333,17 @@ func (h *httpRoomserverInternalAPI) QueryPublishedRooms(
 	)
 }
 
+func (h *httpRoomserverInternalAPI) QueryInsertionEventForBatch(
+	ctx context.Context,
+	request *api.QueryInsertionEventForBatchRequest,
+	response *api.QueryInsertionEventForBatchResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryInsertionEventForBatch", h.roomserverURL+RoomserverQueryInsertionEventForBatchPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
 // QueryMembershipForUser implements RoomserverQueryAPI
 func (h *httpRoomserverInternalAPI) QueryMembershipForUser(
 	ctx context.Context,
@@ -512,6 +537,17 @@ func (h *httpRoomserverInternalAPI) QueryServerBannedFromRoom(
 	)
 }
 
+func (h *httpRoomserverInternalAPI) QueryBannedByPolicy(
+	ctx context.Context,
+	request *api.QueryBannedByPolicyRequest,
+	response *api.QueryBannedByPolicyResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryBannedByPolicy", h.roomserverURL+RoomserverQueryBannedByPolicyPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
 func (h *httpRoomserverInternalAPI) QueryRestrictedJoinAllowed(
 	ctx context.Context,
 	request *api.QueryRestrictedJoinAllowedRequest,
@@ -65,11 +65,21 @@ func AddRoutes(r api.RoomserverInternalAPI, internalAPIMux *mux.Router) {
 		httputil.MakeInternalRPCAPI("RoomserverPerformAdminEvacuateUser", r.PerformAdminEvacuateUser),
 	)
 
+	internalAPIMux.Handle(
+		RoomserverPerformAdminEraseUserPath,
+		httputil.MakeInternalRPCAPI("RoomserverPerformAdminEraseUser", r.PerformAdminEraseUser),
+	)
+
 	internalAPIMux.Handle(
 		RoomserverQueryPublishedRoomsPath,
 		httputil.MakeInternalRPCAPI("RoomserverQueryPublishedRooms", r.QueryPublishedRooms),
 	)
 
+	internalAPIMux.Handle(
+		RoomserverQueryInsertionEventForBatchPath,
+		httputil.MakeInternalRPCAPI("RoomserverQueryInsertionEventForBatch", r.QueryInsertionEventForBatch),
+	)
+
 	internalAPIMux.Handle(
 		RoomserverQueryLatestEventsAndStatePath,
 		httputil.MakeInternalRPCAPI("RoomserverQueryLatestEventsAndState", r.QueryLatestEventsAndState),
@@ -185,6 +195,11 @@ func AddRoutes(r api.RoomserverInternalAPI, internalAPIMux *mux.Router) {
 		httputil.MakeInternalRPCAPI("RoomserverQueryServerBannedFromRoom", r.QueryServerBannedFromRoom),
 	)
 
+	internalAPIMux.Handle(
+		RoomserverQueryBannedByPolicyPath,
+		httputil.MakeInternalRPCAPI("RoomserverQueryBannedByPolicy", r.QueryBannedByPolicy),
+	)
+
 	internalAPIMux.Handle(
 		RoomserverQueryAuthChainPath,
 		httputil.MakeInternalRPCAPI("RoomserverQueryAuthChain", r.QueryAuthChain),
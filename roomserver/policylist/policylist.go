@@ -0,0 +1,242 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policylist implements enforcement of MSC2313 policy lists, which
+// are rooms containing m.policy.rule.* state events describing users, rooms
+// and servers that should be banned.
+package policylist
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RuleUser   = "m.policy.rule.user"
+	RuleRoom   = "m.policy.rule.room"
+	RuleServer = "m.policy.rule.server"
+
+	// RecommendationBan is the only recommendation defined by the spec.
+	RecommendationBan = "m.ban"
+)
+
+// legacyRuleTypes maps the event types used by older policy list
+// implementations onto the current ones.
+var legacyRuleTypes = map[string]string{
+	"m.room.rule.user":               RuleUser,
+	"m.room.rule.room":               RuleRoom,
+	"m.room.rule.server":             RuleServer,
+	"org.matrix.mjolnir.rule.user":   RuleUser,
+	"org.matrix.mjolnir.rule.room":   RuleRoom,
+	"org.matrix.mjolnir.rule.server": RuleServer,
+}
+
+// RuleType returns the canonical policy rule type for the given event type,
+// or an empty string if the event type is not a policy rule.
+func RuleType(eventType string) string {
+	switch eventType {
+	case RuleUser, RuleRoom, RuleServer:
+		return eventType
+	}
+	return legacyRuleTypes[eventType]
+}
+
+type PolicyListDatabase interface {
+	// GetStateEventsWithEventType returns all state events of a given type for a given room.
+	GetStateEventsWithEventType(ctx context.Context, roomID, evType string) ([]*gomatrixserverlib.HeaderedEvent, error)
+}
+
+// PolicyRule is the content of a m.policy.rule.* state event.
+type PolicyRule struct {
+	Entity         string `json:"entity"`
+	Recommendation string `json:"recommendation"`
+	Reason         string `json:"reason"`
+}
+
+type policyRule struct {
+	PolicyRule
+	ruleType string
+	regex    *regexp.Regexp
+}
+
+type ruleKey struct {
+	roomID   string
+	ruleType string
+	stateKey string
+}
+
+type PolicyLists struct {
+	db         PolicyListDatabase
+	rooms      map[string]struct{}     // policy room IDs that we are subscribed to
+	rules      map[ruleKey]*policyRule // (room ID, rule type, state key) -> rule
+	rulesMutex sync.RWMutex            // protects the above
+}
+
+func NewPolicyLists(db PolicyListDatabase, roomIDs []string) *PolicyLists {
+	ctx := context.TODO()
+	p := &PolicyLists{
+		db:    db,
+		rooms: make(map[string]struct{}, len(roomIDs)),
+		rules: make(map[ruleKey]*policyRule),
+	}
+	for _, roomID := range roomIDs {
+		p.rooms[roomID] = struct{}{}
+	}
+	// For each policy room, load any rules we already know about from the
+	// current state. Later changes arrive through OnPolicyRuleUpdate.
+	for _, roomID := range roomIDs {
+		p.loadRoom(ctx, roomID)
+	}
+	return p
+}
+
+// ReloadRoom replaces the rules for the policy room with those in its
+// current state. This picks up the rules which arrived as part of the room
+// state when joining the room, rather than as new events.
+func (p *PolicyLists) ReloadRoom(ctx context.Context, roomID string) {
+	if !p.IsPolicyRoom(roomID) {
+		return
+	}
+	p.rulesMutex.Lock()
+	for key := range p.rules {
+		if key.roomID == roomID {
+			delete(p.rules, key)
+		}
+	}
+	p.rulesMutex.Unlock()
+	p.loadRoom(ctx, roomID)
+}
+
+func (p *PolicyLists) loadRoom(ctx context.Context, roomID string) {
+	ruleTypes := []string{RuleUser, RuleRoom, RuleServer}
+	for legacyType := range legacyRuleTypes {
+		ruleTypes = append(ruleTypes, legacyType)
+	}
+	for _, evType := range ruleTypes {
+		events, err := p.db.GetStateEventsWithEventType(ctx, roomID, evType)
+		if err != nil {
+			logrus.WithError(err).Errorf("Failed to get %q policy rules for room %q", evType, roomID)
+			return
+		}
+		for _, ev := range events {
+			p.OnPolicyRuleUpdate(ev.Event)
+		}
+	}
+}
+
+// IsPolicyRoom returns true if the given room is one of the policy lists
+// that we are enforcing.
+func (p *PolicyLists) IsPolicyRoom(roomID string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.rooms[roomID]
+	return ok
+}
+
+func compileGlobRegex(orig string) (*regexp.Regexp, error) {
+	escaped := regexp.QuoteMeta(orig)
+	escaped = strings.Replace(escaped, "\\?", ".", -1)
+	escaped = strings.Replace(escaped, "\\*", ".*", -1)
+	return regexp.Compile("^" + escaped + "$")
+}
+
+// OnPolicyRuleUpdate processes a policy rule state event. Events that are
+// not in a subscribed policy room are ignored. A rule with no entity, which
+// is how rules are removed, deletes any previous rule with the same key.
+func (p *PolicyLists) OnPolicyRuleUpdate(state *gomatrixserverlib.Event) {
+	if !p.IsPolicyRoom(state.RoomID()) || state.StateKey() == nil {
+		return
+	}
+	ruleType := RuleType(state.Type())
+	if ruleType == "" {
+		return
+	}
+	key := ruleKey{
+		roomID:   state.RoomID(),
+		ruleType: ruleType,
+		stateKey: *state.StateKey(),
+	}
+	rule := &policyRule{ruleType: ruleType}
+	if err := json.Unmarshal(state.Content(), &rule.PolicyRule); err != nil {
+		logrus.WithError(err).Errorf("Failed to unmarshal state content for policy rule")
+		return
+	}
+	p.rulesMutex.Lock()
+	defer p.rulesMutex.Unlock()
+	if rule.Entity == "" || rule.Recommendation == "" {
+		delete(p.rules, key)
+		return
+	}
+	// As with server ACLs, only * and ? are supported as wildcards, so escape
+	// everything else before compiling.
+	expr, err := compileGlobRegex(rule.Entity)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to compile policy rule entity %q", rule.Entity)
+		return
+	}
+	rule.regex = expr
+	logrus.WithFields(logrus.Fields{
+		"rule_type":      ruleType,
+		"entity":         rule.Entity,
+		"recommendation": rule.Recommendation,
+	}).Debugf("Updating policy rule in %q", state.RoomID())
+	p.rules[key] = rule
+}
+
+func (p *PolicyLists) matchBan(ruleType, entity string) (bool, string) {
+	if p == nil {
+		return false, ""
+	}
+	p.rulesMutex.RLock()
+	defer p.rulesMutex.RUnlock()
+	for _, rule := range p.rules {
+		if rule.ruleType != ruleType || rule.Recommendation != RecommendationBan {
+			continue
+		}
+		if rule.regex.MatchString(entity) {
+			return true, rule.Reason
+		}
+	}
+	return false, ""
+}
+
+// IsServerBanned returns true if the given server matches a m.ban server
+// rule, along with the reason given by the rule.
+func (p *PolicyLists) IsServerBanned(serverName gomatrixserverlib.ServerName) (bool, string) {
+	if serverNameOnly, _, err := net.SplitHostPort(string(serverName)); err == nil {
+		serverName = gomatrixserverlib.ServerName(serverNameOnly)
+	}
+	return p.matchBan(RuleServer, string(serverName))
+}
+
+// IsUserBanned returns true if the given user matches a m.ban user rule, or
+// if the user's server matches a m.ban server rule.
+func (p *PolicyLists) IsUserBanned(userID string) (bool, string) {
+	if banned, reason := p.matchBan(RuleUser, userID); banned {
+		return true, reason
+	}
+	_, domain, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		return false, ""
+	}
+	return p.IsServerBanned(domain)
+}
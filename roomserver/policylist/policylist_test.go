@@ -0,0 +1,127 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policylist
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+
+	"github.com/matrix-org/dendrite/test"
+)
+
+type fakePolicyListDatabase struct {
+	state map[string][]*gomatrixserverlib.HeaderedEvent // event type -> events
+}
+
+func (f *fakePolicyListDatabase) GetStateEventsWithEventType(ctx context.Context, roomID, evType string) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	var events []*gomatrixserverlib.HeaderedEvent
+	for _, ev := range f.state[evType] {
+		if ev.RoomID() == roomID {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+func TestPolicyLists(t *testing.T) {
+	alice := test.NewUser(t)
+	policyRoom := test.NewRoom(t, alice)
+	otherRoom := test.NewRoom(t, alice)
+
+	p := &PolicyLists{
+		rooms: map[string]struct{}{policyRoom.ID: {}},
+		rules: make(map[ruleKey]*policyRule),
+	}
+
+	userRule := policyRoom.CreateAndInsert(t, alice, RuleUser, map[string]interface{}{
+		"entity":         "@spam*:example.org",
+		"recommendation": RecommendationBan,
+		"reason":         "spam",
+	}, test.WithStateKey("rule1"))
+	p.OnPolicyRuleUpdate(userRule.Event)
+	p.OnPolicyRuleUpdate(policyRoom.CreateAndInsert(t, alice, "m.room.rule.server", map[string]interface{}{
+		"entity":         "*.evil.com",
+		"recommendation": RecommendationBan,
+	}, test.WithStateKey("rule2")).Event)
+	// Rules from rooms that aren't policy lists should be ignored.
+	p.OnPolicyRuleUpdate(otherRoom.CreateAndInsert(t, alice, RuleUser, map[string]interface{}{
+		"entity":         "@bob:example.org",
+		"recommendation": RecommendationBan,
+	}, test.WithStateKey("rule3")).Event)
+
+	if banned, reason := p.IsUserBanned("@spammer:example.org"); !banned || reason != "spam" {
+		t.Fatalf("expected @spammer:example.org to be banned with reason 'spam', got %v %q", banned, reason)
+	}
+	if banned, _ := p.IsUserBanned("@alice:example.org"); banned {
+		t.Fatalf("expected @alice:example.org not to be banned")
+	}
+	if banned, _ := p.IsUserBanned("@bob:example.org"); banned {
+		t.Fatalf("expected rule from a non-policy room to be ignored")
+	}
+	if banned, _ := p.IsUserBanned("@alice:matrix.evil.com"); !banned {
+		t.Fatalf("expected user on a banned server to be banned")
+	}
+	if banned, _ := p.IsServerBanned("matrix.evil.com:8448"); !banned {
+		t.Fatalf("expected matrix.evil.com:8448 to be banned")
+	}
+	if banned, _ := p.IsServerBanned("evil.com"); banned {
+		t.Fatalf("expected evil.com not to match *.evil.com")
+	}
+
+	// Removing the content of a rule should remove the rule.
+	p.OnPolicyRuleUpdate(policyRoom.CreateAndInsert(t, alice, RuleUser, map[string]interface{}{}, test.WithStateKey("rule1")).Event)
+	if banned, _ := p.IsUserBanned("@spammer:example.org"); banned {
+		t.Fatalf("expected @spammer:example.org not to be banned after the rule was removed")
+	}
+
+	// A nil PolicyLists bans nothing.
+	var nilLists *PolicyLists
+	if banned, _ := nilLists.IsUserBanned("@spammer:example.org"); banned {
+		t.Fatalf("expected nil policy lists to ban nothing")
+	}
+}
+
+func TestPolicyListsReloadRoom(t *testing.T) {
+	alice := test.NewUser(t)
+	policyRoom := test.NewRoom(t, alice)
+	db := &fakePolicyListDatabase{state: map[string][]*gomatrixserverlib.HeaderedEvent{}}
+
+	// Nothing is known about the room before joining it.
+	p := NewPolicyLists(db, []string{policyRoom.ID})
+	if banned, _ := p.IsServerBanned("evil.com"); banned {
+		t.Fatalf("expected evil.com not to be banned before joining the policy room")
+	}
+
+	// The room state we got when joining contains the existing rules.
+	db.state[RuleServer] = []*gomatrixserverlib.HeaderedEvent{
+		policyRoom.CreateAndInsert(t, alice, RuleServer, map[string]interface{}{
+			"entity":         "evil.com",
+			"recommendation": RecommendationBan,
+		}, test.WithStateKey("rule1")),
+	}
+	p.ReloadRoom(context.Background(), policyRoom.ID)
+	if banned, _ := p.IsServerBanned("evil.com"); !banned {
+		t.Fatalf("expected evil.com to be banned after reloading the policy room")
+	}
+
+	// Rules which are no longer in the room state are dropped.
+	db.state[RuleServer] = nil
+	p.ReloadRoom(context.Background(), policyRoom.ID)
+	if banned, _ := p.IsServerBanned("evil.com"); banned {
+		t.Fatalf("expected evil.com not to be banned once the rule has gone")
+	}
+}
@@ -15,14 +15,17 @@
 package producers
 
 import (
+	"context"
 	"encoding/json"
 
+	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 
 	"github.com/matrix-org/dendrite/roomserver/acls"
 	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/policylist"
 	"github.com/matrix-org/dendrite/setup/jetstream"
 )
 
@@ -35,6 +38,7 @@ var keyContentFields = map[string]string{
 type RoomEventProducer struct {
 	Topic     string
 	ACLs      *acls.ServerACLs
+	Policies  *policylist.PolicyLists
 	JetStream nats.JetStreamContext
 }
 
@@ -77,6 +81,17 @@ func (r *RoomEventProducer) ProduceRoomEvents(roomID string, updates []api.Outpu
 				ev := update.NewRoomEvent.Event.Unwrap()
 				defer r.ACLs.OnServerACLUpdate(ev)
 			}
+			if policylist.RuleType(eventType) != "" && r.Policies.IsPolicyRoom(roomID) {
+				ev := update.NewRoomEvent.Event.Unwrap()
+				defer r.Policies.OnPolicyRuleUpdate(ev)
+			}
+			if eventType == gomatrixserverlib.MRoomMember && r.Policies.IsPolicyRoom(roomID) {
+				// Joining a policy room gives us its existing rules as part of
+				// the room state rather than as new events, so load them again.
+				if membership, err := update.NewRoomEvent.Event.Membership(); err == nil && membership == gomatrixserverlib.Join {
+					defer r.Policies.ReloadRoom(context.Background(), roomID)
+				}
+			}
 		}
 		logger.Tracef("Producing to topic '%s'", r.Topic)
 		if _, err := r.JetStream.PublishMsg(msg); err != nil {
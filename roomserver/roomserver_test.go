@@ -3,6 +3,7 @@ package roomserver_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/matrix-org/dendrite/roomserver"
 	"github.com/matrix-org/dendrite/roomserver/api"
@@ -11,6 +12,7 @@ import (
 	"github.com/matrix-org/dendrite/test"
 	"github.com/matrix-org/dendrite/test/testrig"
 	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/tidwall/gjson"
 )
 
 func mustCreateDatabase(t *testing.T, dbType test.DBType) (*base.BaseDendrite, storage.Database, func()) {
@@ -67,3 +69,114 @@ func Test_SharedUsers(t *testing.T) {
 		}
 	})
 }
+
+func Test_PerformBackfillHistoricalBatches(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice, test.RoomHistoryVisibility(gomatrixserverlib.HistoryVisibilityWorldReadable))
+
+	// The batch event isn't referenced by any other event, so it can only
+	// be found by following the batch ID from the insertion event.
+	batchEvent := room.CreateEvent(t, alice, api.MSC2716Batch, map[string]interface{}{
+		api.MSC2716BatchIDKey: "batch1",
+	})
+	insertionEvent := room.CreateAndInsert(t, alice, api.MSC2716Insertion, map[string]interface{}{
+		api.MSC2716NextBatchIDKey: "batch1",
+	})
+
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, _, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		rsAPI := roomserver.NewInternalAPI(base)
+		// SetFederationAPI starts the room event input consumer
+		rsAPI.SetFederationAPI(nil, nil)
+		events := append(room.Events(), batchEvent)
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, events, "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		res := &api.PerformBackfillResponse{}
+		if err := rsAPI.PerformBackfill(ctx, &api.PerformBackfillRequest{
+			RoomID:               room.ID,
+			BackwardsExtremities: map[string][]string{"": {insertionEvent.EventID()}},
+			Limit:                100,
+			ServerName:           "otherserver",
+		}, res); err != nil {
+			t.Fatalf("failed to perform backfill: %v", err)
+		}
+		found := false
+		for _, ev := range res.Events {
+			if ev.EventID() == batchEvent.EventID() {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected backfill to return the batch event, got %d events", len(res.Events))
+		}
+	})
+}
+
+func Test_PerformAdminEraseUser(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+		"membership": "join",
+	}, test.WithStateKey(bob.ID))
+	message := room.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    "please forget me",
+	})
+
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		base, _, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		rsAPI := roomserver.NewInternalAPI(base)
+		// SetFederationAPI starts the room event input consumer
+		rsAPI.SetFederationAPI(nil, nil)
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		res := &api.PerformAdminEraseUserResponse{}
+		if err := rsAPI.PerformAdminEraseUser(ctx, &api.PerformAdminEraseUserRequest{UserID: bob.ID}, res); err != nil {
+			t.Fatalf("failed to erase user: %v", err)
+		}
+		if res.Error != nil {
+			t.Fatalf("failed to erase user: %+v", res.Error)
+		}
+
+		// The erasure runs in the background, so wait for Bob to have left
+		// the room and for his message to have been redacted.
+		var left, redacted bool
+		deadline := time.Now().Add(10 * time.Second)
+		for (!left || !redacted) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			membershipRes := &api.QueryMembershipForUserResponse{}
+			if err := rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{
+				RoomID: room.ID,
+				UserID: bob.ID,
+			}, membershipRes); err != nil {
+				t.Fatalf("failed to query membership: %v", err)
+			}
+			left = membershipRes.Membership == gomatrixserverlib.Leave
+
+			eventsRes := &api.QueryEventsByIDResponse{}
+			if err := rsAPI.QueryEventsByID(ctx, &api.QueryEventsByIDRequest{
+				EventIDs: []string{message.EventID()},
+			}, eventsRes); err != nil {
+				t.Fatalf("failed to query events: %v", err)
+			}
+			redacted = len(eventsRes.Events) == 1 && !gjson.GetBytes(eventsRes.Events[0].Content(), "body").Exists()
+		}
+		if !left {
+			t.Errorf("expected %s to have left the room", bob.ID)
+		}
+		if !redacted {
+			t.Errorf("expected the message from %s to have been redacted", bob.ID)
+		}
+	})
+}
@@ -138,10 +138,12 @@ type Database interface {
 	// not found.
 	// Returns an error if the retrieval went wrong.
 	EventsFromIDs(ctx context.Context, eventIDs []string) ([]types.Event, error)
-	// Publish or unpublish a room from the room directory.
-	PublishRoom(ctx context.Context, roomID string, publish bool) error
-	// Returns a list of room IDs for rooms which are published.
-	GetPublishedRooms(ctx context.Context) ([]string, error)
+	// Publish or unpublish a room from the room directory, or from the given
+	// application service's third party network if the network ID is set.
+	PublishRoom(ctx context.Context, roomID, appserviceID, networkID string, publish bool) error
+	// Returns a list of room IDs for rooms which are published to the given
+	// network, or to the main room directory if the network ID is empty.
+	GetPublishedRooms(ctx context.Context, appserviceID, networkID string, includeAllNetworks bool) ([]string, error)
 	// Returns whether a given room is published or not.
 	GetPublishedRoom(ctx context.Context, roomID string) (bool, error)
 
@@ -170,5 +172,25 @@ type Database interface {
 	// ForgetRoom sets a flag in the membership table, that the user wishes to forget a specific room
 	ForgetRoom(ctx context.Context, userID, roomID string, forget bool) error
 
+	// MarkUserErased records that the given user has been erased. Their erasure will be
+	// reported by GetIncompleteUserErasures until MarkUserErasureComplete is called.
+	MarkUserErased(ctx context.Context, userID string) error
+	MarkUserErasureComplete(ctx context.Context, userID string) error
+	GetIncompleteUserErasures(ctx context.Context) ([]string, error)
+	// EventNIDsForSender returns the numeric IDs of all events in the room sent by the given user.
+	EventNIDsForSender(ctx context.Context, roomNID types.RoomNID, sender string) ([]types.EventNID, error)
+	// EraseEvents redacts the stored JSON of the given events without a redaction event.
+	EraseEvents(ctx context.Context, eventNIDs []types.EventNID) error
+	// ExpiredEventNIDs returns the numeric IDs of up to limit non-state events in the room which
+	// were sent before the given timestamp and haven't been erased yet.
+	ExpiredEventNIDs(ctx context.Context, roomNID types.RoomNID, before gomatrixserverlib.Timestamp, limit int) ([]types.EventNID, error)
+
+	// InsertionEventForBatch returns the ID of the MSC2716 insertion event in the room with the
+	// given next batch ID, or an empty string if there is no such event.
+	InsertionEventForBatch(ctx context.Context, roomNID types.RoomNID, batchID string) (string, error)
+	// BatchEventsForBatch returns the IDs of the MSC2716 batch events in the room which connect
+	// to the insertion event with the given next batch ID.
+	BatchEventsForBatch(ctx context.Context, roomNID types.RoomNID, batchID string) ([]string, error)
+
 	GetHistoryVisibilityState(ctx context.Context, roomInfo *types.RoomInfo, eventID string, domain string) ([]*gomatrixserverlib.Event, error)
 }
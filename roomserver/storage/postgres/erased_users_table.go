@@ -0,0 +1,104 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+)
+
+const erasedUsersSchema = `
+-- Stores which local users have been erased (GDPR right to be forgotten).
+-- The events sent by these users are redacted, and the erasure is tracked
+-- here so that it can be resumed if it is interrupted.
+CREATE TABLE IF NOT EXISTS roomserver_erased_users (
+    -- The user ID of the erased user
+    user_id TEXT NOT NULL PRIMARY KEY,
+    -- Whether all of the user's events have been redacted yet
+    completed BOOLEAN NOT NULL DEFAULT FALSE
+);
+`
+
+const insertErasedUserSQL = "" +
+	"INSERT INTO roomserver_erased_users (user_id, completed) VALUES ($1, FALSE)" +
+	" ON CONFLICT (user_id) DO UPDATE SET completed = FALSE"
+
+const updateErasedUserCompletedSQL = "" +
+	"UPDATE roomserver_erased_users SET completed = TRUE WHERE user_id = $1"
+
+const selectIncompleteErasedUsersSQL = "" +
+	"SELECT user_id FROM roomserver_erased_users WHERE completed = FALSE"
+
+type erasedUsersStatements struct {
+	insertErasedUserStmt            *sql.Stmt
+	updateErasedUserCompletedStmt   *sql.Stmt
+	selectIncompleteErasedUsersStmt *sql.Stmt
+}
+
+func CreateErasedUsersTable(db *sql.DB) error {
+	_, err := db.Exec(erasedUsersSchema)
+	return err
+}
+
+func PrepareErasedUsersTable(db *sql.DB) (tables.ErasedUsers, error) {
+	s := &erasedUsersStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.insertErasedUserStmt, insertErasedUserSQL},
+		{&s.updateErasedUserCompletedStmt, updateErasedUserCompletedSQL},
+		{&s.selectIncompleteErasedUsersStmt, selectIncompleteErasedUsersSQL},
+	}.Prepare(db)
+}
+
+func (s *erasedUsersStatements) InsertErasedUser(
+	ctx context.Context, txn *sql.Tx, userID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertErasedUserStmt)
+	_, err := stmt.ExecContext(ctx, userID)
+	return err
+}
+
+func (s *erasedUsersStatements) UpdateErasedUserCompleted(
+	ctx context.Context, txn *sql.Tx, userID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateErasedUserCompletedStmt)
+	_, err := stmt.ExecContext(ctx, userID)
+	return err
+}
+
+func (s *erasedUsersStatements) SelectIncompleteErasedUsers(
+	ctx context.Context, txn *sql.Tx,
+) ([]string, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectIncompleteErasedUsersStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectIncompleteErasedUsersStmt: rows.close() failed")
+
+	var userIDs []string
+	var userID string
+	for rows.Next() {
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
@@ -147,6 +147,24 @@ const selectRoomNIDsForEventNIDsSQL = "" +
 const selectEventRejectedSQL = "" +
 	"SELECT is_rejected FROM roomserver_events WHERE room_nid = $1 AND event_id = $2"
 
+// The sender isn't stored in the events table, so look into the event JSON
+// instead. This is slow, but is only used for infrequent admin operations.
+const selectEventNIDsForSenderSQL = "" +
+	"SELECT e.event_nid FROM roomserver_events e" +
+	" JOIN roomserver_event_json j ON j.event_nid = e.event_nid" +
+	" WHERE e.room_nid = $1 AND e.is_rejected = FALSE AND (j.event_json::json)->>'sender' = $2" +
+	" ORDER BY e.event_nid ASC"
+
+// The timestamp isn't stored in the events table either. Events which have
+// already been erased have empty content, so they are skipped.
+const selectExpiredEventNIDsSQL = "" +
+	"SELECT e.event_nid FROM roomserver_events e" +
+	" JOIN roomserver_event_json j ON j.event_nid = e.event_nid" +
+	" WHERE e.room_nid = $1 AND e.event_state_key_nid = 0" +
+	" AND ((j.event_json::json)->>'origin_server_ts')::bigint < $2" +
+	" AND (j.event_json::jsonb)->'content' <> '{}'::jsonb" +
+	" ORDER BY e.event_nid ASC LIMIT $3"
+
 type eventStatements struct {
 	insertEventStmt                               *sql.Stmt
 	selectEventStmt                               *sql.Stmt
@@ -166,6 +184,8 @@ type eventStatements struct {
 	selectMaxEventDepthStmt                       *sql.Stmt
 	selectRoomNIDsForEventNIDsStmt                *sql.Stmt
 	selectEventRejectedStmt                       *sql.Stmt
+	selectEventNIDsForSenderStmt                  *sql.Stmt
+	selectExpiredEventNIDsStmt                    *sql.Stmt
 }
 
 func CreateEventsTable(db *sql.DB) error {
@@ -195,6 +215,8 @@ func PrepareEventsTable(db *sql.DB) (tables.Events, error) {
 		{&s.selectMaxEventDepthStmt, selectMaxEventDepthSQL},
 		{&s.selectRoomNIDsForEventNIDsStmt, selectRoomNIDsForEventNIDsSQL},
 		{&s.selectEventRejectedStmt, selectEventRejectedSQL},
+		{&s.selectEventNIDsForSenderStmt, selectEventNIDsForSenderSQL},
+		{&s.selectExpiredEventNIDsStmt, selectExpiredEventNIDsSQL},
 	}.Prepare(db)
 }
 
@@ -571,3 +593,43 @@ func (s *eventStatements) SelectEventRejected(
 	err = stmt.QueryRowContext(ctx, roomNID, eventID).Scan(&rejected)
 	return
 }
+
+func (s *eventStatements) SelectEventNIDsForSender(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, sender string,
+) ([]types.EventNID, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventNIDsForSenderStmt)
+	rows, err := stmt.QueryContext(ctx, roomNID, sender)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventNIDsForSenderStmt: rows.close() failed")
+	var result []types.EventNID
+	var eventNID types.EventNID
+	for rows.Next() {
+		if err = rows.Scan(&eventNID); err != nil {
+			return nil, err
+		}
+		result = append(result, eventNID)
+	}
+	return result, rows.Err()
+}
+
+func (s *eventStatements) SelectExpiredEventNIDs(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, before gomatrixserverlib.Timestamp, limit int,
+) ([]types.EventNID, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectExpiredEventNIDsStmt)
+	rows, err := stmt.QueryContext(ctx, roomNID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectExpiredEventNIDsStmt: rows.close() failed")
+	var result []types.EventNID
+	var eventNID types.EventNID
+	for rows.Next() {
+		if err = rows.Scan(&eventNID); err != nil {
+			return nil, err
+		}
+		result = append(result, eventNID)
+	}
+	return result, rows.Err()
+}
@@ -132,6 +132,12 @@ func (d *Database) create(db *sql.DB) error {
 	if err := CreateRedactionsTable(db); err != nil {
 		return err
 	}
+	if err := CreateErasedUsersTable(db); err != nil {
+		return err
+	}
+	if err := CreateHistoricalBatchesTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -189,23 +195,33 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 	if err != nil {
 		return err
 	}
+	erasedUsers, err := PrepareErasedUsersTable(db)
+	if err != nil {
+		return err
+	}
+	historicalBatches, err := PrepareHistoricalBatchesTable(db)
+	if err != nil {
+		return err
+	}
 	d.Database = shared.Database{
-		DB:                  db,
-		Cache:               cache,
-		Writer:              writer,
-		EventTypesTable:     eventTypes,
-		EventStateKeysTable: eventStateKeys,
-		EventJSONTable:      eventJSON,
-		EventsTable:         events,
-		RoomsTable:          rooms,
-		StateBlockTable:     stateBlock,
-		StateSnapshotTable:  stateSnapshot,
-		PrevEventsTable:     prevEvents,
-		RoomAliasesTable:    roomAliases,
-		InvitesTable:        invites,
-		MembershipTable:     membership,
-		PublishedTable:      published,
-		RedactionsTable:     redactions,
+		DB:                     db,
+		Cache:                  cache,
+		Writer:                 writer,
+		EventTypesTable:        eventTypes,
+		EventStateKeysTable:    eventStateKeys,
+		EventJSONTable:         eventJSON,
+		EventsTable:            events,
+		RoomsTable:             rooms,
+		StateBlockTable:        stateBlock,
+		StateSnapshotTable:     stateSnapshot,
+		PrevEventsTable:        prevEvents,
+		RoomAliasesTable:       roomAliases,
+		InvitesTable:           invites,
+		MembershipTable:        membership,
+		PublishedTable:         published,
+		RedactionsTable:        redactions,
+		ErasedUsersTable:       erasedUsers,
+		HistoricalBatchesTable: historicalBatches,
 	}
 	return nil
 }
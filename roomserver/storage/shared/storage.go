@@ -13,6 +13,7 @@ import (
 
 	"github.com/matrix-org/dendrite/internal/caching"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 	"github.com/matrix-org/dendrite/roomserver/types"
 )
@@ -27,23 +28,25 @@ import (
 const redactionsArePermanent = true
 
 type Database struct {
-	DB                  *sql.DB
-	Cache               caching.RoomServerCaches
-	Writer              sqlutil.Writer
-	EventsTable         tables.Events
-	EventJSONTable      tables.EventJSON
-	EventTypesTable     tables.EventTypes
-	EventStateKeysTable tables.EventStateKeys
-	RoomsTable          tables.Rooms
-	StateSnapshotTable  tables.StateSnapshot
-	StateBlockTable     tables.StateBlock
-	RoomAliasesTable    tables.RoomAliases
-	PrevEventsTable     tables.PreviousEvents
-	InvitesTable        tables.Invites
-	MembershipTable     tables.Membership
-	PublishedTable      tables.Published
-	RedactionsTable     tables.Redactions
-	GetRoomUpdaterFn    func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
+	DB                     *sql.DB
+	Cache                  caching.RoomServerCaches
+	Writer                 sqlutil.Writer
+	EventsTable            tables.Events
+	EventJSONTable         tables.EventJSON
+	EventTypesTable        tables.EventTypes
+	EventStateKeysTable    tables.EventStateKeys
+	RoomsTable             tables.Rooms
+	StateSnapshotTable     tables.StateSnapshot
+	StateBlockTable        tables.StateBlock
+	RoomAliasesTable       tables.RoomAliases
+	PrevEventsTable        tables.PreviousEvents
+	InvitesTable           tables.Invites
+	MembershipTable        tables.Membership
+	PublishedTable         tables.Published
+	RedactionsTable        tables.Redactions
+	ErasedUsersTable       tables.ErasedUsers
+	HistoricalBatchesTable tables.HistoricalBatches
+	GetRoomUpdaterFn       func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
 }
 
 func (d *Database) SupportsConcurrentRoomInputs() bool {
@@ -670,6 +673,9 @@ func (d *Database) storeEvent(
 			if err != nil {
 				return fmt.Errorf("d.handleRedactions: %w", err)
 			}
+			if err = d.handleHistoricalBatch(ctx, txn, roomNID, event); err != nil {
+				return fmt.Errorf("d.handleHistoricalBatch: %w", err)
+			}
 		}
 		return nil
 	})
@@ -722,9 +728,9 @@ func (d *Database) storeEvent(
 	}, redactionEvent, redactedEventID, err
 }
 
-func (d *Database) PublishRoom(ctx context.Context, roomID string, publish bool) error {
+func (d *Database) PublishRoom(ctx context.Context, roomID, appserviceID, networkID string, publish bool) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.PublishedTable.UpsertRoomPublished(ctx, txn, roomID, publish)
+		return d.PublishedTable.UpsertRoomPublished(ctx, txn, roomID, appserviceID, networkID, publish)
 	})
 }
 
@@ -732,8 +738,62 @@ func (d *Database) GetPublishedRoom(ctx context.Context, roomID string) (bool, e
 	return d.PublishedTable.SelectPublishedFromRoomID(ctx, nil, roomID)
 }
 
-func (d *Database) GetPublishedRooms(ctx context.Context) ([]string, error) {
-	return d.PublishedTable.SelectAllPublishedRooms(ctx, nil, true)
+func (d *Database) GetPublishedRooms(ctx context.Context, appserviceID, networkID string, includeAllNetworks bool) ([]string, error) {
+	return d.PublishedTable.SelectAllPublishedRooms(ctx, nil, appserviceID, networkID, true, includeAllNetworks)
+}
+
+// MarkUserErased records that the given user has been erased. The erasure
+// is marked as incomplete until MarkUserErasureComplete is called.
+func (d *Database) MarkUserErased(ctx context.Context, userID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.ErasedUsersTable.InsertErasedUser(ctx, txn, userID)
+	})
+}
+
+func (d *Database) MarkUserErasureComplete(ctx context.Context, userID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.ErasedUsersTable.UpdateErasedUserCompleted(ctx, txn, userID)
+	})
+}
+
+func (d *Database) GetIncompleteUserErasures(ctx context.Context) ([]string, error) {
+	return d.ErasedUsersTable.SelectIncompleteErasedUsers(ctx, nil)
+}
+
+func (d *Database) InsertionEventForBatch(ctx context.Context, roomNID types.RoomNID, batchID string) (string, error) {
+	return d.HistoricalBatchesTable.SelectInsertionEventID(ctx, nil, roomNID, batchID)
+}
+
+func (d *Database) BatchEventsForBatch(ctx context.Context, roomNID types.RoomNID, batchID string) ([]string, error) {
+	return d.HistoricalBatchesTable.SelectBatchEventIDs(ctx, nil, roomNID, batchID)
+}
+
+func (d *Database) EventNIDsForSender(ctx context.Context, roomNID types.RoomNID, sender string) ([]types.EventNID, error) {
+	return d.EventsTable.SelectEventNIDsForSender(ctx, nil, roomNID, sender)
+}
+
+func (d *Database) ExpiredEventNIDs(ctx context.Context, roomNID types.RoomNID, before gomatrixserverlib.Timestamp, limit int) ([]types.EventNID, error) {
+	return d.EventsTable.SelectExpiredEventNIDs(ctx, nil, roomNID, before, limit)
+}
+
+// EraseEvents redacts the stored JSON of the given events in place, without
+// needing a redaction event. This is used to erase the events of erased users
+// so that they are served redacted from then on.
+func (d *Database) EraseEvents(ctx context.Context, eventNIDs []types.EventNID) error {
+	events, err := d.Events(ctx, eventNIDs)
+	if err != nil {
+		return fmt.Errorf("d.Events: %w", err)
+	}
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		for _, event := range events {
+			event.Redact()
+			if err := d.EventJSONTable.InsertEventJSON(ctx, txn, event.EventNID, event.JSON()); err != nil {
+				return fmt.Errorf("d.EventJSONTable.InsertEventJSON: %w", err)
+			}
+			d.Cache.StoreRoomServerEvent(event.EventNID, event.Event)
+		}
+		return nil
+	})
 }
 
 func (d *Database) MissingAuthPrevEvents(
@@ -830,6 +890,37 @@ func extractRoomVersionFromCreateEvent(event *gomatrixserverlib.Event) (
 	return roomVersion, err
 }
 
+// handleHistoricalBatch records MSC2716 insertion and batch events, so that the
+// batches of history that they connect can be found when backfilling.
+func (d *Database) handleHistoricalBatch(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, event *gomatrixserverlib.Event,
+) error {
+	if event.StateKey() != nil {
+		return nil
+	}
+	var isInsertion bool
+	switch event.Type() {
+	case api.MSC2716Insertion:
+		isInsertion = true
+	case api.MSC2716Batch:
+	default:
+		return nil
+	}
+	var content api.MSC2716Content
+	if err := json.Unmarshal(event.Content(), &content); err != nil {
+		// Badly formed content doesn't connect to anything, so just ignore it.
+		return nil
+	}
+	batchID := content.BatchID
+	if isInsertion {
+		batchID = content.NextBatchID
+	}
+	if batchID == "" {
+		return nil
+	}
+	return d.HistoricalBatchesTable.InsertHistoricalBatchEvent(ctx, txn, event.EventID(), roomNID, isInsertion, batchID)
+}
+
 // handleRedactions manages the redacted status of events. There's two cases to consider in order to comply with the spec:
 // "servers should not apply or send redactions to clients until both the redaction event and original event have been seen, and are valid."
 // https://matrix.org/docs/spec/rooms/v3#authorization-rules-for-events
@@ -0,0 +1,116 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+)
+
+const historicalBatchesSchema = `
+-- Stores the MSC2716 insertion and batch events which connect batches of
+-- imported history to each other, so that they can be followed when
+-- backfilling.
+CREATE TABLE IF NOT EXISTS roomserver_historical_batches (
+    -- The ID of the insertion or batch event
+    event_id TEXT NOT NULL PRIMARY KEY,
+    -- The room that the event belongs to
+    room_nid INTEGER NOT NULL,
+    -- Whether the event is an insertion event or a batch event
+    is_insertion BOOLEAN NOT NULL,
+    -- For insertion events, the next batch ID from the event content. For
+    -- batch events, the batch ID that the batch connects to.
+    batch_id TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS roomserver_historical_batches_batch_id ON roomserver_historical_batches(room_nid, batch_id);
+`
+
+const insertHistoricalBatchEventSQL = "" +
+	"INSERT OR IGNORE INTO roomserver_historical_batches (event_id, room_nid, is_insertion, batch_id)" +
+	" VALUES ($1, $2, $3, $4)"
+
+const selectInsertionEventIDSQL = "" +
+	"SELECT event_id FROM roomserver_historical_batches" +
+	" WHERE room_nid = $1 AND batch_id = $2 AND is_insertion = TRUE"
+
+const selectBatchEventIDsSQL = "" +
+	"SELECT event_id FROM roomserver_historical_batches" +
+	" WHERE room_nid = $1 AND batch_id = $2 AND is_insertion = FALSE"
+
+type historicalBatchesStatements struct {
+	insertHistoricalBatchEventStmt *sql.Stmt
+	selectInsertionEventIDStmt     *sql.Stmt
+	selectBatchEventIDsStmt        *sql.Stmt
+}
+
+func CreateHistoricalBatchesTable(db *sql.DB) error {
+	_, err := db.Exec(historicalBatchesSchema)
+	return err
+}
+
+func PrepareHistoricalBatchesTable(db *sql.DB) (tables.HistoricalBatches, error) {
+	s := &historicalBatchesStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.insertHistoricalBatchEventStmt, insertHistoricalBatchEventSQL},
+		{&s.selectInsertionEventIDStmt, selectInsertionEventIDSQL},
+		{&s.selectBatchEventIDsStmt, selectBatchEventIDsSQL},
+	}.Prepare(db)
+}
+
+func (s *historicalBatchesStatements) InsertHistoricalBatchEvent(
+	ctx context.Context, txn *sql.Tx, eventID string, roomNID types.RoomNID, isInsertion bool, batchID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertHistoricalBatchEventStmt)
+	_, err := stmt.ExecContext(ctx, eventID, roomNID, isInsertion, batchID)
+	return err
+}
+
+func (s *historicalBatchesStatements) SelectInsertionEventID(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, batchID string,
+) (eventID string, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectInsertionEventIDStmt)
+	err = stmt.QueryRowContext(ctx, roomNID, batchID).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return
+}
+
+func (s *historicalBatchesStatements) SelectBatchEventIDs(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, batchID string,
+) ([]string, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectBatchEventIDsStmt)
+	rows, err := stmt.QueryContext(ctx, roomNID, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectBatchEventIDsStmt: rows.close() failed")
+
+	var eventIDs []string
+	var eventID string
+	for rows.Next() {
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, rows.Err()
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3/deltas"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 )
 
@@ -27,31 +28,51 @@ const publishedSchema = `
 -- Stores which rooms are published in the room directory
 CREATE TABLE IF NOT EXISTS roomserver_published (
     -- The room ID of the room
-    room_id TEXT NOT NULL PRIMARY KEY,
+    room_id TEXT NOT NULL,
+    -- The appservice ID of the application service which published the room,
+    -- if it was published to a third party network
+    appservice_id TEXT NOT NULL DEFAULT '',
+    -- The network ID of the third party network the room was published to
+    network_id TEXT NOT NULL DEFAULT '',
     -- Whether it is published or not
-    published BOOLEAN NOT NULL DEFAULT false
+    published BOOLEAN NOT NULL DEFAULT false,
+    -- A room can be published to the main room directory and to any number
+    -- of third party networks independently
+    PRIMARY KEY (room_id, appservice_id, network_id)
 );
 `
 
 const upsertPublishedSQL = "" +
-	"INSERT OR REPLACE INTO roomserver_published (room_id, published) VALUES ($1, $2)"
+	"INSERT OR REPLACE INTO roomserver_published (room_id, appservice_id, network_id, published) VALUES ($1, $2, $3, $4)"
 
 const selectAllPublishedSQL = "" +
-	"SELECT room_id FROM roomserver_published WHERE published = $1 ORDER BY room_id ASC"
+	"SELECT DISTINCT room_id FROM roomserver_published WHERE published = $1 ORDER BY room_id ASC"
+
+const selectNetworkPublishedSQL = "" +
+	"SELECT room_id FROM roomserver_published WHERE published = $1 AND appservice_id = $2 AND network_id = $3 ORDER BY room_id ASC"
 
 const selectPublishedSQL = "" +
-	"SELECT published FROM roomserver_published WHERE room_id = $1"
+	"SELECT published FROM roomserver_published WHERE room_id = $1 AND appservice_id = '' AND network_id = ''"
 
 type publishedStatements struct {
-	db                     *sql.DB
-	upsertPublishedStmt    *sql.Stmt
-	selectAllPublishedStmt *sql.Stmt
-	selectPublishedStmt    *sql.Stmt
+	db                         *sql.DB
+	upsertPublishedStmt        *sql.Stmt
+	selectAllPublishedStmt     *sql.Stmt
+	selectNetworkPublishedStmt *sql.Stmt
+	selectPublishedStmt        *sql.Stmt
 }
 
 func CreatePublishedTable(db *sql.DB) error {
 	_, err := db.Exec(publishedSchema)
-	return err
+	if err != nil {
+		return err
+	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "roomserver: published appservice",
+		Up:      deltas.UpPublishedAppservice,
+	})
+	return m.Up(context.Background())
 }
 
 func PreparePublishedTable(db *sql.DB) (tables.Published, error) {
@@ -62,15 +83,16 @@ func PreparePublishedTable(db *sql.DB) (tables.Published, error) {
 	return s, sqlutil.StatementList{
 		{&s.upsertPublishedStmt, upsertPublishedSQL},
 		{&s.selectAllPublishedStmt, selectAllPublishedSQL},
+		{&s.selectNetworkPublishedStmt, selectNetworkPublishedSQL},
 		{&s.selectPublishedStmt, selectPublishedSQL},
 	}.Prepare(db)
 }
 
 func (s *publishedStatements) UpsertRoomPublished(
-	ctx context.Context, txn *sql.Tx, roomID string, published bool,
+	ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool,
 ) error {
 	stmt := sqlutil.TxStmt(txn, s.upsertPublishedStmt)
-	_, err := stmt.ExecContext(ctx, roomID, published)
+	_, err := stmt.ExecContext(ctx, roomID, appserviceID, networkID, published)
 	return err
 }
 
@@ -86,10 +108,17 @@ func (s *publishedStatements) SelectPublishedFromRoomID(
 }
 
 func (s *publishedStatements) SelectAllPublishedRooms(
-	ctx context.Context, txn *sql.Tx, published bool,
+	ctx context.Context, txn *sql.Tx, appserviceID, networkID string, published, includeAllNetworks bool,
 ) ([]string, error) {
-	stmt := sqlutil.TxStmt(txn, s.selectAllPublishedStmt)
-	rows, err := stmt.QueryContext(ctx, published)
+	var rows *sql.Rows
+	var err error
+	if includeAllNetworks {
+		stmt := sqlutil.TxStmt(txn, s.selectAllPublishedStmt)
+		rows, err = stmt.QueryContext(ctx, published)
+	} else {
+		stmt := sqlutil.TxStmt(txn, s.selectNetworkPublishedStmt)
+		rows, err = stmt.QueryContext(ctx, published, appserviceID, networkID)
+	}
 	if err != nil {
 		return nil, err
 	}
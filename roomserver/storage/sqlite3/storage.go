@@ -140,6 +140,12 @@ func (d *Database) create(db *sql.DB) error {
 	if err := CreateRedactionsTable(db); err != nil {
 		return err
 	}
+	if err := CreateErasedUsersTable(db); err != nil {
+		return err
+	}
+	if err := CreateHistoricalBatchesTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -197,24 +203,34 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 	if err != nil {
 		return err
 	}
+	erasedUsers, err := PrepareErasedUsersTable(db)
+	if err != nil {
+		return err
+	}
+	historicalBatches, err := PrepareHistoricalBatchesTable(db)
+	if err != nil {
+		return err
+	}
 	d.Database = shared.Database{
-		DB:                  db,
-		Cache:               cache,
-		Writer:              writer,
-		EventsTable:         events,
-		EventTypesTable:     eventTypes,
-		EventStateKeysTable: eventStateKeys,
-		EventJSONTable:      eventJSON,
-		RoomsTable:          rooms,
-		StateBlockTable:     stateBlock,
-		StateSnapshotTable:  stateSnapshot,
-		PrevEventsTable:     prevEvents,
-		RoomAliasesTable:    roomAliases,
-		InvitesTable:        invites,
-		MembershipTable:     membership,
-		PublishedTable:      published,
-		RedactionsTable:     redactions,
-		GetRoomUpdaterFn:    d.GetRoomUpdater,
+		DB:                     db,
+		Cache:                  cache,
+		Writer:                 writer,
+		EventsTable:            events,
+		EventTypesTable:        eventTypes,
+		EventStateKeysTable:    eventStateKeys,
+		EventJSONTable:         eventJSON,
+		RoomsTable:             rooms,
+		StateBlockTable:        stateBlock,
+		StateSnapshotTable:     stateSnapshot,
+		PrevEventsTable:        prevEvents,
+		RoomAliasesTable:       roomAliases,
+		InvitesTable:           invites,
+		MembershipTable:        membership,
+		PublishedTable:         published,
+		RedactionsTable:        redactions,
+		ErasedUsersTable:       erasedUsers,
+		HistoricalBatchesTable: historicalBatches,
+		GetRoomUpdaterFn:       d.GetRoomUpdater,
 	}
 	return nil
 }
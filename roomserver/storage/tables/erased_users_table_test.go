@@ -0,0 +1,69 @@
+package tables_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/postgres"
+	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCreateErasedUsersTable(t *testing.T, dbType test.DBType) (tab tables.ErasedUsers, close func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	assert.NoError(t, err)
+	switch dbType {
+	case test.DBTypePostgres:
+		err = postgres.CreateErasedUsersTable(db)
+		assert.NoError(t, err)
+		tab, err = postgres.PrepareErasedUsersTable(db)
+	case test.DBTypeSQLite:
+		err = sqlite3.CreateErasedUsersTable(db)
+		assert.NoError(t, err)
+		tab, err = sqlite3.PrepareErasedUsersTable(db)
+	}
+	assert.NoError(t, err)
+
+	return tab, close
+}
+
+func TestErasedUsersTable(t *testing.T) {
+	ctx := context.Background()
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateErasedUsersTable(t, dbType)
+		defer close()
+
+		// Nobody is erased yet
+		incomplete, err := tab.SelectIncompleteErasedUsers(ctx, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, incomplete)
+
+		assert.NoError(t, tab.InsertErasedUser(ctx, nil, alice.ID))
+		assert.NoError(t, tab.InsertErasedUser(ctx, nil, bob.ID))
+		incomplete, err = tab.SelectIncompleteErasedUsers(ctx, nil)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{alice.ID, bob.ID}, incomplete)
+
+		assert.NoError(t, tab.UpdateErasedUserCompleted(ctx, nil, alice.ID))
+		incomplete, err = tab.SelectIncompleteErasedUsers(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{bob.ID}, incomplete)
+
+		// Erasing again should mark the erasure as incomplete again
+		assert.NoError(t, tab.InsertErasedUser(ctx, nil, alice.ID))
+		incomplete, err = tab.SelectIncompleteErasedUsers(ctx, nil)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{alice.ID, bob.ID}, incomplete)
+	})
+}
@@ -15,7 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func mustCreateEventsTable(t *testing.T, dbType test.DBType) (tables.Events, func()) {
+func mustCreateEventsTable(t *testing.T, dbType test.DBType) (tables.Events, tables.EventJSON, func()) {
 	t.Helper()
 	connStr, close := test.PrepareDBConnectionString(t, dbType)
 	db, err := sqlutil.Open(&config.DatabaseOptions{
@@ -23,19 +23,29 @@ func mustCreateEventsTable(t *testing.T, dbType test.DBType) (tables.Events, fun
 	}, sqlutil.NewExclusiveWriter())
 	assert.NoError(t, err)
 	var tab tables.Events
+	var jsonTab tables.EventJSON
 	switch dbType {
 	case test.DBTypePostgres:
+		// The events table joins against the event JSON table, so it must exist.
+		err = postgres.CreateEventJSONTable(db)
+		assert.NoError(t, err)
+		jsonTab, err = postgres.PrepareEventJSONTable(db)
+		assert.NoError(t, err)
 		err = postgres.CreateEventsTable(db)
 		assert.NoError(t, err)
 		tab, err = postgres.PrepareEventsTable(db)
 	case test.DBTypeSQLite:
+		err = sqlite3.CreateEventJSONTable(db)
+		assert.NoError(t, err)
+		jsonTab, err = sqlite3.PrepareEventJSONTable(db)
+		assert.NoError(t, err)
 		err = sqlite3.CreateEventsTable(db)
 		assert.NoError(t, err)
 		tab, err = sqlite3.PrepareEventsTable(db)
 	}
 	assert.NoError(t, err)
 
-	return tab, close
+	return tab, jsonTab, close
 }
 
 func Test_EventsTable(t *testing.T) {
@@ -43,7 +53,7 @@ func Test_EventsTable(t *testing.T) {
 	room := test.NewRoom(t, alice)
 	ctx := context.Background()
 	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
-		tab, close := mustCreateEventsTable(t, dbType)
+		tab, _, close := mustCreateEventsTable(t, dbType)
 		defer close()
 		// create some dummy data
 		eventIDs := make([]string, 0, len(room.Events()))
@@ -154,3 +164,40 @@ func Test_EventsTable(t *testing.T) {
 		assert.Equal(t, int64(len(room.Events())+1), maxDepth)
 	})
 }
+
+func Test_EventsTableSelectEventNIDsForSender(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+		"membership": "join",
+	}, test.WithStateKey(bob.ID))
+	room.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{"body": "hello"})
+	room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "hi bob"})
+	room.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{"body": "hi alice"})
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, jsonTab, close := mustCreateEventsTable(t, dbType)
+		defer close()
+
+		wantBobNIDs := []types.EventNID{}
+		for _, ev := range room.Events() {
+			eventNID, _, err := tab.InsertEvent(ctx, nil, 1, 1, 1, ev.EventID(), ev.EventReference().EventSHA256, nil, ev.Depth(), false)
+			assert.NoError(t, err)
+			err = jsonTab.InsertEventJSON(ctx, nil, eventNID, ev.JSON())
+			assert.NoError(t, err)
+			if ev.Sender() == bob.ID {
+				wantBobNIDs = append(wantBobNIDs, eventNID)
+			}
+		}
+
+		gotBobNIDs, err := tab.SelectEventNIDsForSender(ctx, nil, 1, bob.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, wantBobNIDs, gotBobNIDs)
+
+		// Events in other rooms shouldn't be returned
+		gotBobNIDs, err = tab.SelectEventNIDsForSender(ctx, nil, 2, bob.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, gotBobNIDs)
+	})
+}
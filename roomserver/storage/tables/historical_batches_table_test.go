@@ -0,0 +1,70 @@
+package tables_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/postgres"
+	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCreateHistoricalBatchesTable(t *testing.T, dbType test.DBType) (tab tables.HistoricalBatches, close func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	assert.NoError(t, err)
+	switch dbType {
+	case test.DBTypePostgres:
+		err = postgres.CreateHistoricalBatchesTable(db)
+		assert.NoError(t, err)
+		tab, err = postgres.PrepareHistoricalBatchesTable(db)
+	case test.DBTypeSQLite:
+		err = sqlite3.CreateHistoricalBatchesTable(db)
+		assert.NoError(t, err)
+		tab, err = sqlite3.PrepareHistoricalBatchesTable(db)
+	}
+	assert.NoError(t, err)
+
+	return tab, close
+}
+
+func TestHistoricalBatchesTable(t *testing.T) {
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateHistoricalBatchesTable(t, dbType)
+		defer close()
+
+		// nothing has been inserted yet
+		eventID, err := tab.SelectInsertionEventID(ctx, nil, 1, "batch1")
+		assert.NoError(t, err)
+		assert.Equal(t, "", eventID)
+
+		assert.NoError(t, tab.InsertHistoricalBatchEvent(ctx, nil, "$insertion1", 1, true, "batch1"))
+		assert.NoError(t, tab.InsertHistoricalBatchEvent(ctx, nil, "$batch1", 1, false, "batch1"))
+		assert.NoError(t, tab.InsertHistoricalBatchEvent(ctx, nil, "$batch2", 1, false, "batch1"))
+		// inserting the same event again is a no-op
+		assert.NoError(t, tab.InsertHistoricalBatchEvent(ctx, nil, "$batch2", 1, false, "batch1"))
+		// the same batch ID in another room doesn't match
+		assert.NoError(t, tab.InsertHistoricalBatchEvent(ctx, nil, "$insertion2", 2, true, "batch1"))
+
+		eventID, err = tab.SelectInsertionEventID(ctx, nil, 1, "batch1")
+		assert.NoError(t, err)
+		assert.Equal(t, "$insertion1", eventID)
+
+		eventIDs, err := tab.SelectBatchEventIDs(ctx, nil, 1, "batch1")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"$batch1", "$batch2"}, eventIDs)
+
+		eventIDs, err = tab.SelectBatchEventIDs(ctx, nil, 2, "batch1")
+		assert.NoError(t, err)
+		assert.Empty(t, eventIDs)
+	})
+}
@@ -67,6 +67,12 @@ type Events interface {
 	SelectMaxEventDepth(ctx context.Context, txn *sql.Tx, eventNIDs []types.EventNID) (int64, error)
 	SelectRoomNIDsForEventNIDs(ctx context.Context, txn *sql.Tx, eventNIDs []types.EventNID) (roomNIDs map[types.EventNID]types.RoomNID, err error)
 	SelectEventRejected(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, eventID string) (rejected bool, err error)
+	// SelectEventNIDsForSender returns the numeric IDs of all non-rejected events in the room
+	// which were sent by the given user, ordered by event NID.
+	SelectEventNIDsForSender(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, sender string) ([]types.EventNID, error)
+	// SelectExpiredEventNIDs returns the numeric IDs of up to limit non-state events in the room
+	// which were sent before the given timestamp and haven't been erased yet, ordered by event NID.
+	SelectExpiredEventNIDs(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, before gomatrixserverlib.Timestamp, limit int) ([]types.EventNID, error)
 }
 
 type Rooms interface {
@@ -146,9 +152,16 @@ type Membership interface {
 }
 
 type Published interface {
-	UpsertRoomPublished(ctx context.Context, txn *sql.Tx, roomID string, published bool) (err error)
+	// UpsertRoomPublished sets whether the room is published to the given third
+	// party network, or to the main room directory if the network is empty,
+	// leaving it as it was in any others.
+	UpsertRoomPublished(ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool) (err error)
+	// SelectPublishedFromRoomID returns whether the room is published to the
+	// main room directory.
 	SelectPublishedFromRoomID(ctx context.Context, txn *sql.Tx, roomID string) (published bool, err error)
-	SelectAllPublishedRooms(ctx context.Context, txn *sql.Tx, published bool) ([]string, error)
+	// SelectAllPublishedRooms returns the rooms published to the given third
+	// party network, or to the main room directory if the network is empty.
+	SelectAllPublishedRooms(ctx context.Context, txn *sql.Tx, appserviceID, networkID string, published, includeAllNetworks bool) ([]string, error)
 }
 
 type RedactionInfo struct {
@@ -171,6 +184,26 @@ type Redactions interface {
 	MarkRedactionValidated(ctx context.Context, txn *sql.Tx, redactionEventID string, validated bool) error
 }
 
+type ErasedUsers interface {
+	// InsertErasedUser marks the user as erased. If the user was already erased then
+	// the erasure is marked as incomplete again, so that it will be re-run.
+	InsertErasedUser(ctx context.Context, txn *sql.Tx, userID string) error
+	UpdateErasedUserCompleted(ctx context.Context, txn *sql.Tx, userID string) error
+	// SelectIncompleteErasedUsers returns the user IDs of erasures which haven't completed yet.
+	SelectIncompleteErasedUsers(ctx context.Context, txn *sql.Tx) ([]string, error)
+}
+
+type HistoricalBatches interface {
+	// InsertHistoricalBatchEvent records an MSC2716 insertion or batch event. For insertion
+	// events the batch ID is the next batch ID from the event content.
+	InsertHistoricalBatchEvent(ctx context.Context, txn *sql.Tx, eventID string, roomNID types.RoomNID, isInsertion bool, batchID string) error
+	// SelectInsertionEventID returns the ID of the insertion event in the room with the given
+	// next batch ID, or an empty string if there is no match.
+	SelectInsertionEventID(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, batchID string) (string, error)
+	// SelectBatchEventIDs returns the IDs of the batch events in the room which connect to the given batch ID.
+	SelectBatchEventIDs(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, batchID string) ([]string, error)
+}
+
 // StrippedEvent represents a stripped event for returning extracted content values.
 type StrippedEvent struct {
 	RoomID       string
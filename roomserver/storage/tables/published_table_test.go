@@ -49,7 +49,7 @@ func TestPublishedTable(t *testing.T) {
 		for i := 0; i < 10; i++ {
 			room := test.NewRoom(t, alice)
 			published := i%2 == 0
-			err := tab.UpsertRoomPublished(ctx, nil, room.ID, published)
+			err := tab.UpsertRoomPublished(ctx, nil, room.ID, "", "", published)
 			assert.NoError(t, err)
 			if published {
 				publishedRooms = append(publishedRooms, room.ID)
@@ -61,18 +61,66 @@ func TestPublishedTable(t *testing.T) {
 		sort.Strings(publishedRooms)
 
 		// check that we get the expected published rooms
-		roomIDs, err := tab.SelectAllPublishedRooms(ctx, nil, true)
+		roomIDs, err := tab.SelectAllPublishedRooms(ctx, nil, "", "", true, false)
 		assert.NoError(t, err)
 		assert.Equal(t, publishedRooms, roomIDs)
 
+		// publish a room to a third party network
+		networkRoom := test.NewRoom(t, alice)
+		err = tab.UpsertRoomPublished(ctx, nil, networkRoom.ID, "irc", "freenode", true)
+		assert.NoError(t, err)
+
+		// it should only be returned when querying that network
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "", "", true, false)
+		assert.NoError(t, err)
+		assert.Equal(t, publishedRooms, roomIDs)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "irc", "freenode", true, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{networkRoom.ID}, roomIDs)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "irc", "libera", true, false)
+		assert.NoError(t, err)
+		assert.Empty(t, roomIDs)
+
+		// or when including all networks
+		allRooms := append([]string{networkRoom.ID}, publishedRooms...)
+		sort.Strings(allRooms)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "", "", true, true)
+		assert.NoError(t, err)
+		assert.Equal(t, allRooms, roomIDs)
+
+		// publishing a room to a network doesn't change whether it is in the
+		// main directory, and vice versa
+		err = tab.UpsertRoomPublished(ctx, nil, publishedRooms[0], "irc", "freenode", true)
+		assert.NoError(t, err)
+		publishedRes, err := tab.SelectPublishedFromRoomID(ctx, nil, publishedRooms[0])
+		assert.NoError(t, err)
+		assert.True(t, publishedRes)
+		err = tab.UpsertRoomPublished(ctx, nil, publishedRooms[0], "irc", "freenode", false)
+		assert.NoError(t, err)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "", "", true, false)
+		assert.NoError(t, err)
+		assert.Equal(t, publishedRooms, roomIDs)
+		err = tab.UpsertRoomPublished(ctx, nil, networkRoom.ID, "", "", false)
+		assert.NoError(t, err)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "irc", "freenode", true, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{networkRoom.ID}, roomIDs)
+
+		// rooms published to several directories are only returned once
+		err = tab.UpsertRoomPublished(ctx, nil, networkRoom.ID, "", "", true)
+		assert.NoError(t, err)
+		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "", "", true, true)
+		assert.NoError(t, err)
+		assert.Equal(t, allRooms, roomIDs)
+
 		// test an actual upsert
 		room := test.NewRoom(t, alice)
-		err = tab.UpsertRoomPublished(ctx, nil, room.ID, true)
+		err = tab.UpsertRoomPublished(ctx, nil, room.ID, "", "", true)
 		assert.NoError(t, err)
-		err = tab.UpsertRoomPublished(ctx, nil, room.ID, false)
+		err = tab.UpsertRoomPublished(ctx, nil, room.ID, "", "", false)
 		assert.NoError(t, err)
 		// should now be false, due to the upsert
-		publishedRes, err := tab.SelectPublishedFromRoomID(ctx, nil, room.ID)
+		publishedRes, err = tab.SelectPublishedFromRoomID(ctx, nil, room.ID)
 		assert.NoError(t, err)
 		assert.False(t, publishedRes)
 	})
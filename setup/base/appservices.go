@@ -0,0 +1,66 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+)
+
+// WatchAppServiceRegistrations keeps the application services known to this
+// process up to date with the ones registered at runtime using the admin API.
+// Components which use the application services should call this when they
+// start up. It only subscribes once, however many components call it.
+func (b *BaseDendrite) WatchAppServiceRegistrations() {
+	b.appServicesOnce.Do(func() {
+		js, _ := b.NATS.Prepare(b.ProcessContext, &b.Cfg.Global.JetStream)
+		sub, err := js.Subscribe(
+			b.Cfg.Global.JetStream.Prefixed(jetstream.OutputAppserviceRegistrations),
+			func(msg *nats.Msg) {
+				var output appserviceAPI.OutputAppserviceRegistrations
+				if err := json.Unmarshal(msg.Data, &output); err != nil {
+					logrus.WithError(err).Error("Failed to parse appservice registrations")
+					return
+				}
+				appservices := make([]config.ApplicationService, 0, len(output.Registrations))
+				for _, registration := range output.Registrations {
+					appservice, err := config.ParseApplicationService([]byte(registration))
+					if err != nil {
+						logrus.WithError(err).Error("Failed to parse appservice registration")
+						return
+					}
+					appservices = append(appservices, *appservice)
+				}
+				if err := b.Cfg.AppServiceAPI.SetRuntimeAppServices(appservices); err != nil {
+					logrus.WithError(err).Error("Failed to update appservice registrations")
+				}
+			},
+			nats.DeliverLast(), nats.AckNone(),
+		)
+		if err != nil {
+			logrus.WithError(err).Panic("failed to subscribe to appservice registrations")
+		}
+		go func() {
+			<-b.ProcessContext.Context().Done()
+			_ = sub.Unsubscribe()
+		}()
+	})
+}
@@ -43,6 +43,7 @@ import (
 	"github.com/matrix-org/dendrite/internal/fulltext"
 	"github.com/matrix-org/dendrite/internal/httputil"
 	"github.com/matrix-org/dendrite/internal/pushgateway"
+	"github.com/matrix-org/dendrite/internal/spamcheck"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 
 	"github.com/gorilla/mux"
@@ -93,6 +94,7 @@ type BaseDendrite struct {
 	EnableMetrics          bool
 	Fulltext               *fulltext.Search
 	startupLock            sync.Mutex
+	appServicesOnce        sync.Once
 }
 
 const NoListener = ""
@@ -176,6 +178,8 @@ func NewBaseDendrite(cfg *config.Dendrite, componentName string, options ...Base
 		}
 	}
 
+	spamcheck.Setup(&cfg.Global.SpamChecker)
+
 	var dnsCache *gomatrixserverlib.DNSCache
 	if cfg.Global.DNSCache.Enabled {
 		dnsCache = gomatrixserverlib.NewDNSCache(
@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -111,6 +112,16 @@ type Derived struct {
 	ExclusiveApplicationServicesAliasRegexp *regexp.Regexp
 	// Note: An Exclusive Regex for room ID isn't necessary as we aren't blocking
 	// servers from creating RoomIDs in exclusive application service namespaces
+
+	// Application services can also be registered at runtime, so the fields
+	// above must be accessed through AppServices() and friends, which take
+	// this lock.
+	appServicesMutex sync.RWMutex
+	// The application services loaded from the config files, which are
+	// combined with the runtime registrations.
+	configAppServices []ApplicationService
+	// Called whenever the runtime registrations change.
+	appServicesChanged []func()
 }
 
 type InternalAPIOptions struct {
@@ -294,7 +305,6 @@ func (config *Dendrite) Derive() error {
 
 	config.Derived.Registration.Params = make(map[string]interface{})
 
-	// TODO: Add email auth type
 	// TODO: Add MSISDN auth type
 
 	if config.ClientAPI.RecaptchaEnabled {
@@ -306,6 +316,30 @@ func (config *Dendrite) Derive() error {
 			authtypes.Flow{Stages: []authtypes.LoginType{authtypes.LoginTypeDummy}})
 	}
 
+	// Users must validate an email address as part of every flow if required,
+	// otherwise they can choose flows which do.
+	if config.Global.EmailValidation.Enabled {
+		var emailFlows []authtypes.Flow
+		for _, flow := range config.Derived.Registration.Flows {
+			emailFlows = append(emailFlows, authtypes.Flow{
+				Stages: append([]authtypes.LoginType{authtypes.LoginTypeEmail}, flow.Stages...),
+			})
+		}
+		if config.Global.EmailValidation.RequireAtRegistration {
+			config.Derived.Registration.Flows = emailFlows
+		} else {
+			config.Derived.Registration.Flows = append(config.Derived.Registration.Flows, emailFlows...)
+		}
+	}
+
+	// Users must consent to the terms of service as part of every flow.
+	if consent := config.Global.UserConsent; consent.Enabled && consent.RequireAtRegistration {
+		config.Derived.Registration.Params[authtypes.LoginTypeTerms] = consent.TermsParams()
+		for i := range config.Derived.Registration.Flows {
+			config.Derived.Registration.Flows[i].Stages = append(config.Derived.Registration.Flows[i].Stages, authtypes.LoginTypeTerms)
+		}
+	}
+
 	// Load application service configuration files
 	if err := loadAppServices(&config.AppServiceAPI, &config.Derived); err != nil {
 		return err
@@ -365,6 +399,8 @@ func (c *Dendrite) Wiring() {
 	c.ClientAPI.Derived = &c.Derived
 	c.AppServiceAPI.Derived = &c.Derived
 	c.ClientAPI.MSCs = &c.MSCs
+	c.ClientAPI.FederationAPI = &c.FederationAPI
+	c.MediaAPI.FederationAPI = &c.FederationAPI
 }
 
 // Error returns a string detailing how many errors were contained within a
@@ -36,12 +36,22 @@ type AppServiceAPI struct {
 	DisableTLSValidation bool `yaml:"disable_tls_validation"`
 
 	ConfigFiles []string `yaml:"config_files"`
+
+	// The database which stores the application services registered at
+	// runtime using the admin API.
+	Database DatabaseOptions `yaml:"database,omitempty"`
 }
 
 func (c *AppServiceAPI) Defaults(opts DefaultOpts) {
 	if !opts.Monolithic {
 		c.InternalAPI.Listen = "http://localhost:7777"
 		c.InternalAPI.Connect = "http://localhost:7777"
+		c.Database.Defaults(5)
+	}
+	if opts.Generate {
+		if !opts.Monolithic {
+			c.Database.ConnectionString = "file:appservice.db"
+		}
 	}
 }
 
@@ -49,6 +59,9 @@ func (c *AppServiceAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	if isMonolith { // polylith required configs below
 		return
 	}
+	if c.Matrix.DatabaseOptions.ConnectionString == "" {
+		checkNotEmpty(configErrs, "app_service_api.database.connection_string", string(c.Database.ConnectionString))
+	}
 	checkURL(configErrs, "app_service_api.internal_api.listen", string(c.InternalAPI.Listen))
 	checkURL(configErrs, "app_service_api.internal_api.connect", string(c.InternalAPI.Connect))
 }
@@ -69,7 +82,7 @@ type ApplicationServiceNamespace struct {
 	// trivial.
 	GroupID string `yaml:"group_id"`
 	// Regex object representing our pattern. Saves having to recompile every time
-	RegexpObject *regexp.Regexp
+	RegexpObject *regexp.Regexp `yaml:"-" json:"-"`
 }
 
 // ApplicationService represents a Matrix application service.
@@ -92,6 +105,126 @@ type ApplicationService struct {
 	RateLimited bool `yaml:"rate_limited"`
 	// Any custom protocols that this application service provides (e.g. IRC)
 	Protocols []string `yaml:"protocols"`
+	// Whether the application service should receive ephemeral events, such as
+	// typing notifications, receipts, presence and send-to-device messages.
+	ReceiveEphemeral bool `yaml:"receive_ephemeral"`
+	// The unstable MSC2409 name for ReceiveEphemeral.
+	PushEphemeral bool `yaml:"de.sorunome.msc2409.push_ephemeral"`
+	// Whether the application service should receive device list changes for
+	// users it is interested in, as per MSC3202.
+	DeviceLists bool `yaml:"org.matrix.msc3202"`
+}
+
+// ParseApplicationService parses an application service registration, in
+// the same format as the files listed in config_files.
+func ParseApplicationService(data []byte) (*ApplicationService, error) {
+	// Create a new application service with default options
+	appservice := &ApplicationService{
+		RateLimited: true,
+	}
+	if err := yaml.Unmarshal(data, appservice); err != nil {
+		return nil, err
+	}
+	return appservice, nil
+}
+
+// clone returns a copy of the application service which doesn't share its
+// namespaces with the original, as setupRegexps modifies them.
+func (a ApplicationService) clone() ApplicationService {
+	namespaces := make(map[string][]ApplicationServiceNamespace, len(a.NamespaceMap))
+	for key, namespaceSlice := range a.NamespaceMap {
+		namespaces[key] = append([]ApplicationServiceNamespace(nil), namespaceSlice...)
+	}
+	a.NamespaceMap = namespaces
+	a.Protocols = append([]string(nil), a.Protocols...)
+	return a
+}
+
+// AppServices returns all of the application services, both those loaded
+// from the config files and those registered at runtime.
+func (d *Derived) AppServices() []ApplicationService {
+	d.appServicesMutex.RLock()
+	defer d.appServicesMutex.RUnlock()
+	return d.ApplicationServices
+}
+
+// ExclusiveAppServiceUsernameRegexp returns the regex matching all of the
+// exclusive user namespaces of the application services.
+func (d *Derived) ExclusiveAppServiceUsernameRegexp() *regexp.Regexp {
+	d.appServicesMutex.RLock()
+	defer d.appServicesMutex.RUnlock()
+	return d.ExclusiveApplicationServicesUsernameRegexp
+}
+
+// IsConfigAppService returns true if the application service with the given
+// ID was loaded from the config files, rather than registered at runtime.
+func (d *Derived) IsConfigAppService(appserviceID string) bool {
+	d.appServicesMutex.RLock()
+	defer d.appServicesMutex.RUnlock()
+	for _, appservice := range d.configAppServices {
+		if appservice.ID == appserviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// OnAppServicesChanged registers a function which is called whenever the
+// application services registered at runtime change.
+func (d *Derived) OnAppServicesChanged(f func()) {
+	d.appServicesMutex.Lock()
+	defer d.appServicesMutex.Unlock()
+	d.appServicesChanged = append(d.appServicesChanged, f)
+}
+
+// VerifyRuntimeAppServices checks that the given application services can be
+// registered at runtime alongside the ones from the config files.
+func (c *AppServiceAPI) VerifyRuntimeAppServices(appservices []ApplicationService) error {
+	_, err := c.deriveAppServices(appservices)
+	return err
+}
+
+// SetRuntimeAppServices replaces the application services registered at
+// runtime, recompiling the namespace regexes.
+func (c *AppServiceAPI) SetRuntimeAppServices(appservices []ApplicationService) error {
+	derived, err := c.deriveAppServices(appservices)
+	if err != nil {
+		return err
+	}
+	c.Derived.appServicesMutex.Lock()
+	c.Derived.ApplicationServices = derived.ApplicationServices
+	c.Derived.ExclusiveApplicationServicesUsernameRegexp = derived.ExclusiveApplicationServicesUsernameRegexp
+	c.Derived.ExclusiveApplicationServicesAliasRegexp = derived.ExclusiveApplicationServicesAliasRegexp
+	changed := c.Derived.appServicesChanged
+	c.Derived.appServicesMutex.Unlock()
+	for _, f := range changed {
+		f()
+	}
+	return nil
+}
+
+// deriveAppServices combines the application services from the config files
+// with the given runtime ones, checks them and compiles their regexes.
+func (c *AppServiceAPI) deriveAppServices(appservices []ApplicationService) (*Derived, error) {
+	derived := &Derived{}
+	c.Derived.appServicesMutex.RLock()
+	for _, appservice := range c.Derived.configAppServices {
+		derived.ApplicationServices = append(derived.ApplicationServices, appservice.clone())
+	}
+	c.Derived.appServicesMutex.RUnlock()
+	for _, appservice := range appservices {
+		derived.ApplicationServices = append(derived.ApplicationServices, appservice.clone())
+	}
+	if err := checkErrors(c, derived); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// WantsEphemeral returns true if the application service has asked to
+// receive ephemeral events.
+func (a *ApplicationService) WantsEphemeral() bool {
+	return a.ReceiveEphemeral || a.PushEphemeral
 }
 
 // IsInterestedInRoomID returns a bool on whether an application service's
@@ -162,11 +295,6 @@ func (a *ApplicationService) IsInterestedInRoomAlias(
 // and loads their data into the config object for later access.
 func loadAppServices(config *AppServiceAPI, derived *Derived) error {
 	for _, configPath := range config.ConfigFiles {
-		// Create a new application service with default options
-		appservice := ApplicationService{
-			RateLimited: true,
-		}
-
 		// Create an absolute path from a potentially relative path
 		absPath, err := filepath.Abs(configPath)
 		if err != nil {
@@ -180,13 +308,17 @@ func loadAppServices(config *AppServiceAPI, derived *Derived) error {
 		}
 
 		// Load the config data into our struct
-		if err = yaml.Unmarshal(configData, &appservice); err != nil {
+		appservice, err := ParseApplicationService(configData)
+		if err != nil {
 			return err
 		}
 
 		// Append the parsed application service to the global config
 		derived.ApplicationServices = append(
-			derived.ApplicationServices, appservice,
+			derived.ApplicationServices, *appservice,
+		)
+		derived.configAppServices = append(
+			derived.configAppServices, appservice.clone(),
 		)
 	}
 
@@ -348,10 +480,6 @@ func checkErrors(config *AppServiceAPI, derived *Derived) (err error) {
 		if appservice.RateLimited {
 			log.Warn("WARNING: Application service option rate_limited is currently unimplemented")
 		}
-		// TODO: Remove once protocols is implemented
-		if len(appservice.Protocols) > 0 {
-			log.Warn("WARNING: Application service option protocols is currently unimplemented")
-		}
 	}
 
 	return setupRegexps(config, derived)
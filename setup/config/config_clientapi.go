@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"time"
 )
 
@@ -48,7 +50,20 @@ type ClientAPI struct {
 	// Rate-limiting options
 	RateLimiting RateLimiting `yaml:"rate_limiting"`
 
+	// Password policy options
+	PasswordPolicy PasswordPolicy `yaml:"password_policy"`
+
+	// External password authentication options
+	PasswordAuthProvider PasswordAuthProvider `yaml:"password_auth_provider"`
+
+	// JWT login options
+	JWTLogin JWTLogin `yaml:"jwt_login"`
+
 	MSCs *MSCs `yaml:"-"`
+
+	// The federation API config, so that requests made directly to remote
+	// servers honour the allowed and denied servers.
+	FederationAPI *FederationAPI `yaml:"-"`
 }
 
 func (c *ClientAPI) Defaults(opts DefaultOpts) {
@@ -66,11 +81,17 @@ func (c *ClientAPI) Defaults(opts DefaultOpts) {
 	c.RegistrationDisabled = true
 	c.OpenRegistrationWithoutVerificationEnabled = false
 	c.RateLimiting.Defaults()
+	c.PasswordPolicy.Defaults()
+	c.PasswordAuthProvider.Defaults()
+	c.JWTLogin.Defaults()
 }
 
 func (c *ClientAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	c.TURN.Verify(configErrs)
 	c.RateLimiting.Verify(configErrs)
+	c.PasswordPolicy.Verify(configErrs)
+	c.PasswordAuthProvider.Verify(configErrs)
+	c.JWTLogin.Verify(configErrs)
 	if c.RecaptchaEnabled {
 		checkNotEmpty(configErrs, "client_api.recaptcha_public_key", c.RecaptchaPublicKey)
 		checkNotEmpty(configErrs, "client_api.recaptcha_private_key", c.RecaptchaPrivateKey)
@@ -125,6 +146,140 @@ func (c *TURN) Verify(configErrs *ConfigErrors) {
 	}
 }
 
+// PasswordPolicy is checked when users register or change their passwords.
+type PasswordPolicy struct {
+	// Is the password policy enforced? If not, passwords only need to be at
+	// least 8 characters long.
+	Enabled bool `yaml:"enabled"`
+
+	// The minimum number of characters in a password
+	MinimumLength int `yaml:"minimum_length"`
+
+	// Whether passwords must contain at least one character of each class
+	RequireDigit     bool `yaml:"require_digit"`
+	RequireSymbol    bool `yaml:"require_symbol"`
+	RequireLowercase bool `yaml:"require_lowercase"`
+	RequireUppercase bool `yaml:"require_uppercase"`
+
+	// Passwords which are never allowed, compared case-insensitively
+	BannedPasswords []string `yaml:"banned_passwords"`
+
+	// A file of the uppercase hex SHA-1 hashes of breached passwords, one per
+	// line and sorted, such as the "ordered by hash" download from Have I Been
+	// Pwned. Anything after a colon on each line is ignored. Passwords in the
+	// file are never allowed.
+	BreachedPasswordsFile Path `yaml:"breached_passwords_file"`
+}
+
+func (c *PasswordPolicy) Defaults() {
+	c.Enabled = false
+	c.MinimumLength = 8
+}
+
+func (c *PasswordPolicy) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "client_api.password_policy.minimum_length", int64(c.MinimumLength))
+	if c.BreachedPasswordsFile != "" {
+		if _, err := os.Stat(string(c.BreachedPasswordsFile)); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid config key %q: %s", "client_api.password_policy.breached_passwords_file", err))
+		}
+	}
+}
+
+// PasswordAuthProvider delegates password logins to an external HTTP service,
+// such as a directory bridge.
+type PasswordAuthProvider struct {
+	// Are password logins checked by the external service?
+	Enabled bool `yaml:"enabled"`
+
+	// The URL which credentials are POSTed to for checking
+	URL string `yaml:"url"`
+
+	// How long to wait for the service to respond
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Whether to check the password against the local account if the
+	// service doesn't accept it or can't be reached
+	LocalFallback bool `yaml:"local_fallback"`
+}
+
+func (c *PasswordAuthProvider) Defaults() {
+	c.Enabled = false
+	c.Timeout = time.Second * 10
+	c.LocalFallback = true
+}
+
+func (c *PasswordAuthProvider) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkURL(configErrs, "client_api.password_auth_provider.url", c.URL)
+	checkPositive(configErrs, "client_api.password_auth_provider.timeout", int64(c.Timeout))
+}
+
+// JWTLogin allows logging in with JSON web tokens signed by a trusted service,
+// such as a web portal which has already authenticated the user.
+type JWTLogin struct {
+	// Is the org.matrix.login.jwt login type enabled?
+	Enabled bool `yaml:"enabled"`
+
+	// The algorithm tokens must be signed with: HS256, RS256 or ES256. Not
+	// needed if the keys are given as a JWKS file.
+	Algorithm string `yaml:"algorithm"`
+
+	// The shared secret for HS256
+	Secret string `yaml:"secret"`
+
+	// A PEM file containing the public key for RS256 or ES256
+	PublicKeyPath Path `yaml:"public_key_path"`
+
+	// A JSON web key set file containing the keys tokens can be signed with,
+	// instead of the above
+	JWKSPath Path `yaml:"jwks_path"`
+
+	// The claim containing the localpart or user ID of the user
+	SubjectClaim string `yaml:"subject_claim"`
+
+	// If set, tokens must have been issued by this issuer
+	Issuer string `yaml:"issuer"`
+
+	// If set, tokens must be intended for one of these audiences. Tokens with
+	// an audience are rejected if this isn't set.
+	Audiences []string `yaml:"audiences"`
+
+	// Whether to create accounts for users who don't have one yet. Otherwise
+	// only existing users can log in.
+	AutoRegister bool `yaml:"auto_register"`
+}
+
+func (c *JWTLogin) Defaults() {
+	c.Enabled = false
+	c.SubjectClaim = "sub"
+}
+
+func (c *JWTLogin) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "client_api.jwt_login.subject_claim", c.SubjectClaim)
+	if c.JWKSPath != "" {
+		if _, err := os.Stat(string(c.JWKSPath)); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid value for config key 'client_api.jwt_login.jwks_path': %s", err))
+		}
+		return
+	}
+	switch c.Algorithm {
+	case "HS256":
+		checkNotEmpty(configErrs, "client_api.jwt_login.secret", c.Secret)
+	case "RS256", "ES256":
+		checkNotEmpty(configErrs, "client_api.jwt_login.public_key_path", string(c.PublicKeyPath))
+	default:
+		configErrs.Add(fmt.Sprintf("invalid value for config key 'client_api.jwt_login.algorithm': %q, must be HS256, RS256 or ES256", c.Algorithm))
+	}
+}
+
 type RateLimiting struct {
 	// Is rate limiting enabled or disabled?
 	Enabled bool `yaml:"enabled"`
@@ -140,6 +295,51 @@ type RateLimiting struct {
 	// A list of users that are exempt from rate limiting, i.e. if you want
 	// to run Mjolnir or other bots.
 	ExemptUserIDs []string `yaml:"exempt_user_ids"`
+
+	// Classes configures separate token bucket rate limits for classes of
+	// endpoints, e.g. "login" or "join". Other rate-limited endpoints use the
+	// threshold and cooloff above.
+	Classes map[string]RateLimitClass `yaml:"classes"`
+
+	// The reverse proxies, as IP addresses or CIDR ranges, whose
+	// X-Forwarded-For header is trusted to contain the real client IP. The
+	// header is ignored for requests from anywhere else. If none are given
+	// then DefaultTrustedProxies are trusted.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DefaultTrustedProxies are the reverse proxies which are trusted if none are
+// configured, i.e. proxies running on the same host as Dendrite.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// The rate limit classes which can be configured separately.
+const (
+	RateLimitLogin        = "login"
+	RateLimitRegistration = "registration"
+	RateLimitMessage      = "message"
+	RateLimitJoin         = "join"
+	RateLimitInvite       = "invite"
+	RateLimitMediaUpload  = "media_upload"
+	RateLimitThreePID     = "3pid"
+)
+
+// What requests in a rate limit class are counted by.
+const (
+	RateLimitKeyUser   = "user"
+	RateLimitKeyDevice = "device"
+	RateLimitKeyIP     = "ip"
+)
+
+type RateLimitClass struct {
+	// How many requests can be made in quick succession before being limited.
+	Burst int64 `yaml:"burst"`
+
+	// How many requests per second are allowed once the burst is used up.
+	PerSecond float64 `yaml:"per_second"`
+
+	// What requests are counted by: "user", "device" or "ip". Requests
+	// without an access token are always counted by IP address.
+	Key string `yaml:"key"`
 }
 
 func (r *RateLimiting) Verify(configErrs *ConfigErrors) {
@@ -147,10 +347,41 @@ func (r *RateLimiting) Verify(configErrs *ConfigErrors) {
 		checkPositive(configErrs, "client_api.rate_limiting.threshold", r.Threshold)
 		checkPositive(configErrs, "client_api.rate_limiting.cooloff_ms", r.CooloffMS)
 	}
+	for name, class := range r.Classes {
+		switch name {
+		case RateLimitLogin, RateLimitRegistration, RateLimitMessage, RateLimitJoin,
+			RateLimitInvite, RateLimitMediaUpload, RateLimitThreePID:
+		default:
+			configErrs.Add(fmt.Sprintf("unknown rate limit class %q in config key %q", name, "client_api.rate_limiting.classes"))
+		}
+		checkPositive(configErrs, "client_api.rate_limiting.classes."+name+".burst", class.Burst)
+		if class.PerSecond <= 0 {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %v", "client_api.rate_limiting.classes."+name+".per_second", class.PerSecond))
+		}
+		switch class.Key {
+		case RateLimitKeyUser, RateLimitKeyDevice, RateLimitKeyIP:
+		default:
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", "client_api.rate_limiting.classes."+name+".key", class.Key))
+		}
+	}
+	for _, proxy := range r.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			configErrs.Add(fmt.Sprintf("invalid IP address or CIDR range in config key %q: %q", "client_api.rate_limiting.trusted_proxies", proxy))
+		}
+	}
 }
 
 func (r *RateLimiting) Defaults() {
 	r.Enabled = true
 	r.Threshold = 5
 	r.CooloffMS = 500
+	r.Classes = map[string]RateLimitClass{
+		RateLimitLogin:        {Burst: 3, PerSecond: 0.17, Key: RateLimitKeyIP},
+		RateLimitRegistration: {Burst: 3, PerSecond: 0.17, Key: RateLimitKeyIP},
+		RateLimitMessage:      {Burst: 10, PerSecond: 0.2, Key: RateLimitKeyUser},
+		RateLimitJoin:         {Burst: 10, PerSecond: 0.1, Key: RateLimitKeyUser},
+		RateLimitInvite:       {Burst: 5, PerSecond: 0.1, Key: RateLimitKeyUser},
+		RateLimitMediaUpload:  {Burst: 10, PerSecond: 1, Key: RateLimitKeyUser},
+		RateLimitThreePID:     {Burst: 5, PerSecond: 0.05, Key: RateLimitKeyIP},
+	}
 }
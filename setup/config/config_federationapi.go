@@ -1,6 +1,15 @@
 package config
 
-import "github.com/matrix-org/gomatrixserverlib"
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
 
 type FederationAPI struct {
 	Matrix *Global `yaml:"-"`
@@ -33,6 +42,17 @@ type FederationAPI struct {
 
 	// Should we prefer direct key fetches over perspective ones?
 	PreferDirectFetch bool `yaml:"prefer_direct_fetch"`
+
+	// Limits on how many requests remote servers can make to us.
+	RateLimiting FederationRateLimiting `yaml:"rate_limiting"`
+
+	// If not empty, only federate with remote servers matching one of these
+	// server names or wildcard patterns (using * and ?, as in server ACLs).
+	AllowedServers []string `yaml:"allowed_servers"`
+
+	// Never federate with remote servers matching one of these server names
+	// or wildcard patterns, even if they are also in the allowed servers.
+	DeniedServers []string `yaml:"denied_servers"`
 }
 
 func (c *FederationAPI) Defaults(opts DefaultOpts) {
@@ -45,6 +65,7 @@ func (c *FederationAPI) Defaults(opts DefaultOpts) {
 	c.FederationMaxRetries = 16
 	c.DisableTLSValidation = false
 	c.DisableHTTPKeepalives = false
+	c.RateLimiting.Defaults()
 	if opts.Generate {
 		c.KeyPerspectives = KeyPerspectives{
 			{
@@ -68,6 +89,13 @@ func (c *FederationAPI) Defaults(opts DefaultOpts) {
 }
 
 func (c *FederationAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	c.RateLimiting.Verify(configErrs)
+	for i, pattern := range c.AllowedServers {
+		checkNotEmpty(configErrs, fmt.Sprintf("federation_api.allowed_servers[%d]", i), pattern)
+	}
+	for i, pattern := range c.DeniedServers {
+		checkNotEmpty(configErrs, fmt.Sprintf("federation_api.denied_servers[%d]", i), pattern)
+	}
 	if isMonolith { // polylith required configs below
 		return
 	}
@@ -79,6 +107,62 @@ func (c *FederationAPI) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	checkURL(configErrs, "federation_api.internal_api.connect", string(c.InternalAPI.Connect))
 }
 
+// IsFederationAllowed returns true if we are allowed to federate with the
+// given server name according to the allowed and denied servers. Our own
+// server name is always allowed.
+func (c *FederationAPI) IsFederationAllowed(serverName gomatrixserverlib.ServerName) bool {
+	if c.Matrix != nil && serverName == c.Matrix.ServerName {
+		return true
+	}
+	if matchesServerName(c.DeniedServers, serverName) {
+		return false
+	}
+	if len(c.AllowedServers) == 0 {
+		return true
+	}
+	return matchesServerName(c.AllowedServers, serverName)
+}
+
+// serverNamePatterns caches compiled patterns from the allowed and denied
+// servers, keyed by the pattern string.
+var serverNamePatterns sync.Map
+
+func serverNamePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := serverNamePatterns.Load(pattern); ok {
+		return expr.(*regexp.Regexp), nil
+	}
+	escaped := regexp.QuoteMeta(pattern)
+	escaped = strings.Replace(escaped, "\\?", ".", -1)
+	escaped = strings.Replace(escaped, "\\*", ".*", -1)
+	expr, err := regexp.Compile("^" + escaped + "$")
+	if err != nil {
+		return nil, err
+	}
+	serverNamePatterns.Store(pattern, expr)
+	return expr, nil
+}
+
+// matchesServerName returns true if the server name, or the server name
+// without its port, matches any of the patterns.
+func matchesServerName(patterns []string, serverName gomatrixserverlib.ServerName) bool {
+	candidates := []string{string(serverName)}
+	if host, _, err := net.SplitHostPort(string(serverName)); err == nil {
+		candidates = append(candidates, host)
+	}
+	for _, pattern := range patterns {
+		expr, err := serverNamePattern(pattern)
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			if expr.MatchString(candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // The config for setting a proxy to use for server->server requests
 type Proxy struct {
 	// Is the proxy enabled?
@@ -119,3 +203,63 @@ type KeyPerspectiveTrustKey struct {
 	// The public key in base64 unpadded format
 	PublicKey string `yaml:"public_key"`
 }
+
+// FederationRateLimiting limits inbound federation requests from each remote
+// server. Requests beyond the sleep limit within the window are delayed, and
+// requests beyond the reject limit are refused. Only a few requests from each
+// server are processed at once, the rest wait in a queue. Unsigned requests to
+// the notary key endpoint are limited by IP address in the same way.
+type FederationRateLimiting struct {
+	// Is rate limiting enabled or disabled?
+	Enabled bool `yaml:"enabled"`
+
+	// The window over which requests from each server are counted.
+	WindowSize time.Duration `yaml:"window_size"`
+
+	// How many requests a server can make in the window before further
+	// requests are delayed.
+	SleepLimit int `yaml:"sleep_limit"`
+
+	// How long to delay requests by once the sleep limit is reached.
+	SleepDelay time.Duration `yaml:"sleep_delay"`
+
+	// How many requests a server can make in the window before further
+	// requests are rejected.
+	RejectLimit int `yaml:"reject_limit"`
+
+	// How many requests from each server are processed at once.
+	Concurrent int `yaml:"concurrent"`
+
+	// Servers that are exempt from rate limiting.
+	ExemptServers []gomatrixserverlib.ServerName `yaml:"exempt_servers"`
+
+	// The reverse proxies, as IP addresses or CIDR ranges, whose
+	// X-Forwarded-For header is trusted to contain the real address of
+	// requests to the notary key endpoint. If none are listed then
+	// DefaultTrustedProxies are trusted.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+func (r *FederationRateLimiting) Defaults() {
+	r.Enabled = true
+	r.WindowSize = time.Second
+	r.SleepLimit = 10
+	r.SleepDelay = time.Millisecond * 500
+	r.RejectLimit = 50
+	r.Concurrent = 3
+}
+
+func (r *FederationRateLimiting) Verify(configErrs *ConfigErrors) {
+	if !r.Enabled {
+		return
+	}
+	checkPositive(configErrs, "federation_api.rate_limiting.window_size", int64(r.WindowSize))
+	checkPositive(configErrs, "federation_api.rate_limiting.sleep_limit", int64(r.SleepLimit))
+	checkPositive(configErrs, "federation_api.rate_limiting.reject_limit", int64(r.RejectLimit))
+	checkPositive(configErrs, "federation_api.rate_limiting.concurrent", int64(r.Concurrent))
+	for _, proxy := range r.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			configErrs.Add(fmt.Sprintf("invalid IP address or CIDR range in config key %q: %q", "federation_api.rate_limiting.trusted_proxies", proxy))
+		}
+	}
+}
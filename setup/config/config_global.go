@@ -1,7 +1,11 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"math/rand"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -81,6 +85,33 @@ type Global struct {
 
 	// Configuration for the caches.
 	Cache Cache `yaml:"cache"`
+
+	// SpamChecker configures the spam checkers which are consulted before
+	// accepting events, invites, registrations and other user actions.
+	SpamChecker SpamChecker `yaml:"spam_checker"`
+
+	// Retention configures message retention policies (m.room.retention).
+	Retention Retention `yaml:"retention"`
+
+	// MAU configures monthly active user tracking and limits.
+	MAU MAULimits `yaml:"mau"`
+
+	// SMTP configures the mail server used for sending emails to users.
+	SMTP SMTP `yaml:"smtp"`
+
+	// EmailValidation configures validating users' email addresses by sending
+	// them emails directly, rather than through an identity server.
+	EmailValidation EmailValidation `yaml:"email_validation"`
+
+	// AccountValidity configures accounts which expire unless they are renewed.
+	AccountValidity AccountValidity `yaml:"account_validity"`
+
+	// UserConsent configures the terms of service which users must consent to.
+	UserConsent UserConsent `yaml:"user_consent"`
+
+	// LoginLockout configures locking out accounts and IP addresses after
+	// repeated failed login attempts.
+	LoginLockout LoginLockout `yaml:"login_lockout"`
 }
 
 func (c *Global) Defaults(opts DefaultOpts) {
@@ -105,6 +136,14 @@ func (c *Global) Defaults(opts DefaultOpts) {
 	c.ServerNotices.Defaults(opts)
 	c.ReportStats.Defaults()
 	c.Cache.Defaults()
+	c.SpamChecker.Defaults()
+	c.Retention.Defaults()
+	c.MAU.Defaults()
+	c.SMTP.Defaults()
+	c.EmailValidation.Defaults()
+	c.AccountValidity.Defaults()
+	c.UserConsent.Defaults()
+	c.LoginLockout.Defaults()
 }
 
 func (c *Global) Verify(configErrs *ConfigErrors, isMonolith bool) {
@@ -118,6 +157,17 @@ func (c *Global) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	c.ServerNotices.Verify(configErrs, isMonolith)
 	c.ReportStats.Verify(configErrs, isMonolith)
 	c.Cache.Verify(configErrs, isMonolith)
+	c.SpamChecker.Verify(configErrs, isMonolith)
+	c.Retention.Verify(configErrs, isMonolith)
+	c.MAU.Verify(configErrs, isMonolith)
+	c.SMTP.Verify(configErrs, isMonolith)
+	c.EmailValidation.Verify(configErrs, isMonolith)
+	if c.EmailValidation.Enabled && !c.SMTP.Enabled {
+		configErrs.Add("global.email_validation requires global.smtp to be enabled")
+	}
+	c.AccountValidity.Verify(configErrs, isMonolith)
+	c.UserConsent.Verify(configErrs, isMonolith)
+	c.LoginLockout.Verify(configErrs, isMonolith)
 }
 
 type OldVerifyKeys struct {
@@ -221,6 +271,402 @@ func (c *ReportStats) Verify(configErrs *ConfigErrors, isMonolith bool) {
 	}
 }
 
+type SpamChecker struct {
+	// Modules lists the names of in-process spam checkers to enable. These
+	// must have been registered by the binary using spamcheck.Register.
+	Modules []string `yaml:"modules"`
+
+	// HTTP configures a spam checker which is consulted over HTTP.
+	HTTP SpamCheckerHTTP `yaml:"http"`
+}
+
+type SpamCheckerHTTP struct {
+	// Enabled the HTTP spam checker
+	Enabled bool `yaml:"enabled"`
+
+	// URL that check requests will be POSTed to
+	URL string `yaml:"url"`
+
+	// Timeout for each check request
+	Timeout time.Duration `yaml:"timeout"`
+
+	// FailClosed rejects the action if the spam checker can't be reached,
+	// rather than allowing it.
+	FailClosed bool `yaml:"fail_closed"`
+}
+
+func (c *SpamChecker) Defaults() {
+	c.HTTP.Enabled = false
+	c.HTTP.Timeout = time.Second * 5
+	c.HTTP.FailClosed = false
+}
+
+func (c *SpamChecker) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if c.HTTP.Enabled {
+		checkNotEmpty(configErrs, "global.spam_checker.http.url", c.HTTP.URL)
+		checkPositive(configErrs, "global.spam_checker.http.timeout", int64(c.HTTP.Timeout))
+	}
+}
+
+type Retention struct {
+	// Enabled message retention policies. If disabled, m.room.retention state
+	// events are ignored and no events are ever purged.
+	Enabled bool `yaml:"enabled"`
+
+	// DefaultPolicy is applied to rooms which don't have a m.room.retention
+	// state event, or which don't specify a lifetime in it.
+	DefaultPolicy RetentionPolicy `yaml:"default_policy"`
+
+	// AllowedLifetimeMin and AllowedLifetimeMax are the bounds which room
+	// retention policies are clamped to. Zero means no bound.
+	AllowedLifetimeMin time.Duration `yaml:"allowed_lifetime_min"`
+	AllowedLifetimeMax time.Duration `yaml:"allowed_lifetime_max"`
+
+	// PurgeInterval is how often to purge expired events.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+type RetentionPolicy struct {
+	// Events are kept for at least this long.
+	MinLifetime time.Duration `yaml:"min_lifetime"`
+	// Events are purged after this long. Zero means they are kept forever.
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+}
+
+func (c *Retention) Defaults() {
+	c.Enabled = false
+	c.PurgeInterval = time.Hour
+}
+
+func (c *Retention) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "global.retention.purge_interval", int64(c.PurgeInterval))
+	if c.AllowedLifetimeMin > 0 && c.AllowedLifetimeMax > 0 && c.AllowedLifetimeMin > c.AllowedLifetimeMax {
+		configErrs.Add("global.retention.allowed_lifetime_min must not be greater than global.retention.allowed_lifetime_max")
+	}
+	if c.DefaultPolicy.MaxLifetime > 0 && c.DefaultPolicy.MinLifetime > c.DefaultPolicy.MaxLifetime {
+		configErrs.Add("global.retention.default_policy.min_lifetime must not be greater than global.retention.default_policy.max_lifetime")
+	}
+}
+
+type MAULimits struct {
+	// Enabled tracking of monthly active users and enforcing MaxMAU.
+	Enabled bool `yaml:"enabled"`
+
+	// MaxMAU is the maximum number of users which can be active in a 30 day
+	// period. Once it is reached, users who haven't been active recently are
+	// refused with M_RESOURCE_LIMIT_EXCEEDED, as are new registrations.
+	MaxMAU int64 `yaml:"max_mau"`
+
+	// ReservedUsers are user IDs which are always allowed to use the server,
+	// even once the limit has been reached.
+	ReservedUsers []string `yaml:"reserved_users"`
+
+	// AdminContact is a URI (e.g. mailto:admin@example.com) which clients can
+	// show users to contact when the limit has been reached.
+	AdminContact string `yaml:"admin_contact"`
+
+	// WarningThreshold is the percentage of MaxMAU at which active users are
+	// warned with a server notice. Zero disables warnings.
+	WarningThreshold int `yaml:"warning_threshold"`
+}
+
+func (c *MAULimits) Defaults() {
+	c.Enabled = false
+	c.WarningThreshold = 90
+}
+
+func (c *MAULimits) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "global.mau.max_mau", c.MaxMAU)
+	checkNotEmpty(configErrs, "global.mau.admin_contact", c.AdminContact)
+	if c.WarningThreshold < 0 || c.WarningThreshold > 100 {
+		configErrs.Add("global.mau.warning_threshold must be between 0 and 100")
+	}
+}
+
+// IsReservedUser returns true if the user is always allowed to use the
+// server, regardless of the limit.
+func (c *MAULimits) IsReservedUser(userID string) bool {
+	for _, reserved := range c.ReservedUsers {
+		if reserved == userID {
+			return true
+		}
+	}
+	return false
+}
+
+type SMTP struct {
+	// Enabled sending emails. If disabled, no emails are ever sent.
+	Enabled bool `yaml:"enabled"`
+	// Host is the host and port of the SMTP server, e.g. "smtp.example.com:587".
+	Host string `yaml:"host"`
+	// Username and Password are used to authenticate with the SMTP server,
+	// if set.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the address which emails are sent from.
+	From string `yaml:"from"`
+}
+
+func (c *SMTP) Defaults() {
+	c.Enabled = false
+}
+
+func (c *SMTP) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "global.smtp.host", c.Host)
+	checkNotEmpty(configErrs, "global.smtp.from", c.From)
+}
+
+type EmailValidation struct {
+	// Enabled sending validation emails for registration, adding email
+	// addresses to accounts and resetting passwords through the SMTP server,
+	// instead of delegating to an identity server.
+	Enabled bool `yaml:"enabled"`
+
+	// PublicBaseURL is the URL which clients use to reach the server, which is
+	// used to build the validation links, e.g. "https://matrix.example.com".
+	PublicBaseURL string `yaml:"public_base_url"`
+
+	// TokenLifetime is how long validation links can be used for, and how long
+	// validated email addresses can then be used for.
+	TokenLifetime time.Duration `yaml:"token_lifetime"`
+
+	// RequireAtRegistration makes users validate an email address in order
+	// to register. Otherwise they can choose to.
+	RequireAtRegistration bool `yaml:"require_at_registration"`
+
+	// TemplateDir is a directory of templates for the emails, which replace
+	// the built-in ones: "registration.txt", "add_threepid.txt" and
+	// "password_reset.txt". Templates which don't exist use the built-in ones.
+	TemplateDir Path `yaml:"template_dir"`
+}
+
+func (c *EmailValidation) Defaults() {
+	c.Enabled = false
+	c.TokenLifetime = time.Hour
+}
+
+func (c *EmailValidation) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "global.email_validation.public_base_url", c.PublicBaseURL)
+	checkPositive(configErrs, "global.email_validation.token_lifetime", int64(c.TokenLifetime))
+}
+
+type AccountValidity struct {
+	// Enabled account expiry. New accounts, other than admin and appservice
+	// accounts, expire once Period has passed unless they are renewed.
+	Enabled bool `yaml:"enabled"`
+
+	// Period is how long accounts are valid for after being registered or
+	// renewed.
+	Period time.Duration `yaml:"period"`
+
+	// RenewAt is how long before an account expires its user is sent a
+	// reminder with a link for renewing it.
+	RenewAt time.Duration `yaml:"renew_at"`
+
+	// PublicBaseURL is the URL which clients use to reach the server, which is
+	// used to build renewal links, e.g. "https://matrix.example.com".
+	PublicBaseURL string `yaml:"public_base_url"`
+}
+
+func (c *AccountValidity) Defaults() {
+	c.Enabled = false
+	c.RenewAt = time.Hour * 24 * 7
+}
+
+func (c *AccountValidity) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "global.account_validity.period", int64(c.Period))
+	checkNotEmpty(configErrs, "global.account_validity.public_base_url", c.PublicBaseURL)
+}
+
+type UserConsent struct {
+	// Enabled terms of service consent tracking. Users who haven't consented
+	// to the current Version are prevented from sending events and joining
+	// rooms until they do.
+	Enabled bool `yaml:"enabled"`
+
+	// Version is the version of the policy documents which users must consent
+	// to. Changing it requires all users to consent again.
+	Version string `yaml:"version"`
+
+	// Policies are the documents which users are asked to consent to.
+	Policies []UserConsentPolicy `yaml:"policies"`
+
+	// RequireAtRegistration adds an m.login.terms stage to the registration
+	// flows, so that users consent to the policies when registering.
+	RequireAtRegistration bool `yaml:"require_at_registration"`
+
+	// FormSecret is used to sign the links to the consent page, so that users
+	// can consent without logging in.
+	FormSecret string `yaml:"form_secret"`
+
+	// PublicBaseURL is the URL which clients use to reach the server, which is
+	// used to build links to the consent page, e.g. "https://matrix.example.com".
+	PublicBaseURL string `yaml:"public_base_url"`
+
+	// ServerNoticeContent is sent as a server notice to users who haven't
+	// consented to the current version. "%(consent_uri)s" is replaced with the
+	// link to the consent page. No notices are sent if empty.
+	ServerNoticeContent string `yaml:"server_notice_content"`
+
+	// BlockEventsError is the error message returned to users who haven't
+	// consented to the current version. "%(consent_uri)s" is replaced with the
+	// link to the consent page.
+	BlockEventsError string `yaml:"block_events_error"`
+}
+
+type LoginLockout struct {
+	// Enabled tracking failed password logins. Accounts and IP addresses with
+	// too many failures in a row are locked out for a while, which doubles with
+	// each further failure.
+	Enabled bool `yaml:"enabled"`
+
+	// MaxFailures is how many failed logins to an account are allowed before
+	// it is locked out.
+	MaxFailures int `yaml:"max_failures"`
+
+	// MaxFailuresPerIP is how many failed logins from an IP address, to any
+	// account, are allowed before the IP address is locked out.
+	MaxFailuresPerIP int `yaml:"max_failures_per_ip"`
+
+	// BaseDuration is how long the first lockout lasts.
+	BaseDuration time.Duration `yaml:"base_duration"`
+
+	// MaxDuration is the longest a lockout can last. Failures are forgotten
+	// once this long has passed without any more of them.
+	MaxDuration time.Duration `yaml:"max_duration"`
+
+	// NotifyAfter is how many failed logins to an account there must be
+	// before its user is sent a server notice about them. Zero disables
+	// notices.
+	NotifyAfter int `yaml:"notify_after"`
+}
+
+func (c *LoginLockout) Defaults() {
+	c.Enabled = false
+	c.MaxFailures = 5
+	c.MaxFailuresPerIP = 20
+	c.BaseDuration = time.Minute
+	c.MaxDuration = time.Hour * 24
+	c.NotifyAfter = 5
+}
+
+func (c *LoginLockout) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "global.login_lockout.max_failures", int64(c.MaxFailures))
+	checkPositive(configErrs, "global.login_lockout.max_failures_per_ip", int64(c.MaxFailuresPerIP))
+	checkPositive(configErrs, "global.login_lockout.base_duration", int64(c.BaseDuration))
+	checkPositive(configErrs, "global.login_lockout.max_duration", int64(c.MaxDuration))
+	if c.MaxDuration < c.BaseDuration {
+		configErrs.Add("global.login_lockout.max_duration must not be less than global.login_lockout.base_duration")
+	}
+	if c.NotifyAfter < 0 {
+		configErrs.Add("global.login_lockout.notify_after must not be negative")
+	}
+}
+
+// LockoutDuration returns how long to lock out an account or IP address which
+// has had the given number of failures, out of the allowed maxFailures. It is
+// zero until maxFailures is reached, then doubles with each further failure.
+func (c *LoginLockout) LockoutDuration(failures, maxFailures int) time.Duration {
+	if failures < maxFailures {
+		return 0
+	}
+	d := c.BaseDuration
+	for i := maxFailures; i < failures && d < c.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > c.MaxDuration {
+		d = c.MaxDuration
+	}
+	return d
+}
+
+type UserConsentPolicy struct {
+	// ID identifies the policy, e.g. "terms_of_service" or "privacy_policy".
+	ID string `yaml:"id"`
+	// Name is the human-readable name of the policy.
+	Name string `yaml:"name"`
+	// URL is where the policy document can be read.
+	URL string `yaml:"url"`
+	// Language is the language the policy document is written in, defaults
+	// to "en".
+	Language string `yaml:"language"`
+}
+
+func (c *UserConsent) Defaults() {
+	c.Enabled = false
+	c.RequireAtRegistration = true
+	c.BlockEventsError = "You must review and agree to the terms of service before you can continue: %(consent_uri)s"
+}
+
+func (c *UserConsent) Verify(configErrs *ConfigErrors, isMonolith bool) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "global.user_consent.version", c.Version)
+	checkNotEmpty(configErrs, "global.user_consent.form_secret", c.FormSecret)
+	checkNotEmpty(configErrs, "global.user_consent.public_base_url", c.PublicBaseURL)
+	if len(c.Policies) == 0 {
+		configErrs.Add("global.user_consent.policies must contain at least one policy")
+	}
+	for i := range c.Policies {
+		checkNotEmpty(configErrs, "global.user_consent.policies.id", c.Policies[i].ID)
+		checkNotEmpty(configErrs, "global.user_consent.policies.name", c.Policies[i].Name)
+		checkNotEmpty(configErrs, "global.user_consent.policies.url", c.Policies[i].URL)
+	}
+}
+
+// TermsParams returns the parameters of the m.login.terms stage, which list
+// the policies the user must consent to.
+func (c *UserConsent) TermsParams() map[string]interface{} {
+	policies := make(map[string]map[string]interface{}, len(c.Policies))
+	for _, p := range c.Policies {
+		policy, ok := policies[p.ID]
+		if !ok {
+			policy = map[string]interface{}{"version": c.Version}
+			policies[p.ID] = policy
+		}
+		lang := p.Language
+		if lang == "" {
+			lang = "en"
+		}
+		policy[lang] = map[string]string{"name": p.Name, "url": p.URL}
+	}
+	return map[string]interface{}{"policies": policies}
+}
+
+// UserConsentHash signs the user ID for links to the consent page.
+func (c *UserConsent) UserConsentHash(userID string) string {
+	mac := hmac.New(sha256.New, []byte(c.FormSecret))
+	_, _ = mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ConsentURI returns the link to the consent page for the given user.
+func (c *UserConsent) ConsentURI(userID string) string {
+	query := url.Values{}
+	query.Set("u", userID)
+	query.Set("h", c.UserConsentHash(userID))
+	return strings.TrimSuffix(c.PublicBaseURL, "/") + "/_matrix/client/consent?" + query.Encode()
+}
+
 // The configuration to use for Sentry error reporting
 type Sentry struct {
 	Enabled bool `yaml:"enabled"`
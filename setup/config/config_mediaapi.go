@@ -33,6 +33,10 @@ type MediaAPI struct {
 
 	// A list of thumbnail sizes to be pre-generated for downloaded remote / uploaded content
 	ThumbnailSizes []ThumbnailSize `yaml:"thumbnail_sizes"`
+
+	// The federation API config, so that remote media is only fetched from
+	// servers we are allowed to federate with.
+	FederationAPI *FederationAPI `yaml:"-"`
 }
 
 // DefaultMaxFileSizeBytes defines the default file size allowed in transfers
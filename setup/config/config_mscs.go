@@ -5,9 +5,13 @@ type MSCs struct {
 
 	// The MSCs to enable. Supported MSCs include:
 	// 'msc2444': Peeking over federation - https://github.com/matrix-org/matrix-doc/pull/2444
+	// 'msc2716': Importing batches of history from application services - https://github.com/matrix-org/matrix-spec-proposals/pull/2716
 	// 'msc2753': Peeking via /sync - https://github.com/matrix-org/matrix-doc/pull/2753
 	// 'msc2836': Threading - https://github.com/matrix-org/matrix-doc/pull/2836
 	// 'msc2946': Spaces Summary - https://github.com/matrix-org/matrix-doc/pull/2946
+	// 'msc3882': Getting login tokens to sign in other devices - https://github.com/matrix-org/matrix-spec-proposals/pull/3882
+	// 'msc3886': Rendezvous sessions for signing in with QR codes - https://github.com/matrix-org/matrix-spec-proposals/pull/3886
+	//             Sessions are held in the memory of the client API, so only one client API instance is supported.
 	MSCs []string `yaml:"mscs"`
 
 	Database DatabaseOptions `yaml:"database,omitempty"`
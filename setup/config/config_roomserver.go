@@ -6,6 +6,13 @@ type RoomServer struct {
 	InternalAPI InternalAPIOptions `yaml:"internal_api,omitempty"`
 
 	Database DatabaseOptions `yaml:"database,omitempty"`
+
+	// A list of room IDs containing MSC2313 policy rules (m.policy.rule.*) that
+	// this server should enforce. Users and servers matching an m.ban rule will
+	// be prevented from joining or inviting, their federation transactions will
+	// be refused and their media will not be served. A local user must join
+	// these rooms for the rules to be picked up.
+	PolicyListRooms []string `yaml:"policy_list_rooms,omitempty"`
 }
 
 func (c *RoomServer) Defaults(opts DefaultOpts) {
@@ -17,7 +17,9 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/matrix-org/gomatrixserverlib"
 	"gopkg.in/yaml.v2"
 )
 
@@ -290,3 +292,100 @@ func TestUnmarshalDataUnit(t *testing.T) {
 		}
 	}
 }
+
+func TestUserConsentRegistrationFlows(t *testing.T) {
+	cfg := &Dendrite{}
+	cfg.Defaults(DefaultOpts{Generate: true, Monolithic: true})
+	cfg.Global.UserConsent.Enabled = true
+	cfg.Global.UserConsent.Version = "1.0"
+	cfg.Global.UserConsent.Policies = []UserConsentPolicy{
+		{ID: "terms_of_service", Name: "Terms of Service", URL: "https://example.com/terms.html"},
+		{ID: "terms_of_service", Name: "Nutzungsbedingungen", URL: "https://example.com/terms.de.html", Language: "de"},
+	}
+	if err := cfg.Derive(); err != nil {
+		t.Fatal(err)
+	}
+	for _, flow := range cfg.Derived.Registration.Flows {
+		if last := flow.Stages[len(flow.Stages)-1]; last != "m.login.terms" {
+			t.Fatalf("expected flow to end with m.login.terms, got %v", flow.Stages)
+		}
+	}
+	params := cfg.Derived.Registration.Params["m.login.terms"].(map[string]interface{})
+	policy := params["policies"].(map[string]map[string]interface{})["terms_of_service"]
+	if policy["version"] != "1.0" || policy["en"] == nil || policy["de"] == nil {
+		t.Fatalf("unexpected m.login.terms params: %+v", params)
+	}
+}
+
+func TestEmailValidationRegistrationFlows(t *testing.T) {
+	for _, required := range []bool{false, true} {
+		cfg := &Dendrite{}
+		cfg.Defaults(DefaultOpts{Generate: true, Monolithic: true})
+		cfg.Global.EmailValidation.Enabled = true
+		cfg.Global.EmailValidation.RequireAtRegistration = required
+		if err := cfg.Derive(); err != nil {
+			t.Fatal(err)
+		}
+		withEmail := 0
+		for _, flow := range cfg.Derived.Registration.Flows {
+			if flow.Stages[0] == "m.login.email.identity" {
+				withEmail++
+			}
+		}
+		flows := len(cfg.Derived.Registration.Flows)
+		if required && withEmail != flows {
+			t.Fatalf("expected every flow to start with m.login.email.identity, got %+v", cfg.Derived.Registration.Flows)
+		}
+		if !required && withEmail*2 != flows {
+			t.Fatalf("expected a flow with m.login.email.identity for each other flow, got %+v", cfg.Derived.Registration.Flows)
+		}
+	}
+}
+
+func TestLoginLockoutDuration(t *testing.T) {
+	c := LoginLockout{
+		BaseDuration: time.Minute,
+		MaxDuration:  time.Minute * 5,
+	}
+	for failures, want := range map[int]time.Duration{
+		2: 0,
+		3: time.Minute,
+		4: time.Minute * 2,
+		5: time.Minute * 4,
+		6: time.Minute * 5,
+		9: time.Minute * 5,
+	} {
+		if got := c.LockoutDuration(failures, 3); got != want {
+			t.Errorf("LockoutDuration(%d, 3): got %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestIsFederationAllowed(t *testing.T) {
+	c := FederationAPI{
+		Matrix:         &Global{ServerName: "localhost"},
+		AllowedServers: []string{"partner.example", "*.partner.org", "matrix?.corp"},
+		DeniedServers:  []string{"bad.partner.org"},
+	}
+	for serverName, want := range map[gomatrixserverlib.ServerName]bool{
+		"localhost":             true,
+		"partner.example":       true,
+		"partner.example:8448":  true,
+		"notpartner.example":    false,
+		"chat.partner.org":      true,
+		"partner.org":           false,
+		"bad.partner.org":       false,
+		"matrix1.corp":          true,
+		"matrix12.corp":         false,
+		"partner.example.other": false,
+	} {
+		if got := c.IsFederationAllowed(serverName); got != want {
+			t.Errorf("IsFederationAllowed(%q): got %v, want %v", serverName, got, want)
+		}
+	}
+
+	c.AllowedServers = nil
+	if !c.IsFederationAllowed("anyone.example") || c.IsFederationAllowed("bad.partner.org") {
+		t.Fatal("expected only denied servers to be refused without an allow list")
+	}
+}
@@ -31,6 +31,9 @@ var (
 	RequestPresence         = "GetPresence"
 	OutputPresenceEvent     = "OutputPresenceEvent"
 	InputFulltextReindex    = "InputFulltextReindex"
+	OutputUserErasure       = "OutputUserErasure"
+
+	OutputAppserviceRegistrations = "OutputAppserviceRegistrations"
 )
 
 var safeCharacters = regexp.MustCompile("[^A-Za-z0-9$]+")
@@ -95,10 +98,23 @@ var streams = []*nats.StreamConfig{
 		Retention: nats.InterestPolicy,
 		Storage:   nats.FileStorage,
 	},
+	{
+		Name:      OutputUserErasure,
+		Retention: nats.InterestPolicy,
+		Storage:   nats.FileStorage,
+	},
 	{
 		Name:      OutputPresenceEvent,
 		Retention: nats.InterestPolicy,
 		Storage:   nats.MemoryStorage,
 		MaxAge:    time.Minute * 5,
 	},
+	{
+		// Each message contains all of the runtime registrations, so only
+		// the latest one needs to be kept for new subscribers.
+		Name:      OutputAppserviceRegistrations,
+		Retention: nats.LimitsPolicy,
+		Storage:   nats.FileStorage,
+		MaxMsgs:   1,
+	},
 }
@@ -66,7 +66,7 @@ func (m *Monolith) AddAllPublicRoutes(base *base.BaseDendrite) {
 		m.KeyAPI, nil,
 	)
 	mediaapi.AddPublicRoutes(
-		base, m.UserAPI, m.Client,
+		base, m.UserAPI, m.RoomserverAPI, m.Client,
 	)
 	syncapi.AddPublicRoutes(
 		base, m.UserAPI, m.RoomserverAPI, m.KeyAPI,
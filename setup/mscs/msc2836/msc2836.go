@@ -131,6 +131,9 @@ func Enable(
 
 	base.PublicFederationAPIMux.Handle("/unstable/event_relationships", httputil.MakeExternalAPI(
 		"msc2836_event_relationships", func(req *http.Request) util.JSONResponse {
+			if errResp := httputil.FederationOriginAllowed(req, &base.Cfg.FederationAPI); errResp != nil {
+				return *errResp
+			}
 			fedReq, errResp := gomatrixserverlib.VerifyHTTPRequest(
 				req, time.Now(), base.Cfg.Global.ServerName, keyRing,
 			)
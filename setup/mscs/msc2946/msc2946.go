@@ -63,6 +63,9 @@ func Enable(
 
 	fedAPI := httputil.MakeExternalAPI(
 		"msc2946_fed_spaces", func(req *http.Request) util.JSONResponse {
+			if errResp := httputil.FederationOriginAllowed(req, &base.Cfg.FederationAPI); errResp != nil {
+				return *errResp
+			}
 			fedReq, errResp := gomatrixserverlib.VerifyHTTPRequest(
 				req, time.Now(), base.Cfg.Global.ServerName, keyRing,
 			)
@@ -0,0 +1,91 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/internal/fulltext"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+)
+
+// OutputUserErasureConsumer consumes user erasures from the user API and
+// redacts all of the events that the erased user has sent.
+type OutputUserErasureConsumer struct {
+	ctx       context.Context
+	jetstream nats.JetStreamContext
+	durable   string
+	topic     string
+	db        storage.Database
+	fts       *fulltext.Search
+}
+
+// NewOutputUserErasureConsumer creates a new OutputUserErasureConsumer.
+// Call Start() to begin consuming from the user API.
+func NewOutputUserErasureConsumer(
+	process *process.ProcessContext,
+	cfg *config.SyncAPI,
+	js nats.JetStreamContext,
+	store storage.Database,
+	fts *fulltext.Search,
+) *OutputUserErasureConsumer {
+	return &OutputUserErasureConsumer{
+		ctx:       process.Context(),
+		jetstream: js,
+		topic:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputUserErasure),
+		durable:   cfg.Matrix.JetStream.Durable("SyncAPIUserErasureConsumer"),
+		db:        store,
+		fts:       fts,
+	}
+}
+
+// Start consuming user erasures.
+func (s *OutputUserErasureConsumer) Start() error {
+	return jetstream.JetStreamConsumer(
+		s.ctx, s.jetstream, s.topic, s.durable, 1,
+		s.onMessage, nats.DeliverAll(), nats.ManualAck(),
+	)
+}
+
+func (s *OutputUserErasureConsumer) onMessage(ctx context.Context, msgs []*nats.Msg) bool {
+	msg := msgs[0] // Guaranteed to exist if onMessage is called
+	userID := msg.Header.Get(jetstream.UserID)
+	if userID == "" {
+		return true
+	}
+	logger := log.WithField("user_id", userID)
+
+	eventIDs, err := s.db.EraseEventsForSender(ctx, userID)
+	if err != nil {
+		logger.WithError(err).Error("syncapi user erasure consumer: failed to erase events")
+		return false
+	}
+	if s.fts != nil {
+		for _, eventID := range eventIDs {
+			if err = s.fts.Delete(eventID); err != nil {
+				logger.WithError(err).Error("syncapi user erasure consumer: failed to remove event from fulltext index")
+				return false
+			}
+		}
+	}
+	logger.Infof("Erased %d events for erased user", len(eventIDs))
+	return true
+}
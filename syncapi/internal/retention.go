@@ -0,0 +1,211 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/fulltext"
+	"github.com/matrix-org/dendrite/internal/retention"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+// retentionPurgeBatchSize is how many events are purged at a time.
+const retentionPurgeBatchSize = 100
+
+// maxRetentionRefills is how many times RecentEventsWithRetention goes back
+// for older events to replace the expired ones before giving up.
+const maxRetentionRefills = 5
+
+// RoomExpiryTimestamp returns the timestamp before which non-state events in
+// the room have expired according to its retention policy, or zero if they
+// never expire.
+func RoomExpiryTimestamp(
+	ctx context.Context, snapshot storage.DatabaseTransaction,
+	cfg *config.Retention, roomID string, now time.Time,
+) (gomatrixserverlib.Timestamp, error) {
+	if !cfg.Enabled {
+		return 0, nil
+	}
+	var content []byte
+	policy, err := snapshot.GetStateEvent(ctx, roomID, retention.EventType, "")
+	if err != nil {
+		return 0, fmt.Errorf("snapshot.GetStateEvent: %w", err)
+	}
+	if policy != nil {
+		content = policy.Content()
+	}
+	return retention.ExpiryTimestamp(retention.MaxLifetime(cfg, content), now), nil
+}
+
+// ApplyRetentionFilter removes events which have outlived the retention
+// policy of the room, so that they are hidden even before they are purged.
+func ApplyRetentionFilter(
+	ctx context.Context, snapshot storage.DatabaseTransaction,
+	cfg *config.Retention, roomID string, events []*gomatrixserverlib.HeaderedEvent,
+) ([]*gomatrixserverlib.HeaderedEvent, error) {
+	expiry, err := RoomExpiryTimestamp(ctx, snapshot, cfg, roomID, time.Now())
+	if err != nil || expiry == 0 {
+		return events, err
+	}
+	filtered := make([]*gomatrixserverlib.HeaderedEvent, 0, len(events))
+	for _, ev := range events {
+		if !retention.IsExpired(ev.Event, expiry) {
+			filtered = append(filtered, ev)
+		}
+	}
+	return filtered, nil
+}
+
+// RecentEventsWithRetention returns the most recent events in the range in
+// chronological order, like RecentEvents, but leaves out the events which
+// have outlived the retention policy of the room. Older events are fetched to
+// take the place of the expired ones, so that the timeline is still filled up
+// to the limit of the filter where possible.
+func RecentEventsWithRetention(
+	ctx context.Context, snapshot storage.DatabaseTransaction,
+	cfg *config.Retention, roomID string, r types.Range,
+	eventFilter *gomatrixserverlib.RoomEventFilter, onlySyncEvents bool,
+) ([]types.StreamEvent, bool, error) {
+	expiry, err := RoomExpiryTimestamp(ctx, snapshot, cfg, roomID, time.Now())
+	if err != nil {
+		return nil, false, err
+	}
+	events, limited, err := snapshot.RecentEvents(ctx, roomID, r, eventFilter, true, onlySyncEvents)
+	if err != nil || expiry == 0 {
+		return events, limited, err
+	}
+	result := removeExpiredStreamEvents(events, expiry)
+	for i := 0; i < maxRetentionRefills && limited && len(result) < eventFilter.Limit; i++ {
+		// Look at the events before the oldest one we've seen so far.
+		r = types.Range{From: r.Low(), To: events[0].StreamPosition - 1}
+		if r.To <= r.From {
+			limited = false
+			break
+		}
+		events, limited, err = snapshot.RecentEvents(ctx, roomID, r, eventFilter, true, onlySyncEvents)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		result = append(removeExpiredStreamEvents(events, expiry), result...)
+	}
+	if len(result) > eventFilter.Limit {
+		result = result[len(result)-eventFilter.Limit:]
+		limited = true
+	}
+	return result, limited, nil
+}
+
+func removeExpiredStreamEvents(
+	events []types.StreamEvent, expiry gomatrixserverlib.Timestamp,
+) []types.StreamEvent {
+	filtered := make([]types.StreamEvent, 0, len(events))
+	for _, ev := range events {
+		if !retention.IsExpired(ev.Event, expiry) {
+			filtered = append(filtered, ev)
+		}
+	}
+	return filtered
+}
+
+// RunRetentionPurges periodically removes events which have outlived the
+// retention policy of their room from the sync API database and the
+// fulltext index, until the context is done. It does nothing if retention
+// policies are disabled.
+func RunRetentionPurges(
+	ctx context.Context, cfg *config.Retention, db storage.Database, fts *fulltext.Search,
+) {
+	if !cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeExpiredEvents(ctx, cfg, db, fts)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpiredEvents(
+	ctx context.Context, cfg *config.Retention, db storage.Database, fts *fulltext.Search,
+) {
+	roomIDs, err := db.RoomIDsWithEvents(ctx)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get rooms for retention purge")
+		return
+	}
+	for _, roomID := range roomIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		count, err := purgeExpiredEventsInRoom(ctx, cfg, db, fts, roomID)
+		if err != nil {
+			logrus.WithError(err).WithField("room_id", roomID).Error("Failed to purge expired events")
+			continue
+		}
+		if count > 0 {
+			logrus.WithField("room_id", roomID).Infof("Purged %d expired events", count)
+		}
+	}
+}
+
+func purgeExpiredEventsInRoom(
+	ctx context.Context, cfg *config.Retention, db storage.Database,
+	fts *fulltext.Search, roomID string,
+) (int, error) {
+	snapshot, err := db.NewDatabaseSnapshot(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("db.NewDatabaseSnapshot: %w", err)
+	}
+	expiry, err := RoomExpiryTimestamp(ctx, snapshot, cfg, roomID, time.Now())
+	snapshot.Rollback() // nolint: errcheck
+	if err != nil || expiry == 0 {
+		return 0, err
+	}
+	count := 0
+	for {
+		eventIDs, err := db.ExpiredEventIDs(ctx, roomID, expiry, retentionPurgeBatchSize)
+		if err != nil {
+			return count, fmt.Errorf("db.ExpiredEventIDs: %w", err)
+		}
+		if len(eventIDs) == 0 {
+			return count, nil
+		}
+		if err = db.PurgeEvents(ctx, eventIDs); err != nil {
+			return count, fmt.Errorf("db.PurgeEvents: %w", err)
+		}
+		if fts != nil {
+			for _, eventID := range eventIDs {
+				if err = fts.Delete(eventID); err != nil {
+					return count, fmt.Errorf("fts.Delete: %w", err)
+				}
+			}
+		}
+		count += len(eventIDs)
+	}
+}
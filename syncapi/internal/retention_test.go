@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/dendrite/test/testrig"
+)
+
+func TestRecentEventsWithRetention(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		ctx := context.Background()
+		connStr, closeDB := test.PrepareDBConnectionString(t, dbType)
+		defer closeDB()
+		base, closeBase := testrig.CreateBaseDendrite(t, dbType)
+		defer closeBase()
+		db, err := storage.NewSyncServerDatasource(base, &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		})
+		if err != nil {
+			t.Fatalf("NewSyncServerDatasource returned %s", err)
+		}
+
+		alice := test.NewUser(t)
+		room := test.NewRoom(t, alice)
+		old := time.Now().Add(-2 * time.Hour)
+		before := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "before"})
+		for i := 0; i < 3; i++ {
+			room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "expired"}, test.WithTimestamp(old))
+		}
+		after := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "after"})
+		for _, ev := range room.Events() {
+			var addStateEvents []*gomatrixserverlib.HeaderedEvent
+			var addStateEventIDs []string
+			if ev.StateKey() != nil {
+				addStateEvents = append(addStateEvents, ev)
+				addStateEventIDs = append(addStateEventIDs, ev.EventID())
+			}
+			if _, err = db.WriteEvent(ctx, ev, addStateEvents, addStateEventIDs, nil, nil, false, gomatrixserverlib.HistoryVisibilityShared); err != nil {
+				t.Fatalf("WriteEvent failed: %s", err)
+			}
+		}
+
+		snapshot, err := db.NewDatabaseSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Rollback() // nolint: errcheck
+		latest, err := snapshot.MaxStreamPositionForPDUs(ctx)
+		if err != nil {
+			t.Fatalf("MaxStreamPositionForPDUs failed: %s", err)
+		}
+
+		cfg := &config.Retention{
+			Enabled:       true,
+			DefaultPolicy: config.RetentionPolicy{MaxLifetime: time.Hour},
+		}
+		filter := gomatrixserverlib.DefaultRoomEventFilter()
+		filter.Limit = 2
+		events, limited, err := RecentEventsWithRetention(ctx, snapshot, cfg, room.ID, types.Range{From: 0, To: latest}, &filter, true)
+		if err != nil {
+			t.Fatalf("RecentEventsWithRetention failed: %s", err)
+		}
+		// The expired events should have been replaced by older ones.
+		if len(events) != 2 || events[0].EventID() != before.EventID() || events[1].EventID() != after.EventID() {
+			gotIDs := make([]string, 0, len(events))
+			for _, ev := range events {
+				gotIDs = append(gotIDs, ev.EventID())
+			}
+			t.Fatalf("expected [%s %s], got %v", before.EventID(), after.EventID(), gotIDs)
+		}
+		if !limited {
+			t.Fatalf("expected the timeline to be limited")
+		}
+
+		// Without expired events in the way, the whole room fits in a large page.
+		filter.Limit = 100
+		events, limited, err = RecentEventsWithRetention(ctx, snapshot, cfg, room.ID, types.Range{From: 0, To: latest}, &filter, true)
+		if err != nil {
+			t.Fatalf("RecentEventsWithRetention failed: %s", err)
+		}
+		if limited || len(events) != len(room.Events())-3 {
+			t.Fatalf("expected %d events unlimited, got %d (limited=%v)", len(room.Events())-3, len(events), limited)
+		}
+	})
+}
@@ -360,6 +360,13 @@ func (r *messagesReq) retrieveEvents() (
 		return []gomatrixserverlib.ClientEvent{}, *r.from, *r.to, nil
 	}
 
+	// Hide events which have outlived the retention policy of the room but
+	// which haven't been purged yet.
+	events, err = internal.ApplyRetentionFilter(r.ctx, r.snapshot, &r.cfg.Matrix.Retention, r.roomID, events)
+	if err != nil {
+		return []gomatrixserverlib.ClientEvent{}, *r.from, *r.to, err
+	}
+
 	// Apply room history visibility filter
 	startTime := time.Now()
 	filteredEvents, err := internal.ApplyHistoryVisibilityFilter(r.ctx, r.snapshot, r.rsAPI, events, nil, r.device.UserID, "messages")
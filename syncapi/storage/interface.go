@@ -132,6 +132,18 @@ type Database interface {
 	// PurgeRoomState completely purges room state from the sync API. This is done when
 	// receiving an output event that completely resets the state.
 	PurgeRoomState(ctx context.Context, roomID string) error
+	// RoomIDsWithEvents returns the IDs of all rooms that we have events for.
+	RoomIDsWithEvents(ctx context.Context) ([]string, error)
+	// ExpiredEventIDs returns the IDs of up to limit non-state events in the room which
+	// were sent before the given timestamp.
+	ExpiredEventIDs(ctx context.Context, roomID string, before gomatrixserverlib.Timestamp, limit int) ([]string, error)
+	// PurgeEvents removes the given events from the sync API, e.g. because they have
+	// outlived the retention policy of their room.
+	PurgeEvents(ctx context.Context, eventIDs []string) error
+	// EraseEventsForSender redacts all of the events sent by the given user in place,
+	// without a redaction event, and returns the IDs of the events that were erased.
+	// This is done when the user has been erased.
+	EraseEventsForSender(ctx context.Context, userID string) ([]string, error)
 	// UpsertAccountData keeps track of new or updated account data, by saving the type
 	// of the new/updated data, and the user ID and room ID the data is related to (empty)
 	// room ID means the data isn't specific to any room)
@@ -15,6 +15,7 @@
 package shared
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -263,6 +264,66 @@ func (d *Database) PurgeRoomState(
 	})
 }
 
+func (d *Database) RoomIDsWithEvents(ctx context.Context) ([]string, error) {
+	return d.OutputEvents.SelectRoomIDsWithEvents(ctx, nil)
+}
+
+func (d *Database) ExpiredEventIDs(
+	ctx context.Context, roomID string, before gomatrixserverlib.Timestamp, limit int,
+) ([]string, error) {
+	return d.OutputEvents.SelectExpiredEventIDs(ctx, nil, roomID, before, limit)
+}
+
+func (d *Database) PurgeEvents(
+	ctx context.Context, eventIDs []string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		for _, eventID := range eventIDs {
+			if err := d.OutputEvents.DeleteEvent(ctx, txn, eventID); err != nil {
+				return fmt.Errorf("d.OutputEvents.DeleteEvent: %w", err)
+			}
+			if err := d.Topology.DeleteEventFromTopology(ctx, txn, eventID); err != nil {
+				return fmt.Errorf("d.Topology.DeleteEventFromTopology: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Database) EraseEventsForSender(
+	ctx context.Context, userID string,
+) ([]string, error) {
+	eventIDs, err := d.OutputEvents.SelectEventIDsForSender(ctx, nil, userID)
+	if err != nil {
+		return nil, fmt.Errorf("d.OutputEvents.SelectEventIDsForSender: %w", err)
+	}
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	events, err := d.OutputEvents.SelectEvents(ctx, nil, eventIDs, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("d.OutputEvents.SelectEvents: %w", err)
+	}
+	var erased []string
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		erased = erased[:0]
+		for _, event := range events {
+			// Skip events which have already been erased or redacted.
+			eventJSON := event.JSON()
+			event.Redact()
+			if bytes.Equal(eventJSON, event.JSON()) {
+				continue
+			}
+			if err := d.OutputEvents.UpdateEventJSON(ctx, txn, event.HeaderedEvent); err != nil {
+				return fmt.Errorf("d.OutputEvents.UpdateEventJSON: %w", err)
+			}
+			erased = append(erased, event.EventID())
+		}
+		return nil
+	})
+	return erased, err
+}
+
 func (d *Database) WriteEvent(
 	ctx context.Context,
 	ev *gomatrixserverlib.HeaderedEvent,
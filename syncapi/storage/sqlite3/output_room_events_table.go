@@ -104,6 +104,23 @@ const selectStateInRangeSQL = "" +
 const deleteEventsForRoomSQL = "" +
 	"DELETE FROM syncapi_output_room_events WHERE room_id = $1"
 
+const selectRoomIDsWithEventsSQL = "" +
+	"SELECT DISTINCT room_id FROM syncapi_output_room_events"
+
+// The timestamp isn't stored in its own column, so look into the event JSON
+// instead. This is slow, but is only used by the periodic retention purge.
+const selectExpiredEventIDsSQL = "" +
+	"SELECT event_id FROM syncapi_output_room_events" +
+	" WHERE room_id = $1 AND json_extract(headered_event_json, '$.origin_server_ts') < $2" +
+	" AND json_type(headered_event_json, '$.state_key') IS NULL" +
+	" ORDER BY id ASC LIMIT $3"
+
+const deleteEventSQL = "" +
+	"DELETE FROM syncapi_output_room_events WHERE event_id = $1"
+
+const selectEventIDsForSenderSQL = "" +
+	"SELECT event_id FROM syncapi_output_room_events WHERE sender = $1"
+
 const selectContextEventSQL = "" +
 	"SELECT id, headered_event_json, history_visibility FROM syncapi_output_room_events WHERE room_id = $1 AND event_id = $2"
 
@@ -126,6 +143,10 @@ type outputRoomEventsStatements struct {
 	selectMaxEventIDStmt         *sql.Stmt
 	updateEventJSONStmt          *sql.Stmt
 	deleteEventsForRoomStmt      *sql.Stmt
+	selectRoomIDsWithEventsStmt  *sql.Stmt
+	selectExpiredEventIDsStmt    *sql.Stmt
+	deleteEventStmt              *sql.Stmt
+	selectEventIDsForSenderStmt  *sql.Stmt
 	selectContextEventStmt       *sql.Stmt
 	selectContextBeforeEventStmt *sql.Stmt
 	selectContextAfterEventStmt  *sql.Stmt
@@ -159,6 +180,10 @@ func NewSqliteEventsTable(db *sql.DB, streamID *StreamIDStatements) (tables.Even
 		{&s.selectMaxEventIDStmt, selectMaxEventIDSQL},
 		{&s.updateEventJSONStmt, updateEventJSONSQL},
 		{&s.deleteEventsForRoomStmt, deleteEventsForRoomSQL},
+		{&s.selectRoomIDsWithEventsStmt, selectRoomIDsWithEventsSQL},
+		{&s.selectExpiredEventIDsStmt, selectExpiredEventIDsSQL},
+		{&s.deleteEventStmt, deleteEventSQL},
+		{&s.selectEventIDsForSenderStmt, selectEventIDsForSenderSQL},
 		{&s.selectContextEventStmt, selectContextEventSQL},
 		{&s.selectContextBeforeEventStmt, selectContextBeforeEventSQL},
 		{&s.selectContextAfterEventStmt, selectContextAfterEventSQL},
@@ -489,6 +514,70 @@ func (s *outputRoomEventsStatements) DeleteEventsForRoom(
 	return err
 }
 
+func (s *outputRoomEventsStatements) SelectRoomIDsWithEvents(
+	ctx context.Context, txn *sql.Tx,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectRoomIDsWithEventsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectRoomIDsWithEvents: rows.close() failed")
+	var roomIDs []string
+	var roomID string
+	for rows.Next() {
+		if err = rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs, rows.Err()
+}
+
+func (s *outputRoomEventsStatements) SelectExpiredEventIDs(
+	ctx context.Context, txn *sql.Tx, roomID string, before gomatrixserverlib.Timestamp, limit int,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectExpiredEventIDsStmt).QueryContext(ctx, roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectExpiredEventIDs: rows.close() failed")
+	var eventIDs []string
+	var eventID string
+	for rows.Next() {
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, rows.Err()
+}
+
+func (s *outputRoomEventsStatements) DeleteEvent(
+	ctx context.Context, txn *sql.Tx, eventID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteEventStmt).ExecContext(ctx, eventID)
+	return err
+}
+
+func (s *outputRoomEventsStatements) SelectEventIDsForSender(
+	ctx context.Context, txn *sql.Tx, sender string,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectEventIDsForSenderStmt).QueryContext(ctx, sender)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventIDsForSender: rows.close() failed")
+	var eventIDs []string
+	var eventID string
+	for rows.Next() {
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, rows.Err()
+}
+
 func rowsToStreamEvents(rows *sql.Rows) ([]types.StreamEvent, error) {
 	var result []types.StreamEvent
 	for rows.Next() {
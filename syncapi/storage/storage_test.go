@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/storage"
@@ -60,6 +62,124 @@ func TestWriteEvents(t *testing.T) {
 	})
 }
 
+func TestEraseEventsForSender(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		alice := test.NewUser(t)
+		bob := test.NewUser(t)
+		r := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		r.CreateAndInsert(t, bob, gomatrixserverlib.MRoomMember, map[string]interface{}{
+			"membership": "join",
+		}, test.WithStateKey(bob.ID))
+		bobMessage := r.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{"body": "bob"})
+		aliceMessage := r.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "alice"})
+		db, close, closeBase := MustCreateDatabase(t, dbType)
+		defer close()
+		defer closeBase()
+		MustWriteEvents(t, db, r.Events())
+
+		erased, err := db.EraseEventsForSender(ctx, bob.ID)
+		if err != nil {
+			t.Fatalf("EraseEventsForSender failed: %s", err)
+		}
+		// Only Bob's message should have changed, since the content of his
+		// join event survives redaction.
+		if len(erased) != 1 || erased[0] != bobMessage.EventID() {
+			t.Fatalf("expected only %s to be erased, got %v", bobMessage.EventID(), erased)
+		}
+		events, err := db.Events(ctx, []string{bobMessage.EventID(), aliceMessage.EventID()})
+		if err != nil {
+			t.Fatalf("Events failed: %s", err)
+		}
+		for _, ev := range events {
+			hasBody := bytes.Contains(ev.Content(), []byte("body"))
+			if ev.EventID() == bobMessage.EventID() && hasBody {
+				t.Errorf("expected the message from bob to have been erased")
+			}
+			if ev.EventID() == aliceMessage.EventID() && !hasBody {
+				t.Errorf("expected the message from alice to be left alone")
+			}
+		}
+
+		// Erasing again shouldn't touch the already erased events.
+		if erased, err = db.EraseEventsForSender(ctx, bob.ID); err != nil || len(erased) != 0 {
+			t.Fatalf("expected nothing to be erased again, got %v, %v", erased, err)
+		}
+	})
+}
+
+func TestPurgeExpiredEvents(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		alice := test.NewUser(t)
+		r := test.NewRoom(t, alice)
+		old := time.Now().Add(-2 * time.Hour)
+		var wantExpired []string
+		for i := 0; i < 3; i++ {
+			ev := r.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "old"}, test.WithTimestamp(old))
+			wantExpired = append(wantExpired, ev.EventID())
+		}
+		// Old state events are never expired.
+		r.CreateAndInsert(t, alice, "m.room.topic", map[string]interface{}{"topic": "old"}, test.WithStateKey(""), test.WithTimestamp(old))
+		recent := r.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "new"})
+		db, close, closeBase := MustCreateDatabase(t, dbType)
+		defer close()
+		defer closeBase()
+		// dummy room to make sure SQL queries are filtering on room ID
+		MustWriteEvents(t, db, test.NewRoom(t, alice).Events())
+		MustWriteEvents(t, db, r.Events())
+
+		roomIDs, err := db.RoomIDsWithEvents(ctx)
+		if err != nil {
+			t.Fatalf("RoomIDsWithEvents failed: %s", err)
+		}
+		if len(roomIDs) != 2 {
+			t.Fatalf("expected 2 rooms with events, got %v", roomIDs)
+		}
+
+		before := gomatrixserverlib.AsTimestamp(time.Now().Add(-time.Hour))
+		eventIDs, err := db.ExpiredEventIDs(ctx, r.ID, before, 2)
+		if err != nil {
+			t.Fatalf("ExpiredEventIDs failed: %s", err)
+		}
+		if len(eventIDs) != 2 {
+			t.Fatalf("expected the limit to be applied, got %v", eventIDs)
+		}
+		eventIDs, err = db.ExpiredEventIDs(ctx, r.ID, before, 100)
+		if err != nil {
+			t.Fatalf("ExpiredEventIDs failed: %s", err)
+		}
+		sort.Strings(eventIDs)
+		sort.Strings(wantExpired)
+		if !reflect.DeepEqual(eventIDs, wantExpired) {
+			t.Fatalf("expected expired events %v, got %v", wantExpired, eventIDs)
+		}
+
+		if err = db.PurgeEvents(ctx, eventIDs); err != nil {
+			t.Fatalf("PurgeEvents failed: %s", err)
+		}
+		events, err := db.Events(ctx, eventIDs)
+		if err != nil {
+			t.Fatalf("Events failed: %s", err)
+		}
+		if len(events) != 0 {
+			t.Fatalf("expected purged events to be gone, got %d", len(events))
+		}
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			for _, eventID := range eventIDs {
+				if _, err := snapshot.EventPositionInTopology(ctx, eventID); err == nil {
+					t.Errorf("expected %s to be removed from the topology", eventID)
+				}
+			}
+		})
+		events, err = db.Events(ctx, []string{recent.EventID()})
+		if err != nil || len(events) != 1 {
+			t.Fatalf("expected the recent event to be left alone, got %v, %v", events, err)
+		}
+		if eventIDs, err = db.ExpiredEventIDs(ctx, r.ID, before, 100); err != nil || len(eventIDs) != 0 {
+			t.Fatalf("expected nothing left to purge, got %v, %v", eventIDs, err)
+		}
+	})
+}
+
 func WithSnapshot(t *testing.T, db storage.Database, f func(snapshot storage.DatabaseTransaction)) {
 	snapshot, err := db.NewDatabaseSnapshot(ctx)
 	if err != nil {
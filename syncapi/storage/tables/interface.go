@@ -71,6 +71,14 @@ type Events interface {
 	UpdateEventJSON(ctx context.Context, txn *sql.Tx, event *gomatrixserverlib.HeaderedEvent) error
 	// DeleteEventsForRoom removes all event information for a room. This should only be done when removing the room entirely.
 	DeleteEventsForRoom(ctx context.Context, txn *sql.Tx, roomID string) (err error)
+	// SelectRoomIDsWithEvents returns the IDs of all rooms that we have events for.
+	SelectRoomIDsWithEvents(ctx context.Context, txn *sql.Tx) ([]string, error)
+	// SelectExpiredEventIDs returns the IDs of up to limit non-state events in the room which were sent before the given timestamp.
+	SelectExpiredEventIDs(ctx context.Context, txn *sql.Tx, roomID string, before gomatrixserverlib.Timestamp, limit int) ([]string, error)
+	// DeleteEvent removes a single event. This is used to purge events which have expired.
+	DeleteEvent(ctx context.Context, txn *sql.Tx, eventID string) error
+	// SelectEventIDsForSender returns the IDs of all events sent by the given user.
+	SelectEventIDsForSender(ctx context.Context, txn *sql.Tx, sender string) ([]string, error)
 
 	SelectContextEvent(ctx context.Context, txn *sql.Tx, roomID, eventID string) (int, gomatrixserverlib.HeaderedEvent, error)
 	SelectContextBeforeEvent(ctx context.Context, txn *sql.Tx, id int, roomID string, filter *gomatrixserverlib.RoomEventFilter) ([]*gomatrixserverlib.HeaderedEvent, error)
@@ -95,6 +103,8 @@ type Topology interface {
 	SelectMaxPositionInTopology(ctx context.Context, txn *sql.Tx, roomID string) (depth types.StreamPosition, spos types.StreamPosition, err error)
 	// SelectStreamToTopologicalPosition converts a stream position to a topological position by finding the nearest topological position in the room.
 	SelectStreamToTopologicalPosition(ctx context.Context, txn *sql.Tx, roomID string, streamPos types.StreamPosition, forward bool) (topoPos types.StreamPosition, err error)
+	// DeleteEventFromTopology removes a single event from the topology.
+	DeleteEventFromTopology(ctx context.Context, txn *sql.Tx, eventID string) error
 }
 
 type CurrentRoomState interface {
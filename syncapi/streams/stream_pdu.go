@@ -9,6 +9,7 @@ import (
 
 	"github.com/matrix-org/dendrite/internal/caching"
 	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/internal"
 	"github.com/matrix-org/dendrite/syncapi/storage"
 	"github.com/matrix-org/dendrite/syncapi/types"
@@ -37,6 +38,7 @@ type PDUStreamProvider struct {
 	lazyLoadCache caching.LazyLoadCache
 	rsAPI         roomserverAPI.SyncRoomserverAPI
 	notifier      *notifier.Notifier
+	retention     *config.Retention
 }
 
 func (p *PDUStreamProvider) Setup(
@@ -236,9 +238,11 @@ func (p *PDUStreamProvider) addRoomDeltaToResponse(
 		// This is all "okay" assuming history_visibility == "shared" which it is by default.
 		r.To = delta.MembershipPos
 	}
-	recentStreamEvents, limited, err := snapshot.RecentEvents(
-		ctx, delta.RoomID, r,
-		eventFilter, true, true,
+	// Events which have outlived the retention policy of the room but which
+	// haven't been purged yet are left out here.
+	recentStreamEvents, limited, err := internal.RecentEventsWithRetention(
+		ctx, snapshot, p.retention, delta.RoomID, r,
+		eventFilter, true,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -437,8 +441,10 @@ func (p *PDUStreamProvider) getJoinResponseForCompleteSync(
 	jr = types.NewJoinResponse()
 	// TODO: When filters are added, we may need to call this multiple times to get enough events.
 	//       See: https://github.com/matrix-org/synapse/blob/v0.19.3/synapse/handlers/sync.py#L316
-	recentStreamEvents, limited, err := snapshot.RecentEvents(
-		ctx, roomID, r, eventFilter, true, true,
+	// Events which have outlived the retention policy of the room but which
+	// haven't been purged yet are left out here.
+	recentStreamEvents, limited, err := internal.RecentEventsWithRetention(
+		ctx, snapshot, p.retention, roomID, r, eventFilter, true,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
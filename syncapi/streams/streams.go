@@ -7,6 +7,7 @@ import (
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	keyapi "github.com/matrix-org/dendrite/keyserver/api"
 	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/notifier"
 	"github.com/matrix-org/dendrite/syncapi/storage"
 	"github.com/matrix-org/dendrite/syncapi/types"
@@ -29,6 +30,7 @@ func NewSyncStreamProviders(
 	d storage.Database, userAPI userapi.SyncUserAPI,
 	rsAPI rsapi.SyncRoomserverAPI, keyAPI keyapi.SyncKeyAPI,
 	eduCache *caching.EDUCache, lazyLoadCache caching.LazyLoadCache, notifier *notifier.Notifier,
+	retention *config.Retention,
 ) *Streams {
 	streams := &Streams{
 		PDUStreamProvider: &PDUStreamProvider{
@@ -36,6 +38,7 @@ func NewSyncStreamProviders(
 			lazyLoadCache:         lazyLoadCache,
 			rsAPI:                 rsAPI,
 			notifier:              notifier,
+			retention:             retention,
 		},
 		TypingStreamProvider: &TypingStreamProvider{
 			DefaultStreamProvider: DefaultStreamProvider{DB: d},
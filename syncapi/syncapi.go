@@ -28,6 +28,7 @@ import (
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 
 	"github.com/matrix-org/dendrite/syncapi/consumers"
+	"github.com/matrix-org/dendrite/syncapi/internal"
 	"github.com/matrix-org/dendrite/syncapi/notifier"
 	"github.com/matrix-org/dendrite/syncapi/producers"
 	"github.com/matrix-org/dendrite/syncapi/routing"
@@ -55,7 +56,9 @@ func AddPublicRoutes(
 
 	eduCache := caching.NewTypingCache()
 	notifier := notifier.NewNotifier()
-	streams := streams.NewSyncStreamProviders(syncDB, userAPI, rsAPI, keyAPI, eduCache, base.Caches, notifier)
+	streams := streams.NewSyncStreamProviders(
+		syncDB, userAPI, rsAPI, keyAPI, eduCache, base.Caches, notifier, &cfg.Matrix.Retention,
+	)
 	notifier.SetCurrentPosition(streams.Latest(context.Background()))
 	if err = notifier.Load(context.Background(), syncDB); err != nil {
 		logrus.WithError(err).Panicf("failed to load notifier ")
@@ -130,6 +133,17 @@ func AddPublicRoutes(
 		logrus.WithError(err).Panicf("failed to start receipts consumer")
 	}
 
+	userErasureConsumer := consumers.NewOutputUserErasureConsumer(
+		base.ProcessContext, cfg, js, syncDB, base.Fulltext,
+	)
+	if err = userErasureConsumer.Start(); err != nil {
+		logrus.WithError(err).Panicf("failed to start user erasure consumer")
+	}
+
+	go internal.RunRetentionPurges(
+		base.ProcessContext.Context(), &cfg.Matrix.Retention, syncDB, base.Fulltext,
+	)
+
 	routing.Setup(
 		base.PublicClientAPIMux, requestPool, syncDB, userAPI,
 		rsAPI, cfg, base.Caches, base.Fulltext,
@@ -17,6 +17,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/matrix-org/gomatrixserverlib"
 
@@ -39,8 +40,10 @@ type UserInternalAPI interface {
 
 // api functions required by the appservice api
 type AppserviceUserAPI interface {
+	QueryAccountByLocalpart(ctx context.Context, req *QueryAccountByLocalpartRequest, res *QueryAccountByLocalpartResponse) error
 	PerformAccountCreation(ctx context.Context, req *PerformAccountCreationRequest, res *PerformAccountCreationResponse) error
 	PerformDeviceCreation(ctx context.Context, req *PerformDeviceCreationRequest, res *PerformDeviceCreationResponse) error
+	PerformDeviceDeletion(ctx context.Context, req *PerformDeviceDeletionRequest, res *PerformDeviceDeletionResponse) error
 }
 
 type KeyserverUserAPI interface {
@@ -85,6 +88,7 @@ type ClientUserAPI interface {
 	QueryPushers(ctx context.Context, req *QueryPushersRequest, res *QueryPushersResponse) error
 	QueryPushRules(ctx context.Context, req *QueryPushRulesRequest, res *QueryPushRulesResponse) error
 	QueryAccountAvailability(ctx context.Context, req *QueryAccountAvailabilityRequest, res *QueryAccountAvailabilityResponse) error
+	QueryAccountByLocalpart(ctx context.Context, req *QueryAccountByLocalpartRequest, res *QueryAccountByLocalpartResponse) error
 	PerformAccountCreation(ctx context.Context, req *PerformAccountCreationRequest, res *PerformAccountCreationResponse) error
 	PerformDeviceCreation(ctx context.Context, req *PerformDeviceCreationRequest, res *PerformDeviceCreationResponse) error
 	PerformDeviceUpdate(ctx context.Context, req *PerformDeviceUpdateRequest, res *PerformDeviceUpdateResponse) error
@@ -94,7 +98,25 @@ type ClientUserAPI interface {
 	PerformPusherSet(ctx context.Context, req *PerformPusherSetRequest, res *struct{}) error
 	PerformPushRulesPut(ctx context.Context, req *PerformPushRulesPutRequest, res *struct{}) error
 	PerformAccountDeactivation(ctx context.Context, req *PerformAccountDeactivationRequest, res *PerformAccountDeactivationResponse) error
+	PerformShadowBan(ctx context.Context, req *PerformShadowBanRequest, res *PerformShadowBanResponse) error
 	PerformOpenIDTokenCreation(ctx context.Context, req *PerformOpenIDTokenCreationRequest, res *PerformOpenIDTokenCreationResponse) error
+	PerformImpersonationTokenCreation(ctx context.Context, req *PerformImpersonationTokenCreationRequest, res *PerformImpersonationTokenCreationResponse) error
+	QueryImpersonationAudit(ctx context.Context, req *QueryImpersonationAuditRequest, res *QueryImpersonationAuditResponse) error
+	QueryMonthlyActiveUsers(ctx context.Context, req *QueryMonthlyActiveUsersRequest, res *QueryMonthlyActiveUsersResponse) error
+	PerformAccountValidityRenewal(ctx context.Context, req *PerformAccountValidityRenewalRequest, res *PerformAccountValidityRenewalResponse) error
+	PerformAccountValidityReminders(ctx context.Context, req *PerformAccountValidityRemindersRequest, res *PerformAccountValidityRemindersResponse) error
+	PerformAccountValidityReminderSent(ctx context.Context, req *PerformAccountValidityReminderSentRequest, res *PerformAccountValidityReminderSentResponse) error
+	QueryPolicyVersion(ctx context.Context, req *QueryPolicyVersionRequest, res *QueryPolicyVersionResponse) error
+	QueryOutdatedPolicy(ctx context.Context, req *QueryOutdatedPolicyRequest, res *QueryOutdatedPolicyResponse) error
+	PerformUpdatePolicyVersion(ctx context.Context, req *PerformUpdatePolicyVersionRequest, res *PerformUpdatePolicyVersionResponse) error
+	PerformLoginLockoutReset(ctx context.Context, req *PerformLoginLockoutResetRequest, res *PerformLoginLockoutResetResponse) error
+	PerformLoginFailureNotices(ctx context.Context, req *PerformLoginFailureNoticesRequest, res *PerformLoginFailureNoticesResponse) error
+	QueryLoginLockout(ctx context.Context, req *QueryLoginLockoutRequest, res *QueryLoginLockoutResponse) error
+	PerformLoginAttempt(ctx context.Context, req *PerformLoginAttemptRequest, res *PerformLoginAttemptResponse) error
+	PerformThreePIDValidationSessionCreation(ctx context.Context, req *PerformThreePIDValidationSessionCreationRequest, res *PerformThreePIDValidationSessionCreationResponse) error
+	PerformThreePIDValidation(ctx context.Context, req *PerformThreePIDValidationRequest, res *PerformThreePIDValidationResponse) error
+	QueryThreePIDValidationSession(ctx context.Context, req *QueryThreePIDValidationSessionRequest, res *QueryThreePIDValidationSessionResponse) error
+	PerformThreePIDValidationSessionDeletion(ctx context.Context, req *PerformThreePIDValidationSessionDeletionRequest, res *PerformThreePIDValidationSessionDeletionResponse) error
 	SetAvatarURL(ctx context.Context, req *PerformSetAvatarURLRequest, res *PerformSetAvatarURLResponse) error
 	SetDisplayName(ctx context.Context, req *PerformUpdateDisplayNameRequest, res *struct{}) error
 	QueryNotifications(ctx context.Context, req *QueryNotificationsRequest, res *QueryNotificationsResponse) error
@@ -260,6 +282,14 @@ type QueryAccessTokenRequest struct {
 type QueryAccessTokenResponse struct {
 	Device *Device
 	Err    string // e.g ErrorForbidden
+	// ResourceLimitExceeded is true if the user may not use the server because
+	// it has reached its monthly active user limit. AdminContact is then set to
+	// who the user should contact about it.
+	ResourceLimitExceeded bool
+	AdminContact          string
+	// AccountExpired is true if the user's account has expired and must be
+	// renewed before it can be used again.
+	AccountExpired bool
 }
 
 // QueryAccountDataRequest is the request for QueryAccountData
@@ -324,6 +354,8 @@ type PerformAccountCreationRequest struct {
 	AppServiceID string // optional: the application service ID (not user ID) creating this account, if any.
 	Password     string // optional: if missing then this account will be a passwordless account
 	OnConflict   Conflict
+
+	PolicyVersion string // optional: the version of the terms of service the user consented to when registering
 }
 
 // PerformAccountCreationResponse is the response for PerformAccountCreation
@@ -385,6 +417,9 @@ type PerformDeviceCreationResponse struct {
 // PerformAccountDeactivationRequest is the request for PerformAccountDeactivation
 type PerformAccountDeactivationRequest struct {
 	Localpart string
+	// Erase requests that all of the user's events, profile and media are
+	// also removed, as per the "erase" flag on /account/deactivate.
+	Erase bool
 }
 
 // PerformAccountDeactivationResponse is the response for PerformAccountDeactivation
@@ -392,6 +427,17 @@ type PerformAccountDeactivationResponse struct {
 	AccountDeactivated bool
 }
 
+// PerformShadowBanRequest is the request for PerformShadowBan
+type PerformShadowBanRequest struct {
+	Localpart    string
+	ShadowBanned bool
+}
+
+// PerformShadowBanResponse is the response for PerformShadowBan
+type PerformShadowBanResponse struct {
+	AccountExists bool
+}
+
 // PerformOpenIDTokenCreationRequest is the request for PerformOpenIDTokenCreation
 type PerformOpenIDTokenCreationRequest struct {
 	UserID string
@@ -402,6 +448,246 @@ type PerformOpenIDTokenCreationResponse struct {
 	Token OpenIDToken
 }
 
+// PerformImpersonationTokenCreationRequest is the request for PerformImpersonationTokenCreation
+type PerformImpersonationTokenCreationRequest struct {
+	// The admin requesting the token, recorded in the audit log.
+	AdminUserID string
+	// The local user to impersonate.
+	Localpart string
+	// The access token to issue for the new device.
+	AccessToken string
+	// How long the token should be valid for. If zero, the default
+	// impersonation token lifetime is used.
+	Lifetime time.Duration
+}
+
+// PerformImpersonationTokenCreationResponse is the response for PerformImpersonationTokenCreation
+type PerformImpersonationTokenCreationResponse struct {
+	// Device is nil if the user doesn't exist.
+	Device      *Device
+	ExpiresAtMS int64
+}
+
+// QueryImpersonationAuditRequest is the request for QueryImpersonationAudit
+type QueryImpersonationAuditRequest struct {
+	Localpart string
+}
+
+// QueryImpersonationAuditResponse is the response for QueryImpersonationAudit
+type QueryImpersonationAuditResponse struct {
+	Entries []ImpersonationAuditEntry
+}
+
+// QueryMonthlyActiveUsersRequest is the request for QueryMonthlyActiveUsers
+type QueryMonthlyActiveUsersRequest struct {
+	IncludeUserIDs bool // if true, the user IDs of the active users are returned too
+}
+
+// QueryMonthlyActiveUsersResponse is the response for QueryMonthlyActiveUsers
+type QueryMonthlyActiveUsersResponse struct {
+	Count   int64
+	UserIDs []string
+}
+
+// PerformAccountValidityRenewalRequest is the request for PerformAccountValidityRenewal.
+// Either RenewalToken or UserID must be given.
+type PerformAccountValidityRenewalRequest struct {
+	RenewalToken string // renew the account which this renewal token was issued for
+	UserID       string // renew this account
+	ExpiresAtMS  int64  // optional: when the account should expire, defaults to the configured period from now
+}
+
+// PerformAccountValidityRenewalResponse is the response for PerformAccountValidityRenewal
+type PerformAccountValidityRenewalResponse struct {
+	Renewed     bool // false if the renewal token or user is unknown
+	UserID      string
+	ExpiresAtMS int64
+}
+
+// PerformAccountValidityRemindersRequest is the request for PerformAccountValidityReminders
+type PerformAccountValidityRemindersRequest struct{}
+
+// PerformAccountValidityRemindersResponse is the response for PerformAccountValidityReminders
+type PerformAccountValidityRemindersResponse struct {
+	Reminders []AccountValidityReminder
+}
+
+// AccountValidityReminder is a user who should be reminded to renew their account.
+type AccountValidityReminder struct {
+	UserID       string
+	ExpiresAtMS  int64
+	RenewalToken string
+}
+
+// PerformAccountValidityReminderSentRequest is the request for PerformAccountValidityReminderSent
+type PerformAccountValidityReminderSentRequest struct {
+	UserID       string
+	RenewalToken string
+}
+
+// PerformAccountValidityReminderSentResponse is the response for PerformAccountValidityReminderSent
+type PerformAccountValidityReminderSentResponse struct{}
+
+// QueryPolicyVersionRequest is the request for QueryPolicyVersion
+type QueryPolicyVersionRequest struct {
+	Localpart string
+}
+
+// QueryPolicyVersionResponse is the response for QueryPolicyVersion
+type QueryPolicyVersionResponse struct {
+	PolicyVersion string // empty if the user hasn't consented to any version
+}
+
+// QueryOutdatedPolicyRequest is the request for QueryOutdatedPolicy
+type QueryOutdatedPolicyRequest struct {
+	PolicyVersion string
+}
+
+// QueryOutdatedPolicyResponse is the response for QueryOutdatedPolicy
+type QueryOutdatedPolicyResponse struct {
+	// Localparts of the users who haven't consented to the policy version,
+	// and who haven't been sent a server notice about it yet.
+	UserLocalparts []string
+}
+
+// PerformUpdatePolicyVersionRequest is the request for PerformUpdatePolicyVersion
+type PerformUpdatePolicyVersionRequest struct {
+	Localpart     string
+	PolicyVersion string
+	// ServerNoticeUpdate records that the user has been sent a server notice
+	// about the policy version, rather than that they consented to it.
+	ServerNoticeUpdate bool
+}
+
+// PerformUpdatePolicyVersionResponse is the response for PerformUpdatePolicyVersion
+type PerformUpdatePolicyVersionResponse struct{}
+
+// PerformLoginLockoutResetRequest is the request for PerformLoginLockoutReset
+type PerformLoginLockoutResetRequest struct {
+	Localpart string
+}
+
+// PerformLoginLockoutResetResponse is the response for PerformLoginLockoutReset
+type PerformLoginLockoutResetResponse struct {
+	AccountExists bool
+}
+
+// PerformLoginFailureNoticesRequest is the request for PerformLoginFailureNotices
+type PerformLoginFailureNoticesRequest struct{}
+
+// PerformLoginFailureNoticesResponse is the response for PerformLoginFailureNotices
+type PerformLoginFailureNoticesResponse struct {
+	Notices []LoginFailureNotice
+}
+
+// QueryLoginLockoutRequest is the request for QueryLoginLockout
+type QueryLoginLockoutRequest struct {
+	Localpart  string
+	RemoteAddr string
+}
+
+// QueryLoginLockoutResponse is the response for QueryLoginLockout
+type QueryLoginLockoutResponse struct {
+	LockedUntilMS int64 // zero if neither the account nor the IP address is locked out
+}
+
+// PerformLoginAttemptRequest is the request for PerformLoginAttempt, which
+// records the outcome of a login that was checked outside of the user API,
+// e.g. by a password provider.
+type PerformLoginAttemptRequest struct {
+	Localpart  string
+	RemoteAddr string
+	Succeeded  bool
+}
+
+// PerformLoginAttemptResponse is the response for PerformLoginAttempt
+type PerformLoginAttemptResponse struct{}
+
+// LoginFailureNotice is a user who should be told about failed logins to their account.
+type LoginFailureNotice struct {
+	UserID        string
+	Failures      int
+	LastIP        string
+	LockedUntilMS int64 // zero if the account isn't locked out
+}
+
+// LoginFailures are the failed logins in a row to an account, or from an IP address.
+type LoginFailures struct {
+	Failures      int
+	LastFailureTS int64
+	LockedUntilTS int64  // zero if not locked out
+	LastIP        string // only set for accounts
+	Notified      bool   // whether the user has been told about the failures
+}
+
+// PerformThreePIDValidationSessionCreationRequest is the request for PerformThreePIDValidationSessionCreation
+type PerformThreePIDValidationSessionCreationRequest struct {
+	ClientSecret string
+	Medium       string
+	Address      string
+	SendAttempt  int
+	NextLink     string
+}
+
+// PerformThreePIDValidationSessionCreationResponse is the response for PerformThreePIDValidationSessionCreation
+type PerformThreePIDValidationSessionCreationResponse struct {
+	SessionID string
+	Token     string
+	// Send is true if the token should be sent to the address, i.e. the
+	// session is new or the client has made a new send attempt.
+	Send bool
+}
+
+// PerformThreePIDValidationRequest is the request for PerformThreePIDValidation
+type PerformThreePIDValidationRequest struct {
+	SessionID    string
+	ClientSecret string
+	Token        string
+}
+
+// PerformThreePIDValidationResponse is the response for PerformThreePIDValidation
+type PerformThreePIDValidationResponse struct {
+	Validated bool
+	NextLink  string
+}
+
+// QueryThreePIDValidationSessionRequest is the request for QueryThreePIDValidationSession
+type QueryThreePIDValidationSessionRequest struct {
+	SessionID    string
+	ClientSecret string
+}
+
+// QueryThreePIDValidationSessionResponse is the response for QueryThreePIDValidationSession
+type QueryThreePIDValidationSessionResponse struct {
+	// Validated is true if the session exists, the token was submitted and it
+	// hasn't been too long since then.
+	Validated bool
+	Medium    string
+	Address   string
+}
+
+// PerformThreePIDValidationSessionDeletionRequest is the request for PerformThreePIDValidationSessionDeletion
+type PerformThreePIDValidationSessionDeletionRequest struct {
+	SessionID string
+}
+
+// PerformThreePIDValidationSessionDeletionResponse is the response for PerformThreePIDValidationSessionDeletion
+type PerformThreePIDValidationSessionDeletionResponse struct{}
+
+// ThreePIDValidationSession is a session for validating a third-party
+// identifier by sending a token to it.
+type ThreePIDValidationSession struct {
+	SessionID    string
+	ClientSecret string
+	Medium       string
+	Address      string
+	Token        string
+	SendAttempt  int
+	NextLink     string
+	CreatedTS    int64
+	ValidatedTS  int64 // zero if the token hasn't been submitted
+}
+
 // QueryOpenIDTokenRequest is the request for QueryOpenIDToken
 type QueryOpenIDTokenRequest struct {
 	Token string
@@ -432,6 +718,11 @@ type Device struct {
 	// this is the appservice ID.
 	AppserviceID string
 	AccountType  AccountType
+	// ShadowBanned is true if the account owning this device is shadow-banned.
+	ShadowBanned bool
+	// ConsentNotGiven is true if the account owning this device hasn't
+	// consented to the current version of the terms of service.
+	ConsentNotGiven bool
 }
 
 // Account represents a Matrix account on this home server.
@@ -441,6 +732,15 @@ type Account struct {
 	ServerName   gomatrixserverlib.ServerName
 	AppServiceID string
 	AccountType  AccountType
+	// ShadowBanned accounts have their requests accepted, but never acted upon.
+	ShadowBanned bool
+	// ExpiresAtMS is when the account expires unless it is renewed, or zero
+	// if it never expires.
+	ExpiresAtMS int64
+	// PolicyVersion is the version of the terms of service the user has
+	// consented to, or empty if they haven't consented to any.
+	PolicyVersion string
+	Deactivated   bool
 	// TODO: Associations (e.g. with application services)
 }
 
@@ -457,6 +757,15 @@ type OpenIDTokenAttributes struct {
 	ExpiresAtMS int64
 }
 
+// ImpersonationAuditEntry records an access token issued to an admin for impersonating a user
+type ImpersonationAuditEntry struct {
+	AdminUserID string `json:"admin_user_id"`
+	Localpart   string `json:"-"`
+	DeviceID    string `json:"device_id"`
+	CreatedAtMS int64  `json:"created_ts"`
+	ExpiresAtMS int64  `json:"expires_ts"`
+}
+
 // UserInfo is for returning information about the user an OpenID token was issued for
 type UserInfo struct {
 	Sub string // The Matrix user's ID who generated the token
@@ -593,13 +902,29 @@ type QueryAccountAvailabilityResponse struct {
 	Available bool
 }
 
+// QueryAccountByLocalpartRequest is the request for QueryAccountByLocalpart
+type QueryAccountByLocalpartRequest struct {
+	Localpart string
+}
+
+// QueryAccountByLocalpartResponse is the response for QueryAccountByLocalpart
+type QueryAccountByLocalpartResponse struct {
+	Account *Account // nil if there is no account with the localpart
+}
+
 type QueryAccountByPasswordRequest struct {
 	Localpart, PlaintextPassword string
+	// RemoteAddr is the IP address the login came from, if known, which is
+	// used to lock out IP addresses with too many failed logins.
+	RemoteAddr string
 }
 
 type QueryAccountByPasswordResponse struct {
 	Account *Account
 	Exists  bool
+	// LockedUntilMS is set if the account or IP address is locked out because
+	// of too many failed logins, in which case the password isn't checked.
+	LockedUntilMS int64
 }
 
 type PerformUpdateDisplayNameRequest struct {
@@ -26,6 +26,14 @@ import (
 // Synapse uses 2 min (https://github.com/matrix-org/synapse/blob/78d5f91de1a9baf4dbb0a794cb49a799f29f7a38/synapse/handlers/auth.py#L1323-L1325).
 const DefaultLoginTokenLifetime = 2 * time.Minute
 
+// DefaultImpersonationTokenLifetime determines how long an admin
+// impersonation token is valid for, unless another lifetime is requested.
+const DefaultImpersonationTokenLifetime = time.Hour
+
+// MaxImpersonationTokenLifetime is the longest lifetime that may be
+// requested for an admin impersonation token.
+const MaxImpersonationTokenLifetime = 24 * time.Hour
+
 type LoginTokenInternalAPI interface {
 	// PerformLoginTokenCreation creates a new login token and associates it with the provided data.
 	PerformLoginTokenCreation(ctx context.Context, req *PerformLoginTokenCreationRequest, res *PerformLoginTokenCreationResponse) error
@@ -69,6 +69,104 @@ func (t *UserInternalAPITrace) PerformAccountDeactivation(ctx context.Context, r
 	util.GetLogger(ctx).Infof("PerformAccountDeactivation req=%+v res=%+v", js(req), js(res))
 	return err
 }
+func (t *UserInternalAPITrace) PerformShadowBan(ctx context.Context, req *PerformShadowBanRequest, res *PerformShadowBanResponse) error {
+	err := t.Impl.PerformShadowBan(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformShadowBan req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformImpersonationTokenCreation(ctx context.Context, req *PerformImpersonationTokenCreationRequest, res *PerformImpersonationTokenCreationResponse) error {
+	err := t.Impl.PerformImpersonationTokenCreation(ctx, req, res)
+	// Don't log the access token.
+	var deviceID string
+	if res.Device != nil {
+		deviceID = res.Device.ID
+	}
+	util.GetLogger(ctx).Infof(
+		"PerformImpersonationTokenCreation admin_user_id=%s localpart=%s lifetime=%s device_id=%s expires_at_ms=%d",
+		req.AdminUserID, req.Localpart, req.Lifetime, deviceID, res.ExpiresAtMS,
+	)
+	return err
+}
+func (t *UserInternalAPITrace) QueryImpersonationAudit(ctx context.Context, req *QueryImpersonationAuditRequest, res *QueryImpersonationAuditResponse) error {
+	err := t.Impl.QueryImpersonationAudit(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryImpersonationAudit req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryMonthlyActiveUsers(ctx context.Context, req *QueryMonthlyActiveUsersRequest, res *QueryMonthlyActiveUsersResponse) error {
+	err := t.Impl.QueryMonthlyActiveUsers(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryMonthlyActiveUsers req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformAccountValidityRenewal(ctx context.Context, req *PerformAccountValidityRenewalRequest, res *PerformAccountValidityRenewalResponse) error {
+	err := t.Impl.PerformAccountValidityRenewal(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformAccountValidityRenewal req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformAccountValidityReminderSent(ctx context.Context, req *PerformAccountValidityReminderSentRequest, res *PerformAccountValidityReminderSentResponse) error {
+	err := t.Impl.PerformAccountValidityReminderSent(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformAccountValidityReminderSent user_id=%s", req.UserID)
+	return err
+}
+func (t *UserInternalAPITrace) PerformAccountValidityReminders(ctx context.Context, req *PerformAccountValidityRemindersRequest, res *PerformAccountValidityRemindersResponse) error {
+	err := t.Impl.PerformAccountValidityReminders(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformAccountValidityReminders req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryPolicyVersion(ctx context.Context, req *QueryPolicyVersionRequest, res *QueryPolicyVersionResponse) error {
+	err := t.Impl.QueryPolicyVersion(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryPolicyVersion req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryOutdatedPolicy(ctx context.Context, req *QueryOutdatedPolicyRequest, res *QueryOutdatedPolicyResponse) error {
+	err := t.Impl.QueryOutdatedPolicy(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryOutdatedPolicy req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformUpdatePolicyVersion(ctx context.Context, req *PerformUpdatePolicyVersionRequest, res *PerformUpdatePolicyVersionResponse) error {
+	err := t.Impl.PerformUpdatePolicyVersion(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformUpdatePolicyVersion req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformLoginLockoutReset(ctx context.Context, req *PerformLoginLockoutResetRequest, res *PerformLoginLockoutResetResponse) error {
+	err := t.Impl.PerformLoginLockoutReset(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformLoginLockoutReset req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryLoginLockout(ctx context.Context, req *QueryLoginLockoutRequest, res *QueryLoginLockoutResponse) error {
+	err := t.Impl.QueryLoginLockout(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryLoginLockout req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformLoginAttempt(ctx context.Context, req *PerformLoginAttemptRequest, res *PerformLoginAttemptResponse) error {
+	err := t.Impl.PerformLoginAttempt(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformLoginAttempt req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformLoginFailureNotices(ctx context.Context, req *PerformLoginFailureNoticesRequest, res *PerformLoginFailureNoticesResponse) error {
+	err := t.Impl.PerformLoginFailureNotices(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformLoginFailureNotices req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformThreePIDValidationSessionCreation(ctx context.Context, req *PerformThreePIDValidationSessionCreationRequest, res *PerformThreePIDValidationSessionCreationResponse) error {
+	err := t.Impl.PerformThreePIDValidationSessionCreation(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformThreePIDValidationSessionCreation req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformThreePIDValidation(ctx context.Context, req *PerformThreePIDValidationRequest, res *PerformThreePIDValidationResponse) error {
+	err := t.Impl.PerformThreePIDValidation(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformThreePIDValidation req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) QueryThreePIDValidationSession(ctx context.Context, req *QueryThreePIDValidationSessionRequest, res *QueryThreePIDValidationSessionResponse) error {
+	err := t.Impl.QueryThreePIDValidationSession(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryThreePIDValidationSession req=%+v res=%+v", js(req), js(res))
+	return err
+}
+func (t *UserInternalAPITrace) PerformThreePIDValidationSessionDeletion(ctx context.Context, req *PerformThreePIDValidationSessionDeletionRequest, res *PerformThreePIDValidationSessionDeletionResponse) error {
+	err := t.Impl.PerformThreePIDValidationSessionDeletion(ctx, req, res)
+	util.GetLogger(ctx).Infof("PerformThreePIDValidationSessionDeletion req=%+v res=%+v", js(req), js(res))
+	return err
+}
 func (t *UserInternalAPITrace) PerformOpenIDTokenCreation(ctx context.Context, req *PerformOpenIDTokenCreationRequest, res *PerformOpenIDTokenCreationResponse) error {
 	err := t.Impl.PerformOpenIDTokenCreation(ctx, req, res)
 	util.GetLogger(ctx).Infof("PerformOpenIDTokenCreation req=%+v res=%+v", js(req), js(res))
@@ -162,6 +260,11 @@ func (t *UserInternalAPITrace) QueryNumericLocalpart(ctx context.Context, res *Q
 	return err
 }
 
+func (t *UserInternalAPITrace) QueryAccountByLocalpart(ctx context.Context, req *QueryAccountByLocalpartRequest, res *QueryAccountByLocalpartResponse) error {
+	err := t.Impl.QueryAccountByLocalpart(ctx, req, res)
+	util.GetLogger(ctx).Infof("QueryAccountByLocalpart req=%+v res=%+v", js(req), js(res))
+	return err
+}
 func (t *UserInternalAPITrace) QueryAccountAvailability(ctx context.Context, req *QueryAccountAvailabilityRequest, res *QueryAccountAvailabilityResponse) error {
 	err := t.Impl.QueryAccountAvailability(ctx, req, res)
 	util.GetLogger(ctx).Infof("QueryAccountAvailability req=%+v res=%+v", js(req), js(res))
@@ -44,13 +44,16 @@ import (
 )
 
 type UserInternalAPI struct {
-	DB           storage.Database
-	SyncProducer *producers.SyncAPI
+	Config          *config.UserAPI
+	DB              storage.Database
+	SyncProducer    *producers.SyncAPI
+	ErasureProducer *producers.UserErasure
 
 	DisableTLSValidation bool
 	ServerName           gomatrixserverlib.ServerName
-	// AppServices is the list of all registered AS
-	AppServices []config.ApplicationService
+	// AppServices returns the list of all registered AS, which can change
+	// at runtime. May be nil if there are none.
+	AppServices func() []config.ApplicationService
 	KeyAPI      keyapi.UserKeyAPI
 	RSAPI       rsapi.UserRoomserverAPI
 	PgClient    pushgateway.Client
@@ -155,6 +158,17 @@ func (a *UserInternalAPI) PerformAccountCreation(ctx context.Context, req *api.P
 		return nil
 	}
 
+	if err = a.setInitialAccountExpiry(ctx, acc); err != nil {
+		return err
+	}
+
+	if req.PolicyVersion != "" {
+		if err = a.DB.SetPolicyVersion(ctx, acc.Localpart, req.PolicyVersion); err != nil {
+			return fmt.Errorf("a.DB.SetPolicyVersion: %w", err)
+		}
+		acc.PolicyVersion = req.PolicyVersion
+	}
+
 	// Inform the SyncAPI about the newly created push_rules
 	if err = a.SyncProducer.SendAccountData(acc.UserID, eventutil.AccountData{
 		Type: "m.push_rules",
@@ -471,11 +485,36 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 	if err != nil {
 		return err
 	}
+	// Impersonation tokens are short-lived, so stop accepting them once they
+	// have expired. The device itself is removed by PruneImpersonations.
+	expiresAtMS, err := a.DB.GetImpersonationExpiry(ctx, req.AccessToken)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case expiresAtMS <= time.Now().UnixNano()/int64(time.Millisecond):
+		return nil
+	}
 	acc, err := a.DB.GetAccountByLocalpart(ctx, localPart)
 	if err != nil {
 		return err
 	}
+	if acc.ExpiresAtMS > 0 && acc.ExpiresAtMS <= int64(gomatrixserverlib.AsTimestamp(time.Now())) {
+		res.AccountExpired = true
+		return nil
+	}
+	allowed, err := a.checkMonthlyActiveUser(ctx, acc)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		res.ResourceLimitExceeded = true
+		res.AdminContact = a.Config.Matrix.MAU.AdminContact
+		return nil
+	}
 	device.AccountType = acc.AccountType
+	device.ShadowBanned = acc.ShadowBanned
+	device.ConsentNotGiven = a.consentNotGiven(acc)
 	res.Device = device
 	return nil
 }
@@ -484,8 +523,11 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 // creating a 'device'.
 func (a *UserInternalAPI) queryAppServiceToken(ctx context.Context, token, appServiceUserID string) (*api.Device, error) {
 	// Search for app service with given access_token
+	if a.AppServices == nil {
+		return nil, nil
+	}
 	var appService *config.ApplicationService
-	for _, as := range a.AppServices {
+	for _, as := range a.AppServices() {
 		if as.ASToken == token {
 			appService = &as
 			break
@@ -530,15 +572,21 @@ func (a *UserInternalAPI) queryAppServiceToken(ctx context.Context, token, appSe
 
 // PerformAccountDeactivation deactivates the user's account, removing all ability for the user to login again.
 func (a *UserInternalAPI) PerformAccountDeactivation(ctx context.Context, req *api.PerformAccountDeactivationRequest, res *api.PerformAccountDeactivationResponse) error {
-	evacuateReq := &rsapi.PerformAdminEvacuateUserRequest{
-		UserID: fmt.Sprintf("@%s:%s", req.Localpart, a.ServerName),
-	}
-	evacuateRes := &rsapi.PerformAdminEvacuateUserResponse{}
-	if err := a.RSAPI.PerformAdminEvacuateUser(ctx, evacuateReq, evacuateRes); err != nil {
-		return err
-	}
-	if err := evacuateRes.Error; err != nil {
-		logrus.WithError(err).Errorf("Failed to evacuate user after account deactivation")
+	if req.Erase {
+		if err := a.eraseUser(ctx, req.Localpart); err != nil {
+			return err
+		}
+	} else {
+		evacuateReq := &rsapi.PerformAdminEvacuateUserRequest{
+			UserID: fmt.Sprintf("@%s:%s", req.Localpart, a.ServerName),
+		}
+		evacuateRes := &rsapi.PerformAdminEvacuateUserResponse{}
+		if err := a.RSAPI.PerformAdminEvacuateUser(ctx, evacuateReq, evacuateRes); err != nil {
+			return err
+		}
+		if err := evacuateRes.Error; err != nil {
+			logrus.WithError(err).Errorf("Failed to evacuate user after account deactivation")
+		}
 	}
 
 	deviceReq := &api.PerformDeviceDeletionRequest{
@@ -561,6 +609,46 @@ func (a *UserInternalAPI) PerformAccountDeactivation(ctx context.Context, req *a
 	return err
 }
 
+// PerformShadowBan shadow-bans the user's account, or lifts the shadow-ban.
+func (a *UserInternalAPI) PerformShadowBan(ctx context.Context, req *api.PerformShadowBanRequest, res *api.PerformShadowBanResponse) error {
+	if _, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	res.AccountExists = true
+	return a.DB.SetShadowBanned(ctx, req.Localpart, req.ShadowBanned)
+}
+
+// eraseUser blanks the user's profile, asks the media API to remove their
+// uploads and the sync API to erase their events, and starts the roomserver
+// erasure job, which redacts their events and makes them leave all of their
+// rooms in the background.
+func (a *UserInternalAPI) eraseUser(ctx context.Context, localpart string) error {
+	userID := fmt.Sprintf("@%s:%s", localpart, a.ServerName)
+	if err := a.DB.SetDisplayName(ctx, localpart, ""); err != nil {
+		return fmt.Errorf("a.DB.SetDisplayName: %w", err)
+	}
+	if err := a.DB.SetAvatarURL(ctx, localpart, ""); err != nil {
+		return fmt.Errorf("a.DB.SetAvatarURL: %w", err)
+	}
+	if err := a.ErasureProducer.SendUserErased(userID); err != nil {
+		return fmt.Errorf("a.ErasureProducer.SendUserErased: %w", err)
+	}
+	eraseReq := &rsapi.PerformAdminEraseUserRequest{
+		UserID: userID,
+	}
+	eraseRes := &rsapi.PerformAdminEraseUserResponse{}
+	if err := a.RSAPI.PerformAdminEraseUser(ctx, eraseReq, eraseRes); err != nil {
+		return err
+	}
+	if err := eraseRes.Error; err != nil {
+		logrus.WithError(err).Errorf("Failed to start erasing user after account deactivation")
+	}
+	return nil
+}
+
 // PerformOpenIDTokenCreation creates a new token that a relying party uses to authenticate a user
 func (a *UserInternalAPI) PerformOpenIDTokenCreation(ctx context.Context, req *api.PerformOpenIDTokenCreationRequest, res *api.PerformOpenIDTokenCreationResponse) error {
 	token := util.RandomString(24)
@@ -576,6 +664,74 @@ func (a *UserInternalAPI) PerformOpenIDTokenCreation(ctx context.Context, req *a
 	return err
 }
 
+// PerformImpersonationTokenCreation creates a new, short-lived device for an admin to
+// impersonate the given user, and records this in the impersonation audit log.
+func (a *UserInternalAPI) PerformImpersonationTokenCreation(ctx context.Context, req *api.PerformImpersonationTokenCreationRequest, res *api.PerformImpersonationTokenCreationResponse) error {
+	lifetime := req.Lifetime
+	if lifetime <= 0 {
+		lifetime = api.DefaultImpersonationTokenLifetime
+	}
+	if lifetime > api.MaxImpersonationTokenLifetime {
+		lifetime = api.MaxImpersonationTokenLifetime
+	}
+	if _, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("a.DB.GetAccountByLocalpart: %w", err)
+	}
+
+	displayName := fmt.Sprintf("Admin impersonation by %s", req.AdminUserID)
+	deviceReq := &api.PerformDeviceCreationRequest{
+		Localpart:         req.Localpart,
+		AccessToken:       req.AccessToken,
+		DeviceDisplayName: &displayName,
+		// The impersonated user's other devices shouldn't be told about this one.
+		NoDeviceListUpdate: true,
+	}
+	deviceRes := &api.PerformDeviceCreationResponse{}
+	if err := a.PerformDeviceCreation(ctx, deviceReq, deviceRes); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	createdAtMS := now.UnixNano() / int64(time.Millisecond)
+	expiresAtMS := now.Add(lifetime).UnixNano() / int64(time.Millisecond)
+	if err := a.DB.StoreImpersonation(ctx, req.AdminUserID, req.Localpart, deviceRes.Device.ID, deviceRes.Device.AccessToken, createdAtMS, expiresAtMS); err != nil {
+		// Don't hand out a token which isn't in the audit log.
+		if rmErr := a.DB.RemoveDevices(ctx, req.Localpart, []string{deviceRes.Device.ID}); rmErr != nil {
+			util.GetLogger(ctx).WithError(rmErr).Error("Failed to remove impersonation device")
+		}
+		return fmt.Errorf("a.DB.StoreImpersonation: %w", err)
+	}
+	util.GetLogger(ctx).WithFields(logrus.Fields{
+		"admin_user_id": req.AdminUserID,
+		"localpart":     req.Localpart,
+		"device_id":     deviceRes.Device.ID,
+	}).Warn("Issued admin impersonation token")
+
+	res.Device = deviceRes.Device
+	res.ExpiresAtMS = expiresAtMS
+	return nil
+}
+
+// PruneImpersonations logs out the devices of impersonation tokens which have expired.
+func (a *UserInternalAPI) PruneImpersonations(ctx context.Context) {
+	if err := a.DB.RemoveExpiredImpersonations(ctx, time.Now().UnixNano()/int64(time.Millisecond)); err != nil {
+		logrus.WithError(err).Error("Failed to prune expired impersonations")
+	}
+}
+
+// QueryImpersonationAudit returns the audit log of admin impersonations of the given user.
+func (a *UserInternalAPI) QueryImpersonationAudit(ctx context.Context, req *api.QueryImpersonationAuditRequest, res *api.QueryImpersonationAuditResponse) error {
+	entries, err := a.DB.GetImpersonations(ctx, req.Localpart)
+	if err != nil {
+		return err
+	}
+	res.Entries = entries
+	return nil
+}
+
 // QueryOpenIDToken validates that the OpenID token was issued for the user, the replying party uses this for validation
 func (a *UserInternalAPI) QueryOpenIDToken(ctx context.Context, req *api.QueryOpenIDTokenRequest, res *api.QueryOpenIDTokenResponse) error {
 	openIDTokenAttrs, err := a.DB.GetOpenIDTokenAttributes(ctx, req.Token)
@@ -831,16 +987,47 @@ func (a *UserInternalAPI) QueryAccountAvailability(ctx context.Context, req *api
 	return err
 }
 
+func (a *UserInternalAPI) QueryAccountByLocalpart(ctx context.Context, req *api.QueryAccountByLocalpartRequest, res *api.QueryAccountByLocalpartResponse) error {
+	acc, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	}
+	res.Account = acc
+	return nil
+}
+
 func (a *UserInternalAPI) QueryAccountByPassword(ctx context.Context, req *api.QueryAccountByPasswordRequest, res *api.QueryAccountByPasswordResponse) error {
+	lockout := a.loginLockoutEnabled()
+	if lockout {
+		// Don't check the password at all while locked out, so that guessing
+		// it gets no further.
+		lockedUntil, err := a.loginLockedUntil(ctx, req.Localpart, req.RemoteAddr)
+		if err != nil || lockedUntil > 0 {
+			res.LockedUntilMS = lockedUntil
+			return err
+		}
+	}
 	acc, err := a.DB.GetAccountByPassword(ctx, req.Localpart, req.PlaintextPassword)
 	switch err {
 	case sql.ErrNoRows: // user does not exist
+		if lockout {
+			return a.loginFailed(ctx, req.Localpart, req.RemoteAddr, false)
+		}
 		return nil
 	case bcrypt.ErrMismatchedHashAndPassword: // user exists, but password doesn't match
+		if lockout {
+			return a.loginFailed(ctx, req.Localpart, req.RemoteAddr, true)
+		}
 		return nil
 	default:
 		res.Exists = true
 		res.Account = acc
+		if lockout {
+			return a.DB.RemoveLoginFailures(ctx, loginFailuresUser, req.Localpart)
+		}
 		return nil
 	}
 }
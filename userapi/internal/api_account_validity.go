@@ -0,0 +1,151 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// renewalTokenByteLength is the length of generated renewal tokens.
+const renewalTokenByteLength = 32
+
+// accountValidityEnabled returns true if accounts expire unless renewed.
+func (a *UserInternalAPI) accountValidityEnabled() bool {
+	return a.Config != nil && a.Config.Matrix.AccountValidity.Enabled
+}
+
+// setInitialAccountExpiry sets when a newly created account expires. Admin
+// and appservice accounts never expire.
+func (a *UserInternalAPI) setInitialAccountExpiry(ctx context.Context, acc *api.Account) error {
+	if !a.accountValidityEnabled() {
+		return nil
+	}
+	switch acc.AccountType {
+	case api.AccountTypeAdmin, api.AccountTypeAppService:
+		return nil
+	}
+	expiresAt := gomatrixserverlib.AsTimestamp(time.Now().Add(a.Config.Matrix.AccountValidity.Period))
+	if err := a.DB.SetAccountExpiry(ctx, acc.Localpart, int64(expiresAt)); err != nil {
+		return fmt.Errorf("a.DB.SetAccountExpiry: %w", err)
+	}
+	acc.ExpiresAtMS = int64(expiresAt)
+	return nil
+}
+
+// PerformAccountValidityRenewal extends the validity of an account, either
+// identified by the renewal token sent to its user or by its user ID.
+func (a *UserInternalAPI) PerformAccountValidityRenewal(ctx context.Context, req *api.PerformAccountValidityRenewalRequest, res *api.PerformAccountValidityRenewalResponse) error {
+	var localpart string
+	var err error
+	if req.RenewalToken != "" {
+		localpart, err = a.DB.GetLocalpartForRenewalToken(ctx, req.RenewalToken)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("a.DB.GetLocalpartForRenewalToken: %w", err)
+		}
+	} else {
+		var domain gomatrixserverlib.ServerName
+		localpart, domain, err = gomatrixserverlib.SplitID('@', req.UserID)
+		if err != nil {
+			return err
+		}
+		if domain != a.ServerName {
+			return fmt.Errorf("cannot renew a remote account: got %s want %s", domain, a.ServerName)
+		}
+		if _, err = a.DB.GetAccountByLocalpart(ctx, localpart); err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("a.DB.GetAccountByLocalpart: %w", err)
+		}
+	}
+
+	expiresAt := req.ExpiresAtMS
+	if expiresAt == 0 {
+		if a.Config == nil || a.Config.Matrix.AccountValidity.Period <= 0 {
+			return fmt.Errorf("no expiry given and no account validity period configured")
+		}
+		expiresAt = int64(gomatrixserverlib.AsTimestamp(time.Now().Add(a.Config.Matrix.AccountValidity.Period)))
+	}
+	if err = a.DB.SetAccountExpiry(ctx, localpart, expiresAt); err != nil {
+		return fmt.Errorf("a.DB.SetAccountExpiry: %w", err)
+	}
+	util.GetLogger(ctx).WithField("localpart", localpart).Infof("Renewed account until %d", expiresAt)
+	res.Renewed = true
+	res.UserID = userutil.MakeUserID(localpart, a.ServerName)
+	res.ExpiresAtMS = expiresAt
+	return nil
+}
+
+// PerformAccountValidityReminders generates renewal tokens for the accounts
+// which will soon expire and whose users haven't been reminded yet, so that
+// their users can be sent renewal links. The tokens aren't stored until
+// PerformAccountValidityReminderSent is called, so users who couldn't be
+// reminded are tried again later.
+func (a *UserInternalAPI) PerformAccountValidityReminders(ctx context.Context, req *api.PerformAccountValidityRemindersRequest, res *api.PerformAccountValidityRemindersResponse) error {
+	if !a.accountValidityEnabled() {
+		return nil
+	}
+	before := gomatrixserverlib.AsTimestamp(time.Now().Add(a.Config.Matrix.AccountValidity.RenewAt))
+	accounts, err := a.DB.GetAccountsDueRenewal(ctx, int64(before))
+	if err != nil {
+		return fmt.Errorf("a.DB.GetAccountsDueRenewal: %w", err)
+	}
+	for localpart, expiresAt := range accounts {
+		token, err := generateRenewalToken()
+		if err != nil {
+			return err
+		}
+		res.Reminders = append(res.Reminders, api.AccountValidityReminder{
+			UserID:       userutil.MakeUserID(localpart, a.ServerName),
+			ExpiresAtMS:  expiresAt,
+			RenewalToken: token,
+		})
+	}
+	return nil
+}
+
+// PerformAccountValidityReminderSent stores the renewal token which the user
+// was reminded with, so that the link works and they aren't reminded again.
+func (a *UserInternalAPI) PerformAccountValidityReminderSent(ctx context.Context, req *api.PerformAccountValidityReminderSentRequest, res *api.PerformAccountValidityReminderSentResponse) error {
+	localpart, _, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if err = a.DB.SetRenewalToken(ctx, localpart, req.RenewalToken); err != nil {
+		return fmt.Errorf("a.DB.SetRenewalToken: %w", err)
+	}
+	return nil
+}
+
+func generateRenewalToken() (string, error) {
+	b := make([]byte, renewalTokenByteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
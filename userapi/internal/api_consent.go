@@ -0,0 +1,92 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// userConsentEnabled returns true if users must consent to the terms of service.
+func (a *UserInternalAPI) userConsentEnabled() bool {
+	return a.Config != nil && a.Config.Matrix.UserConsent.Enabled
+}
+
+// consentNotGiven returns true if the account must consent to the current
+// version of the terms of service before it can be used. Guests, appservice
+// users and the server notices user are exempt.
+func (a *UserInternalAPI) consentNotGiven(acc *api.Account) bool {
+	if !a.userConsentEnabled() {
+		return false
+	}
+	switch acc.AccountType {
+	case api.AccountTypeGuest, api.AccountTypeAppService:
+		return false
+	}
+	if acc.Localpart == a.Config.Matrix.ServerNotices.LocalPart {
+		return false
+	}
+	return acc.PolicyVersion != a.Config.Matrix.UserConsent.Version
+}
+
+// QueryPolicyVersion returns the version of the terms of service the user has
+// consented to.
+func (a *UserInternalAPI) QueryPolicyVersion(ctx context.Context, req *api.QueryPolicyVersionRequest, res *api.QueryPolicyVersionResponse) error {
+	acc, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("a.DB.GetAccountByLocalpart: %w", err)
+	}
+	res.PolicyVersion = acc.PolicyVersion
+	return nil
+}
+
+// QueryOutdatedPolicy returns the users who haven't consented to the given
+// version of the terms of service and haven't been sent a server notice
+// about it yet.
+func (a *UserInternalAPI) QueryOutdatedPolicy(ctx context.Context, req *api.QueryOutdatedPolicyRequest, res *api.QueryOutdatedPolicyResponse) error {
+	localparts, err := a.DB.GetOutdatedPolicy(ctx, req.PolicyVersion)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetOutdatedPolicy: %w", err)
+	}
+	res.UserLocalparts = make([]string, 0, len(localparts))
+	for _, localpart := range localparts {
+		if a.Config != nil && localpart == a.Config.Matrix.ServerNotices.LocalPart {
+			continue
+		}
+		res.UserLocalparts = append(res.UserLocalparts, localpart)
+	}
+	return nil
+}
+
+// PerformUpdatePolicyVersion records that the user has consented to, or has
+// been sent a server notice about, the given version of the terms of service.
+func (a *UserInternalAPI) PerformUpdatePolicyVersion(ctx context.Context, req *api.PerformUpdatePolicyVersionRequest, res *api.PerformUpdatePolicyVersionResponse) error {
+	if req.ServerNoticeUpdate {
+		if err := a.DB.SetPolicyVersionSent(ctx, req.Localpart, req.PolicyVersion); err != nil {
+			return fmt.Errorf("a.DB.SetPolicyVersionSent: %w", err)
+		}
+		return nil
+	}
+	if err := a.DB.SetPolicyVersion(ctx, req.Localpart, req.PolicyVersion); err != nil {
+		return fmt.Errorf("a.DB.SetPolicyVersion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// The kinds of failed login records, which are counted separately.
+const (
+	loginFailuresUser = "user"
+	loginFailuresIP   = "ip"
+)
+
+// loginLockoutEnabled returns true if failed logins are tracked, and accounts
+// and IP addresses with too many of them are locked out.
+func (a *UserInternalAPI) loginLockoutEnabled() bool {
+	return a.Config != nil && a.Config.Matrix.LoginLockout.Enabled
+}
+
+// loginLockedUntil returns when the lockout of the account or the IP address
+// ends, whichever is later, or zero if neither is locked out.
+func (a *UserInternalAPI) loginLockedUntil(ctx context.Context, localpart, remoteAddr string) (int64, error) {
+	now := int64(gomatrixserverlib.AsTimestamp(time.Now()))
+	var lockedUntil int64
+	for kind, key := range map[string]string{loginFailuresUser: localpart, loginFailuresIP: remoteAddr} {
+		if key == "" {
+			continue
+		}
+		f, err := a.DB.GetLoginFailures(ctx, kind, key)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			return 0, fmt.Errorf("a.DB.GetLoginFailures: %w", err)
+		}
+		if f.LockedUntilTS > now && f.LockedUntilTS > lockedUntil {
+			lockedUntil = f.LockedUntilTS
+		}
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginFailure counts a failed login to the account or from the IP
+// address, locking it out once there have been maxFailures in a row.
+func (a *UserInternalAPI) recordLoginFailure(ctx context.Context, kind, key, remoteAddr string, maxFailures int) error {
+	cfg := &a.Config.Matrix.LoginLockout
+	now := time.Now()
+	err := a.DB.UpdateLoginFailures(ctx, kind, key, func(f *api.LoginFailures) {
+		// Failures are forgotten once there haven't been any for a while.
+		if now.Sub(gomatrixserverlib.Timestamp(f.LastFailureTS).Time()) > cfg.MaxDuration {
+			*f = api.LoginFailures{}
+		}
+		f.Failures++
+		f.LastFailureTS = int64(gomatrixserverlib.AsTimestamp(now))
+		if kind == loginFailuresUser {
+			f.LastIP = remoteAddr
+		}
+		if d := cfg.LockoutDuration(f.Failures, maxFailures); d > 0 {
+			f.LockedUntilTS = int64(gomatrixserverlib.AsTimestamp(now.Add(d)))
+			logrus.WithField(kind, key).Warnf("Locking out login for %s after %d failed attempts", d, f.Failures)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("a.DB.UpdateLoginFailures: %w", err)
+	}
+	return nil
+}
+
+// loginFailed records a failed login to the given account from the given IP
+// address. Failures are only counted against accounts which exist.
+func (a *UserInternalAPI) loginFailed(ctx context.Context, localpart, remoteAddr string, accountExists bool) error {
+	cfg := &a.Config.Matrix.LoginLockout
+	if accountExists {
+		if err := a.recordLoginFailure(ctx, loginFailuresUser, localpart, remoteAddr, cfg.MaxFailures); err != nil {
+			return err
+		}
+	}
+	if remoteAddr != "" {
+		return a.recordLoginFailure(ctx, loginFailuresIP, remoteAddr, remoteAddr, cfg.MaxFailuresPerIP)
+	}
+	return nil
+}
+
+// QueryLoginLockout returns whether the account or the IP address is locked
+// out, for logins which are checked outside of the user API.
+func (a *UserInternalAPI) QueryLoginLockout(ctx context.Context, req *api.QueryLoginLockoutRequest, res *api.QueryLoginLockoutResponse) error {
+	if !a.loginLockoutEnabled() {
+		return nil
+	}
+	var err error
+	res.LockedUntilMS, err = a.loginLockedUntil(ctx, req.Localpart, req.RemoteAddr)
+	return err
+}
+
+// PerformLoginAttempt records the outcome of a login which was checked
+// outside of the user API, in the same way as QueryAccountByPassword does.
+func (a *UserInternalAPI) PerformLoginAttempt(ctx context.Context, req *api.PerformLoginAttemptRequest, res *api.PerformLoginAttemptResponse) error {
+	if !a.loginLockoutEnabled() {
+		return nil
+	}
+	if req.Succeeded {
+		return a.DB.RemoveLoginFailures(ctx, loginFailuresUser, req.Localpart)
+	}
+	_, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart)
+	switch {
+	case err == sql.ErrNoRows:
+		return a.loginFailed(ctx, req.Localpart, req.RemoteAddr, false)
+	case err != nil:
+		return fmt.Errorf("a.DB.GetAccountByLocalpart: %w", err)
+	}
+	return a.loginFailed(ctx, req.Localpart, req.RemoteAddr, true)
+}
+
+// PerformLoginLockoutReset forgets the failed logins to the account, unlocking
+// it if it is locked out.
+func (a *UserInternalAPI) PerformLoginLockoutReset(ctx context.Context, req *api.PerformLoginLockoutResetRequest, res *api.PerformLoginLockoutResetResponse) error {
+	if _, err := a.DB.GetAccountByLocalpart(ctx, req.Localpart); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	res.AccountExists = true
+	return a.DB.RemoveLoginFailures(ctx, loginFailuresUser, req.Localpart)
+}
+
+// PerformLoginFailureNotices returns the users who should be told about failed
+// logins to their accounts, and marks them as told so that they are only
+// returned once for each run of failures.
+func (a *UserInternalAPI) PerformLoginFailureNotices(ctx context.Context, req *api.PerformLoginFailureNoticesRequest, res *api.PerformLoginFailureNoticesResponse) error {
+	if !a.loginLockoutEnabled() || a.Config.Matrix.LoginLockout.NotifyAfter == 0 {
+		return nil
+	}
+	failures, err := a.DB.GetLoginFailuresToNotify(ctx, a.Config.Matrix.LoginLockout.NotifyAfter)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetLoginFailuresToNotify: %w", err)
+	}
+	now := int64(gomatrixserverlib.AsTimestamp(time.Now()))
+	for localpart, f := range failures {
+		if err = a.DB.UpdateLoginFailures(ctx, loginFailuresUser, localpart, func(f *api.LoginFailures) {
+			f.Notified = true
+		}); err != nil {
+			return fmt.Errorf("a.DB.UpdateLoginFailures: %w", err)
+		}
+		notice := api.LoginFailureNotice{
+			UserID:   userutil.MakeUserID(localpart, a.ServerName),
+			Failures: f.Failures,
+			LastIP:   f.LastIP,
+		}
+		if f.LockedUntilTS > now {
+			notice.LockedUntilMS = f.LockedUntilTS
+		}
+		res.Notices = append(res.Notices, notice)
+	}
+	return nil
+}
+
+// PruneLoginFailures forgets about failed logins which are old enough that
+// they no longer count towards a lockout.
+func (a *UserInternalAPI) PruneLoginFailures(ctx context.Context) {
+	before := int64(gomatrixserverlib.AsTimestamp(time.Now().Add(-a.Config.Matrix.LoginLockout.MaxDuration)))
+	if err := a.DB.RemoveLoginFailuresBefore(ctx, before); err != nil {
+		logrus.WithError(err).Error("Failed to prune login failures")
+	}
+}
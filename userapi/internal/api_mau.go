@@ -0,0 +1,104 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// mauPeriod is how recently a user must have been active to count as a
+	// monthly active user.
+	mauPeriod = 30 * 24 * time.Hour
+	// mauUpdateInterval is how often a user's activity is written to the
+	// database, so that we don't write on every request.
+	mauUpdateInterval = time.Hour
+)
+
+// mauEnabled returns true if monthly active users are tracked and limited.
+func (a *UserInternalAPI) mauEnabled() bool {
+	return a.Config != nil && a.Config.Matrix.MAU.Enabled
+}
+
+// checkMonthlyActiveUser records that the user is active, returning false if
+// they aren't allowed to use the server because it has reached its monthly
+// active user limit. Users who are already counted as active and reserved
+// users are always allowed.
+func (a *UserInternalAPI) checkMonthlyActiveUser(ctx context.Context, acc *api.Account) (bool, error) {
+	if !a.mauEnabled() || acc.AccountType == api.AccountTypeAppService {
+		return true, nil
+	}
+	if acc.Localpart == a.Config.Matrix.ServerNotices.LocalPart {
+		return true, nil
+	}
+	now := time.Now()
+	lastActive, err := a.DB.GetMonthlyActiveUserTimestamp(ctx, acc.Localpart)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return false, err
+	case now.Sub(gomatrixserverlib.Timestamp(lastActive).Time()) < mauUpdateInterval:
+		return true, nil
+	case now.Sub(gomatrixserverlib.Timestamp(lastActive).Time()) < mauPeriod:
+		return true, a.DB.MarkMonthlyActiveUser(ctx, acc.Localpart, int64(gomatrixserverlib.AsTimestamp(now)))
+	}
+	// The user isn't active yet, so only allow them if there's room for them.
+	mau := &a.Config.Matrix.MAU
+	if mau.IsReservedUser(acc.UserID) {
+		return true, a.DB.MarkMonthlyActiveUser(ctx, acc.Localpart, int64(gomatrixserverlib.AsTimestamp(now)))
+	}
+	return a.DB.MarkNewMonthlyActiveUser(
+		ctx, acc.Localpart, int64(gomatrixserverlib.AsTimestamp(now)),
+		int64(gomatrixserverlib.AsTimestamp(now.Add(-mauPeriod))), mau.MaxMAU,
+	)
+}
+
+// QueryMonthlyActiveUsers returns how many users have been active in the last 30 days.
+func (a *UserInternalAPI) QueryMonthlyActiveUsers(ctx context.Context, req *api.QueryMonthlyActiveUsersRequest, res *api.QueryMonthlyActiveUsersResponse) error {
+	since := int64(gomatrixserverlib.AsTimestamp(time.Now().Add(-mauPeriod)))
+	count, err := a.DB.CountMonthlyActiveUsers(ctx, since)
+	if err != nil {
+		return err
+	}
+	res.Count = count
+	if !req.IncludeUserIDs {
+		return nil
+	}
+	localparts, err := a.DB.GetMonthlyActiveUsers(ctx, since)
+	if err != nil {
+		return err
+	}
+	res.UserIDs = make([]string, 0, len(localparts))
+	for _, localpart := range localparts {
+		res.UserIDs = append(res.UserIDs, userutil.MakeUserID(localpart, a.ServerName))
+	}
+	return nil
+}
+
+// PruneMonthlyActiveUsers forgets users which haven't been active in the
+// last 30 days.
+func (a *UserInternalAPI) PruneMonthlyActiveUsers(ctx context.Context) {
+	before := int64(gomatrixserverlib.AsTimestamp(time.Now().Add(-mauPeriod)))
+	if err := a.DB.RemoveMonthlyActiveUsersBefore(ctx, before); err != nil {
+		logrus.WithError(err).Error("Failed to prune monthly active users")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// validationTokenByteLength is the length of generated validation session IDs
+// and tokens.
+const validationTokenByteLength = 32
+
+// threePIDValidationEnabled returns true if the server validates third-party
+// identifiers itself, rather than asking an identity server to.
+func (a *UserInternalAPI) threePIDValidationEnabled() bool {
+	return a.Config != nil && a.Config.Matrix.EmailValidation.Enabled
+}
+
+func (a *UserInternalAPI) PerformThreePIDValidationSessionCreation(ctx context.Context, req *api.PerformThreePIDValidationSessionCreationRequest, res *api.PerformThreePIDValidationSessionCreationResponse) error {
+	if !a.threePIDValidationEnabled() {
+		return fmt.Errorf("third-party identifier validation is not enabled")
+	}
+	sessionID, err := generateValidationToken()
+	if err != nil {
+		return err
+	}
+	token, err := generateValidationToken()
+	if err != nil {
+		return err
+	}
+	session, send, err := a.DB.CreateThreePIDValidationSession(ctx, &api.ThreePIDValidationSession{
+		SessionID:    sessionID,
+		ClientSecret: req.ClientSecret,
+		Medium:       req.Medium,
+		Address:      req.Address,
+		Token:        token,
+		SendAttempt:  req.SendAttempt,
+		NextLink:     req.NextLink,
+		CreatedTS:    int64(gomatrixserverlib.AsTimestamp(time.Now())),
+	})
+	if err != nil {
+		return fmt.Errorf("a.DB.CreateThreePIDValidationSession: %w", err)
+	}
+	res.SessionID = session.SessionID
+	res.Token = session.Token
+	res.Send = send
+	return nil
+}
+
+func (a *UserInternalAPI) PerformThreePIDValidation(ctx context.Context, req *api.PerformThreePIDValidationRequest, res *api.PerformThreePIDValidationResponse) error {
+	if !a.threePIDValidationEnabled() {
+		return nil
+	}
+	session, err := a.getThreePIDValidationSession(ctx, req.SessionID, req.ClientSecret)
+	if err != nil || session == nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.Token), []byte(req.Token)) != 1 {
+		return nil
+	}
+	// The token has to be used within its lifetime of being sent.
+	now := time.Now()
+	if now.Sub(gomatrixserverlib.Timestamp(session.CreatedTS).Time()) > a.Config.Matrix.EmailValidation.TokenLifetime {
+		return nil
+	}
+	if session.ValidatedTS == 0 {
+		if err = a.DB.MarkThreePIDValidationSessionValidated(ctx, session.SessionID, int64(gomatrixserverlib.AsTimestamp(now))); err != nil {
+			return fmt.Errorf("a.DB.MarkThreePIDValidationSessionValidated: %w", err)
+		}
+	}
+	res.Validated = true
+	res.NextLink = session.NextLink
+	return nil
+}
+
+func (a *UserInternalAPI) QueryThreePIDValidationSession(ctx context.Context, req *api.QueryThreePIDValidationSessionRequest, res *api.QueryThreePIDValidationSessionResponse) error {
+	if !a.threePIDValidationEnabled() {
+		return nil
+	}
+	session, err := a.getThreePIDValidationSession(ctx, req.SessionID, req.ClientSecret)
+	if err != nil || session == nil || session.ValidatedTS == 0 {
+		return err
+	}
+	// Once validated, the third-party identifier has to be used within the
+	// token lifetime, otherwise it has to be validated again.
+	if time.Since(gomatrixserverlib.Timestamp(session.ValidatedTS).Time()) > a.Config.Matrix.EmailValidation.TokenLifetime {
+		return nil
+	}
+	res.Validated = true
+	res.Medium = session.Medium
+	res.Address = session.Address
+	return nil
+}
+
+func (a *UserInternalAPI) PerformThreePIDValidationSessionDeletion(ctx context.Context, req *api.PerformThreePIDValidationSessionDeletionRequest, res *api.PerformThreePIDValidationSessionDeletionResponse) error {
+	return a.DB.RemoveThreePIDValidationSession(ctx, req.SessionID)
+}
+
+// getThreePIDValidationSession returns the session with the given ID, or nil
+// if there isn't one or it was created with a different client secret.
+func (a *UserInternalAPI) getThreePIDValidationSession(ctx context.Context, sessionID, clientSecret string) (*api.ThreePIDValidationSession, error) {
+	session, err := a.DB.GetThreePIDValidationSession(ctx, sessionID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("a.DB.GetThreePIDValidationSession: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(session.ClientSecret), []byte(clientSecret)) != 1 {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// PruneThreePIDValidationSessions removes the validation sessions which can no
+// longer be used, i.e. those which were created more than twice the token
+// lifetime ago.
+func (a *UserInternalAPI) PruneThreePIDValidationSessions(ctx context.Context) {
+	before := int64(gomatrixserverlib.AsTimestamp(time.Now().Add(-2 * a.Config.Matrix.EmailValidation.TokenLifetime)))
+	if err := a.DB.RemoveThreePIDValidationSessionsBefore(ctx, before); err != nil {
+		logrus.WithError(err).Error("Failed to prune third-party identifier validation sessions")
+	}
+}
+
+func generateValidationToken() (string, error) {
+	b := make([]byte, validationTokenByteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
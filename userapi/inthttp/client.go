@@ -27,22 +27,39 @@ import (
 const (
 	InputAccountDataPath = "/userapi/inputAccountData"
 
-	PerformDeviceCreationPath          = "/userapi/performDeviceCreation"
-	PerformAccountCreationPath         = "/userapi/performAccountCreation"
-	PerformPasswordUpdatePath          = "/userapi/performPasswordUpdate"
-	PerformDeviceDeletionPath          = "/userapi/performDeviceDeletion"
-	PerformLastSeenUpdatePath          = "/userapi/performLastSeenUpdate"
-	PerformDeviceUpdatePath            = "/userapi/performDeviceUpdate"
-	PerformAccountDeactivationPath     = "/userapi/performAccountDeactivation"
-	PerformOpenIDTokenCreationPath     = "/userapi/performOpenIDTokenCreation"
-	PerformKeyBackupPath               = "/userapi/performKeyBackup"
-	PerformPusherSetPath               = "/pushserver/performPusherSet"
-	PerformPusherDeletionPath          = "/pushserver/performPusherDeletion"
-	PerformPushRulesPutPath            = "/pushserver/performPushRulesPut"
-	PerformSetAvatarURLPath            = "/userapi/performSetAvatarURL"
-	PerformSetDisplayNamePath          = "/userapi/performSetDisplayName"
-	PerformForgetThreePIDPath          = "/userapi/performForgetThreePID"
-	PerformSaveThreePIDAssociationPath = "/userapi/performSaveThreePIDAssociation"
+	PerformDeviceCreationPath                    = "/userapi/performDeviceCreation"
+	PerformAccountCreationPath                   = "/userapi/performAccountCreation"
+	PerformPasswordUpdatePath                    = "/userapi/performPasswordUpdate"
+	PerformDeviceDeletionPath                    = "/userapi/performDeviceDeletion"
+	PerformLastSeenUpdatePath                    = "/userapi/performLastSeenUpdate"
+	PerformDeviceUpdatePath                      = "/userapi/performDeviceUpdate"
+	PerformAccountDeactivationPath               = "/userapi/performAccountDeactivation"
+	PerformOpenIDTokenCreationPath               = "/userapi/performOpenIDTokenCreation"
+	PerformImpersonationTokenCreationPath        = "/userapi/performImpersonationTokenCreation"
+	PerformShadowBanPath                         = "/userapi/performShadowBan"
+	QueryImpersonationAuditPath                  = "/userapi/queryImpersonationAudit"
+	QueryMonthlyActiveUsersPath                  = "/userapi/queryMonthlyActiveUsers"
+	PerformAccountValidityRenewalPath            = "/userapi/performAccountValidityRenewal"
+	PerformAccountValidityRemindersPath          = "/userapi/performAccountValidityReminders"
+	PerformAccountValidityReminderSentPath       = "/userapi/performAccountValidityReminderSent"
+	QueryPolicyVersionPath                       = "/userapi/queryPolicyVersion"
+	QueryOutdatedPolicyPath                      = "/userapi/queryOutdatedPolicy"
+	PerformUpdatePolicyVersionPath               = "/userapi/performUpdatePolicyVersion"
+	PerformLoginLockoutResetPath                 = "/userapi/performLoginLockoutReset"
+	PerformLoginFailureNoticesPath               = "/userapi/performLoginFailureNotices"
+	PerformLoginAttemptPath                      = "/userapi/performLoginAttempt"
+	PerformThreePIDValidationSessionCreationPath = "/userapi/performThreePIDValidationSessionCreation"
+	PerformThreePIDValidationPath                = "/userapi/performThreePIDValidation"
+	QueryThreePIDValidationSessionPath           = "/userapi/queryThreePIDValidationSession"
+	PerformThreePIDValidationSessionDeletionPath = "/userapi/performThreePIDValidationSessionDeletion"
+	PerformKeyBackupPath                         = "/userapi/performKeyBackup"
+	PerformPusherSetPath                         = "/pushserver/performPusherSet"
+	PerformPusherDeletionPath                    = "/pushserver/performPusherDeletion"
+	PerformPushRulesPutPath                      = "/pushserver/performPushRulesPut"
+	PerformSetAvatarURLPath                      = "/userapi/performSetAvatarURL"
+	PerformSetDisplayNamePath                    = "/userapi/performSetDisplayName"
+	PerformForgetThreePIDPath                    = "/userapi/performForgetThreePID"
+	PerformSaveThreePIDAssociationPath           = "/userapi/performSaveThreePIDAssociation"
 
 	QueryKeyBackupPath             = "/userapi/queryKeyBackup"
 	QueryProfilePath               = "/userapi/queryProfile"
@@ -58,6 +75,8 @@ const (
 	QueryNumericLocalpartPath      = "/userapi/queryNumericLocalpart"
 	QueryAccountAvailabilityPath   = "/userapi/queryAccountAvailability"
 	QueryAccountByPasswordPath     = "/userapi/queryAccountByPassword"
+	QueryAccountByLocalpartPath    = "/userapi/queryAccountByLocalpart"
+	QueryLoginLockoutPath          = "/userapi/queryLoginLockout"
 	QueryLocalpartForThreePIDPath  = "/userapi/queryLocalpartForThreePID"
 	QueryThreePIDsForLocalpartPath = "/userapi/queryThreePIDsForLocalpart"
 )
@@ -177,6 +196,204 @@ func (h *httpUserInternalAPI) PerformOpenIDTokenCreation(
 	)
 }
 
+func (h *httpUserInternalAPI) PerformShadowBan(
+	ctx context.Context,
+	request *api.PerformShadowBanRequest,
+	response *api.PerformShadowBanResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformShadowBan", h.apiURL+PerformShadowBanPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformImpersonationTokenCreation(
+	ctx context.Context,
+	request *api.PerformImpersonationTokenCreationRequest,
+	response *api.PerformImpersonationTokenCreationResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformImpersonationTokenCreation", h.apiURL+PerformImpersonationTokenCreationPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryImpersonationAudit(
+	ctx context.Context,
+	request *api.QueryImpersonationAuditRequest,
+	response *api.QueryImpersonationAuditResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryImpersonationAudit", h.apiURL+QueryImpersonationAuditPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryMonthlyActiveUsers(
+	ctx context.Context,
+	request *api.QueryMonthlyActiveUsersRequest,
+	response *api.QueryMonthlyActiveUsersResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryMonthlyActiveUsers", h.apiURL+QueryMonthlyActiveUsersPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformAccountValidityRenewal(
+	ctx context.Context,
+	request *api.PerformAccountValidityRenewalRequest,
+	response *api.PerformAccountValidityRenewalResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformAccountValidityRenewal", h.apiURL+PerformAccountValidityRenewalPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformAccountValidityReminders(
+	ctx context.Context,
+	request *api.PerformAccountValidityRemindersRequest,
+	response *api.PerformAccountValidityRemindersResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformAccountValidityReminders", h.apiURL+PerformAccountValidityRemindersPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformAccountValidityReminderSent(
+	ctx context.Context,
+	request *api.PerformAccountValidityReminderSentRequest,
+	response *api.PerformAccountValidityReminderSentResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformAccountValidityReminderSent", h.apiURL+PerformAccountValidityReminderSentPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryPolicyVersion(
+	ctx context.Context,
+	request *api.QueryPolicyVersionRequest,
+	response *api.QueryPolicyVersionResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryPolicyVersion", h.apiURL+QueryPolicyVersionPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryOutdatedPolicy(
+	ctx context.Context,
+	request *api.QueryOutdatedPolicyRequest,
+	response *api.QueryOutdatedPolicyResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryOutdatedPolicy", h.apiURL+QueryOutdatedPolicyPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformUpdatePolicyVersion(
+	ctx context.Context,
+	request *api.PerformUpdatePolicyVersionRequest,
+	response *api.PerformUpdatePolicyVersionResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformUpdatePolicyVersion", h.apiURL+PerformUpdatePolicyVersionPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformLoginLockoutReset(
+	ctx context.Context,
+	request *api.PerformLoginLockoutResetRequest,
+	response *api.PerformLoginLockoutResetResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformLoginLockoutReset", h.apiURL+PerformLoginLockoutResetPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformLoginFailureNotices(
+	ctx context.Context,
+	request *api.PerformLoginFailureNoticesRequest,
+	response *api.PerformLoginFailureNoticesResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformLoginFailureNotices", h.apiURL+PerformLoginFailureNoticesPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryLoginLockout(
+	ctx context.Context,
+	request *api.QueryLoginLockoutRequest,
+	response *api.QueryLoginLockoutResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryLoginLockout", h.apiURL+QueryLoginLockoutPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformLoginAttempt(
+	ctx context.Context,
+	request *api.PerformLoginAttemptRequest,
+	response *api.PerformLoginAttemptResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformLoginAttempt", h.apiURL+PerformLoginAttemptPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformThreePIDValidationSessionCreation(
+	ctx context.Context,
+	request *api.PerformThreePIDValidationSessionCreationRequest,
+	response *api.PerformThreePIDValidationSessionCreationResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformThreePIDValidationSessionCreation", h.apiURL+PerformThreePIDValidationSessionCreationPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformThreePIDValidation(
+	ctx context.Context,
+	request *api.PerformThreePIDValidationRequest,
+	response *api.PerformThreePIDValidationResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformThreePIDValidation", h.apiURL+PerformThreePIDValidationPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) QueryThreePIDValidationSession(
+	ctx context.Context,
+	request *api.QueryThreePIDValidationSessionRequest,
+	response *api.QueryThreePIDValidationSessionResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryThreePIDValidationSession", h.apiURL+QueryThreePIDValidationSessionPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
+func (h *httpUserInternalAPI) PerformThreePIDValidationSessionDeletion(
+	ctx context.Context,
+	request *api.PerformThreePIDValidationSessionDeletionRequest,
+	response *api.PerformThreePIDValidationSessionDeletionResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"PerformThreePIDValidationSessionDeletion", h.apiURL+PerformThreePIDValidationSessionDeletionPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
 func (h *httpUserInternalAPI) QueryProfile(
 	ctx context.Context,
 	request *api.QueryProfileRequest,
@@ -363,6 +580,17 @@ func (h *httpUserInternalAPI) QueryNumericLocalpart(
 	)
 }
 
+func (h *httpUserInternalAPI) QueryAccountByLocalpart(
+	ctx context.Context,
+	request *api.QueryAccountByLocalpartRequest,
+	response *api.QueryAccountByLocalpartResponse,
+) error {
+	return httputil.CallInternalRPCAPI(
+		"QueryAccountByLocalpart", h.apiURL+QueryAccountByLocalpartPath,
+		h.httpClient, ctx, request, response,
+	)
+}
+
 func (h *httpUserInternalAPI) QueryAccountAvailability(
 	ctx context.Context,
 	request *api.QueryAccountAvailabilityRequest,
@@ -67,6 +67,96 @@ func AddRoutes(internalAPIMux *mux.Router, s api.UserInternalAPI) {
 		httputil.MakeInternalRPCAPI("UserAPIPerformOpenIDTokenCreation", s.PerformOpenIDTokenCreation),
 	)
 
+	internalAPIMux.Handle(
+		PerformShadowBanPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformShadowBan", s.PerformShadowBan),
+	)
+
+	internalAPIMux.Handle(
+		PerformImpersonationTokenCreationPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformImpersonationTokenCreation", s.PerformImpersonationTokenCreation),
+	)
+
+	internalAPIMux.Handle(
+		QueryImpersonationAuditPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryImpersonationAudit", s.QueryImpersonationAudit),
+	)
+
+	internalAPIMux.Handle(
+		QueryMonthlyActiveUsersPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryMonthlyActiveUsers", s.QueryMonthlyActiveUsers),
+	)
+
+	internalAPIMux.Handle(
+		PerformAccountValidityRenewalPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformAccountValidityRenewal", s.PerformAccountValidityRenewal),
+	)
+
+	internalAPIMux.Handle(
+		PerformAccountValidityRemindersPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformAccountValidityReminders", s.PerformAccountValidityReminders),
+	)
+
+	internalAPIMux.Handle(
+		PerformAccountValidityReminderSentPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformAccountValidityReminderSent", s.PerformAccountValidityReminderSent),
+	)
+
+	internalAPIMux.Handle(
+		QueryPolicyVersionPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryPolicyVersion", s.QueryPolicyVersion),
+	)
+
+	internalAPIMux.Handle(
+		QueryOutdatedPolicyPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryOutdatedPolicy", s.QueryOutdatedPolicy),
+	)
+
+	internalAPIMux.Handle(
+		PerformUpdatePolicyVersionPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformUpdatePolicyVersion", s.PerformUpdatePolicyVersion),
+	)
+
+	internalAPIMux.Handle(
+		PerformLoginLockoutResetPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformLoginLockoutReset", s.PerformLoginLockoutReset),
+	)
+
+	internalAPIMux.Handle(
+		PerformLoginFailureNoticesPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformLoginFailureNotices", s.PerformLoginFailureNotices),
+	)
+
+	internalAPIMux.Handle(
+		QueryLoginLockoutPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryLoginLockout", s.QueryLoginLockout),
+	)
+
+	internalAPIMux.Handle(
+		PerformLoginAttemptPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformLoginAttempt", s.PerformLoginAttempt),
+	)
+
+	internalAPIMux.Handle(
+		PerformThreePIDValidationSessionCreationPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformThreePIDValidationSessionCreation", s.PerformThreePIDValidationSessionCreation),
+	)
+
+	internalAPIMux.Handle(
+		PerformThreePIDValidationPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformThreePIDValidation", s.PerformThreePIDValidation),
+	)
+
+	internalAPIMux.Handle(
+		QueryThreePIDValidationSessionPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryThreePIDValidationSession", s.QueryThreePIDValidationSession),
+	)
+
+	internalAPIMux.Handle(
+		PerformThreePIDValidationSessionDeletionPath,
+		httputil.MakeInternalRPCAPI("UserAPIPerformThreePIDValidationSessionDeletion", s.PerformThreePIDValidationSessionDeletion),
+	)
+
 	internalAPIMux.Handle(
 		QueryProfilePath,
 		httputil.MakeInternalRPCAPI("UserAPIQueryProfile", s.QueryProfile),
@@ -168,6 +258,11 @@ func AddRoutes(internalAPIMux *mux.Router, s api.UserInternalAPI) {
 		httputil.MakeInternalRPCAPI("UserAPIQueryAccountAvailability", s.QueryAccountAvailability),
 	)
 
+	internalAPIMux.Handle(
+		QueryAccountByLocalpartPath,
+		httputil.MakeInternalRPCAPI("UserAPIQueryAccountByLocalpart", s.QueryAccountByLocalpart),
+	)
+
 	internalAPIMux.Handle(
 		QueryAccountByPasswordPath,
 		httputil.MakeInternalRPCAPI("UserAPIQueryAccountByPassword", s.QueryAccountByPassword),
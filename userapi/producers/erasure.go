@@ -0,0 +1,50 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producers
+
+import (
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/setup/jetstream"
+)
+
+// UserErasure produces messages telling other components to remove
+// any data they hold about an erased user.
+type UserErasure struct {
+	producer JetStreamPublisher
+	topic    string
+}
+
+func NewUserErasure(js JetStreamPublisher, topic string) *UserErasure {
+	return &UserErasure{
+		producer: js,
+		topic:    topic,
+	}
+}
+
+// SendUserErased notifies other components that the user has been erased.
+func (p *UserErasure) SendUserErased(userID string) error {
+	m := &nats.Msg{
+		Subject: p.topic,
+		Header:  nats.Header{},
+	}
+	m.Header.Set(jetstream.UserID, userID)
+
+	log.WithField("user_id", userID).Tracef("Producing to topic '%s'", p.topic)
+
+	_, err := p.producer.PublishMsg(m)
+	return err
+}
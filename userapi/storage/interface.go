@@ -43,6 +43,30 @@ type Account interface {
 	CheckAccountAvailability(ctx context.Context, localpart string) (bool, error)
 	GetAccountByLocalpart(ctx context.Context, localpart string) (*api.Account, error)
 	DeactivateAccount(ctx context.Context, localpart string) (err error)
+	// SetShadowBanned marks the account as shadow-banned, or not.
+	SetShadowBanned(ctx context.Context, localpart string, shadowBanned bool) error
+	// SetAccountExpiry sets when the account expires, or that it never expires
+	// if expiresAtMS is zero, and discards any renewal token.
+	SetAccountExpiry(ctx context.Context, localpart string, expiresAtMS int64) error
+	// SetRenewalToken stores the token which the user can renew their account with.
+	SetRenewalToken(ctx context.Context, localpart, renewalToken string) error
+	// GetAccountsDueRenewal returns the localparts and expiry timestamps of the
+	// accounts which expire before the given time and which haven't been
+	// issued a renewal token yet.
+	GetAccountsDueRenewal(ctx context.Context, beforeMS int64) (map[string]int64, error)
+	// GetLocalpartForRenewalToken returns the localpart of the account the
+	// renewal token was issued for. Returns sql.ErrNoRows if it is unknown.
+	GetLocalpartForRenewalToken(ctx context.Context, renewalToken string) (string, error)
+	// SetPolicyVersion records that the user has consented to the given
+	// version of the terms of service.
+	SetPolicyVersion(ctx context.Context, localpart, policyVersion string) error
+	// SetPolicyVersionSent records that the user has been sent a server
+	// notice asking them to consent to the given version of the terms of service.
+	SetPolicyVersionSent(ctx context.Context, localpart, policyVersion string) error
+	// GetOutdatedPolicy returns the localparts of the accounts which haven't
+	// consented to the given version of the terms of service, and which
+	// haven't been sent a server notice about it yet.
+	GetOutdatedPolicy(ctx context.Context, policyVersion string) ([]string, error)
 	SetPassword(ctx context.Context, localpart string, plaintextPassword string) error
 }
 
@@ -99,6 +123,45 @@ type LoginToken interface {
 	GetLoginTokenDataByToken(ctx context.Context, token string) (*api.LoginTokenData, error)
 }
 
+type ImpersonationAudit interface {
+	// StoreImpersonation records that an admin was issued a token for impersonating a user.
+	StoreImpersonation(ctx context.Context, adminUserID, localpart, deviceID, accessToken string, createdAtMS, expiresAtMS int64) error
+	// GetImpersonationExpiry returns when the given impersonation access token
+	// expires. Returns sql.ErrNoRows if the token wasn't issued for an impersonation.
+	GetImpersonationExpiry(ctx context.Context, accessToken string) (int64, error)
+	// RemoveExpiredImpersonations removes the devices of impersonation tokens
+	// which expired at or before the given time.
+	RemoveExpiredImpersonations(ctx context.Context, nowMS int64) error
+	GetImpersonations(ctx context.Context, localpart string) ([]api.ImpersonationAuditEntry, error)
+}
+
+type LoginFailures interface {
+	// GetLoginFailures returns the failed logins of the account or IP address.
+	// Returns sql.ErrNoRows if there haven't been any.
+	GetLoginFailures(ctx context.Context, kind, key string) (*api.LoginFailures, error)
+	// UpdateLoginFailures reads, updates and stores the failed logins of the
+	// account or IP address within one transaction.
+	UpdateLoginFailures(ctx context.Context, kind, key string, update func(f *api.LoginFailures)) error
+	RemoveLoginFailures(ctx context.Context, kind, key string) error
+	GetLoginFailuresToNotify(ctx context.Context, minFailures int) (map[string]*api.LoginFailures, error)
+	RemoveLoginFailuresBefore(ctx context.Context, beforeMS int64) error
+}
+
+type MonthlyActiveUsers interface {
+	// MarkMonthlyActiveUser records that the user was active at the given time.
+	MarkMonthlyActiveUser(ctx context.Context, localpart string, timestampMS int64) error
+	// GetMonthlyActiveUserTimestamp returns when the user was last recorded as
+	// active. Returns sql.ErrNoRows if the user hasn't been active.
+	GetMonthlyActiveUserTimestamp(ctx context.Context, localpart string) (int64, error)
+	// MarkNewMonthlyActiveUser records that the user was active at the given
+	// time, unless maxUsers users have already been active since sinceMS.
+	// Returns false if the user wasn't recorded.
+	MarkNewMonthlyActiveUser(ctx context.Context, localpart string, timestampMS, sinceMS, maxUsers int64) (bool, error)
+	CountMonthlyActiveUsers(ctx context.Context, sinceMS int64) (int64, error)
+	GetMonthlyActiveUsers(ctx context.Context, sinceMS int64) ([]string, error)
+	RemoveMonthlyActiveUsersBefore(ctx context.Context, beforeMS int64) error
+}
+
 type OpenID interface {
 	CreateOpenIDToken(ctx context.Context, token, userID string) (exp int64, err error)
 	GetOpenIDTokenAttributes(ctx context.Context, token string) (*api.OpenIDTokenAttributes, error)
@@ -118,6 +181,19 @@ type ThreePID interface {
 	GetThreePIDsForLocalpart(ctx context.Context, localpart string) (threepids []authtypes.ThreePID, err error)
 }
 
+type ThreePIDValidation interface {
+	// CreateThreePIDValidationSession stores a new validation session, or returns
+	// the client's existing one for the third-party identifier. The returned bool
+	// is true if a validation token should be sent.
+	CreateThreePIDValidationSession(ctx context.Context, session *api.ThreePIDValidationSession) (*api.ThreePIDValidationSession, bool, error)
+	// GetThreePIDValidationSession returns the validation session with the given
+	// ID. Returns sql.ErrNoRows if there isn't one.
+	GetThreePIDValidationSession(ctx context.Context, sessionID string) (*api.ThreePIDValidationSession, error)
+	MarkThreePIDValidationSessionValidated(ctx context.Context, sessionID string, validatedTS int64) error
+	RemoveThreePIDValidationSession(ctx context.Context, sessionID string) error
+	RemoveThreePIDValidationSessionsBefore(ctx context.Context, beforeMS int64) error
+}
+
 type Notification interface {
 	InsertNotification(ctx context.Context, localpart, eventID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error
 	DeleteNotificationsUpTo(ctx context.Context, localpart, roomID string, pos uint64) (affected bool, err error)
@@ -132,14 +208,18 @@ type Database interface {
 	Account
 	AccountData
 	Device
+	ImpersonationAudit
 	KeyBackup
+	LoginFailures
 	LoginToken
+	MonthlyActiveUsers
 	Notification
 	OpenID
 	Profile
 	Pusher
 	Statistics
 	ThreePID
+	ThreePIDValidation
 }
 
 type Statistics interface {
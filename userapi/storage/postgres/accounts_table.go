@@ -22,6 +22,7 @@ import (
 	"github.com/matrix-org/gomatrixserverlib"
 
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/postgres/deltas"
@@ -44,7 +45,17 @@ CREATE TABLE IF NOT EXISTS account_accounts (
     -- If the account is currently active
     is_deactivated BOOLEAN DEFAULT FALSE,
 	-- The account_type (user = 1, guest = 2, admin = 3, appservice = 4)
-	account_type SMALLINT NOT NULL
+	account_type SMALLINT NOT NULL,
+	-- If the account is shadow-banned, in which case its requests are accepted but ignored
+	is_shadow_banned BOOLEAN NOT NULL DEFAULT FALSE,
+	-- When the account expires unless it is renewed, as a unix timestamp (ms resolution), or NULL if it never expires
+	expires_ts BIGINT,
+	-- The token for renewing the account, if the user has been sent a renewal reminder
+	renewal_token TEXT,
+	-- The version of the terms of service the user has consented to, if any
+	policy_version TEXT,
+	-- The version of the terms of service the user has last been sent a server notice for, if any
+	policy_version_sent TEXT
     -- TODO:
     -- upgraded_ts, devices, any email reset stuff?
 );
@@ -59,8 +70,36 @@ const updatePasswordSQL = "" +
 const deactivateAccountSQL = "" +
 	"UPDATE account_accounts SET is_deactivated = TRUE WHERE localpart = $1"
 
+const updateShadowBannedSQL = "" +
+	"UPDATE account_accounts SET is_shadow_banned = $1 WHERE localpart = $2"
+
+const updateExpirySQL = "" +
+	"UPDATE account_accounts SET expires_ts = $1, renewal_token = NULL WHERE localpart = $2"
+
+const updateRenewalTokenSQL = "" +
+	"UPDATE account_accounts SET renewal_token = $1 WHERE localpart = $2"
+
+const updatePolicyVersionSQL = "" +
+	"UPDATE account_accounts SET policy_version = $1 WHERE localpart = $2"
+
+const updatePolicyVersionSentSQL = "" +
+	"UPDATE account_accounts SET policy_version_sent = $1 WHERE localpart = $2"
+
 const selectAccountByLocalpartSQL = "" +
-	"SELECT localpart, appservice_id, account_type FROM account_accounts WHERE localpart = $1"
+	"SELECT localpart, appservice_id, account_type, is_shadow_banned, COALESCE(expires_ts, 0), COALESCE(policy_version, ''), COALESCE(is_deactivated, FALSE) FROM account_accounts WHERE localpart = $1"
+
+// Guests and appservice users (account types 2 and 4) never consent to the terms of service.
+const selectOutdatedPolicySQL = "" +
+	"SELECT localpart FROM account_accounts" +
+	" WHERE (policy_version IS NULL OR policy_version <> $1) AND (policy_version_sent IS NULL OR policy_version_sent <> $2)" +
+	" AND account_type <> 2 AND account_type <> 4 AND is_deactivated = FALSE"
+
+const selectAccountsDueRenewalSQL = "" +
+	"SELECT localpart, expires_ts FROM account_accounts" +
+	" WHERE expires_ts IS NOT NULL AND expires_ts < $1 AND renewal_token IS NULL AND is_deactivated = FALSE"
+
+const selectLocalpartByRenewalTokenSQL = "" +
+	"SELECT localpart FROM account_accounts WHERE renewal_token = $1"
 
 const selectPasswordHashSQL = "" +
 	"SELECT password_hash FROM account_accounts WHERE localpart = $1 AND is_deactivated = FALSE"
@@ -69,13 +108,21 @@ const selectNewNumericLocalpartSQL = "" +
 	"SELECT COALESCE(MAX(localpart::bigint), 0) FROM account_accounts WHERE localpart ~ '^[0-9]{1,}$'"
 
 type accountsStatements struct {
-	insertAccountStmt             *sql.Stmt
-	updatePasswordStmt            *sql.Stmt
-	deactivateAccountStmt         *sql.Stmt
-	selectAccountByLocalpartStmt  *sql.Stmt
-	selectPasswordHashStmt        *sql.Stmt
-	selectNewNumericLocalpartStmt *sql.Stmt
-	serverName                    gomatrixserverlib.ServerName
+	insertAccountStmt                 *sql.Stmt
+	updatePasswordStmt                *sql.Stmt
+	deactivateAccountStmt             *sql.Stmt
+	updateShadowBannedStmt            *sql.Stmt
+	updateExpiryStmt                  *sql.Stmt
+	updateRenewalTokenStmt            *sql.Stmt
+	updatePolicyVersionStmt           *sql.Stmt
+	updatePolicyVersionSentStmt       *sql.Stmt
+	selectAccountByLocalpartStmt      *sql.Stmt
+	selectOutdatedPolicyStmt          *sql.Stmt
+	selectAccountsDueRenewalStmt      *sql.Stmt
+	selectLocalpartByRenewalTokenStmt *sql.Stmt
+	selectPasswordHashStmt            *sql.Stmt
+	selectNewNumericLocalpartStmt     *sql.Stmt
+	serverName                        gomatrixserverlib.ServerName
 }
 
 func NewPostgresAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerName) (tables.AccountsTable, error) {
@@ -98,6 +145,21 @@ func NewPostgresAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerNam
 			Up:      deltas.UpAddAccountType,
 			Down:    deltas.DownAddAccountType,
 		},
+		{
+			Version: "userapi: add shadow banned",
+			Up:      deltas.UpAddShadowBanned,
+			Down:    deltas.DownAddShadowBanned,
+		},
+		{
+			Version: "userapi: add account validity",
+			Up:      deltas.UpAddAccountValidity,
+			Down:    deltas.DownAddAccountValidity,
+		},
+		{
+			Version: "userapi: add policy version",
+			Up:      deltas.UpAddPolicyVersion,
+			Down:    deltas.DownAddPolicyVersion,
+		},
 	}...)
 	err = m.Up(context.Background())
 	if err != nil {
@@ -107,7 +169,15 @@ func NewPostgresAccountsTable(db *sql.DB, serverName gomatrixserverlib.ServerNam
 		{&s.insertAccountStmt, insertAccountSQL},
 		{&s.updatePasswordStmt, updatePasswordSQL},
 		{&s.deactivateAccountStmt, deactivateAccountSQL},
+		{&s.updateShadowBannedStmt, updateShadowBannedSQL},
+		{&s.updateExpiryStmt, updateExpirySQL},
+		{&s.updateRenewalTokenStmt, updateRenewalTokenSQL},
+		{&s.updatePolicyVersionStmt, updatePolicyVersionSQL},
+		{&s.updatePolicyVersionSentStmt, updatePolicyVersionSentSQL},
 		{&s.selectAccountByLocalpartStmt, selectAccountByLocalpartSQL},
+		{&s.selectOutdatedPolicyStmt, selectOutdatedPolicySQL},
+		{&s.selectAccountsDueRenewalStmt, selectAccountsDueRenewalSQL},
+		{&s.selectLocalpartByRenewalTokenStmt, selectLocalpartByRenewalTokenSQL},
 		{&s.selectPasswordHashStmt, selectPasswordHashSQL},
 		{&s.selectNewNumericLocalpartStmt, selectNewNumericLocalpartSQL},
 	}.Prepare(db)
@@ -155,6 +225,98 @@ func (s *accountsStatements) DeactivateAccount(
 	return
 }
 
+func (s *accountsStatements) UpdateShadowBanned(
+	ctx context.Context, localpart string, shadowBanned bool,
+) (err error) {
+	_, err = s.updateShadowBannedStmt.ExecContext(ctx, shadowBanned, localpart)
+	return
+}
+
+// UpdateExpiry sets when the account expires, or that it never expires if
+// expiresTS is zero, and discards any renewal token.
+func (s *accountsStatements) UpdateExpiry(
+	ctx context.Context, txn *sql.Tx, localpart string, expiresTS int64,
+) (err error) {
+	expires := sql.NullInt64{Int64: expiresTS, Valid: expiresTS > 0}
+	_, err = sqlutil.TxStmt(txn, s.updateExpiryStmt).ExecContext(ctx, expires, localpart)
+	return
+}
+
+func (s *accountsStatements) UpdateRenewalToken(
+	ctx context.Context, txn *sql.Tx, localpart, renewalToken string,
+) (err error) {
+	_, err = sqlutil.TxStmt(txn, s.updateRenewalTokenStmt).ExecContext(ctx, renewalToken, localpart)
+	return
+}
+
+// SelectAccountsDueRenewal returns the localparts and expiry timestamps of
+// the active accounts which expire before the given time, and which haven't
+// been issued a renewal token yet.
+func (s *accountsStatements) SelectAccountsDueRenewal(
+	ctx context.Context, before int64,
+) (map[string]int64, error) {
+	rows, err := s.selectAccountsDueRenewalStmt.QueryContext(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAccountsDueRenewal: rows.close() failed")
+
+	accounts := make(map[string]int64)
+	for rows.Next() {
+		var localpart string
+		var expiresTS int64
+		if err = rows.Scan(&localpart, &expiresTS); err != nil {
+			return nil, err
+		}
+		accounts[localpart] = expiresTS
+	}
+	return accounts, rows.Err()
+}
+
+func (s *accountsStatements) SelectLocalpartByRenewalToken(
+	ctx context.Context, renewalToken string,
+) (localpart string, err error) {
+	err = s.selectLocalpartByRenewalTokenStmt.QueryRowContext(ctx, renewalToken).Scan(&localpart)
+	return
+}
+
+func (s *accountsStatements) UpdatePolicyVersion(
+	ctx context.Context, txn *sql.Tx, localpart, policyVersion string,
+) (err error) {
+	_, err = sqlutil.TxStmt(txn, s.updatePolicyVersionStmt).ExecContext(ctx, policyVersion, localpart)
+	return
+}
+
+func (s *accountsStatements) UpdatePolicyVersionSent(
+	ctx context.Context, txn *sql.Tx, localpart, policyVersion string,
+) (err error) {
+	_, err = sqlutil.TxStmt(txn, s.updatePolicyVersionSentStmt).ExecContext(ctx, policyVersion, localpart)
+	return
+}
+
+// SelectOutdatedPolicy returns the localparts of the active accounts which
+// haven't consented to the given policy version, and which haven't been sent
+// a server notice about it yet.
+func (s *accountsStatements) SelectOutdatedPolicy(
+	ctx context.Context, policyVersion string,
+) ([]string, error) {
+	rows, err := s.selectOutdatedPolicyStmt.QueryContext(ctx, policyVersion, policyVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectOutdatedPolicy: rows.close() failed")
+
+	var localparts []string
+	for rows.Next() {
+		var localpart string
+		if err = rows.Scan(&localpart); err != nil {
+			return nil, err
+		}
+		localparts = append(localparts, localpart)
+	}
+	return localparts, rows.Err()
+}
+
 func (s *accountsStatements) SelectPasswordHash(
 	ctx context.Context, localpart string,
 ) (hash string, err error) {
@@ -169,7 +331,7 @@ func (s *accountsStatements) SelectAccountByLocalpart(
 	var acc api.Account
 
 	stmt := s.selectAccountByLocalpartStmt
-	err := stmt.QueryRowContext(ctx, localpart).Scan(&acc.Localpart, &appserviceIDPtr, &acc.AccountType)
+	err := stmt.QueryRowContext(ctx, localpart).Scan(&acc.Localpart, &appserviceIDPtr, &acc.AccountType, &acc.ShadowBanned, &acc.ExpiresAtMS, &acc.PolicyVersion, &acc.Deactivated)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			log.WithError(err).Error("Unable to retrieve user from the db")
@@ -0,0 +1,43 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func UpAddAccountValidity(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE account_accounts ADD COLUMN IF NOT EXISTS expires_ts BIGINT;
+ALTER TABLE account_accounts ADD COLUMN IF NOT EXISTS renewal_token TEXT;
+CREATE INDEX IF NOT EXISTS account_accounts_renewal_token_idx ON account_accounts(renewal_token);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownAddAccountValidity(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+DROP INDEX IF EXISTS account_accounts_renewal_token_idx;
+ALTER TABLE account_accounts DROP COLUMN expires_ts;
+ALTER TABLE account_accounts DROP COLUMN renewal_token;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+)
+
+const impersonationAuditSchema = `
+-- Stores an audit trail of access tokens issued to admins for impersonating users.
+CREATE TABLE IF NOT EXISTS userapi_impersonation_audit (
+	id BIGSERIAL PRIMARY KEY,
+	-- The Matrix user ID of the admin who requested the token
+	admin_user_id TEXT NOT NULL,
+	-- The localpart of the impersonated user
+	localpart TEXT NOT NULL,
+	-- The device that was created for the impersonation
+	device_id TEXT NOT NULL,
+	-- The hex-encoded SHA-256 hash of the access token issued for the device,
+	-- or NULL once the device has been removed after the token expired.
+	access_token_hash TEXT,
+	-- When the token was issued, as a unix timestamp (ms resolution).
+	created_ts BIGINT NOT NULL,
+	-- When the token expires, as a unix timestamp (ms resolution).
+	expires_ts BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS userapi_impersonation_audit_idx ON userapi_impersonation_audit(localpart);
+CREATE INDEX IF NOT EXISTS userapi_impersonation_audit_access_token_hash_idx ON userapi_impersonation_audit(access_token_hash);
+`
+
+const insertImpersonationSQL = "" +
+	"INSERT INTO userapi_impersonation_audit(admin_user_id, localpart, device_id, access_token_hash, created_ts, expires_ts) VALUES ($1, $2, $3, $4, $5, $6)"
+
+const selectImpersonationExpirySQL = "" +
+	"SELECT expires_ts FROM userapi_impersonation_audit WHERE access_token_hash = $1"
+
+const selectExpiredImpersonationsSQL = "" +
+	"SELECT localpart, device_id FROM userapi_impersonation_audit WHERE access_token_hash IS NOT NULL AND expires_ts <= $1"
+
+const clearImpersonationAccessTokenSQL = "" +
+	"UPDATE userapi_impersonation_audit SET access_token_hash = NULL WHERE localpart = $1 AND device_id = $2"
+
+const selectImpersonationsSQL = "" +
+	"SELECT admin_user_id, device_id, created_ts, expires_ts FROM userapi_impersonation_audit WHERE localpart = $1 ORDER BY id ASC"
+
+type impersonationAuditStatements struct {
+	insertImpersonationStmt           *sql.Stmt
+	selectImpersonationExpiryStmt     *sql.Stmt
+	selectExpiredImpersonationsStmt   *sql.Stmt
+	clearImpersonationAccessTokenStmt *sql.Stmt
+	selectImpersonationsStmt          *sql.Stmt
+}
+
+func NewPostgresImpersonationAuditTable(db *sql.DB) (tables.ImpersonationAuditTable, error) {
+	s := &impersonationAuditStatements{}
+	_, err := db.Exec(impersonationAuditSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertImpersonationStmt, insertImpersonationSQL},
+		{&s.selectImpersonationExpiryStmt, selectImpersonationExpirySQL},
+		{&s.selectExpiredImpersonationsStmt, selectExpiredImpersonationsSQL},
+		{&s.clearImpersonationAccessTokenStmt, clearImpersonationAccessTokenSQL},
+		{&s.selectImpersonationsStmt, selectImpersonationsSQL},
+	}.Prepare(db)
+}
+
+func (s *impersonationAuditStatements) InsertImpersonation(
+	ctx context.Context, txn *sql.Tx,
+	adminUserID, localpart, deviceID, accessTokenHash string,
+	createdTS, expiresTS int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertImpersonationStmt)
+	_, err := stmt.ExecContext(ctx, adminUserID, localpart, deviceID, accessTokenHash, createdTS, expiresTS)
+	return err
+}
+
+// SelectImpersonationExpiry returns when the access token with the given hash
+// expires. Returns sql.ErrNoRows if the token wasn't issued for an impersonation.
+func (s *impersonationAuditStatements) SelectImpersonationExpiry(
+	ctx context.Context, accessTokenHash string,
+) (expiresTS int64, err error) {
+	err = s.selectImpersonationExpiryStmt.QueryRowContext(ctx, accessTokenHash).Scan(&expiresTS)
+	return
+}
+
+// SelectExpiredImpersonations returns the impersonations which expired at or
+// before the given time and whose devices haven't been removed yet.
+func (s *impersonationAuditStatements) SelectExpiredImpersonations(
+	ctx context.Context, txn *sql.Tx, nowTS int64,
+) ([]api.ImpersonationAuditEntry, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectExpiredImpersonationsStmt).QueryContext(ctx, nowTS)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectExpiredImpersonations: rows.close() failed")
+
+	var expired []api.ImpersonationAuditEntry
+	for rows.Next() {
+		var entry api.ImpersonationAuditEntry
+		if err = rows.Scan(&entry.Localpart, &entry.DeviceID); err != nil {
+			return nil, err
+		}
+		expired = append(expired, entry)
+	}
+	return expired, rows.Err()
+}
+
+func (s *impersonationAuditStatements) ClearImpersonationAccessToken(
+	ctx context.Context, txn *sql.Tx, localpart, deviceID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.clearImpersonationAccessTokenStmt).ExecContext(ctx, localpart, deviceID)
+	return err
+}
+
+func (s *impersonationAuditStatements) SelectImpersonations(
+	ctx context.Context, localpart string,
+) ([]api.ImpersonationAuditEntry, error) {
+	rows, err := s.selectImpersonationsStmt.QueryContext(ctx, localpart)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectImpersonations: rows.close() failed")
+
+	var entries []api.ImpersonationAuditEntry
+	for rows.Next() {
+		entry := api.ImpersonationAuditEntry{
+			Localpart: localpart,
+		}
+		if err = rows.Scan(&entry.AdminUserID, &entry.DeviceID, &entry.CreatedAtMS, &entry.ExpiresAtMS); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
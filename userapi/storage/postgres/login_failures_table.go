@@ -0,0 +1,150 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+)
+
+const loginFailuresSchema = `
+-- Tracks failed password logins to accounts and from IP addresses, for locking
+-- them out after too many failures.
+CREATE TABLE IF NOT EXISTS userapi_login_failures (
+	-- Either 'user' or 'ip'
+	kind TEXT NOT NULL,
+	-- The localpart of the account or the IP address
+	key TEXT NOT NULL,
+	-- The number of failures in a row
+	failures INTEGER NOT NULL,
+	-- When the last failure happened, as a unix timestamp (ms resolution).
+	last_failure_ts BIGINT NOT NULL,
+	-- When the lockout ends, as a unix timestamp (ms resolution), or 0 if not locked out.
+	locked_until_ts BIGINT NOT NULL DEFAULT 0,
+	-- The IP address the last failure came from, for accounts.
+	last_ip TEXT NOT NULL DEFAULT '',
+	-- Whether the user has been sent a server notice about the failures.
+	notified BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (kind, key)
+);
+CREATE INDEX IF NOT EXISTS userapi_login_failures_last_failure_ts_idx ON userapi_login_failures(last_failure_ts);
+`
+
+const selectLoginFailuresSQL = "" +
+	"SELECT failures, last_failure_ts, locked_until_ts, last_ip, notified FROM userapi_login_failures WHERE kind = $1 AND key = $2"
+
+const upsertLoginFailuresSQL = "" +
+	"INSERT INTO userapi_login_failures(kind, key, failures, last_failure_ts, locked_until_ts, last_ip, notified)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7)" +
+	" ON CONFLICT (kind, key) DO UPDATE SET failures = $3, last_failure_ts = $4, locked_until_ts = $5, last_ip = $6, notified = $7"
+
+const deleteLoginFailuresSQL = "" +
+	"DELETE FROM userapi_login_failures WHERE kind = $1 AND key = $2"
+
+const selectLoginFailuresToNotifySQL = "" +
+	"SELECT key, failures, last_failure_ts, locked_until_ts, last_ip, notified FROM userapi_login_failures" +
+	" WHERE kind = 'user' AND notified = FALSE AND failures >= $1"
+
+const deleteLoginFailuresBeforeSQL = "" +
+	"DELETE FROM userapi_login_failures WHERE last_failure_ts <= $1"
+
+type loginFailuresStatements struct {
+	selectLoginFailuresStmt         *sql.Stmt
+	upsertLoginFailuresStmt         *sql.Stmt
+	deleteLoginFailuresStmt         *sql.Stmt
+	selectLoginFailuresToNotifyStmt *sql.Stmt
+	deleteLoginFailuresBeforeStmt   *sql.Stmt
+}
+
+func NewPostgresLoginFailuresTable(db *sql.DB) (tables.LoginFailuresTable, error) {
+	s := &loginFailuresStatements{}
+	_, err := db.Exec(loginFailuresSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.selectLoginFailuresStmt, selectLoginFailuresSQL},
+		{&s.upsertLoginFailuresStmt, upsertLoginFailuresSQL},
+		{&s.deleteLoginFailuresStmt, deleteLoginFailuresSQL},
+		{&s.selectLoginFailuresToNotifyStmt, selectLoginFailuresToNotifySQL},
+		{&s.deleteLoginFailuresBeforeStmt, deleteLoginFailuresBeforeSQL},
+	}.Prepare(db)
+}
+
+// SelectLoginFailures returns the failed logins of the account or IP address.
+// Returns sql.ErrNoRows if there haven't been any.
+func (s *loginFailuresStatements) SelectLoginFailures(
+	ctx context.Context, txn *sql.Tx, kind, key string,
+) (*api.LoginFailures, error) {
+	f := &api.LoginFailures{}
+	stmt := sqlutil.TxStmt(txn, s.selectLoginFailuresStmt)
+	err := stmt.QueryRowContext(ctx, kind, key).Scan(
+		&f.Failures, &f.LastFailureTS, &f.LockedUntilTS, &f.LastIP, &f.Notified,
+	)
+	return f, err
+}
+
+func (s *loginFailuresStatements) UpsertLoginFailures(
+	ctx context.Context, txn *sql.Tx, kind, key string, f *api.LoginFailures,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.upsertLoginFailuresStmt)
+	_, err := stmt.ExecContext(ctx, kind, key, f.Failures, f.LastFailureTS, f.LockedUntilTS, f.LastIP, f.Notified)
+	return err
+}
+
+func (s *loginFailuresStatements) DeleteLoginFailures(
+	ctx context.Context, txn *sql.Tx, kind, key string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteLoginFailuresStmt)
+	_, err := stmt.ExecContext(ctx, kind, key)
+	return err
+}
+
+// SelectLoginFailuresToNotify returns the failed logins of the accounts with
+// at least minFailures of them, whose users haven't been notified, keyed by
+// localpart.
+func (s *loginFailuresStatements) SelectLoginFailuresToNotify(
+	ctx context.Context, minFailures int,
+) (map[string]*api.LoginFailures, error) {
+	rows, err := s.selectLoginFailuresToNotifyStmt.QueryContext(ctx, minFailures)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLoginFailuresToNotify: rows.close() failed")
+
+	result := make(map[string]*api.LoginFailures)
+	for rows.Next() {
+		var localpart string
+		f := &api.LoginFailures{}
+		if err = rows.Scan(&localpart, &f.Failures, &f.LastFailureTS, &f.LockedUntilTS, &f.LastIP, &f.Notified); err != nil {
+			return nil, err
+		}
+		result[localpart] = f
+	}
+	return result, rows.Err()
+}
+
+func (s *loginFailuresStatements) DeleteLoginFailuresBefore(
+	ctx context.Context, txn *sql.Tx, before int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteLoginFailuresBeforeStmt)
+	_, err := stmt.ExecContext(ctx, before)
+	return err
+}
@@ -64,10 +64,26 @@ func NewDatabase(base *base.BaseDendrite, dbProperties *config.DatabaseOptions,
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresOpenIDTable: %w", err)
 	}
+	impersonationsTable, err := NewPostgresImpersonationAuditTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresImpersonationAuditTable: %w", err)
+	}
+	loginFailuresTable, err := NewPostgresLoginFailuresTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresLoginFailuresTable: %w", err)
+	}
+	monthlyActiveUsersTable, err := NewPostgresMonthlyActiveUsersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresMonthlyActiveUsersTable: %w", err)
+	}
 	profilesTable, err := NewPostgresProfilesTable(db, serverNoticesLocalpart)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresProfilesTable: %w", err)
 	}
+	threePIDValidationSessionsTable, err := NewPostgresThreePIDValidationSessionsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresThreePIDValidationSessionsTable: %w", err)
+	}
 	threePIDTable, err := NewPostgresThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresThreePIDTable: %w", err)
@@ -92,8 +108,12 @@ func NewDatabase(base *base.BaseDendrite, dbProperties *config.DatabaseOptions,
 		KeyBackupVersions:     keyBackupVersionTable,
 		LoginTokens:           loginTokenTable,
 		OpenIDTokens:          openIDTable,
+		Impersonations:        impersonationsTable,
+		LoginFailures:         loginFailuresTable,
+		MonthlyActiveUsers:    monthlyActiveUsersTable,
 		Profiles:              profilesTable,
 		ThreePIDs:             threePIDTable,
+		ThreePIDValidation:    threePIDValidationSessionsTable,
 		Pushers:               pusherTable,
 		Notifications:         notificationsTable,
 		Stats:                 statsTable,
@@ -17,8 +17,10 @@ package shared
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -46,8 +48,12 @@ type Database struct {
 	Profiles              tables.ProfileTable
 	AccountDatas          tables.AccountDataTable
 	ThreePIDs             tables.ThreePIDTable
+	ThreePIDValidation    tables.ThreePIDValidationSessionsTable
 	OpenIDTokens          tables.OpenIDTable
+	Impersonations        tables.ImpersonationAuditTable
 	KeyBackups            tables.KeyBackupTable
+	LoginFailures         tables.LoginFailuresTable
+	MonthlyActiveUsers    tables.MonthlyActiveUsersTable
 	KeyBackupVersions     tables.KeyBackupVersionTable
 	Devices               tables.DevicesTable
 	LoginTokens           tables.LoginTokenTable
@@ -359,6 +365,64 @@ func (d *Database) DeactivateAccount(ctx context.Context, localpart string) (err
 	})
 }
 
+// SetShadowBanned marks the account as shadow-banned, or not.
+func (d *Database) SetShadowBanned(ctx context.Context, localpart string, shadowBanned bool) (err error) {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateShadowBanned(ctx, localpart, shadowBanned)
+	})
+}
+
+// SetAccountExpiry sets when the account expires, or that it never expires
+// if expiresAtMS is zero, and discards any renewal token.
+func (d *Database) SetAccountExpiry(ctx context.Context, localpart string, expiresAtMS int64) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateExpiry(ctx, txn, localpart, expiresAtMS)
+	})
+}
+
+// SetRenewalToken stores the token which the user can renew their account with.
+func (d *Database) SetRenewalToken(ctx context.Context, localpart, renewalToken string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateRenewalToken(ctx, txn, localpart, renewalToken)
+	})
+}
+
+// GetAccountsDueRenewal returns the localparts and expiry timestamps of the
+// accounts which expire before the given time and which haven't been issued
+// a renewal token yet.
+func (d *Database) GetAccountsDueRenewal(ctx context.Context, beforeMS int64) (map[string]int64, error) {
+	return d.Accounts.SelectAccountsDueRenewal(ctx, beforeMS)
+}
+
+// GetLocalpartForRenewalToken returns the localpart of the account the renewal
+// token was issued for. Returns sql.ErrNoRows if it is unknown.
+func (d *Database) GetLocalpartForRenewalToken(ctx context.Context, renewalToken string) (string, error) {
+	return d.Accounts.SelectLocalpartByRenewalToken(ctx, renewalToken)
+}
+
+// SetPolicyVersion records that the user has consented to the given version
+// of the terms of service.
+func (d *Database) SetPolicyVersion(ctx context.Context, localpart, policyVersion string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdatePolicyVersion(ctx, txn, localpart, policyVersion)
+	})
+}
+
+// SetPolicyVersionSent records that the user has been sent a server notice
+// asking them to consent to the given version of the terms of service.
+func (d *Database) SetPolicyVersionSent(ctx context.Context, localpart, policyVersion string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdatePolicyVersionSent(ctx, txn, localpart, policyVersion)
+	})
+}
+
+// GetOutdatedPolicy returns the localparts of the accounts which haven't
+// consented to the given version of the terms of service, and which haven't
+// been sent a server notice about it yet.
+func (d *Database) GetOutdatedPolicy(ctx context.Context, policyVersion string) ([]string, error) {
+	return d.Accounts.SelectOutdatedPolicy(ctx, policyVersion)
+}
+
 // CreateOpenIDToken persists a new token that was issued for OpenID Connect
 func (d *Database) CreateOpenIDToken(
 	ctx context.Context,
@@ -379,6 +443,250 @@ func (d *Database) GetOpenIDTokenAttributes(
 	return d.OpenIDTokens.SelectOpenIDTokenAtrributes(ctx, token)
 }
 
+// impersonationTokenHash returns the hex-encoded SHA-256 hash of an
+// impersonation access token, which is stored instead of the token itself.
+func impersonationTokenHash(accessToken string) string {
+	hash := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// StoreImpersonation records that an admin was issued a token for impersonating a user.
+func (d *Database) StoreImpersonation(
+	ctx context.Context,
+	adminUserID, localpart, deviceID, accessToken string,
+	createdAtMS, expiresAtMS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Impersonations.InsertImpersonation(ctx, txn, adminUserID, localpart, deviceID, impersonationTokenHash(accessToken), createdAtMS, expiresAtMS)
+	})
+}
+
+// GetImpersonationExpiry returns when the given impersonation access token
+// expires. Returns sql.ErrNoRows if the token wasn't issued for an impersonation.
+func (d *Database) GetImpersonationExpiry(
+	ctx context.Context, accessToken string,
+) (int64, error) {
+	return d.Impersonations.SelectImpersonationExpiry(ctx, impersonationTokenHash(accessToken))
+}
+
+// RemoveExpiredImpersonations removes the devices of impersonation tokens
+// which expired at or before the given time. The audit entries are kept, but
+// no longer refer to the access token.
+func (d *Database) RemoveExpiredImpersonations(
+	ctx context.Context, nowMS int64,
+) error {
+	expired, err := d.Impersonations.SelectExpiredImpersonations(ctx, nil, nowMS)
+	if err != nil {
+		return err
+	}
+	for _, entry := range expired {
+		// The device may already have been logged out, in which case deleting
+		// it does nothing.
+		err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+			if err = d.Devices.DeleteDevice(ctx, txn, entry.DeviceID, entry.Localpart); err != nil {
+				return err
+			}
+			return d.Impersonations.ClearImpersonationAccessToken(ctx, txn, entry.Localpart, entry.DeviceID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetImpersonations returns the audit trail of impersonations of the given user.
+func (d *Database) GetImpersonations(
+	ctx context.Context, localpart string,
+) ([]api.ImpersonationAuditEntry, error) {
+	return d.Impersonations.SelectImpersonations(ctx, localpart)
+}
+
+// MarkMonthlyActiveUser records that the user was active at the given time.
+func (d *Database) MarkMonthlyActiveUser(
+	ctx context.Context, localpart string, timestampMS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MonthlyActiveUsers.UpsertMonthlyActiveUser(ctx, txn, localpart, timestampMS)
+	})
+}
+
+// MarkNewMonthlyActiveUser records that the user was active at the given
+// time, unless maxUsers users have already been active since sinceMS. The
+// users are counted in the same transaction, so that concurrent logins can't
+// take the server over its limit. Returns false if the user wasn't recorded.
+func (d *Database) MarkNewMonthlyActiveUser(
+	ctx context.Context, localpart string, timestampMS, sinceMS, maxUsers int64,
+) (marked bool, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if err = d.MonthlyActiveUsers.LockMonthlyActiveUsers(ctx, txn); err != nil {
+			return err
+		}
+		var count int64
+		count, err = d.MonthlyActiveUsers.CountMonthlyActiveUsers(ctx, txn, sinceMS)
+		if err != nil || count >= maxUsers {
+			return err
+		}
+		marked = true
+		return d.MonthlyActiveUsers.UpsertMonthlyActiveUser(ctx, txn, localpart, timestampMS)
+	})
+	return
+}
+
+// GetMonthlyActiveUserTimestamp returns when the user was last recorded as
+// active. Returns sql.ErrNoRows if the user hasn't been active.
+func (d *Database) GetMonthlyActiveUserTimestamp(
+	ctx context.Context, localpart string,
+) (int64, error) {
+	return d.MonthlyActiveUsers.SelectMonthlyActiveUserTimestamp(ctx, localpart)
+}
+
+// CountMonthlyActiveUsers returns how many users have been active since the
+// given time.
+func (d *Database) CountMonthlyActiveUsers(
+	ctx context.Context, sinceMS int64,
+) (int64, error) {
+	return d.MonthlyActiveUsers.CountMonthlyActiveUsers(ctx, nil, sinceMS)
+}
+
+// GetMonthlyActiveUsers returns the localparts of the users which have been
+// active since the given time.
+func (d *Database) GetMonthlyActiveUsers(
+	ctx context.Context, sinceMS int64,
+) ([]string, error) {
+	return d.MonthlyActiveUsers.SelectMonthlyActiveUsers(ctx, sinceMS)
+}
+
+// RemoveMonthlyActiveUsersBefore forgets users which haven't been active
+// since the given time.
+func (d *Database) RemoveMonthlyActiveUsersBefore(
+	ctx context.Context, beforeMS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MonthlyActiveUsers.DeleteMonthlyActiveUsersBefore(ctx, txn, beforeMS)
+	})
+}
+
+// GetLoginFailures returns the failed logins of the account or IP address.
+// Returns sql.ErrNoRows if there haven't been any.
+func (d *Database) GetLoginFailures(
+	ctx context.Context, kind, key string,
+) (*api.LoginFailures, error) {
+	return d.LoginFailures.SelectLoginFailures(ctx, nil, kind, key)
+}
+
+// UpdateLoginFailures reads the failed logins of the account or IP address,
+// passes them to the update function and stores the result, all within one
+// transaction. If there haven't been any failures then the update function is
+// passed a new api.LoginFailures.
+func (d *Database) UpdateLoginFailures(
+	ctx context.Context, kind, key string, update func(f *api.LoginFailures),
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		f, err := d.LoginFailures.SelectLoginFailures(ctx, txn, kind, key)
+		switch {
+		case err == sql.ErrNoRows:
+			f = &api.LoginFailures{}
+		case err != nil:
+			return err
+		}
+		update(f)
+		return d.LoginFailures.UpsertLoginFailures(ctx, txn, kind, key, f)
+	})
+}
+
+// RemoveLoginFailures forgets the failed logins of the account or IP address.
+func (d *Database) RemoveLoginFailures(
+	ctx context.Context, kind, key string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.LoginFailures.DeleteLoginFailures(ctx, txn, kind, key)
+	})
+}
+
+// GetLoginFailuresToNotify returns the failed logins of the accounts with at
+// least minFailures of them whose users haven't been notified, keyed by localpart.
+func (d *Database) GetLoginFailuresToNotify(
+	ctx context.Context, minFailures int,
+) (map[string]*api.LoginFailures, error) {
+	return d.LoginFailures.SelectLoginFailuresToNotify(ctx, minFailures)
+}
+
+// RemoveLoginFailuresBefore forgets the failed logins of the accounts and IP
+// addresses which haven't had any since the given time.
+func (d *Database) RemoveLoginFailuresBefore(
+	ctx context.Context, beforeMS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.LoginFailures.DeleteLoginFailuresBefore(ctx, txn, beforeMS)
+	})
+}
+
+// CreateThreePIDValidationSession stores a new validation session. If the
+// client has already created a session for the third-party identifier with the
+// same secret, then that session is returned instead, with its send attempt
+// and next link updated if the send attempt is higher. The returned bool is
+// true if a validation token should be sent, i.e. the session is new or the
+// send attempt is higher.
+func (d *Database) CreateThreePIDValidationSession(
+	ctx context.Context, session *api.ThreePIDValidationSession,
+) (result *api.ThreePIDValidationSession, send bool, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		existing, err := d.ThreePIDValidation.SelectSessionBySecret(ctx, txn, session.ClientSecret, session.Medium, session.Address)
+		switch {
+		case err == sql.ErrNoRows:
+			result, send = session, true
+			return d.ThreePIDValidation.InsertSession(ctx, txn, session)
+		case err != nil:
+			return err
+		case session.SendAttempt <= existing.SendAttempt:
+			result, send = existing, false
+			return nil
+		}
+		existing.SendAttempt, existing.NextLink = session.SendAttempt, session.NextLink
+		result, send = existing, true
+		return d.ThreePIDValidation.UpdateSessionSendAttempt(ctx, txn, existing.SessionID, existing.SendAttempt, existing.NextLink)
+	})
+	return
+}
+
+// GetThreePIDValidationSession returns the validation session with the given
+// ID. Returns sql.ErrNoRows if there isn't one.
+func (d *Database) GetThreePIDValidationSession(
+	ctx context.Context, sessionID string,
+) (*api.ThreePIDValidationSession, error) {
+	return d.ThreePIDValidation.SelectSession(ctx, nil, sessionID)
+}
+
+// MarkThreePIDValidationSessionValidated records when the session's token was submitted.
+func (d *Database) MarkThreePIDValidationSessionValidated(
+	ctx context.Context, sessionID string, validatedTS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.ThreePIDValidation.UpdateSessionValidated(ctx, txn, sessionID, validatedTS)
+	})
+}
+
+// RemoveThreePIDValidationSession deletes the validation session, once the
+// third-party identifier it validated has been used.
+func (d *Database) RemoveThreePIDValidationSession(
+	ctx context.Context, sessionID string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.ThreePIDValidation.DeleteSession(ctx, txn, sessionID)
+	})
+}
+
+// RemoveThreePIDValidationSessionsBefore deletes the validation sessions which
+// were created before the given time.
+func (d *Database) RemoveThreePIDValidationSessionsBefore(
+	ctx context.Context, beforeMS int64,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.ThreePIDValidation.DeleteSessionsBefore(ctx, txn, beforeMS)
+	})
+}
+
 func (d *Database) CreateKeyBackup(
 	ctx context.Context, userID, algorithm string, authData json.RawMessage,
 ) (version string, err error) {
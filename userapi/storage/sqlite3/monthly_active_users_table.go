@@ -0,0 +1,134 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+)
+
+const monthlyActiveUsersSchema = `
+-- Tracks when local users were last active, for enforcing MAU limits.
+CREATE TABLE IF NOT EXISTS userapi_monthly_active_users (
+	-- The localpart of the user
+	localpart TEXT NOT NULL PRIMARY KEY,
+	-- When the user was last active, as a unix timestamp (ms resolution).
+	timestamp BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS userapi_monthly_active_users_timestamp_idx ON userapi_monthly_active_users(timestamp);
+`
+
+const upsertMonthlyActiveUserSQL = "" +
+	"INSERT INTO userapi_monthly_active_users(localpart, timestamp) VALUES ($1, $2)" +
+	" ON CONFLICT (localpart) DO UPDATE SET timestamp = $2"
+
+const selectMonthlyActiveUserTimestampSQL = "" +
+	"SELECT timestamp FROM userapi_monthly_active_users WHERE localpart = $1"
+
+const countMonthlyActiveUsersSQL = "" +
+	"SELECT COUNT(*) FROM userapi_monthly_active_users WHERE timestamp > $1"
+
+const selectMonthlyActiveUsersSQL = "" +
+	"SELECT localpart FROM userapi_monthly_active_users WHERE timestamp > $1"
+
+const deleteMonthlyActiveUsersBeforeSQL = "" +
+	"DELETE FROM userapi_monthly_active_users WHERE timestamp <= $1"
+
+type monthlyActiveUsersStatements struct {
+	upsertMonthlyActiveUserStmt          *sql.Stmt
+	selectMonthlyActiveUserTimestampStmt *sql.Stmt
+	countMonthlyActiveUsersStmt          *sql.Stmt
+	selectMonthlyActiveUsersStmt         *sql.Stmt
+	deleteMonthlyActiveUsersBeforeStmt   *sql.Stmt
+}
+
+func NewSQLiteMonthlyActiveUsersTable(db *sql.DB) (tables.MonthlyActiveUsersTable, error) {
+	s := &monthlyActiveUsersStatements{}
+	_, err := db.Exec(monthlyActiveUsersSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertMonthlyActiveUserStmt, upsertMonthlyActiveUserSQL},
+		{&s.selectMonthlyActiveUserTimestampStmt, selectMonthlyActiveUserTimestampSQL},
+		{&s.countMonthlyActiveUsersStmt, countMonthlyActiveUsersSQL},
+		{&s.selectMonthlyActiveUsersStmt, selectMonthlyActiveUsersSQL},
+		{&s.deleteMonthlyActiveUsersBeforeStmt, deleteMonthlyActiveUsersBeforeSQL},
+	}.Prepare(db)
+}
+
+func (s *monthlyActiveUsersStatements) UpsertMonthlyActiveUser(
+	ctx context.Context, txn *sql.Tx, localpart string, timestamp int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.upsertMonthlyActiveUserStmt)
+	_, err := stmt.ExecContext(ctx, localpart, timestamp)
+	return err
+}
+
+// SelectMonthlyActiveUserTimestamp returns when the user was last active.
+// Returns sql.ErrNoRows if the user hasn't been active.
+func (s *monthlyActiveUsersStatements) SelectMonthlyActiveUserTimestamp(
+	ctx context.Context, localpart string,
+) (timestamp int64, err error) {
+	err = s.selectMonthlyActiveUserTimestampStmt.QueryRowContext(ctx, localpart).Scan(&timestamp)
+	return
+}
+
+func (s *monthlyActiveUsersStatements) CountMonthlyActiveUsers(
+	ctx context.Context, txn *sql.Tx, since int64,
+) (count int64, err error) {
+	err = sqlutil.TxStmt(txn, s.countMonthlyActiveUsersStmt).QueryRowContext(ctx, since).Scan(&count)
+	return
+}
+
+func (s *monthlyActiveUsersStatements) SelectMonthlyActiveUsers(
+	ctx context.Context, since int64,
+) ([]string, error) {
+	rows, err := s.selectMonthlyActiveUsersStmt.QueryContext(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMonthlyActiveUsers: rows.close() failed")
+
+	var localparts []string
+	for rows.Next() {
+		var localpart string
+		if err = rows.Scan(&localpart); err != nil {
+			return nil, err
+		}
+		localparts = append(localparts, localpart)
+	}
+	return localparts, rows.Err()
+}
+
+func (s *monthlyActiveUsersStatements) DeleteMonthlyActiveUsersBefore(
+	ctx context.Context, txn *sql.Tx, before int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteMonthlyActiveUsersBeforeStmt)
+	_, err := stmt.ExecContext(ctx, before)
+	return err
+}
+
+// LockMonthlyActiveUsers does nothing, as SQLite only allows one writer at a
+// time anyway.
+func (s *monthlyActiveUsersStatements) LockMonthlyActiveUsers(
+	ctx context.Context, txn *sql.Tx,
+) error {
+	return nil
+}
@@ -62,10 +62,26 @@ func NewDatabase(base *base.BaseDendrite, dbProperties *config.DatabaseOptions,
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteOpenIDTable: %w", err)
 	}
+	impersonationsTable, err := NewSQLiteImpersonationAuditTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteImpersonationAuditTable: %w", err)
+	}
+	loginFailuresTable, err := NewSQLiteLoginFailuresTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteLoginFailuresTable: %w", err)
+	}
+	monthlyActiveUsersTable, err := NewSQLiteMonthlyActiveUsersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteMonthlyActiveUsersTable: %w", err)
+	}
 	profilesTable, err := NewSQLiteProfilesTable(db, serverNoticesLocalpart)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteProfilesTable: %w", err)
 	}
+	threePIDValidationSessionsTable, err := NewSQLiteThreePIDValidationSessionsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteThreePIDValidationSessionsTable: %w", err)
+	}
 	threePIDTable, err := NewSQLiteThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteThreePIDTable: %w", err)
@@ -90,8 +106,12 @@ func NewDatabase(base *base.BaseDendrite, dbProperties *config.DatabaseOptions,
 		KeyBackupVersions:     keyBackupVersionTable,
 		LoginTokens:           loginTokenTable,
 		OpenIDTokens:          openIDTable,
+		Impersonations:        impersonationsTable,
+		LoginFailures:         loginFailuresTable,
+		MonthlyActiveUsers:    monthlyActiveUsersTable,
 		Profiles:              profilesTable,
 		ThreePIDs:             threePIDTable,
+		ThreePIDValidation:    threePIDValidationSessionsTable,
 		Pushers:               pusherTable,
 		Notifications:         notificationsTable,
 		Stats:                 statsTable,
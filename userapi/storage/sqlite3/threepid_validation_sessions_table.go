@@ -0,0 +1,172 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+)
+
+const threePIDValidationSessionsSchema = `
+-- Stores the sessions for validating third-party identifiers (e.g. email
+-- addresses) by sending a token to them.
+CREATE TABLE IF NOT EXISTS userapi_threepid_validation_sessions (
+	-- The ID of the session, which is given to the client
+	session_id TEXT NOT NULL PRIMARY KEY,
+	-- The secret the client created the session with
+	client_secret TEXT NOT NULL,
+	-- The medium and address of the third-party identifier
+	medium TEXT NOT NULL,
+	address TEXT NOT NULL,
+	-- The token sent to the address
+	token TEXT NOT NULL,
+	-- The highest send attempt the client has made
+	send_attempt INTEGER NOT NULL,
+	-- Where the user is redirected to after validating, if anywhere
+	next_link TEXT NOT NULL DEFAULT '',
+	-- When the session was created, as a unix timestamp (ms resolution).
+	created_ts BIGINT NOT NULL,
+	-- When the token was submitted, as a unix timestamp (ms resolution), or 0 if it hasn't been.
+	validated_ts BIGINT NOT NULL DEFAULT 0,
+	UNIQUE (client_secret, medium, address)
+);
+CREATE INDEX IF NOT EXISTS userapi_threepid_validation_sessions_created_ts_idx ON userapi_threepid_validation_sessions(created_ts);
+`
+
+const insertThreePIDValidationSessionSQL = "" +
+	"INSERT INTO userapi_threepid_validation_sessions(session_id, client_secret, medium, address, token, send_attempt, next_link, created_ts)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+
+const selectThreePIDValidationSessionSQL = "" +
+	"SELECT session_id, client_secret, medium, address, token, send_attempt, next_link, created_ts, validated_ts" +
+	" FROM userapi_threepid_validation_sessions WHERE session_id = $1"
+
+const selectThreePIDValidationSessionBySecretSQL = "" +
+	"SELECT session_id, client_secret, medium, address, token, send_attempt, next_link, created_ts, validated_ts" +
+	" FROM userapi_threepid_validation_sessions WHERE client_secret = $1 AND medium = $2 AND address = $3"
+
+const updateThreePIDValidationSessionSendAttemptSQL = "" +
+	"UPDATE userapi_threepid_validation_sessions SET send_attempt = $1, next_link = $2 WHERE session_id = $3"
+
+const updateThreePIDValidationSessionValidatedSQL = "" +
+	"UPDATE userapi_threepid_validation_sessions SET validated_ts = $1 WHERE session_id = $2"
+
+const deleteThreePIDValidationSessionSQL = "" +
+	"DELETE FROM userapi_threepid_validation_sessions WHERE session_id = $1"
+
+const deleteThreePIDValidationSessionsBeforeSQL = "" +
+	"DELETE FROM userapi_threepid_validation_sessions WHERE created_ts <= $1"
+
+type threePIDValidationSessionsStatements struct {
+	insertSessionStmt            *sql.Stmt
+	selectSessionStmt            *sql.Stmt
+	selectSessionBySecretStmt    *sql.Stmt
+	updateSessionSendAttemptStmt *sql.Stmt
+	updateSessionValidatedStmt   *sql.Stmt
+	deleteSessionStmt            *sql.Stmt
+	deleteSessionsBeforeStmt     *sql.Stmt
+}
+
+func NewSQLiteThreePIDValidationSessionsTable(db *sql.DB) (tables.ThreePIDValidationSessionsTable, error) {
+	s := &threePIDValidationSessionsStatements{}
+	_, err := db.Exec(threePIDValidationSessionsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertSessionStmt, insertThreePIDValidationSessionSQL},
+		{&s.selectSessionStmt, selectThreePIDValidationSessionSQL},
+		{&s.selectSessionBySecretStmt, selectThreePIDValidationSessionBySecretSQL},
+		{&s.updateSessionSendAttemptStmt, updateThreePIDValidationSessionSendAttemptSQL},
+		{&s.updateSessionValidatedStmt, updateThreePIDValidationSessionValidatedSQL},
+		{&s.deleteSessionStmt, deleteThreePIDValidationSessionSQL},
+		{&s.deleteSessionsBeforeStmt, deleteThreePIDValidationSessionsBeforeSQL},
+	}.Prepare(db)
+}
+
+func (s *threePIDValidationSessionsStatements) InsertSession(
+	ctx context.Context, txn *sql.Tx, session *api.ThreePIDValidationSession,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertSessionStmt)
+	_, err := stmt.ExecContext(
+		ctx, session.SessionID, session.ClientSecret, session.Medium, session.Address,
+		session.Token, session.SendAttempt, session.NextLink, session.CreatedTS,
+	)
+	return err
+}
+
+// SelectSession returns the session with the given ID.
+// Returns sql.ErrNoRows if there isn't one.
+func (s *threePIDValidationSessionsStatements) SelectSession(
+	ctx context.Context, txn *sql.Tx, sessionID string,
+) (*api.ThreePIDValidationSession, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectSessionStmt)
+	return scanThreePIDValidationSession(stmt.QueryRowContext(ctx, sessionID))
+}
+
+// SelectSessionBySecret returns the session the client created for the
+// third-party identifier with the given secret.
+// Returns sql.ErrNoRows if there isn't one.
+func (s *threePIDValidationSessionsStatements) SelectSessionBySecret(
+	ctx context.Context, txn *sql.Tx, clientSecret, medium, address string,
+) (*api.ThreePIDValidationSession, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectSessionBySecretStmt)
+	return scanThreePIDValidationSession(stmt.QueryRowContext(ctx, clientSecret, medium, address))
+}
+
+func scanThreePIDValidationSession(row *sql.Row) (*api.ThreePIDValidationSession, error) {
+	session := &api.ThreePIDValidationSession{}
+	err := row.Scan(
+		&session.SessionID, &session.ClientSecret, &session.Medium, &session.Address, &session.Token,
+		&session.SendAttempt, &session.NextLink, &session.CreatedTS, &session.ValidatedTS,
+	)
+	return session, err
+}
+
+func (s *threePIDValidationSessionsStatements) UpdateSessionSendAttempt(
+	ctx context.Context, txn *sql.Tx, sessionID string, sendAttempt int, nextLink string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateSessionSendAttemptStmt)
+	_, err := stmt.ExecContext(ctx, sendAttempt, nextLink, sessionID)
+	return err
+}
+
+func (s *threePIDValidationSessionsStatements) UpdateSessionValidated(
+	ctx context.Context, txn *sql.Tx, sessionID string, validatedTS int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.updateSessionValidatedStmt)
+	_, err := stmt.ExecContext(ctx, validatedTS, sessionID)
+	return err
+}
+
+func (s *threePIDValidationSessionsStatements) DeleteSession(
+	ctx context.Context, txn *sql.Tx, sessionID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSessionStmt)
+	_, err := stmt.ExecContext(ctx, sessionID)
+	return err
+}
+
+func (s *threePIDValidationSessionsStatements) DeleteSessionsBefore(
+	ctx context.Context, txn *sql.Tx, before int64,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteSessionsBeforeStmt)
+	_, err := stmt.ExecContext(ctx, before)
+	return err
+}
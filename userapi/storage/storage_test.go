@@ -2,6 +2,9 @@ package storage_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"testing"
@@ -116,6 +119,18 @@ func Test_Accounts(t *testing.T) {
 		assert.NoError(t, err, "failed to get account by new password")
 		assert.Equal(t, accAlice, accGet)
 
+		// shadow-ban alice, and lift the shadow-ban again
+		err = db.SetShadowBanned(ctx, aliceLocalpart, true)
+		assert.NoError(t, err, "failed to shadow-ban account")
+		accGet, err = db.GetAccountByLocalpart(ctx, aliceLocalpart)
+		assert.NoError(t, err, "failed to get account by localpart")
+		assert.True(t, accGet.ShadowBanned)
+		err = db.SetShadowBanned(ctx, aliceLocalpart, false)
+		assert.NoError(t, err, "failed to lift shadow-ban")
+		accGet, err = db.GetAccountByLocalpart(ctx, aliceLocalpart)
+		assert.NoError(t, err, "failed to get account by localpart")
+		assert.False(t, accGet.ShadowBanned)
+
 		// deactivate account
 		err = db.DeactivateAccount(ctx, aliceLocalpart)
 		assert.NoError(t, err, "failed to deactivate account")
@@ -338,6 +353,86 @@ func Test_LoginToken(t *testing.T) {
 	})
 }
 
+func Test_ImpersonationAudit(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		// a token which wasn't issued for an impersonation
+		_, err := db.GetImpersonationExpiry(ctx, "TOKEN")
+		assert.Equal(t, sql.ErrNoRows, err)
+
+		want := []api.ImpersonationAuditEntry{
+			{AdminUserID: "@admin:localhost", Localpart: "alice", DeviceID: "DEVICE", CreatedAtMS: 1, ExpiresAtMS: 10},
+			{AdminUserID: "@admin2:localhost", Localpart: "alice", DeviceID: "DEVICE2", CreatedAtMS: 2, ExpiresAtMS: 20},
+		}
+		for _, entry := range want {
+			_, err = db.CreateDevice(ctx, entry.Localpart, &entry.DeviceID, "TOKEN_"+entry.DeviceID, nil, "", "")
+			assert.NoError(t, err, "unable to create device")
+			err = db.StoreImpersonation(ctx, entry.AdminUserID, entry.Localpart, entry.DeviceID, "TOKEN_"+entry.DeviceID, entry.CreatedAtMS, entry.ExpiresAtMS)
+			assert.NoError(t, err, "unable to store impersonation")
+		}
+		// a regular device, which shares its ID with an impersonation device
+		deviceID := "DEVICE"
+		_, err = db.CreateDevice(ctx, "bob", &deviceID, "TOKEN_BOB", nil, "", "")
+		assert.NoError(t, err, "unable to create device")
+
+		expiresAtMS, err := db.GetImpersonationExpiry(ctx, "TOKEN_DEVICE2")
+		assert.NoError(t, err, "unable to get impersonation expiry")
+		assert.Equal(t, int64(20), expiresAtMS)
+		// only the hash of the token is stored, so the hash isn't a valid token
+		tokenHash := sha256.Sum256([]byte("TOKEN_DEVICE2"))
+		_, err = db.GetImpersonationExpiry(ctx, hex.EncodeToString(tokenHash[:]))
+		assert.Equal(t, sql.ErrNoRows, err)
+
+		// only the first impersonation has expired
+		err = db.RemoveExpiredImpersonations(ctx, 15)
+		assert.NoError(t, err, "unable to remove expired impersonations")
+		_, err = db.GetDeviceByAccessToken(ctx, "TOKEN_DEVICE")
+		assert.Equal(t, sql.ErrNoRows, err)
+		_, err = db.GetImpersonationExpiry(ctx, "TOKEN_DEVICE")
+		assert.Equal(t, sql.ErrNoRows, err)
+		_, err = db.GetDeviceByAccessToken(ctx, "TOKEN_DEVICE2")
+		assert.NoError(t, err, "unexpired impersonation device was removed")
+		_, err = db.GetDeviceByAccessToken(ctx, "TOKEN_BOB")
+		assert.NoError(t, err, "regular device was removed")
+
+		got, err := db.GetImpersonations(ctx, "alice")
+		assert.NoError(t, err, "unable to get impersonations")
+		assert.Equal(t, want, got)
+
+		got, err = db.GetImpersonations(ctx, "bob")
+		assert.NoError(t, err, "unable to get impersonations")
+		assert.Empty(t, got)
+	})
+}
+
+func Test_MonthlyActiveUsers(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+
+		// alice was active too long ago to count
+		err := db.MarkMonthlyActiveUser(ctx, "alice", 5)
+		assert.NoError(t, err, "unable to mark monthly active user")
+
+		marked, err := db.MarkNewMonthlyActiveUser(ctx, "bob", 20, 10, 1)
+		assert.NoError(t, err, "unable to mark new monthly active user")
+		assert.True(t, marked)
+
+		// the limit has been reached
+		marked, err = db.MarkNewMonthlyActiveUser(ctx, "charlie", 20, 10, 1)
+		assert.NoError(t, err, "unable to mark new monthly active user")
+		assert.False(t, marked)
+		_, err = db.GetMonthlyActiveUserTimestamp(ctx, "charlie")
+		assert.Equal(t, sql.ErrNoRows, err)
+
+		count, err := db.CountMonthlyActiveUsers(ctx, 10)
+		assert.NoError(t, err, "unable to count monthly active users")
+		assert.Equal(t, int64(1), count)
+	})
+}
+
 func Test_OpenID(t *testing.T) {
 	alice := test.NewUser(t)
 	token := util.RandomString(24)
@@ -35,6 +35,14 @@ type AccountsTable interface {
 	InsertAccount(ctx context.Context, txn *sql.Tx, localpart, hash, appserviceID string, accountType api.AccountType) (*api.Account, error)
 	UpdatePassword(ctx context.Context, localpart, passwordHash string) (err error)
 	DeactivateAccount(ctx context.Context, localpart string) (err error)
+	UpdateShadowBanned(ctx context.Context, localpart string, shadowBanned bool) (err error)
+	UpdateExpiry(ctx context.Context, txn *sql.Tx, localpart string, expiresTS int64) (err error)
+	UpdateRenewalToken(ctx context.Context, txn *sql.Tx, localpart, renewalToken string) (err error)
+	SelectAccountsDueRenewal(ctx context.Context, before int64) (map[string]int64, error)
+	SelectLocalpartByRenewalToken(ctx context.Context, renewalToken string) (localpart string, err error)
+	UpdatePolicyVersion(ctx context.Context, txn *sql.Tx, localpart, policyVersion string) (err error)
+	UpdatePolicyVersionSent(ctx context.Context, txn *sql.Tx, localpart, policyVersion string) (err error)
+	SelectOutdatedPolicy(ctx context.Context, policyVersion string) ([]string, error)
 	SelectPasswordHash(ctx context.Context, localpart string) (hash string, err error)
 	SelectAccountByLocalpart(ctx context.Context, localpart string) (*api.Account, error)
 	SelectNewNumericLocalpart(ctx context.Context, txn *sql.Tx) (id int64, err error)
@@ -76,6 +84,31 @@ type LoginTokenTable interface {
 	SelectLoginToken(ctx context.Context, token string) (*api.LoginTokenData, error)
 }
 
+type ImpersonationAuditTable interface {
+	InsertImpersonation(ctx context.Context, txn *sql.Tx, adminUserID, localpart, deviceID, accessTokenHash string, createdTS, expiresTS int64) error
+	SelectImpersonationExpiry(ctx context.Context, accessTokenHash string) (int64, error)
+	SelectExpiredImpersonations(ctx context.Context, txn *sql.Tx, nowTS int64) ([]api.ImpersonationAuditEntry, error)
+	ClearImpersonationAccessToken(ctx context.Context, txn *sql.Tx, localpart, deviceID string) error
+	SelectImpersonations(ctx context.Context, localpart string) ([]api.ImpersonationAuditEntry, error)
+}
+
+type LoginFailuresTable interface {
+	SelectLoginFailures(ctx context.Context, txn *sql.Tx, kind, key string) (*api.LoginFailures, error)
+	UpsertLoginFailures(ctx context.Context, txn *sql.Tx, kind, key string, f *api.LoginFailures) error
+	DeleteLoginFailures(ctx context.Context, txn *sql.Tx, kind, key string) error
+	SelectLoginFailuresToNotify(ctx context.Context, minFailures int) (map[string]*api.LoginFailures, error)
+	DeleteLoginFailuresBefore(ctx context.Context, txn *sql.Tx, before int64) error
+}
+
+type MonthlyActiveUsersTable interface {
+	UpsertMonthlyActiveUser(ctx context.Context, txn *sql.Tx, localpart string, timestamp int64) error
+	SelectMonthlyActiveUserTimestamp(ctx context.Context, localpart string) (int64, error)
+	CountMonthlyActiveUsers(ctx context.Context, txn *sql.Tx, since int64) (int64, error)
+	SelectMonthlyActiveUsers(ctx context.Context, since int64) ([]string, error)
+	DeleteMonthlyActiveUsersBefore(ctx context.Context, txn *sql.Tx, before int64) error
+	LockMonthlyActiveUsers(ctx context.Context, txn *sql.Tx) error
+}
+
 type OpenIDTable interface {
 	InsertOpenIDToken(ctx context.Context, txn *sql.Tx, token, localpart string, expiresAtMS int64) (err error)
 	SelectOpenIDTokenAtrributes(ctx context.Context, token string) (*api.OpenIDTokenAttributes, error)
@@ -96,6 +129,16 @@ type ThreePIDTable interface {
 	DeleteThreePID(ctx context.Context, txn *sql.Tx, threepid string, medium string) (err error)
 }
 
+type ThreePIDValidationSessionsTable interface {
+	InsertSession(ctx context.Context, txn *sql.Tx, session *api.ThreePIDValidationSession) error
+	SelectSession(ctx context.Context, txn *sql.Tx, sessionID string) (*api.ThreePIDValidationSession, error)
+	SelectSessionBySecret(ctx context.Context, txn *sql.Tx, clientSecret, medium, address string) (*api.ThreePIDValidationSession, error)
+	UpdateSessionSendAttempt(ctx context.Context, txn *sql.Tx, sessionID string, sendAttempt int, nextLink string) error
+	UpdateSessionValidated(ctx context.Context, txn *sql.Tx, sessionID string, validatedTS int64) error
+	DeleteSession(ctx context.Context, txn *sql.Tx, sessionID string) error
+	DeleteSessionsBefore(ctx context.Context, txn *sql.Tx, before int64) error
+}
+
 type PusherTable interface {
 	InsertPusher(ctx context.Context, txn *sql.Tx, session_id int64, pushkey string, pushkeyTS int64, kind api.PusherKind, appid, appdisplayname, devicedisplayname, profiletag, lang, data, localpart string) error
 	SelectPushers(ctx context.Context, txn *sql.Tx, localpart string) ([]api.Pusher, error)
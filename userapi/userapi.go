@@ -45,10 +45,11 @@ func AddInternalRoutes(router *mux.Router, intAPI api.UserInternalAPI) {
 // can call functions directly on the returned API or via an HTTP interface using AddInternalRoutes.
 func NewInternalAPI(
 	base *base.BaseDendrite, cfg *config.UserAPI,
-	appServices []config.ApplicationService, keyAPI keyapi.UserKeyAPI,
+	appServices func() []config.ApplicationService, keyAPI keyapi.UserKeyAPI,
 	rsAPI rsapi.UserRoomserverAPI, pgClient pushgateway.Client,
 ) api.UserInternalAPI {
 	js, _ := base.NATS.Prepare(base.ProcessContext, &cfg.Matrix.JetStream)
+	base.WatchAppServiceRegistrations()
 
 	db, err := storage.NewUserAPIDatabase(
 		base,
@@ -73,9 +74,15 @@ func NewInternalAPI(
 		cfg.Matrix.JetStream.Prefixed(jetstream.OutputNotificationData),
 	)
 
+	erasureProducer := producers.NewUserErasure(
+		js, cfg.Matrix.JetStream.Prefixed(jetstream.OutputUserErasure),
+	)
+
 	userAPI := &internal.UserInternalAPI{
+		Config:               cfg,
 		DB:                   db,
 		SyncProducer:         syncProducer,
+		ErasureProducer:      erasureProducer,
 		ServerName:           cfg.Matrix.ServerName,
 		AppServices:          appServices,
 		KeyAPI:               keyAPI,
@@ -108,6 +115,40 @@ func NewInternalAPI(
 	}
 	time.AfterFunc(time.Minute, cleanOldNotifs)
 
+	var pruneImpersonations func()
+	pruneImpersonations = func() {
+		userAPI.PruneImpersonations(base.Context())
+		time.AfterFunc(time.Minute, pruneImpersonations)
+	}
+	time.AfterFunc(time.Minute, pruneImpersonations)
+
+	if cfg.Matrix.MAU.Enabled {
+		var pruneMAU func()
+		pruneMAU = func() {
+			userAPI.PruneMonthlyActiveUsers(base.Context())
+			time.AfterFunc(time.Hour, pruneMAU)
+		}
+		time.AfterFunc(time.Minute, pruneMAU)
+	}
+
+	if cfg.Matrix.LoginLockout.Enabled {
+		var pruneLoginFailures func()
+		pruneLoginFailures = func() {
+			userAPI.PruneLoginFailures(base.Context())
+			time.AfterFunc(time.Hour, pruneLoginFailures)
+		}
+		time.AfterFunc(time.Minute, pruneLoginFailures)
+	}
+
+	if cfg.Matrix.EmailValidation.Enabled {
+		var pruneValidationSessions func()
+		pruneValidationSessions = func() {
+			userAPI.PruneThreePIDValidationSessions(base.Context())
+			time.AfterFunc(time.Hour, pruneValidationSessions)
+		}
+		time.AfterFunc(time.Minute, pruneValidationSessions)
+	}
+
 	if base.Cfg.Global.ReportStats.Enabled {
 		go util.StartPhoneHomeCollector(time.Now(), base.Cfg, db)
 	}
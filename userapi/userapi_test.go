@@ -42,6 +42,11 @@ const (
 
 type apiTestOpts struct {
 	loginTokenLifetime time.Duration
+	mau                config.MAULimits
+	accountValidity    config.AccountValidity
+	userConsent        config.UserConsent
+	loginLockout       config.LoginLockout
+	emailValidation    config.EmailValidation
 }
 
 func MustMakeInternalAPI(t *testing.T, opts apiTestOpts, dbType test.DBType) (api.UserInternalAPI, storage.Database, func()) {
@@ -59,11 +64,17 @@ func MustMakeInternalAPI(t *testing.T, opts apiTestOpts, dbType test.DBType) (ap
 
 	cfg := &config.UserAPI{
 		Matrix: &config.Global{
-			ServerName: serverName,
+			ServerName:      serverName,
+			MAU:             opts.mau,
+			AccountValidity: opts.accountValidity,
+			UserConsent:     opts.userConsent,
+			LoginLockout:    opts.loginLockout,
+			EmailValidation: opts.emailValidation,
 		},
 	}
 
 	return &internal.UserInternalAPI{
+		Config:     cfg,
 		DB:         accountDB,
 		ServerName: cfg.Matrix.ServerName,
 	}, accountDB, close
@@ -272,3 +283,517 @@ func TestLoginToken(t *testing.T) {
 		})
 	})
 }
+
+func TestImpersonationToken(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{}, dbType)
+		defer close()
+		_, err := accountDB.CreateAccount(ctx, "auser", "apassword", "", api.AccountTypeUser)
+		if err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+
+		creq := api.PerformImpersonationTokenCreationRequest{
+			AdminUserID: "@admin:example.com",
+			Localpart:   "auser",
+			AccessToken: "impersonation_token",
+			Lifetime:    time.Millisecond,
+		}
+		var cresp api.PerformImpersonationTokenCreationResponse
+		if err = userAPI.PerformImpersonationTokenCreation(ctx, &creq, &cresp); err != nil {
+			t.Fatalf("PerformImpersonationTokenCreation failed: %v", err)
+		}
+		if cresp.Device == nil || cresp.Device.AccessToken == "" {
+			t.Fatalf("PerformImpersonationTokenCreation Device: got %+v, want a device with an access token", cresp.Device)
+		}
+
+		var aresp api.QueryImpersonationAuditResponse
+		if err = userAPI.QueryImpersonationAudit(ctx, &api.QueryImpersonationAuditRequest{Localpart: "auser"}, &aresp); err != nil {
+			t.Fatalf("QueryImpersonationAudit failed: %v", err)
+		}
+		if len(aresp.Entries) != 1 {
+			t.Fatalf("QueryImpersonationAudit Entries: got %d, want 1", len(aresp.Entries))
+		}
+		if entry := aresp.Entries[0]; entry.AdminUserID != creq.AdminUserID || entry.DeviceID != cresp.Device.ID || entry.ExpiresAtMS != cresp.ExpiresAtMS {
+			t.Errorf("QueryImpersonationAudit Entries[0]: got %+v, unexpected", entry)
+		}
+
+		// The token has expired, so it should no longer be usable.
+		time.Sleep(time.Millisecond * 5)
+		var qresp api.QueryAccessTokenResponse
+		if err = userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: cresp.Device.AccessToken}, &qresp); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if qresp.Device != nil {
+			t.Errorf("QueryAccessToken Device: got %+v, want nil", qresp.Device)
+		}
+		userAPI.(*internal.UserInternalAPI).PruneImpersonations(ctx)
+		devices, err := accountDB.GetDevicesByLocalpart(ctx, "auser")
+		if err != nil {
+			t.Fatalf("failed to get devices: %v", err)
+		}
+		if len(devices) != 0 {
+			t.Errorf("expected the expired impersonation device to be removed, got %d devices", len(devices))
+		}
+
+		// Unknown users can't be impersonated.
+		creq.Localpart = "unknown"
+		cresp = api.PerformImpersonationTokenCreationResponse{}
+		if err = userAPI.PerformImpersonationTokenCreation(ctx, &creq, &cresp); err != nil {
+			t.Fatalf("PerformImpersonationTokenCreation failed: %v", err)
+		}
+		if cresp.Device != nil {
+			t.Errorf("PerformImpersonationTokenCreation Device: got %+v, want nil", cresp.Device)
+		}
+	})
+}
+
+func TestMonthlyActiveUserLimit(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{
+			mau: config.MAULimits{
+				Enabled:       true,
+				MaxMAU:        1,
+				ReservedUsers: []string{"@reserved:example.com"},
+				AdminContact:  "mailto:admin@example.com",
+			},
+		}, dbType)
+		defer close()
+
+		for _, localpart := range []string{"alice", "bob", "reserved"} {
+			if _, err := accountDB.CreateAccount(ctx, localpart, "apassword", "", api.AccountTypeUser); err != nil {
+				t.Fatalf("failed to make account: %s", err)
+			}
+			if _, err := accountDB.CreateDevice(ctx, localpart, nil, localpart+"_token", nil, "", ""); err != nil {
+				t.Fatalf("failed to make device: %s", err)
+			}
+		}
+
+		queryAccessToken := func(token string) *api.QueryAccessTokenResponse {
+			var res api.QueryAccessTokenResponse
+			if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: token}, &res); err != nil {
+				t.Fatalf("QueryAccessToken failed: %v", err)
+			}
+			return &res
+		}
+
+		if res := queryAccessToken("alice_token"); res.Device == nil || res.ResourceLimitExceeded {
+			t.Fatalf("expected alice to be allowed, got %+v", res)
+		}
+		res := queryAccessToken("bob_token")
+		if res.Device != nil || !res.ResourceLimitExceeded {
+			t.Fatalf("expected bob to be refused, got %+v", res)
+		}
+		if res.AdminContact != "mailto:admin@example.com" {
+			t.Errorf("AdminContact: got %q, want mailto:admin@example.com", res.AdminContact)
+		}
+		if res := queryAccessToken("reserved_token"); res.Device == nil || res.ResourceLimitExceeded {
+			t.Fatalf("expected reserved user to be allowed, got %+v", res)
+		}
+		if res := queryAccessToken("alice_token"); res.Device == nil || res.ResourceLimitExceeded {
+			t.Fatalf("expected alice to still be allowed, got %+v", res)
+		}
+
+		var mres api.QueryMonthlyActiveUsersResponse
+		if err := userAPI.QueryMonthlyActiveUsers(ctx, &api.QueryMonthlyActiveUsersRequest{IncludeUserIDs: true}, &mres); err != nil {
+			t.Fatalf("QueryMonthlyActiveUsers failed: %v", err)
+		}
+		if mres.Count != 2 || len(mres.UserIDs) != 2 {
+			t.Errorf("QueryMonthlyActiveUsers: got %d users (%v), want 2", mres.Count, mres.UserIDs)
+		}
+	})
+}
+
+func TestAccountValidity(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{
+			accountValidity: config.AccountValidity{
+				Enabled: true,
+				Period:  time.Hour,
+				RenewAt: time.Hour,
+			},
+		}, dbType)
+		defer close()
+
+		if _, err := accountDB.CreateAccount(ctx, "alice", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+		if _, err := accountDB.CreateDevice(ctx, "alice", nil, "alice_token", nil, "", ""); err != nil {
+			t.Fatalf("failed to make device: %s", err)
+		}
+		expired := time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond)
+		if err := accountDB.SetAccountExpiry(ctx, "alice", expired); err != nil {
+			t.Fatalf("failed to set account expiry: %s", err)
+		}
+
+		var qresp api.QueryAccessTokenResponse
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "alice_token"}, &qresp); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if qresp.Device != nil || !qresp.AccountExpired {
+			t.Fatalf("expected expired account to be refused, got %+v", qresp)
+		}
+
+		var rresp api.PerformAccountValidityRemindersResponse
+		if err := userAPI.PerformAccountValidityReminders(ctx, &api.PerformAccountValidityRemindersRequest{}, &rresp); err != nil {
+			t.Fatalf("PerformAccountValidityReminders failed: %v", err)
+		}
+		if len(rresp.Reminders) != 1 || rresp.Reminders[0].UserID != "@alice:example.com" || rresp.Reminders[0].RenewalToken == "" {
+			t.Fatalf("PerformAccountValidityReminders: got %+v, want one reminder for alice", rresp.Reminders)
+		}
+		token := rresp.Reminders[0].RenewalToken
+
+		// The token isn't valid until the reminder has been sent.
+		var renewResp api.PerformAccountValidityRenewalResponse
+		if err := userAPI.PerformAccountValidityRenewal(ctx, &api.PerformAccountValidityRenewalRequest{RenewalToken: token}, &renewResp); err != nil {
+			t.Fatalf("PerformAccountValidityRenewal failed: %v", err)
+		}
+		if renewResp.Renewed {
+			t.Fatalf("PerformAccountValidityRenewal: got %+v, want the unsent token to be refused", renewResp)
+		}
+
+		// Users who weren't reminded are tried again, with a new token.
+		rresp = api.PerformAccountValidityRemindersResponse{}
+		if err := userAPI.PerformAccountValidityReminders(ctx, &api.PerformAccountValidityRemindersRequest{}, &rresp); err != nil {
+			t.Fatalf("PerformAccountValidityReminders failed: %v", err)
+		}
+		if len(rresp.Reminders) != 1 || rresp.Reminders[0].RenewalToken == token {
+			t.Fatalf("PerformAccountValidityReminders: got %+v, want a new reminder for alice", rresp.Reminders)
+		}
+		token = rresp.Reminders[0].RenewalToken
+		if err := userAPI.PerformAccountValidityReminderSent(ctx, &api.PerformAccountValidityReminderSentRequest{
+			UserID:       rresp.Reminders[0].UserID,
+			RenewalToken: token,
+		}, &api.PerformAccountValidityReminderSentResponse{}); err != nil {
+			t.Fatalf("PerformAccountValidityReminderSent failed: %v", err)
+		}
+
+		// Users are only reminded once.
+		rresp = api.PerformAccountValidityRemindersResponse{}
+		if err := userAPI.PerformAccountValidityReminders(ctx, &api.PerformAccountValidityRemindersRequest{}, &rresp); err != nil {
+			t.Fatalf("PerformAccountValidityReminders failed: %v", err)
+		}
+		if len(rresp.Reminders) != 0 {
+			t.Fatalf("PerformAccountValidityReminders: got %+v, want no reminders", rresp.Reminders)
+		}
+
+		renewResp = api.PerformAccountValidityRenewalResponse{}
+		if err := userAPI.PerformAccountValidityRenewal(ctx, &api.PerformAccountValidityRenewalRequest{RenewalToken: token}, &renewResp); err != nil {
+			t.Fatalf("PerformAccountValidityRenewal failed: %v", err)
+		}
+		if !renewResp.Renewed || renewResp.UserID != "@alice:example.com" || renewResp.ExpiresAtMS <= expired {
+			t.Fatalf("PerformAccountValidityRenewal: got %+v, want alice renewed", renewResp)
+		}
+
+		qresp = api.QueryAccessTokenResponse{}
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "alice_token"}, &qresp); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if qresp.Device == nil || qresp.AccountExpired {
+			t.Fatalf("expected renewed account to be allowed, got %+v", qresp)
+		}
+
+		// Renewal tokens can only be used once.
+		renewResp = api.PerformAccountValidityRenewalResponse{}
+		if err := userAPI.PerformAccountValidityRenewal(ctx, &api.PerformAccountValidityRenewalRequest{RenewalToken: token}, &renewResp); err != nil {
+			t.Fatalf("PerformAccountValidityRenewal failed: %v", err)
+		}
+		if renewResp.Renewed {
+			t.Fatalf("expected used renewal token to be rejected")
+		}
+
+		// Admins can renew accounts until a given time.
+		renewResp = api.PerformAccountValidityRenewalResponse{}
+		if err := userAPI.PerformAccountValidityRenewal(ctx, &api.PerformAccountValidityRenewalRequest{
+			UserID:      "@alice:example.com",
+			ExpiresAtMS: expired,
+		}, &renewResp); err != nil {
+			t.Fatalf("PerformAccountValidityRenewal failed: %v", err)
+		}
+		acc, err := accountDB.GetAccountByLocalpart(ctx, "alice")
+		if err != nil {
+			t.Fatalf("failed to get account: %s", err)
+		}
+		if acc.ExpiresAtMS != expired {
+			t.Errorf("ExpiresAtMS: got %d, want %d", acc.ExpiresAtMS, expired)
+		}
+	})
+}
+
+func TestUserConsent(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{
+			userConsent: config.UserConsent{
+				Enabled: true,
+				Version: "2.0",
+			},
+		}, dbType)
+		defer close()
+
+		if _, err := accountDB.CreateAccount(ctx, "alice", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+		if _, err := accountDB.CreateDevice(ctx, "alice", nil, "alice_token", nil, "", ""); err != nil {
+			t.Fatalf("failed to make device: %s", err)
+		}
+		// Appservice users never have to consent.
+		if _, err := accountDB.CreateAccount(ctx, "bridge", "", "as", api.AccountTypeAppService); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+		if err := accountDB.SetPolicyVersion(ctx, "alice", "1.0"); err != nil {
+			t.Fatalf("failed to set policy version: %s", err)
+		}
+
+		var qresp api.QueryAccessTokenResponse
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "alice_token"}, &qresp); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if qresp.Device == nil || !qresp.Device.ConsentNotGiven {
+			t.Fatalf("expected device to need consent, got %+v", qresp.Device)
+		}
+
+		var oresp api.QueryOutdatedPolicyResponse
+		if err := userAPI.QueryOutdatedPolicy(ctx, &api.QueryOutdatedPolicyRequest{PolicyVersion: "2.0"}, &oresp); err != nil {
+			t.Fatalf("QueryOutdatedPolicy failed: %v", err)
+		}
+		if !reflect.DeepEqual(oresp.UserLocalparts, []string{"alice"}) {
+			t.Fatalf("QueryOutdatedPolicy: got %v, want [alice]", oresp.UserLocalparts)
+		}
+
+		// Users are only sent one server notice per version.
+		if err := userAPI.PerformUpdatePolicyVersion(ctx, &api.PerformUpdatePolicyVersionRequest{
+			Localpart: "alice", PolicyVersion: "2.0", ServerNoticeUpdate: true,
+		}, &api.PerformUpdatePolicyVersionResponse{}); err != nil {
+			t.Fatalf("PerformUpdatePolicyVersion failed: %v", err)
+		}
+		oresp = api.QueryOutdatedPolicyResponse{}
+		if err := userAPI.QueryOutdatedPolicy(ctx, &api.QueryOutdatedPolicyRequest{PolicyVersion: "2.0"}, &oresp); err != nil {
+			t.Fatalf("QueryOutdatedPolicy failed: %v", err)
+		}
+		if len(oresp.UserLocalparts) != 0 {
+			t.Fatalf("QueryOutdatedPolicy: got %v, want none", oresp.UserLocalparts)
+		}
+
+		if err := userAPI.PerformUpdatePolicyVersion(ctx, &api.PerformUpdatePolicyVersionRequest{
+			Localpart: "alice", PolicyVersion: "2.0",
+		}, &api.PerformUpdatePolicyVersionResponse{}); err != nil {
+			t.Fatalf("PerformUpdatePolicyVersion failed: %v", err)
+		}
+		var presp api.QueryPolicyVersionResponse
+		if err := userAPI.QueryPolicyVersion(ctx, &api.QueryPolicyVersionRequest{Localpart: "alice"}, &presp); err != nil {
+			t.Fatalf("QueryPolicyVersion failed: %v", err)
+		}
+		if presp.PolicyVersion != "2.0" {
+			t.Fatalf("QueryPolicyVersion: got %q, want 2.0", presp.PolicyVersion)
+		}
+		qresp = api.QueryAccessTokenResponse{}
+		if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "alice_token"}, &qresp); err != nil {
+			t.Fatalf("QueryAccessToken failed: %v", err)
+		}
+		if qresp.Device == nil || qresp.Device.ConsentNotGiven {
+			t.Fatalf("expected device to have consented, got %+v", qresp.Device)
+		}
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{
+			loginLockout: config.LoginLockout{
+				Enabled:          true,
+				MaxFailures:      2,
+				MaxFailuresPerIP: 5,
+				BaseDuration:     time.Minute,
+				MaxDuration:      time.Hour,
+				NotifyAfter:      2,
+			},
+		}, dbType)
+		defer close()
+
+		if _, err := accountDB.CreateAccount(ctx, "alice", "apassword", "", api.AccountTypeUser); err != nil {
+			t.Fatalf("failed to make account: %s", err)
+		}
+		login := func(password, remoteAddr string) *api.QueryAccountByPasswordResponse {
+			t.Helper()
+			res := &api.QueryAccountByPasswordResponse{}
+			if err := userAPI.QueryAccountByPassword(ctx, &api.QueryAccountByPasswordRequest{
+				Localpart: "alice", PlaintextPassword: password, RemoteAddr: remoteAddr,
+			}, res); err != nil {
+				t.Fatalf("QueryAccountByPassword failed: %v", err)
+			}
+			return res
+		}
+
+		// A successful login resets the failures.
+		login("wrong", "10.0.0.1")
+		if res := login("apassword", "10.0.0.1"); !res.Exists {
+			t.Fatalf("expected login to succeed, got %+v", res)
+		}
+		login("wrong", "10.0.0.1")
+		if res := login("wrong", "10.0.0.2"); res.Exists || res.LockedUntilMS != 0 {
+			t.Fatalf("expected login to fail without lockout, got %+v", res)
+		}
+
+		// The account is now locked out, even with the right password.
+		res := login("apassword", "10.0.0.3")
+		if res.Exists || res.LockedUntilMS == 0 {
+			t.Fatalf("expected account to be locked out, got %+v", res)
+		}
+
+		// The user is notified about the failures once.
+		var nresp api.PerformLoginFailureNoticesResponse
+		if err := userAPI.PerformLoginFailureNotices(ctx, &api.PerformLoginFailureNoticesRequest{}, &nresp); err != nil {
+			t.Fatalf("PerformLoginFailureNotices failed: %v", err)
+		}
+		if len(nresp.Notices) != 1 || nresp.Notices[0].Failures != 2 || nresp.Notices[0].LastIP != "10.0.0.2" || nresp.Notices[0].LockedUntilMS == 0 {
+			t.Fatalf("PerformLoginFailureNotices: got %+v, want one notice for 2 failures", nresp.Notices)
+		}
+		nresp = api.PerformLoginFailureNoticesResponse{}
+		if err := userAPI.PerformLoginFailureNotices(ctx, &api.PerformLoginFailureNoticesRequest{}, &nresp); err != nil {
+			t.Fatalf("PerformLoginFailureNotices failed: %v", err)
+		}
+		if len(nresp.Notices) != 0 {
+			t.Fatalf("PerformLoginFailureNotices: got %+v, want none", nresp.Notices)
+		}
+
+		// Admins can unlock the account.
+		var uresp api.PerformLoginLockoutResetResponse
+		if err := userAPI.PerformLoginLockoutReset(ctx, &api.PerformLoginLockoutResetRequest{Localpart: "alice"}, &uresp); err != nil {
+			t.Fatalf("PerformLoginLockoutReset failed: %v", err)
+		}
+		if !uresp.AccountExists {
+			t.Fatalf("PerformLoginLockoutReset: expected account to exist")
+		}
+		if res = login("apassword", "10.0.0.3"); !res.Exists {
+			t.Fatalf("expected login to succeed after unlocking, got %+v", res)
+		}
+
+		// Too many failures from one IP address lock it out, whichever accounts
+		// they were for.
+		for i := 0; i < 5; i++ {
+			res = &api.QueryAccountByPasswordResponse{}
+			if err := userAPI.QueryAccountByPassword(ctx, &api.QueryAccountByPasswordRequest{
+				Localpart: fmt.Sprintf("nobody%d", i), PlaintextPassword: "wrong", RemoteAddr: "10.0.0.4",
+			}, res); err != nil {
+				t.Fatalf("QueryAccountByPassword failed: %v", err)
+			}
+		}
+		if res = login("apassword", "10.0.0.4"); res.Exists || res.LockedUntilMS == 0 {
+			t.Fatalf("expected IP address to be locked out, got %+v", res)
+		}
+		if res = login("apassword", "10.0.0.5"); !res.Exists {
+			t.Fatalf("expected login from another IP address to succeed, got %+v", res)
+		}
+
+		// Logins checked outside of the user API, e.g. by a password provider,
+		// count towards the same lockout.
+		for i := 0; i < 2; i++ {
+			if err := userAPI.PerformLoginAttempt(ctx, &api.PerformLoginAttemptRequest{
+				Localpart: "alice", RemoteAddr: "10.0.0.6",
+			}, &api.PerformLoginAttemptResponse{}); err != nil {
+				t.Fatalf("PerformLoginAttempt failed: %v", err)
+			}
+		}
+		var lresp api.QueryLoginLockoutResponse
+		if err := userAPI.QueryLoginLockout(ctx, &api.QueryLoginLockoutRequest{Localpart: "alice"}, &lresp); err != nil {
+			t.Fatalf("QueryLoginLockout failed: %v", err)
+		}
+		if lresp.LockedUntilMS == 0 {
+			t.Fatalf("expected account to be locked out after failed external logins")
+		}
+	})
+}
+
+func TestThreePIDValidationSession(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, _, close := MustMakeInternalAPI(t, apiTestOpts{
+			emailValidation: config.EmailValidation{
+				Enabled:       true,
+				TokenLifetime: time.Hour,
+			},
+		}, dbType)
+		defer close()
+
+		createSession := func(sendAttempt int) *api.PerformThreePIDValidationSessionCreationResponse {
+			t.Helper()
+			res := &api.PerformThreePIDValidationSessionCreationResponse{}
+			if err := userAPI.PerformThreePIDValidationSessionCreation(ctx, &api.PerformThreePIDValidationSessionCreationRequest{
+				ClientSecret: "secret", Medium: "email", Address: "alice@example.com",
+				SendAttempt: sendAttempt, NextLink: "https://example.com",
+			}, res); err != nil {
+				t.Fatalf("PerformThreePIDValidationSessionCreation failed: %v", err)
+			}
+			return res
+		}
+		validate := func(sessionID, clientSecret, token string) *api.PerformThreePIDValidationResponse {
+			t.Helper()
+			res := &api.PerformThreePIDValidationResponse{}
+			if err := userAPI.PerformThreePIDValidation(ctx, &api.PerformThreePIDValidationRequest{
+				SessionID: sessionID, ClientSecret: clientSecret, Token: token,
+			}, res); err != nil {
+				t.Fatalf("PerformThreePIDValidation failed: %v", err)
+			}
+			return res
+		}
+		query := func(sessionID, clientSecret string) *api.QueryThreePIDValidationSessionResponse {
+			t.Helper()
+			res := &api.QueryThreePIDValidationSessionResponse{}
+			if err := userAPI.QueryThreePIDValidationSession(ctx, &api.QueryThreePIDValidationSessionRequest{
+				SessionID: sessionID, ClientSecret: clientSecret,
+			}, res); err != nil {
+				t.Fatalf("QueryThreePIDValidationSession failed: %v", err)
+			}
+			return res
+		}
+
+		session := createSession(1)
+		if !session.Send || session.SessionID == "" || session.Token == "" {
+			t.Fatalf("expected a new session to be sent, got %+v", session)
+		}
+		// Retrying with the same send attempt returns the same session, without
+		// sending the token again.
+		if retry := createSession(1); retry.Send || retry.SessionID != session.SessionID || retry.Token != session.Token {
+			t.Fatalf("expected the same session without sending, got %+v", retry)
+		}
+		if retry := createSession(2); !retry.Send || retry.SessionID != session.SessionID {
+			t.Fatalf("expected the same session to be sent again, got %+v", retry)
+		}
+
+		if query(session.SessionID, "secret").Validated {
+			t.Fatalf("expected session not to be validated before submitting the token")
+		}
+		if validate(session.SessionID, "secret", "wrong").Validated {
+			t.Fatalf("expected the wrong token not to validate the session")
+		}
+		if validate(session.SessionID, "wrong", session.Token).Validated {
+			t.Fatalf("expected the wrong client secret not to validate the session")
+		}
+		res := validate(session.SessionID, "secret", session.Token)
+		if !res.Validated || res.NextLink != "https://example.com" {
+			t.Fatalf("expected the session to be validated, got %+v", res)
+		}
+
+		if q := query(session.SessionID, "wrong"); q.Validated {
+			t.Fatalf("expected the wrong client secret not to be able to use the session")
+		}
+		q := query(session.SessionID, "secret")
+		if !q.Validated || q.Medium != "email" || q.Address != "alice@example.com" {
+			t.Fatalf("expected the session to be validated, got %+v", q)
+		}
+
+		if err := userAPI.PerformThreePIDValidationSessionDeletion(ctx, &api.PerformThreePIDValidationSessionDeletionRequest{
+			SessionID: session.SessionID,
+		}, &api.PerformThreePIDValidationSessionDeletionResponse{}); err != nil {
+			t.Fatalf("PerformThreePIDValidationSessionDeletion failed: %v", err)
+		}
+		if query(session.SessionID, "secret").Validated {
+			t.Fatalf("expected a deleted session not to be usable")
+		}
+	})
+}